@@ -1,17 +1,19 @@
 package git
 
 import (
-	"bytes"
+	"container/heap"
+	"context"
 	"errors"
-	"fmt"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
-	"unicode/utf8"
+	"unicode"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/utils/diff"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 // BlameResult represents the result of a Blame operation.
@@ -24,80 +26,125 @@ type BlameResult struct {
 	Lines []*Line
 }
 
+// BlameRanges are the lines of a BlameResult blamed on a commit.
+type BlameRanges struct {
+	// Hash is the hash of the commit the lines are blamed on.
+	Hash plumbing.Hash
+	// Ranges are the ranges of consecutive lines of the file blamed on the
+	// commit, in the order they appear in the file.
+	Ranges []LineRange
+}
+
+// LineRange is a range of consecutive lines of a file, numbered from 1 and
+// including both Start and End.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// Ranges groups the lines of the result by the commit they are blamed on.
+// The commits are returned in the order they first appear in the file.
+func (r *BlameResult) Ranges() []*BlameRanges {
+	var result []*BlameRanges
+	byHash := make(map[plumbing.Hash]*BlameRanges)
+	for i, l := range r.Lines {
+		br, ok := byHash[l.Hash]
+		if !ok {
+			br = &BlameRanges{Hash: l.Hash}
+			byHash[l.Hash] = br
+			result = append(result, br)
+		}
+
+		n := i + 1
+		last := len(br.Ranges) - 1
+		if last >= 0 && br.Ranges[last].End == n-1 {
+			br.Ranges[last].End = n
+			continue
+		}
+
+		br.Ranges = append(br.Ranges, LineRange{Start: n, End: n})
+	}
+
+	return result
+}
+
 // Blame returns a BlameResult with the information about the last author of
 // each line from file `path` at commit `c`.
 func Blame(c *object.Commit, path string) (*BlameResult, error) {
-	// The file to blame is identified by the input arguments:
-	// commit and path. commit is a Commit object obtained from a Repository. Path
-	// represents a path to a specific file contained into the repository.
-	//
-	// Blaming a file is a two step process:
-	//
-	// 1. Create a linear history of the commits affecting a file. We use
-	// revlist.New for that.
-	//
-	// 2. Then build a graph with a node for every line in every file in
-	// the history of the file.
-	//
-	// Each node is assigned a commit: Start by the nodes in the first
-	// commit. Assign that commit as the creator of all its lines.
-	//
-	// Then jump to the nodes in the next commit, and calculate the diff
-	// between the two files. Newly created lines get
-	// assigned the new commit as its origin. Modified lines also get
-	// this new commit. Untouched lines retain the old commit.
-	//
-	// All this work is done in the assignOrigin function which holds all
-	// the internal relevant data in a "blame" struct, that is not
-	// exported.
-	//
-	// TODO: ways to improve the efficiency of this function:
-	// 1. Improve revlist
-	// 2. Improve how to traverse the history (example a backward traversal will
-	// be much more efficient)
-	//
-	// TODO: ways to improve the function in general:
-	// 1. Add memoization between revlist and assign.
-	// 2. It is using much more memory than needed, see the TODOs below.
-
-	b := new(blame)
-	b.fRev = c
-	b.path = path
+	return BlameWithOptions(c, path, &BlameOptions{})
+}
 
-	// get all the file revisions
-	if err := b.fillRevs(); err != nil {
+// BlameWithOptions returns a BlameResult like Blame, performing the blame as
+// described by the given options.
+func BlameWithOptions(c *object.Commit, path string, o *BlameOptions) (*BlameResult, error) {
+	// The lines of the file are passed from a commit to its parents as long
+	// as they are found unchanged in them, starting from the given commit.
+	// The lines not found in any parent are blamed on the commit.
+	//
+	// The commits are visited newest first, so the lines reaching a commit
+	// from several children are blamed together, and the walk ends as soon
+	// as every line has been blamed. When the file is not found in a
+	// parent, its previous name is looked for by detecting renames.
+	if err := o.Validate(); err != nil {
 		return nil, err
 	}
 
-	// calculate the line tracking graph and fill in
-	// file contents in data.
-	if err := b.fillGraphAndData(); err != nil {
+	file, err := c.File(path)
+	if err != nil {
 		return nil, err
 	}
 
-	file, err := b.fRev.File(b.path)
+	contents, err := file.Contents()
 	if err != nil {
 		return nil, err
 	}
+
 	finalLines, err := file.Lines()
 	if err != nil {
 		return nil, err
 	}
 
-	// Each node (line) holds the commit where it was introduced or
-	// last modified. To achieve that we use the FORWARD algorithm
-	// described in Zimmermann, et al. "Mining Version Archives for
-	// Co-changed Lines", in proceedings of the Mining Software
-	// Repositories workshop, Shanghai, May 22-23, 2006.
-	lines, err := newLines(finalLines, b.sliceGraph(len(b.graph)-1))
+	n := countLines(contents)
+	b := &blame{
+		opts:     o,
+		suspects: make(map[blameKey]*blameSuspect),
+		origins:  make([]blameOrigin, n),
+	}
+
+	lines := make([]blameLine, n)
+	for i := range lines {
+		lines[i] = blameLine{final: i, line: i}
+	}
+
+	b.push(c, path, lines)
+	for b.queue.Len() > 0 {
+		s := heap.Pop(&b.queue).(*blameSuspect)
+		delete(b.suspects, blameKey{s.commit.Hash, s.path})
+
+		if err := b.pass(s); err != nil {
+			return nil, err
+		}
+	}
+
+	commits := make([]*object.Commit, n)
+	for i, o := range b.origins {
+		commits[i] = o.commit
+	}
+
+	result, err := newLines(finalLines, commits)
 	if err != nil {
 		return nil, err
 	}
 
+	for i, o := range b.origins {
+		result[i].OriginalLine = o.line + 1
+		result[i].OriginalPath = o.path
+	}
+
 	return &BlameResult{
 		Path:  path,
 		Rev:   c.Hash,
-		Lines: lines,
+		Lines: result,
 	}, nil
 }
 
@@ -111,6 +158,12 @@ type Line struct {
 	Date time.Time
 	// Hash is the commit hash that introduced the original line
 	Hash plumbing.Hash
+	// OriginalLine is the number of the line, starting at 1, in the file of
+	// the commit that introduced it.
+	OriginalLine int
+	// OriginalPath is the path of the file in the commit that introduced the
+	// line, it differs from the blamed path if the file was renamed later.
+	OriginalPath string
 }
 
 func newLine(author, text string, date time.Time, hash plumbing.Hash) *Line {
@@ -145,158 +198,326 @@ func newLines(contents []string, commits []*object.Commit) ([]*Line, error) {
 	return result, nil
 }
 
-// this struct is internally used by the blame function to hold its
-// inputs, outputs and state.
+// this struct is internally used by the blame function to hold its state.
 type blame struct {
-	// the path of the file to blame
+	opts *BlameOptions
+	// the commits with lines still to be blamed, newest first
+	queue blameQueue
+	// the suspects in the queue by commit and path
+	suspects map[blameKey]*blameSuspect
+	// the commit, path and line each line of the final file is blamed on
+	origins []blameOrigin
+}
+
+type blameKey struct {
+	hash plumbing.Hash
 	path string
-	// the commit of the final revision of the file to blame
-	fRev *object.Commit
-	// the chain of revisions affecting the the file to blame
-	revs []*object.Commit
-	// the contents of the file across all its revisions
-	data []string
-	// the graph of the lines in the file across all the revisions
-	graph [][]*object.Commit
 }
 
-// calculate the history of a file "path", starting from commit "from", sorted by commit date.
-func (b *blame) fillRevs() error {
-	var err error
+// blameSuspect is a commit with lines of the file at path still to be blamed.
+type blameSuspect struct {
+	commit *object.Commit
+	path   string
+	lines  []blameLine
+}
+
+// blameLine is a line of the final file, found at line in a suspect.
+type blameLine struct {
+	final int
+	line  int
+}
 
-	b.revs, err = references(b.fRev, b.path)
-	return err
+type blameOrigin struct {
+	commit *object.Commit
+	path   string
+	line   int
 }
 
-// build graph of a file from its revision history
-func (b *blame) fillGraphAndData() error {
-	//TODO: not all commits are needed, only the current rev and the prev
-	b.graph = make([][]*object.Commit, len(b.revs))
-	b.data = make([]string, len(b.revs)) // file contents in all the revisions
-	// for every revision of the file, starting with the first
-	// one...
-	for i, rev := range b.revs {
-		// get the contents of the file
-		file, err := rev.File(b.path)
+// push adds the given lines to the suspect for the commit and path, adding the
+// suspect to the queue if it was not there yet.
+func (b *blame) push(c *object.Commit, path string, lines []blameLine) {
+	if len(lines) == 0 {
+		return
+	}
+
+	key := blameKey{c.Hash, path}
+	if s, ok := b.suspects[key]; ok {
+		s.lines = mergeBlameLines(s.lines, lines)
+		return
+	}
+
+	s := &blameSuspect{commit: c, path: path, lines: lines}
+	b.suspects[key] = s
+	heap.Push(&b.queue, s)
+}
+
+// mergeBlameLines merges two lists of lines sorted by line.
+func mergeBlameLines(a, b []blameLine) []blameLine {
+	result := make([]blameLine, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].line <= b[0].line {
+			result = append(result, a[0])
+			a = a[1:]
+		} else {
+			result = append(result, b[0])
+			b = b[1:]
+		}
+	}
+
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+// blameParent is a parent of a suspect with the file to blame.
+type blameParent struct {
+	commit *object.Commit
+	path   string
+	file   *object.File
+}
+
+// pass passes the lines of the suspect found unchanged in its parents to them,
+// blaming the rest on the suspect.
+func (b *blame) pass(s *blameSuspect) error {
+	tree, err := s.commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	file, err := tree.File(s.path)
+	if err != nil {
+		return err
+	}
+
+	var parents []*blameParent
+	for i := 0; i < s.commit.NumParents(); i++ {
+		parent, err := s.commit.Parent(i)
 		if err != nil {
+			return err
+		}
+
+		p, err := b.parentFile(parent, tree, s.path)
+		if err != nil {
+			return err
+		}
+
+		if p == nil {
+			continue
+		}
+
+		// the file is the same in the parent, every line comes from it
+		if p.file.Hash == file.Hash {
+			b.push(p.commit, p.path, s.lines)
 			return nil
 		}
-		b.data[i], err = file.Contents()
+
+		parents = append(parents, p)
+	}
+
+	remaining := s.lines
+	if len(parents) != 0 {
+		contents, err := file.Contents()
 		if err != nil {
 			return err
 		}
-		nLines := countLines(b.data[i])
-		// create a node for each line
-		b.graph[i] = make([]*object.Commit, nLines)
-		// assign a commit to each node
-		// if this is the first revision, then the node is assigned to
-		// this first commit.
-		if i == 0 {
-			for j := 0; j < nLines; j++ {
-				b.graph[i][j] = (*object.Commit)(b.revs[i])
+
+		for _, p := range parents {
+			if len(remaining) == 0 {
+				break
+			}
+
+			remaining, err = b.passToParent(contents, p, remaining)
+			if err != nil {
+				return err
 			}
-		} else {
-			// if this is not the first commit, then assign to the old
-			// commit or to the new one, depending on what the diff
-			// says.
-			b.assignOrigin(i, i-1)
 		}
 	}
+
+	for _, l := range remaining {
+		b.origins[l.final] = blameOrigin{commit: s.commit, path: s.path, line: l.line}
+	}
+
 	return nil
 }
 
-// sliceGraph returns a slice of commits (one per line) for a particular
-// revision of a file (0=first revision).
-func (b *blame) sliceGraph(i int) []*object.Commit {
-	fVs := b.graph[i]
-	result := make([]*object.Commit, 0, len(fVs))
-	for _, v := range fVs {
-		c := object.Commit(*v)
-		result = append(result, &c)
+// parentFile returns the file to blame in the given parent, following the
+// renames of the file. It returns nil if the file does not exist in the
+// parent.
+func (b *blame) parentFile(parent *object.Commit, tree *object.Tree, path string) (*blameParent, error) {
+	ptree, err := parent.Tree()
+	if err != nil {
+		return nil, err
 	}
-	return result
+
+	file, err := ptree.File(path)
+	if err == nil {
+		return &blameParent{commit: parent, path: path, file: file}, nil
+	}
+
+	if err != object.ErrFileNotFound {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), ptree, tree, &object.DiffTreeOptions{
+		DetectRenames: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ch := range changes {
+		if ch.To.Name != path || ch.From.Name == "" {
+			continue
+		}
+
+		file, err := ptree.File(ch.From.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &blameParent{commit: parent, path: ch.From.Name, file: file}, nil
+	}
+
+	return nil, nil
 }
 
-// Assigns origin to vertexes in current (c) rev from data in its previous (p)
-// revision
-func (b *blame) assignOrigin(c, p int) {
-	// assign origin based on diff info
-	hunks := diff.Do(b.data[p], b.data[c])
+// passToParent passes to the parent the lines found unchanged in it, and
+// moved when moves are detected, returning the lines not found.
+func (b *blame) passToParent(contents string, p *blameParent, lines []blameLine) ([]blameLine, error) {
+	pcontents, err := p.file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	// the line of the parent each line of the suspect comes from, if any
+	mapping := make([]int, countLines(contents))
 	sl := -1 // source line
 	dl := -1 // destination line
-	for h := range hunks {
-		hLines := countLines(hunks[h].Text)
+	for _, h := range diff.Do(pcontents, contents) {
+		hLines := countLines(h.Text)
 		for hl := 0; hl < hLines; hl++ {
-			switch {
-			case hunks[h].Type == 0:
+			switch h.Type {
+			case diffmatchpatch.DiffEqual:
 				sl++
 				dl++
-				b.graph[c][dl] = b.graph[p][sl]
-			case hunks[h].Type == 1:
+				mapping[dl] = sl
+			case diffmatchpatch.DiffInsert:
 				dl++
-				b.graph[c][dl] = (*object.Commit)(b.revs[c])
-			case hunks[h].Type == -1:
+				mapping[dl] = -1
+			case diffmatchpatch.DiffDelete:
 				sl++
-			default:
-				panic("unreachable")
 			}
 		}
 	}
-}
 
-// GoString prints the results of a Blame using git-blame's style.
-func (b *blame) GoString() string {
-	var buf bytes.Buffer
+	var passed, remaining []blameLine
+	for _, l := range lines {
+		if mapping[l.line] == -1 {
+			remaining = append(remaining, l)
+			continue
+		}
 
-	file, err := b.fRev.File(b.path)
-	if err != nil {
-		panic("PrettyPrint: internal error in repo.Data")
+		passed = append(passed, blameLine{final: l.final, line: mapping[l.line]})
 	}
-	contents, err := file.Contents()
-	if err != nil {
-		panic("PrettyPrint: internal error in repo.Data")
+
+	if b.opts.DetectMoves && len(remaining) != 0 {
+		var moved []blameLine
+		moved, remaining = b.findMoves(splitLines(contents), splitLines(pcontents), remaining)
+		passed = mergeBlameLines(sortBlameLines(passed), sortBlameLines(moved))
 	}
 
-	lines := strings.Split(contents, "\n")
-	// max line number length
-	mlnl := len(strconv.Itoa(len(lines)))
-	// max author length
-	mal := b.maxAuthorLength()
-	format := fmt.Sprintf("%%s (%%-%ds %%%dd) %%s\n",
-		mal, mlnl)
+	b.push(p.commit, p.path, sortBlameLines(passed))
+	return remaining, nil
+}
+
+// findMoves looks for the blocks of consecutive lines of the suspect found
+// anywhere in the parent, returning the ones moved and the ones not found. A
+// block is only considered moved if it has at least MoveScore alphanumeric
+// characters, as in git.
+func (b *blame) findMoves(lines, plines []string, remaining []blameLine) (moved, rest []blameLine) {
+	for i := 0; i < len(remaining); {
+		start, size := -1, 0
+		for j := range plines {
+			n := 0
+			for i+n < len(remaining) && j+n < len(plines) &&
+				remaining[i+n].line == remaining[i].line+n &&
+				lines[remaining[i+n].line] == plines[j+n] {
+				n++
+			}
+
+			if n > size {
+				start, size = j, n
+			}
+		}
+
+		if size == 0 || alnumCount(lines[remaining[i].line:remaining[i].line+size]) < b.opts.MoveScore {
+			rest = append(rest, remaining[i])
+			i++
+			continue
+		}
+
+		for n := 0; n < size; n++ {
+			moved = append(moved, blameLine{final: remaining[i+n].final, line: start + n})
+		}
 
-	fVs := b.graph[len(b.graph)-1]
-	for ln, v := range fVs {
-		fmt.Fprintf(&buf, format, v.Hash.String()[:8],
-			prettyPrintAuthor(fVs[ln]), ln+1, lines[ln])
+		i += size
 	}
-	return buf.String()
+
+	return moved, rest
 }
 
-// utility function to pretty print the author.
-func prettyPrintAuthor(c *object.Commit) string {
-	return fmt.Sprintf("%s %s", c.Author.Name, c.Author.When.Format("2006-01-02"))
+// sortBlameLines sorts by line the lines passed to a parent, which are out of
+// order only when moves are detected.
+func sortBlameLines(lines []blameLine) []blameLine {
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].line < lines[j].line
+	})
+
+	return lines
 }
 
-// utility function to calculate the number of runes needed
-// to print the longest author name in the blame of a file.
-func (b *blame) maxAuthorLength() int {
-	memo := make(map[plumbing.Hash]struct{}, len(b.graph)-1)
-	fVs := b.graph[len(b.graph)-1]
-	m := 0
-	for ln := range fVs {
-		if _, ok := memo[fVs[ln].Hash]; ok {
-			continue
-		}
-		memo[fVs[ln].Hash] = struct{}{}
-		m = max(m, utf8.RuneCountInString(prettyPrintAuthor(fVs[ln])))
+// splitLines splits the contents in lines as counted by countLines, keeping
+// the line endings.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
 	}
-	return m
+
+	return lines
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+func alnumCount(lines []string) int {
+	var n int
+	for _, l := range lines {
+		for _, r := range l {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				n++
+			}
+		}
 	}
-	return b
+
+	return n
+}
+
+// blameQueue is a heap of suspects sorted by commit date, newest first.
+type blameQueue []*blameSuspect
+
+func (q blameQueue) Len() int { return len(q) }
+
+func (q blameQueue) Less(i, j int) bool {
+	return q[i].commit.Committer.When.After(q[j].commit.Committer.When)
+}
+
+func (q blameQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *blameQueue) Push(x interface{}) {
+	*q = append(*q, x.(*blameSuspect))
+}
+
+func (q *blameQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	s := old[n-1]
+	*q = old[:n-1]
+	return s
 }
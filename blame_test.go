@@ -39,6 +39,91 @@ func (s *BlameSuite) TestNewLinesWithNewLine(c *C) {
 	c.Assert(lines[1].Text, Equals, "\n")
 }
 
+func (s *BlameSuite) TestBlameRename(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{
+		"foo": "first line of foo\nsecond line of foo\nthird line of foo\n",
+	})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+
+	renamed := commitFiles(c, w, map[string]string{
+		"foo":     "",
+		"dir/bar": "first line of foo\nnew line of bar\nsecond line of foo\nthird line of foo\n",
+	}, "rename\n")
+
+	commit, err := r.CommitObject(renamed)
+	c.Assert(err, IsNil)
+
+	result, err := Blame(commit, "dir/bar")
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines, HasLen, 4)
+
+	for i, expected := range []struct {
+		hash plumbing.Hash
+		path string
+		line int
+	}{
+		{base, "foo", 1},
+		{renamed, "dir/bar", 2},
+		{base, "foo", 2},
+		{base, "foo", 3},
+	} {
+		c.Assert(result.Lines[i].Hash, Equals, expected.hash)
+		c.Assert(result.Lines[i].OriginalPath, Equals, expected.path)
+		c.Assert(result.Lines[i].OriginalLine, Equals, expected.line)
+	}
+
+	c.Assert(result.Ranges(), DeepEquals, []*BlameRanges{
+		{Hash: base, Ranges: []LineRange{{1, 1}, {3, 4}}},
+		{Hash: renamed, Ranges: []LineRange{{2, 2}}},
+	})
+}
+
+func (s *BlameSuite) TestBlameDetectMoves(c *C) {
+	moved := "func moved() {\n\treturn somethingLongEnough\n}\n"
+	other := "func other() {\n\treturn somethingElse\n}\n"
+	r, w, _ := newMergeRepository(c, map[string]string{
+		"foo": moved + "\n" + other,
+	})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+
+	h := commitFiles(c, w, map[string]string{"foo": other + "\n" + moved}, "move\n")
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+
+	result, err := Blame(commit, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines, HasLen, 7)
+	c.Assert(result.Lines[4].Hash, Equals, h)
+
+	result, err = BlameWithOptions(commit, "foo", &BlameOptions{DetectMoves: true})
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines, HasLen, 7)
+
+	// the empty line has not enough alphanumeric characters to be moved
+	for i, line := range []int{5, 6, 7, 0, 1, 2, 3} {
+		if line == 0 {
+			c.Assert(result.Lines[i].Hash, Equals, h)
+			continue
+		}
+
+		c.Assert(result.Lines[i].Hash, Equals, base, Commentf("line %d", i+1))
+		c.Assert(result.Lines[i].OriginalLine, Equals, line, Commentf("line %d", i+1))
+	}
+
+	result, err = BlameWithOptions(commit, "foo", &BlameOptions{
+		DetectMoves: true,
+		MoveScore:   100,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines[4].Hash, Equals, h)
+}
+
 type blameTest struct {
 	repo   string
 	rev    string
@@ -57,6 +142,16 @@ func (s *BlameSuite) TestBlame(c *C) {
 
 		obt, err := Blame(commit, t.path)
 		c.Assert(err, IsNil)
+
+		for i, l := range obt.Lines {
+			// the original lines are not known by the tests, but the files
+			// were never renamed
+			c.Assert(l.OriginalPath, Equals, t.path)
+			c.Assert(l.OriginalLine > 0, Equals, true)
+			exp.Lines[i].OriginalPath = l.OriginalPath
+			exp.Lines[i].OriginalLine = l.OriginalLine
+		}
+
 		c.Assert(obt, DeepEquals, exp)
 
 		for i, l := range obt.Lines {
@@ -0,0 +1,239 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var (
+	// ErrEmptyPick is returned by CherryPick and Revert when the changes
+	// introduced by the commit result in no changes over HEAD.
+	ErrEmptyPick = errors.New("the resulting changes are empty")
+)
+
+const (
+	// cherryPickHead is the reference written when a cherry-pick stops with
+	// conflicts, it points to the commit being picked.
+	cherryPickHead plumbing.ReferenceName = "CHERRY_PICK_HEAD"
+	// revertHead is the reference written when a revert stops with
+	// conflicts, it points to the commit being reverted.
+	revertHead plumbing.ReferenceName = "REVERT_HEAD"
+)
+
+// CherryPick applies the changes introduced by the given commit on top of
+// HEAD. The commit is compared with its parent, or with the parent given by
+// Mainline for merge commits, and the changes are merged into the tree of
+// HEAD using a three-way merge. When the result is clean a new commit keeping
+// the author and message of the picked commit is created and its hash is
+// returned.
+//
+// When the changes can not be applied cleanly, the conflicts are recorded in
+// the index and the worktree like in Merge and ErrMergeConflicts is returned.
+// Once the conflicts are resolved and added, Commit creates the new commit.
+func (w *Worktree) CherryPick(h plumbing.Hash, opts *CherryPickOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit, err := w.r.CommitObject(h)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(commit, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	base, err := commitTree(parent)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	theirs, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.applyPick(&pick{
+		commit:   commit,
+		head:     cherryPickHead,
+		base:     base,
+		theirs:   theirs,
+		label:    commitLabel(commit),
+		noCommit: opts.NoCommit,
+		message:  commit.Message,
+		opts: &CommitOptions{
+			Author:    &commit.Author,
+			Committer: opts.Committer,
+			SignKey:   opts.SignKey,
+		},
+	})
+}
+
+// Revert creates a new commit undoing the changes introduced by the given
+// commit. The inverse of the changes, compared with its parent or with the
+// parent given by Mainline for merge commits, is merged into the tree of HEAD
+// using a three-way merge. The hash of the new commit is returned.
+//
+// When the changes can not be reverted cleanly, the conflicts are recorded in
+// the index and the worktree like in Merge and ErrMergeConflicts is returned.
+// Once the conflicts are resolved and added, Commit creates the new commit.
+func (w *Worktree) Revert(h plumbing.Hash, opts *RevertOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit, err := w.r.CommitObject(h)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(commit, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	base, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	theirs, err := commitTree(parent)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = revertMessage(commit, parent)
+	}
+
+	return w.applyPick(&pick{
+		commit:   commit,
+		head:     revertHead,
+		base:     base,
+		theirs:   theirs,
+		label:    "parent of " + commitLabel(commit),
+		noCommit: opts.NoCommit,
+		message:  msg,
+		opts: &CommitOptions{
+			Author:    opts.Author,
+			Committer: opts.Committer,
+			SignKey:   opts.SignKey,
+		},
+	})
+}
+
+// pick describes the changes applied on top of HEAD by a cherry-pick or a
+// revert, the changes from base to theirs.
+type pick struct {
+	commit       *object.Commit
+	head         plumbing.ReferenceName
+	base, theirs *object.Tree
+	label        string
+	noCommit     bool
+	message      string
+	opts         *CommitOptions
+}
+
+func (w *Worktree) applyPick(p *pick) (plumbing.Hash, error) {
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	m := newTreeMerger(w.r.Storer, "HEAD", p.label)
+	tree, err := m.Merge(p.base, oursTree, p.theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(m.conflicts) == 0 && tree.Hash == ours.TreeHash {
+		return plumbing.ZeroHash, ErrEmptyPick
+	}
+
+	if err := w.checkoutMergeResult(tree, m.conflicts); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(m.conflicts) != 0 {
+		ref := plumbing.NewHashReference(p.head, p.commit.Hash)
+		if err := w.r.Storer.SetReference(ref); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return plumbing.ZeroHash, ErrMergeConflicts
+	}
+
+	if p.noCommit {
+		return plumbing.ZeroHash, nil
+	}
+
+	p.opts.Parents = []plumbing.Hash{ours.Hash}
+	commit, err := w.buildCommitObject(p.message, p.opts, tree.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	action := "cherry-pick"
+	if p.head == revertHead {
+		action = "revert"
+	}
+
+	return commit, w.updateHEAD(commit, p.opts.Committer, action+": "+firstLine(p.message))
+}
+
+// mainlineParent returns the parent of c the changes are computed against,
+// mainline is the number of the parent starting from 1, or zero for commits
+// with a single parent. A nil commit is returned for root commits.
+func mainlineParent(c *object.Commit, mainline int) (*object.Commit, error) {
+	n := c.NumParents()
+	switch {
+	case n > 1 && mainline == 0:
+		return nil, ErrMainlineRequired
+	case n <= 1 && mainline != 0, mainline > n:
+		return nil, ErrInvalidMainline
+	case n == 0:
+		return nil, nil
+	case mainline == 0:
+		mainline = 1
+	}
+
+	return c.Parent(mainline - 1)
+}
+
+// commitTree returns the tree of the given commit, nil if the commit is nil.
+func commitTree(c *object.Commit) (*object.Tree, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	return c.Tree()
+}
+
+func revertMessage(c, parent *object.Commit) string {
+	msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", firstLine(c.Message), c.Hash)
+	if c.NumParents() > 1 {
+		msg += fmt.Sprintf(", reversing\nchanges made to %s", parent.Hash)
+	}
+
+	return msg + ".\n"
+}
@@ -0,0 +1,244 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type CherryPickSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&CherryPickSuite{})
+
+func (s *CherryPickSuite) TestCherryPick(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\ne\n",
+	})
+
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "unrelated\n")
+	fix := commitFiles(c, w, map[string]string{"foo": "a\nb\nc\nd\nE\n"}, "fix\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "A\nb\nc\nd\ne\n"}, "master\n")
+
+	committer := defaultSignature()
+	committer.Name = "committer"
+
+	hash, err := w.CherryPick(fix, &CherryPickOptions{Committer: committer})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, hash)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master})
+	c.Assert(commit.Message, Equals, "fix\n")
+	c.Assert(commit.Author.Name, Equals, defaultSignature().Name)
+	c.Assert(commit.Committer.Name, Equals, "committer")
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "A\nb\nc\nd\nE\n")
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = w.CherryPick(fix, &CherryPickOptions{Committer: committer})
+	c.Assert(err, Equals, ErrEmptyPick)
+}
+
+func (s *CherryPickSuite) TestCherryPickNoCommit(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	fix := commitFiles(c, w, map[string]string{"foo": "fix\n"}, "fix\n")
+	checkoutBranch(c, w, "master")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	hash, err := w.CherryPick(fix, &CherryPickOptions{NoCommit: true})
+	c.Assert(err, IsNil)
+	c.Assert(hash.IsZero(), Equals, true)
+
+	current, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(current.Hash(), Equals, head.Hash())
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "fix\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+}
+
+func (s *CherryPickSuite) TestCherryPickConflicts(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	fix := commitFiles(c, w, map[string]string{"foo": "fix\n"}, "fix\n")
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "master\n"}, "master\n")
+
+	_, err := w.CherryPick(fix, &CherryPickOptions{Committer: defaultSignature()})
+	c.Assert(err, Equals, ErrMergeConflicts)
+
+	ref, err := r.Reference(cherryPickHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, fix)
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals,
+		"<<<<<<< HEAD\nmaster\n=======\nfix\n>>>>>>> "+fix.String()[:7]+" (fix)\n",
+	)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, UpdatedButUnmerged)
+
+	err = util.WriteFile(fs, "foo", []byte("resolved\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	hash, err := w.Commit("fix\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	_, err = r.Reference(cherryPickHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *CherryPickSuite) TestCherryPickMergeCommit(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "feature\n")
+	checkoutBranch(c, w, "master")
+	commitFiles(c, w, map[string]string{"qux": "qux\n"}, "master\n")
+
+	merge, err := w.Merge(&MergeOptions{
+		ReferenceName: plumbing.NewBranchReferenceName("feature"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+
+	base, err := r.CommitObject(merge)
+	c.Assert(err, IsNil)
+	base, err = base.Parent(0)
+	c.Assert(err, IsNil)
+	base, err = base.Parent(0)
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName("backport"),
+		Hash:   base.Hash,
+		Create: true,
+	})
+	c.Assert(err, IsNil)
+
+	opts := &CherryPickOptions{Committer: defaultSignature()}
+	_, err = w.CherryPick(merge, opts)
+	c.Assert(err, Equals, ErrMainlineRequired)
+
+	opts.Mainline = 3
+	_, err = w.CherryPick(merge, opts)
+	c.Assert(err, Equals, ErrInvalidMainline)
+
+	opts.Mainline = 1
+	_, err = w.CherryPick(merge, opts)
+	c.Assert(err, IsNil)
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, IsNil)
+	_, err = fs.Stat("qux")
+	c.Assert(err, NotNil)
+}
+
+func (s *CherryPickSuite) TestRevert(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{
+		"foo": "a\nb\nc\nd\ne\n",
+	})
+
+	checkoutBranch(c, w, "master")
+	bad := commitFiles(c, w, map[string]string{
+		"foo": "A\nb\nc\nd\ne\n",
+		"bar": "bar\n",
+	}, "bad change\n")
+	last := commitFiles(c, w, map[string]string{"foo": "A\nb\nc\nd\nE\n"}, "other\n")
+
+	hash, err := w.Revert(bad, &RevertOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{last})
+	c.Assert(commit.Message, Equals,
+		"Revert \"bad change\"\n\nThis reverts commit "+bad.String()+".\n",
+	)
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a\nb\nc\nd\nE\n")
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *CherryPickSuite) TestRevertConflictsAbort(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	checkoutBranch(c, w, "master")
+	bad := commitFiles(c, w, map[string]string{"foo": "bad\n"}, "bad\n")
+	last := commitFiles(c, w, map[string]string{"foo": "other\n"}, "other\n")
+
+	_, err := w.Revert(bad, &RevertOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrMergeConflicts)
+
+	ref, err := r.Reference(revertHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, bad)
+
+	err = w.Reset(&ResetOptions{Mode: HardReset, Commit: last})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference(revertHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *CherryPickSuite) TestInvalidOptions(c *C) {
+	_, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	_, err := w.CherryPick(plumbing.ZeroHash, &CherryPickOptions{})
+	c.Assert(err, Equals, ErrMissingCommitter)
+
+	_, err = w.Revert(plumbing.ZeroHash, &RevertOptions{})
+	c.Assert(err, Equals, ErrMissingAuthor)
+
+	_, err = w.Revert(plumbing.ZeroHash, &RevertOptions{
+		Author:   defaultSignature(),
+		Mainline: -1,
+	})
+	c.Assert(err, Equals, ErrInvalidMainline)
+}
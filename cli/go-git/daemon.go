@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/git"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+)
+
+type CmdDaemon struct {
+	cmd
+
+	BasePath       string   `long:"base-path" description:"Directory containing the repositories, the requested paths are relative to it" default:"."`
+	Listen         string   `long:"listen" description:"Address to listen on"`
+	Port           int      `long:"port" description:"Port to listen on" default:"9418"`
+	Enable         []string `long:"enable" description:"Enable a service besides upload-pack, only receive-pack is supported"`
+	MaxConnections int      `long:"max-connections" description:"Maximum number of connections served at the same time, 0 for no limit"`
+	Timeout        int      `long:"timeout" description:"Seconds of inactivity after which a connection is closed, 0 for no timeout"`
+}
+
+func (CmdDaemon) Usage() string {
+	return fmt.Sprintf("usage: %s daemon [--base-path=<path>] [--listen=<host>] [--port=<n>] [--enable=receive-pack] [--max-connections=<n>] [--timeout=<n>]", bin)
+}
+
+func (c *CmdDaemon) Execute(args []string) error {
+	base, err := filepath.Abs(c.BasePath)
+	if err != nil {
+		return err
+	}
+
+	d := git.NewDaemon(server.NewFilesystemLoader(osfs.New(base)))
+	d.MaxConnections = c.MaxConnections
+	d.Timeout = time.Duration(c.Timeout) * time.Second
+
+	for _, service := range c.Enable {
+		switch service {
+		case "receive-pack", transport.ReceivePackServiceName:
+			d.ReceivePack = true
+		default:
+			return fmt.Errorf("unknown service: %s", service)
+		}
+	}
+
+	// the connections being served are drained before exiting, Shutdown
+	// returning once they are done or the timeout expires
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- d.Shutdown(ctx)
+	}()
+
+	addr := net.JoinHostPort(c.Listen, fmt.Sprint(c.Port))
+	if c.Verbose {
+		fmt.Fprintf(os.Stderr, "serving %s on %s\n", base, addr)
+	}
+
+	if err := d.ListenAndServe(addr); err != git.ErrDaemonClosed {
+		return err
+	}
+
+	return <-done
+}
@@ -22,6 +22,7 @@ func main() {
 	}
 
 	parser := flags.NewNamedParser(bin, flags.Default)
+	parser.AddCommand("daemon", "Serve repositories with the git protocol.", "", &CmdDaemon{})
 	parser.AddCommand("receive-pack", "", "", &CmdReceivePack{})
 	parser.AddCommand("upload-pack", "", "", &CmdUploadPack{})
 	parser.AddCommand("version", "Show the version information.", "", &CmdVersion{})
@@ -121,6 +121,8 @@ const (
 	windowKey        = "window"
 	mergeKey         = "merge"
 	rebaseKey        = "rebase"
+	promisorKey      = "promisor"
+	partialFilterKey = "partialclonefilter"
 
 	// DefaultPackWindow holds the number of previous objects used to
 	// generate deltas. The value 10 is the same used by git command.
@@ -327,6 +329,12 @@ type RemoteConfig struct {
 	URLs []string
 	// Fetch the default set of "refspec" for fetch operation
 	Fetch []RefSpec
+	// Promisor is true if the objects missing from a partial clone of the
+	// remote repository can be fetched lazily from it.
+	Promisor bool
+	// PartialCloneFilter is the filter-spec used by default on the fetches
+	// from a promisor remote, e.g. "blob:none".
+	PartialCloneFilter string
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called
@@ -372,6 +380,8 @@ func (c *RemoteConfig) unmarshal(s *format.Subsection) error {
 	c.Name = c.raw.Name
 	c.URLs = append([]string(nil), c.raw.Options.GetAll(urlKey)...)
 	c.Fetch = fetch
+	c.Promisor = c.raw.Options.Get(promisorKey) == "true"
+	c.PartialCloneFilter = c.raw.Options.Get(partialFilterKey)
 
 	return nil
 }
@@ -399,6 +409,18 @@ func (c *RemoteConfig) marshal() *format.Subsection {
 		c.raw.SetOption(fetchKey, values...)
 	}
 
+	if !c.Promisor {
+		c.raw.RemoveOption(promisorKey)
+	} else {
+		c.raw.SetOption(promisorKey, "true")
+	}
+
+	if c.PartialCloneFilter == "" {
+		c.raw.RemoveOption(partialFilterKey)
+	} else {
+		c.raw.SetOption(partialFilterKey, c.PartialCloneFilter)
+	}
+
 	return c.raw
 }
 
@@ -24,6 +24,8 @@ func (s *ConfigSuite) TestUnmarshall(c *C) {
 		url = git@github.com:src-d/go-git.git
 		fetch = +refs/heads/*:refs/remotes/origin/*
 		fetch = +refs/pull/*:refs/remotes/origin/pull/*
+		promisor = true
+		partialclonefilter = blob:none
 [remote "win-local"]
 		url = X:\\Git\\
 [submodule "qux"]
@@ -50,6 +52,9 @@ func (s *ConfigSuite) TestUnmarshall(c *C) {
 	c.Assert(cfg.Remotes["alt"].Name, Equals, "alt")
 	c.Assert(cfg.Remotes["alt"].URLs, DeepEquals, []string{"git@github.com:mcuadros/go-git.git", "git@github.com:src-d/go-git.git"})
 	c.Assert(cfg.Remotes["alt"].Fetch, DeepEquals, []RefSpec{"+refs/heads/*:refs/remotes/origin/*", "+refs/pull/*:refs/remotes/origin/pull/*"})
+	c.Assert(cfg.Remotes["alt"].Promisor, Equals, true)
+	c.Assert(cfg.Remotes["alt"].PartialCloneFilter, Equals, "blob:none")
+	c.Assert(cfg.Remotes["origin"].Promisor, Equals, false)
 	c.Assert(cfg.Remotes["win-local"].Name, Equals, "win-local")
 	c.Assert(cfg.Remotes["win-local"].URLs, DeepEquals, []string{"X:\\Git\\"})
 	c.Assert(cfg.Submodules, HasLen, 1)
@@ -73,6 +78,8 @@ func (s *ConfigSuite) TestMarshall(c *C) {
 	fetch = +refs/pull/*:refs/remotes/origin/pull/*
 [remote "origin"]
 	url = git@github.com:mcuadros/go-git.git
+	promisor = true
+	partialclonefilter = tree:0
 [remote "win-local"]
 	url = "X:\\Git\\"
 [submodule "qux"]
@@ -87,8 +94,10 @@ func (s *ConfigSuite) TestMarshall(c *C) {
 	cfg.Core.Worktree = "bar"
 	cfg.Pack.Window = 20
 	cfg.Remotes["origin"] = &RemoteConfig{
-		Name: "origin",
-		URLs: []string{"git@github.com:mcuadros/go-git.git"},
+		Name:               "origin",
+		URLs:               []string{"git@github.com:mcuadros/go-git.git"},
+		Promisor:           true,
+		PartialCloneFilter: "tree:0",
 	}
 
 	cfg.Remotes["alt"] = &RemoteConfig{
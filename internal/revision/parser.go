@@ -47,7 +47,7 @@ type CaretReg struct {
 	Negate bool
 }
 
-// CaretType represents ^{commit}
+// CaretType represents ^{commit}, the object type is empty for ^{}
 type CaretType struct {
 	ObjectType string
 }
@@ -191,32 +191,37 @@ func (p *Parser) validateFullRevision(chunks *[]Revisioner) error {
 				return &ErrInvalidRevision{`reference must be defined once at the beginning`}
 			}
 		case AtDate:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<ISO-8601 date>}, @{<ISO-8601 date>}`}
 		case AtReflog:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<n>}, @{<n>}`}
 		case AtCheckout:
-			if len(*chunks) == 1 {
-				return nil
+			if i == 0 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : @{-<n>}`}
 		case AtUpstream:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{upstream}, @{upstream}, <refname>@{u}, @{u}`}
 		case AtPush:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasReference = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{push}, @{push}`}
@@ -413,7 +418,8 @@ func (p *Parser) parseCaretBraces() (Revisioner, error) {
 		case tok == word && nextTok == cbrace && (lit == "commit" || lit == "tree" || lit == "blob" || lit == "tag" || lit == "object"):
 			return CaretType{lit}, nil
 		case re == "" && tok == cbrace:
-			return CaretType{"tag"}, nil
+			p.unscan()
+			return CaretType{""}, nil
 		case re == "" && tok == emark && nextTok == emark:
 			re += lit
 		case re == "" && tok == emark && nextTok == minus:
@@ -98,6 +98,15 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 		"@{2016-12-16T21:42:47Z}": []Revisioner{AtDate{tim}},
 		"@{1}":  []Revisioner{AtReflog{1}},
 		"@{-1}": []Revisioner{AtCheckout{1}},
+		"master@{1}~2": []Revisioner{
+			Ref("master"),
+			AtReflog{1},
+			TildePath{2},
+		},
+		"@{-1}^2": []Revisioner{
+			AtCheckout{1},
+			CaretPath{2},
+		},
 		"master@{upstream}": []Revisioner{
 			Ref("master"),
 			AtUpstream{},
@@ -112,10 +121,23 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 			Ref("master"),
 			AtPush{},
 		},
+		"master@{u}~1": []Revisioner{
+			Ref("master"),
+			AtUpstream{},
+			TildePath{1},
+		},
+		"@{push}^": []Revisioner{
+			AtPush{},
+			CaretPath{1},
+		},
 		"master@{2016-12-16T21:42:47Z}": []Revisioner{
 			Ref("master"),
 			AtDate{tim},
 		},
+		"@{2016-12-16T21:42:47Z}:README": []Revisioner{
+			AtDate{tim},
+			ColonPath{"README"},
+		},
 		"HEAD^": []Revisioner{
 			Ref("HEAD"),
 			CaretPath{1},
@@ -130,7 +152,12 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 		},
 		"v0.99.8^{}": []Revisioner{
 			Ref("v0.99.8"),
-			CaretType{"tag"},
+			CaretType{""},
+		},
+		"v0.99.8^{}:README": []Revisioner{
+			Ref("v0.99.8"),
+			CaretType{""},
+			ColonPath{"README"},
 		},
 		"HEAD^{/fix nasty bug}": []Revisioner{
 			Ref("HEAD"),
@@ -249,7 +276,7 @@ func (s *ParserSuite) TestParseCaretWithValidExpression(c *C) {
 	datas := map[string]Revisioner{
 		"":                    CaretPath{1},
 		"2":                   CaretPath{2},
-		"{}":                  CaretType{"tag"},
+		"{}":                  CaretType{""},
 		"{commit}":            CaretType{"commit"},
 		"{tree}":              CaretType{"tree"},
 		"{blob}":              CaretType{"blob"},
@@ -0,0 +1,329 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	fdiff "gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// logFilter selects the commits listed by Log, from the filters of its
+// LogOptions.
+type logFilter struct {
+	o     *LogOptions
+	paths []*pathspec
+}
+
+// newLogFilter returns the filter of o, with the given paths as pathspecs
+// besides its Paths, or nil if it does not filter any commit.
+func newLogFilter(o *LogOptions, paths ...string) *logFilter {
+	f := &logFilter{o: o}
+	for _, p := range append(paths, o.Paths...) {
+		f.paths = append(f.paths, newPathspec(p))
+	}
+
+	if o.Since == nil && o.Until == nil && o.Author == nil &&
+		o.Committer == nil && o.Grep == nil && !o.NoMerges &&
+		o.MinParents == 0 && o.MaxParents == nil && len(f.paths) == 0 &&
+		o.Pickaxe == "" && o.PickaxeRegexp == nil {
+		return nil
+	}
+
+	return f
+}
+
+// Match returns if the commit is listed, checking first the filters not
+// requiring to diff it against its parents.
+func (f *logFilter) Match(c *object.Commit) (bool, error) {
+	o := f.o
+	if o.Since != nil && c.Committer.When.Before(*o.Since) ||
+		o.Until != nil && c.Committer.When.After(*o.Until) {
+		return false, nil
+	}
+
+	parents := c.NumParents()
+	if o.NoMerges && parents > 1 || parents < o.MinParents ||
+		o.MaxParents != nil && parents > *o.MaxParents {
+		return false, nil
+	}
+
+	if o.Author != nil && !o.Author.MatchString(signatureString(&c.Author)) ||
+		o.Committer != nil && !o.Committer.MatchString(signatureString(&c.Committer)) ||
+		o.Grep != nil && !o.Grep.MatchString(c.Message) {
+		return false, nil
+	}
+
+	if len(f.paths) == 0 && o.Pickaxe == "" && o.PickaxeRegexp == nil {
+		return true, nil
+	}
+
+	return f.matchChanges(c)
+}
+
+// matchChanges returns if the commit changes a file matching the pathspecs
+// from each of its parents, as git log -- <pathspec> does, and, with a
+// pickaxe, if its changes match it. As in git, the changes of a merge commit
+// never match a pickaxe.
+func (f *logFilter) matchChanges(c *object.Commit) (bool, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return false, err
+	}
+
+	var parentTrees []*object.Tree
+	err = c.Parents().ForEach(func(p *object.Commit) error {
+		t, err := p.Tree()
+		parentTrees = append(parentTrees, t)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if len(parentTrees) == 0 {
+		parentTrees = append(parentTrees, nil)
+	}
+
+	pickaxe := f.o.Pickaxe != "" || f.o.PickaxeRegexp != nil
+	if pickaxe && len(parentTrees) > 1 {
+		return false, nil
+	}
+
+	for _, pt := range parentTrees {
+		changes, err := object.DiffTree(pt, tree)
+		if err != nil {
+			return false, err
+		}
+
+		matched, err := f.matchTreeChanges(changes, pickaxe)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (f *logFilter) matchTreeChanges(changes object.Changes, pickaxe bool) (bool, error) {
+	for _, ch := range changes {
+		if !f.matchPaths(ch.From.Name) && !f.matchPaths(ch.To.Name) {
+			continue
+		}
+
+		if !pickaxe {
+			return true, nil
+		}
+
+		matched, err := f.matchPickaxe(ch)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+// matchPaths returns if the path matches a pathspec, or if there are none.
+func (f *logFilter) matchPaths(path string) bool {
+	if path == "" {
+		return false
+	}
+
+	if len(f.paths) == 0 {
+		return true
+	}
+
+	for _, p := range f.paths {
+		if p.Match(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPickaxe returns if the change modifies the number of occurrences of
+// the Pickaxe string, as git log -S does, and adds or removes a line matching
+// the PickaxeRegexp, as git log -G does.
+func (f *logFilter) matchPickaxe(ch *object.Change) (bool, error) {
+	if f.o.Pickaxe != "" {
+		from, to, err := ch.Files()
+		if err != nil {
+			return false, err
+		}
+
+		fromCount, err := countOccurrences(from, f.o.Pickaxe)
+		if err != nil {
+			return false, err
+		}
+
+		toCount, err := countOccurrences(to, f.o.Pickaxe)
+		if err != nil || fromCount == toCount {
+			return false, err
+		}
+	}
+
+	if f.o.PickaxeRegexp == nil {
+		return true, nil
+	}
+
+	patch, err := ch.Patch()
+	if err != nil {
+		return false, err
+	}
+
+	for _, fp := range patch.FilePatches() {
+		if fp.IsBinary() {
+			continue
+		}
+
+		for _, chunk := range fp.Chunks() {
+			if chunk.Type() == fdiff.Equal {
+				continue
+			}
+
+			for _, line := range strings.Split(chunk.Content(), "\n") {
+				if f.o.PickaxeRegexp.MatchString(line) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func countOccurrences(file *object.File, s string) (int, error) {
+	if file == nil {
+		return 0, nil
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return 0, err
+	}
+
+	return strings.Count(content, s), nil
+}
+
+func signatureString(s *object.Signature) string {
+	return fmt.Sprintf("%s <%s>", s.Name, s.Email)
+}
+
+// pathspec is a path limiting the log, as the pathspecs of git log: a file, a
+// directory or a glob pattern, where * and ? also match a /.
+type pathspec struct {
+	path string
+	glob *regexp.Regexp
+}
+
+func newPathspec(path string) *pathspec {
+	p := &pathspec{path: strings.Trim(path, "/")}
+	if p.path == "." {
+		p.path = ""
+	}
+
+	if !strings.ContainsAny(p.path, "*?[") {
+		return p
+	}
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(p.path); i++ {
+		switch ch := p.path[i]; ch {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			if j := strings.IndexByte(p.path[i:], ']'); j > 1 {
+				re.WriteString(p.path[i : i+j+1])
+				i += j
+				continue
+			}
+
+			re.WriteString(`\[`)
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	re.WriteString("$")
+	if glob, err := regexp.Compile(re.String()); err == nil {
+		p.glob = glob
+	}
+
+	return p
+}
+
+// Match returns if the path is the one of the pathspec, is under its
+// directory or matches its glob pattern.
+func (p *pathspec) Match(path string) bool {
+	if path == p.path || p.path == "" || strings.HasPrefix(path, p.path+"/") {
+		return true
+	}
+
+	return p.glob != nil && p.glob.MatchString(path)
+}
+
+// filterCommitIter lists the commits of an iterator matching a logFilter.
+// With stopAtSince, the iterator is sorted by committer time and the walk
+// stops at the first commit older than the Since option of the filter.
+type filterCommitIter struct {
+	object.CommitIter
+	filter      *logFilter
+	stopAtSince bool
+}
+
+func newFilterCommitIter(iter object.CommitIter, f *logFilter, stopAtSince bool) object.CommitIter {
+	return &filterCommitIter{CommitIter: iter, filter: f, stopAtSince: stopAtSince}
+}
+
+func (it *filterCommitIter) Next() (*object.Commit, error) {
+	for {
+		c, err := it.CommitIter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		since := it.filter.o.Since
+		if it.stopAtSince && since != nil && c.Committer.When.Before(*since) {
+			return nil, io.EOF
+		}
+
+		matched, err := it.filter.Match(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			return c, nil
+		}
+	}
+}
+
+func (it *filterCommitIter) ForEach(cb func(*object.Commit) error) error {
+	for {
+		c, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cb(c)
+		if err == storer.ErrStop {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"regexp"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	. "gopkg.in/check.v1"
+)
+
+type LogFilterSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&LogFilterSuite{})
+
+func (s *LogFilterSuite) logHashes(c *C, r *Repository, o *LogOptions) []string {
+	iter, err := r.Log(o)
+	c.Assert(err, IsNil)
+
+	var hashes []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	return hashes
+}
+
+func (s *LogFilterSuite) TestFilters(c *C) {
+	r := openBasicRepository(c)
+
+	since := time.Date(2015, 3, 31, 11, 50, 0, 0, time.UTC)
+	zero, one := 0, 1
+	for _, t := range []struct {
+		name     string
+		o        *LogOptions
+		expected []string
+	}{
+		{"since", &LogOptions{Since: &since}, []string{"6ecf0ef", "918c48b", "af2d6a6"}},
+		{"since by committer time", &LogOptions{Since: &since, Order: LogOrderCommitterTime}, []string{"6ecf0ef", "918c48b", "af2d6a6"}},
+		{"until", &LogOptions{Until: &since, Order: LogOrderCommitterTime}, []string{"1669dce", "a5b8b09", "35e8510", "b8e471f", "b029517"}},
+		{"author", &LogOptions{Author: regexp.MustCompile("Daniel")}, []string{"b8e471f"}},
+		{"author email", &LogOptions{Author: regexp.MustCompile("<daniel@")}, []string{"b8e471f"}},
+		{"committer", &LogOptions{Committer: regexp.MustCompile("Cuadros <"), Order: LogOrderCommitterTime}, []string{"a5b8b09", "b029517"}},
+		{"grep", &LogOptions{Grep: regexp.MustCompile("^Merge"), Order: LogOrderCommitterTime}, []string{"1669dce", "a5b8b09"}},
+		{"first parent", &LogOptions{FirstParent: true}, []string{"6ecf0ef", "918c48b", "af2d6a6", "1669dce", "35e8510", "b029517"}},
+		{"first parent since", &LogOptions{FirstParent: true, Since: &since}, []string{"6ecf0ef", "918c48b", "af2d6a6"}},
+		{"no merges", &LogOptions{NoMerges: true, Order: LogOrderCommitterTime}, []string{"6ecf0ef", "918c48b", "af2d6a6", "35e8510", "b8e471f", "b029517"}},
+		{"min parents", &LogOptions{MinParents: 2, Order: LogOrderCommitterTime}, []string{"1669dce", "a5b8b09"}},
+		{"max parents", &LogOptions{MaxParents: &zero}, []string{"b029517"}},
+		{"min and max parents", &LogOptions{MinParents: 1, MaxParents: &one, Author: regexp.MustCompile("Daniel")}, []string{"b8e471f"}},
+		{"paths", &LogOptions{Paths: []string{"go", "vendor/"}}, []string{"6ecf0ef", "918c48b"}},
+		{"glob", &LogOptions{Paths: []string{"*.go"}}, []string{"6ecf0ef", "918c48b"}},
+		{"file through merges", &LogOptions{Paths: []string{"CHANGELOG"}}, []string{"b8e471f"}},
+		{"directory", &LogOptions{Paths: []string{"json"}}, []string{"af2d6a6"}},
+		{"pickaxe", &LogOptions{Pickaxe: "func main"}, []string{"6ecf0ef"}},
+		{"pickaxe regexp", &LogOptions{PickaxeRegexp: regexp.MustCompile(`^\s*"`)}, []string{"918c48b", "af2d6a6"}},
+		{"pickaxe with paths", &LogOptions{PickaxeRegexp: regexp.MustCompile(`^\s*"`), Paths: []string{"json/short.json"}}, []string{"af2d6a6"}},
+	} {
+		c.Assert(s.logHashes(c, r, t.o), DeepEquals, t.expected, Commentf(t.name))
+	}
+}
+
+func (s *LogFilterSuite) TestFiltersWithRevisions(c *C) {
+	r := openBasicRepository(c)
+
+	c.Assert(s.logHashes(c, r, &LogOptions{
+		Revisions:   []string{"HEAD", "^HEAD~3^2"},
+		FirstParent: true,
+		Boundary:    true,
+	}), DeepEquals, []string{"6ecf0ef", "918c48b", "af2d6a6", "1669dce", "35e8510", "b029517"})
+
+	c.Assert(s.logHashes(c, r, &LogOptions{
+		Revisions: []string{"branch"},
+		NoMerges:  true,
+		Paths:     []string{"*.json", "CHANGELOG"},
+	}), DeepEquals, []string{"af2d6a6", "b8e471f"})
+}
+
+func (s *LogFilterSuite) TestNegativeParents(c *C) {
+	r := openBasicRepository(c)
+
+	_, err := r.Log(&LogOptions{MinParents: -1})
+	c.Assert(err, Equals, ErrNegativeParents)
+}
+
+func (s *LogFilterSuite) TestPathspec(c *C) {
+	for _, t := range []struct {
+		spec, path string
+		match      bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foo/bar", true},
+		{"foo/", "foo/bar", true},
+		{"foo", "foobar", false},
+		{".", "foo/bar", true},
+		{"*.go", "foo/bar.go", true},
+		{"foo/*.go", "foo/bar/baz.go", true},
+		{"foo/*.go", "bar/baz.go", false},
+		{"fo?", "foo", true},
+		{"[fb]oo", "boo", true},
+		{"[fb]oo", "zoo", false},
+	} {
+		c.Assert(newPathspec(t.spec).Match(t.path), Equals, t.match, Commentf("%s %s", t.spec, t.path))
+	}
+}
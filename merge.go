@@ -0,0 +1,744 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	stdioutil "io/ioutil"
+	"path"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/binary"
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+var (
+	// ErrMergeConflicts is returned when a merge can not be completed
+	// automatically, the conflicted paths are recorded in the index as
+	// unmerged entries and the worktree files contain conflict markers.
+	ErrMergeConflicts = errors.New("automatic merge failed, fix conflicts and commit the result")
+	// ErrUnmergedPaths is returned when an operation is not possible because
+	// the index contains unmerged entries.
+	ErrUnmergedPaths = errors.New("index contains unmerged paths")
+)
+
+// mergeHead is the reference written when a merge stops with conflicts, it
+// points to the commit being merged and is used as second parent by Commit.
+const mergeHead plumbing.ReferenceName = "MERGE_HEAD"
+
+// Merge incorporates the changes from the commit given in the MergeOptions
+// into the current branch. The merge base between HEAD and the commit is used
+// to perform a three-way merge of both trees, if the merge is clean a merge
+// commit with HEAD and the merged commit as parents is created and its hash
+// is returned.
+//
+// If HEAD can be fast-forwarded to the merged commit no merge commit is
+// created, unless NoFastForward is used. With FastForwardOnly the merge is
+// refused with ErrNonFastForwardUpdate when HEAD can not be fast-forwarded. If
+// the commit is already reachable from HEAD NoErrAlreadyUpToDate is returned.
+//
+// When the merge results in conflicts, the resolved changes are written to the
+// index, the conflicted paths are recorded as unmerged entries (stages 1, 2
+// and 3), the worktree files contain conflict markers and ErrMergeConflicts
+// is returned. Once the conflicts are resolved and added, Commit creates the
+// merge commit.
+func (w *Worktree) Merge(opts *MergeOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	theirs, err := w.getCommitFromMergeOptions(opts)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := w.r.Head()
+	msg := "merge " + opts.label() + ": "
+	if err == plumbing.ErrReferenceNotFound {
+		return theirs.Hash, w.fastForward(theirs.Hash, opts.Committer, msg+"Fast-forward")
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ours, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	isFastForward := len(bases) == 1 && bases[0].Hash == ours.Hash
+	switch {
+	case len(bases) == 1 && bases[0].Hash == theirs.Hash:
+		return ours.Hash, NoErrAlreadyUpToDate
+	case isFastForward && opts.FastForward != NoFastForward:
+		return theirs.Hash, w.fastForward(theirs.Hash, opts.Committer, msg+"Fast-forward")
+	case !isFastForward && opts.FastForward == FastForwardOnly:
+		return plumbing.ZeroHash, ErrNonFastForwardUpdate
+	}
+
+	tree, conflicts, err := w.mergeCommits(bases, ours, theirs, "HEAD", opts.label())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.checkoutMergeResult(tree, conflicts); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(conflicts) != 0 {
+		ref := plumbing.NewHashReference(mergeHead, theirs.Hash)
+		if err := w.r.Storer.SetReference(ref); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return plumbing.ZeroHash, ErrMergeConflicts
+	}
+
+	commit, err := w.buildCommitObject(opts.Message, &CommitOptions{
+		Author:    opts.Author,
+		Committer: opts.Committer,
+		Parents:   []plumbing.Hash{ours.Hash, theirs.Hash},
+		SignKey:   opts.SignKey,
+	}, tree.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commit, w.updateHEAD(commit, opts.Committer, msg+"Merge made by three-way merge.")
+}
+
+func (w *Worktree) getCommitFromMergeOptions(opts *MergeOptions) (*object.Commit, error) {
+	h := opts.CommitHash
+	if opts.ReferenceName != "" {
+		ref, err := w.r.Reference(opts.ReferenceName, true)
+		if err != nil {
+			return nil, err
+		}
+
+		h = ref.Hash()
+	}
+
+	h, err := w.r.resolveToCommitHash(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.r.CommitObject(h)
+}
+
+// fastForward moves HEAD to the given commit and updates the index and the
+// worktree, the untracked files are kept. The update of HEAD is recorded in
+// the reflog with the given signature and message.
+func (w *Worktree) fastForward(commit plumbing.Hash, sig *object.Signature, msg string) error {
+	unstaged, err := w.containsUnstagedChanges()
+	if err != nil {
+		return err
+	}
+
+	if unstaged {
+		return ErrUnstagedChanges
+	}
+
+	t, err := w.getTreeFromCommitHash(commit)
+	if err != nil {
+		return err
+	}
+
+	if err := w.updateHEAD(commit, sig, msg); err != nil {
+		return err
+	}
+
+	return w.checkoutMergeResult(t, nil)
+}
+
+// checkCleanForMerge returns an error if the index or the tracked files of the
+// worktree contain changes, untracked files are allowed.
+func (w *Worktree) checkCleanForMerge() error {
+	s, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, fs := range s {
+		if fs.Staging == UpdatedButUnmerged {
+			return ErrUnmergedPaths
+		}
+
+		if fs.Worktree == Untracked || fs.Worktree == Unmodified && fs.Staging == Unmodified {
+			continue
+		}
+
+		return ErrWorktreeNotClean
+	}
+
+	return nil
+}
+
+// mergeCommits merges the trees of ours and theirs using the given merge
+// bases, the resulting tree is returned along with the conflicts found.
+func (w *Worktree) mergeCommits(
+	bases []*object.Commit, ours, theirs *object.Commit, oursLabel, theirsLabel string,
+) (*object.Tree, []*mergeConflict, error) {
+	base, err := mergeBasesTree(w.r.Storer, bases)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := newTreeMerger(w.r.Storer, oursLabel, theirsLabel)
+	tree, err := m.Merge(base, oursTree, theirsTree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tree, m.conflicts, nil
+}
+
+// checkoutMergeResult updates the index and the worktree to the given merged
+// tree and records the conflicts as unmerged entries in the index. The
+// untracked files are kept.
+func (w *Worktree) checkoutMergeResult(t *object.Tree, conflicts []*mergeConflict) error {
+	untracked, err := w.untrackedPaths()
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(t); err != nil {
+		return err
+	}
+
+	if err := w.resetWorktreeKeeping(context.Background(), t, untracked); err != nil {
+		return err
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range conflicts {
+		removeIndexEntries(idx, c.Name)
+		c.addToIndex(idx)
+	}
+
+	return w.r.Storer.SetIndex(idx)
+}
+
+// unmergedPaths returns the paths with unmerged entries in the index.
+func (w *Worktree) unmergedPaths() ([]string, error) {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, e := range idx.Entries {
+		if e.Stage == index.Merged || seen[e.Name] {
+			continue
+		}
+
+		seen[e.Name] = true
+		paths = append(paths, e.Name)
+	}
+
+	return paths, nil
+}
+
+// removeMergeState removes the references recording a merge, cherry-pick or
+// revert stopped with conflicts.
+func (w *Worktree) removeMergeState() error {
+	for _, name := range []plumbing.ReferenceName{mergeHead, cherryPickHead, revertHead} {
+		if err := removeReferenceIfExists(w.r.Storer, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeReferenceIfExists removes the given reference, if it exists.
+func removeReferenceIfExists(s storer.ReferenceStorer, name plumbing.ReferenceName) error {
+	_, err := s.Reference(name)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.RemoveReference(name)
+}
+
+// removeIndexEntries removes all the entries, in any stage, of the given path.
+func removeIndexEntries(idx *index.Index, name string) {
+	for {
+		if _, err := idx.Remove(name); err != nil {
+			return
+		}
+	}
+}
+
+// hasUnmergedEntries returns true if any of the index entries is unmerged.
+func hasUnmergedEntries(idx *index.Index) bool {
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeBasesTree returns the tree to be used as common ancestor in a three-way
+// merge. When more than one merge base exists, they are merged into a virtual
+// common ancestor, in the same way that the recursive strategy of git does.
+func mergeBasesTree(s storer.EncodedObjectStorer, bases []*object.Commit) (*object.Tree, error) {
+	if len(bases) == 0 {
+		return nil, nil
+	}
+
+	tree, err := bases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range bases[1:] {
+		ancestors, err := bases[0].MergeBase(other)
+		if err != nil {
+			return nil, err
+		}
+
+		ancestor, err := mergeBasesTree(s, ancestors)
+		if err != nil {
+			return nil, err
+		}
+
+		otherTree, err := other.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		m := newTreeMerger(s, "Temporary merge branch 1", "Temporary merge branch 2")
+		tree, err = m.Merge(ancestor, tree, otherTree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// mergeConflict is a path that could not be merged automatically, any of the
+// entries can be nil if the path does not exist in that version.
+type mergeConflict struct {
+	Name   string
+	Base   *object.TreeEntry
+	Ours   *object.TreeEntry
+	Theirs *object.TreeEntry
+}
+
+func (c *mergeConflict) addToIndex(idx *index.Index) {
+	stages := []struct {
+		entry *object.TreeEntry
+		stage index.Stage
+	}{
+		{c.Base, index.AncestorMode},
+		{c.Ours, index.OurMode},
+		{c.Theirs, index.TheirMode},
+	}
+
+	for _, s := range stages {
+		if s.entry == nil {
+			continue
+		}
+
+		e := idx.Add(c.Name)
+		e.Hash = s.entry.Hash
+		e.Mode = s.entry.Mode
+		e.Stage = s.stage
+	}
+}
+
+// treeMerger performs a three-way merge of trees, writing the resulting trees
+// and blobs into the storer. Subtrees are only traversed when they differ on
+// both sides, the paths that can not be merged are collected as conflicts.
+type treeMerger struct {
+	s           storer.EncodedObjectStorer
+	oursLabel   string
+	theirsLabel string
+
+	conflicts []*mergeConflict
+}
+
+func newTreeMerger(s storer.EncodedObjectStorer, oursLabel, theirsLabel string) *treeMerger {
+	return &treeMerger{
+		s:           s,
+		oursLabel:   oursLabel,
+		theirsLabel: theirsLabel,
+	}
+}
+
+// Merge merges the changes from base to ours and from base to theirs, any of
+// the trees can be nil. The merged tree is returned, the conflicted paths
+// contain the version with conflict markers when the content is text, or the
+// ours version otherwise.
+func (m *treeMerger) Merge(base, ours, theirs *object.Tree) (*object.Tree, error) {
+	h, err := m.mergeTree("", base, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.IsZero() {
+		if h, err = m.storeTree(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return object.GetTree(m.s, h)
+}
+
+func (m *treeMerger) mergeTree(dir string, base, ours, theirs *object.Tree) (plumbing.Hash, error) {
+	b, o, t := treeHash(base), treeHash(ours), treeHash(theirs)
+	switch {
+	case o == t, b == t:
+		return o, nil
+	case b == o:
+		return t, nil
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, tree := range []*object.Tree{base, ours, theirs} {
+		if tree == nil {
+			continue
+		}
+
+		for _, e := range tree.Entries {
+			if !seen[e.Name] {
+				seen[e.Name] = true
+				names = append(names, e.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	var entries []object.TreeEntry
+	for _, name := range names {
+		e, err := m.mergeEntry(path.Join(dir, name),
+			treeEntry(base, name), treeEntry(ours, name), treeEntry(theirs, name),
+		)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if e != nil {
+			entries = append(entries, object.TreeEntry{
+				Name: name,
+				Mode: e.Mode,
+				Hash: e.Hash,
+			})
+		}
+	}
+
+	if len(entries) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+
+	return m.storeTree(entries)
+}
+
+func (m *treeMerger) mergeEntry(name string, base, ours, theirs *object.TreeEntry) (*object.TreeEntry, error) {
+	switch {
+	case sameTreeEntry(ours, theirs), sameTreeEntry(base, theirs):
+		return ours, nil
+	case sameTreeEntry(base, ours):
+		return theirs, nil
+	}
+
+	if isDirEntry(ours) || isDirEntry(theirs) {
+		return m.mergeSubtree(name, base, ours, theirs)
+	}
+
+	// a directory replaced by files in both sides, the files are merged as
+	// if they were added
+	if isDirEntry(base) {
+		return m.mergeEntry(name, nil, ours, theirs)
+	}
+
+	return m.mergeFile(name, base, ours, theirs)
+}
+
+// mergeSubtree merges a path that is a directory in ours or theirs. A file in
+// the other side is a directory/file conflict, the directory is kept and the
+// file is recorded as conflicted.
+func (m *treeMerger) mergeSubtree(name string, base, ours, theirs *object.TreeEntry) (*object.TreeEntry, error) {
+	c := &mergeConflict{Name: name}
+	if base != nil && !isDirEntry(base) {
+		c.Base = base
+	}
+
+	if ours != nil && !isDirEntry(ours) {
+		c.Ours = ours
+	}
+
+	if theirs != nil && !isDirEntry(theirs) {
+		c.Theirs = theirs
+	}
+
+	if c.Ours != nil || c.Theirs != nil {
+		m.conflicts = append(m.conflicts, c)
+	}
+
+	var trees [3]*object.Tree
+	for i, e := range []*object.TreeEntry{base, ours, theirs} {
+		if !isDirEntry(e) {
+			continue
+		}
+
+		t, err := object.GetTree(m.s, e.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		trees[i] = t
+	}
+
+	h, err := m.mergeTree(name, trees[0], trees[1], trees[2])
+	if err != nil || h.IsZero() {
+		return nil, err
+	}
+
+	return &object.TreeEntry{Mode: filemode.Dir, Hash: h}, nil
+}
+
+func (m *treeMerger) mergeFile(name string, base, ours, theirs *object.TreeEntry) (*object.TreeEntry, error) {
+	c := &mergeConflict{Name: name, Base: base, Ours: ours, Theirs: theirs}
+
+	// modified in one side and deleted in the other one
+	if ours == nil || theirs == nil {
+		m.conflicts = append(m.conflicts, c)
+		if ours != nil {
+			return ours, nil
+		}
+
+		return theirs, nil
+	}
+
+	mode, modeClean := mergeFileMode(base, ours, theirs)
+	hash, contentClean, err := m.mergeBlob(base, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !modeClean || !contentClean {
+		m.conflicts = append(m.conflicts, c)
+	}
+
+	return &object.TreeEntry{Mode: mode, Hash: hash}, nil
+}
+
+func mergeFileMode(base, ours, theirs *object.TreeEntry) (filemode.FileMode, bool) {
+	switch {
+	case ours.Mode == theirs.Mode:
+		return ours.Mode, true
+	case base != nil && base.Mode == ours.Mode:
+		return theirs.Mode, true
+	case base != nil && base.Mode == theirs.Mode:
+		return ours.Mode, true
+	}
+
+	return ours.Mode, false
+}
+
+// mergeBlob merges the content of the given entries, returns the hash of the
+// merged blob and true if the merge is clean.
+func (m *treeMerger) mergeBlob(base, ours, theirs *object.TreeEntry) (plumbing.Hash, bool, error) {
+	switch {
+	case ours.Hash == theirs.Hash:
+		return ours.Hash, true, nil
+	case base != nil && base.Hash == ours.Hash:
+		return theirs.Hash, true, nil
+	case base != nil && base.Hash == theirs.Hash:
+		return ours.Hash, true, nil
+	}
+
+	if !isMergeableMode(ours.Mode) || !isMergeableMode(theirs.Mode) {
+		return ours.Hash, false, nil
+	}
+
+	var b []byte
+	if base != nil && isMergeableMode(base.Mode) {
+		var err error
+		if b, err = m.readBlob(base.Hash); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+
+	o, err := m.readBlob(ours.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	t, err := m.readBlob(theirs.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	for _, content := range [][]byte{b, o, t} {
+		isBinary, err := binary.IsBinary(bytes.NewReader(content))
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+
+		if isBinary {
+			return ours.Hash, false, nil
+		}
+	}
+
+	merged, clean := diff.Merge(string(b), string(o), string(t), m.oursLabel, m.theirsLabel)
+	h, err := m.storeBlob([]byte(merged))
+	return h, clean, err
+}
+
+func (m *treeMerger) readBlob(h plumbing.Hash) (content []byte, err error) {
+	b, err := object.GetBlob(m.s, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return stdioutil.ReadAll(r)
+}
+
+func (m *treeMerger) storeBlob(content []byte) (h plumbing.Hash, err error) {
+	obj := m.s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return m.s.SetEncodedObject(obj)
+}
+
+func (m *treeMerger) storeTree(entries []object.TreeEntry) (plumbing.Hash, error) {
+	sort.Sort(sortableEntries(entries))
+
+	t := &object.Tree{Entries: entries}
+	obj := m.s.NewEncodedObject()
+	if err := t.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return m.s.SetEncodedObject(obj)
+}
+
+func treeHash(t *object.Tree) plumbing.Hash {
+	if t == nil {
+		return plumbing.ZeroHash
+	}
+
+	return t.Hash
+}
+
+func treeEntry(t *object.Tree, name string) *object.TreeEntry {
+	if t == nil {
+		return nil
+	}
+
+	for i := range t.Entries {
+		if t.Entries[i].Name == name {
+			return &t.Entries[i]
+		}
+	}
+
+	return nil
+}
+
+func sameTreeEntry(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+func isDirEntry(e *object.TreeEntry) bool {
+	return e != nil && e.Mode == filemode.Dir
+}
+
+func isMergeableMode(m filemode.FileMode) bool {
+	return m == filemode.Regular || m == filemode.Executable || m == filemode.Deprecated
+}
+
+func (o *MergeOptions) label() string {
+	if o.ReferenceName != "" {
+		return o.ReferenceName.Short()
+	}
+
+	return o.CommitHash.String()
+}
+
+func (o *MergeOptions) defaultMessage() string {
+	name := o.ReferenceName
+	switch {
+	case name == "":
+		return fmt.Sprintf("Merge commit '%s'\n", o.CommitHash)
+	case name.IsBranch():
+		return fmt.Sprintf("Merge branch '%s'\n", name.Short())
+	case name.IsRemote():
+		return fmt.Sprintf("Merge remote-tracking branch '%s'\n", name.Short())
+	case name.IsTag():
+		return fmt.Sprintf("Merge tag '%s'\n", name.Short())
+	}
+
+	return fmt.Sprintf("Merge '%s'\n", name.Short())
+}
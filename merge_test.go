@@ -0,0 +1,303 @@
+package git
+
+import (
+	"io/ioutil"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type MergeSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&MergeSuite{})
+
+// newMergeRepository returns a repository with a base commit in master and a
+// branch called feature pointing to it.
+func newMergeRepository(c *C, files map[string]string) (*Repository, *Worktree, billy.Filesystem) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, files, "base\n")
+
+	err = w.Checkout(&CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName("feature"),
+		Create: true,
+	})
+	c.Assert(err, IsNil)
+
+	return r, w, fs
+}
+
+// commitFiles writes the given files and commits them, an empty content
+// removes the file.
+func commitFiles(c *C, w *Worktree, files map[string]string, msg string) plumbing.Hash {
+	for name, content := range files {
+		if content == "" {
+			_, err := w.Remove(name)
+			c.Assert(err, IsNil)
+			continue
+		}
+
+		err := util.WriteFile(w.Filesystem, name, []byte(content), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	h, err := w.Commit(msg, &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func readWorktreeFile(fs billy.Filesystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func checkoutBranch(c *C, w *Worktree, name string) {
+	err := w.Checkout(&CheckoutOptions{Branch: plumbing.NewBranchReferenceName(name)})
+	c.Assert(err, IsNil)
+}
+
+func (s *MergeSuite) TestMergeInvalidOptions(c *C) {
+	_, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	_, err := w.Merge(&MergeOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrMissingMergeCommit)
+
+	_, err = w.Merge(&MergeOptions{ReferenceName: "refs/heads/feature"})
+	c.Assert(err, Equals, ErrMissingAuthor)
+
+	_, err = w.Merge(&MergeOptions{
+		ReferenceName: "refs/heads/feature",
+		CommitHash:    plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrHashOrReference)
+}
+
+func (s *MergeSuite) TestMergeClean(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{
+		"foo":     "a\nb\nc\nd\ne\n",
+		"bar/baz": "baz\n",
+	})
+
+	feature := commitFiles(c, w, map[string]string{
+		"foo":     "a\nb\nc\nd\nE\n",
+		"bar/qux": "qux\n",
+	}, "feature\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{
+		"foo":     "A\nb\nc\nd\ne\n",
+		"bar/baz": "",
+	}, "master\n")
+
+	hash, err := w.Merge(&MergeOptions{
+		ReferenceName: plumbing.NewBranchReferenceName("feature"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, hash)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master, feature})
+	c.Assert(commit.Message, Equals, "Merge branch 'feature'\n")
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "A\nb\nc\nd\nE\n")
+
+	_, err = fs.Stat("bar/baz")
+	c.Assert(err, NotNil)
+
+	content, err = readWorktreeFile(fs, "bar/qux")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "qux\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *MergeSuite) TestMergeFastForward(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	feature := commitFiles(c, w, map[string]string{"foo": "bar\n"}, "feature\n")
+	checkoutBranch(c, w, "master")
+
+	hash, err := w.Merge(&MergeOptions{
+		CommitHash: feature,
+		Author:     defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hash, Equals, feature)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, feature)
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "bar\n")
+
+	_, err = w.Merge(&MergeOptions{
+		CommitHash: feature,
+		Author:     defaultSignature(),
+	})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *MergeSuite) TestMergeConflicts(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{
+		"foo": "a\nb\nc\n",
+		"bar": "bar\n",
+	})
+
+	feature := commitFiles(c, w, map[string]string{
+		"foo": "a\nfeature\nc\n",
+		"bar": "",
+	}, "feature\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{
+		"foo": "a\nmaster\nc\n",
+		"bar": "master\n",
+	}, "master\n")
+
+	hash, err := w.Merge(&MergeOptions{
+		ReferenceName: plumbing.NewBranchReferenceName("feature"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrMergeConflicts)
+	c.Assert(hash.IsZero(), Equals, true)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, master)
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals,
+		"a\n<<<<<<< HEAD\nmaster\n=======\nfeature\n>>>>>>> feature\nc\n",
+	)
+
+	content, err = readWorktreeFile(fs, "bar")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "master\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	stages := make(map[string][]index.Stage)
+	for _, e := range idx.Entries {
+		stages[e.Name] = append(stages[e.Name], e.Stage)
+	}
+
+	c.Assert(stages["foo"], DeepEquals, []index.Stage{
+		index.AncestorMode, index.OurMode, index.TheirMode,
+	})
+	c.Assert(stages["bar"], DeepEquals, []index.Stage{
+		index.AncestorMode, index.OurMode,
+	})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, UpdatedButUnmerged)
+	c.Assert(status.File("bar").Staging, Equals, UpdatedButUnmerged)
+
+	_, err = w.Commit("merge\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, Equals, ErrUnmergedPaths)
+
+	err = util.WriteFile(fs, "foo", []byte("a\nresolved\nc\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	_, err = w.Remove("bar")
+	c.Assert(err, IsNil)
+
+	hash, err = w.Commit("merge\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master, feature})
+
+	_, err = r.Reference(mergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *MergeSuite) TestMergeAbortWithHardReset(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"foo": "feature\n"}, "feature\n")
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "master\n"}, "master\n")
+
+	_, err := w.Merge(&MergeOptions{
+		ReferenceName: plumbing.NewBranchReferenceName("feature"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrMergeConflicts)
+
+	err = w.Reset(&ResetOptions{Mode: HardReset, Commit: master})
+	c.Assert(err, IsNil)
+
+	_, err = r.Reference(mergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "master\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *MergeSuite) TestMergeWorktreeNotClean(c *C) {
+	_, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "feature\n")
+	checkoutBranch(c, w, "master")
+	commitFiles(c, w, map[string]string{"qux": "qux\n"}, "master\n")
+
+	err := util.WriteFile(fs, "foo", []byte("modified\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Merge(&MergeOptions{
+		ReferenceName: plumbing.NewBranchReferenceName("feature"),
+		Author:        defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrWorktreeNotClean)
+}
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/negotiator"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
@@ -47,6 +50,10 @@ type CloneOptions struct {
 	NoCheckout bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// Filter requests a partial clone, the objects not matching the filter
+	// are omitted (e.g. packp.FilterBlobNone()) and the remote is configured
+	// as a promisor remote, from which they are fetched when needed.
+	Filter packp.Filter
 	// RecurseSubmodules after the clone is created, initialize all submodules
 	// within, using their default settings. This option is ignored if the
 	// cloned repository does not have a worktree.
@@ -58,6 +65,11 @@ type CloneOptions struct {
 	// Tags describe how the tags will be fetched from the remote repository,
 	// by default is AllTags.
 	Tags TagMode
+	// ProtocolVersion is the version of the protocol requested to the server,
+	// by default the version 0. The version 2 lists only the references
+	// matching the refspecs, a server not speaking it answers with the
+	// version 0, which is used instead.
+	ProtocolVersion transport.ProtocolVersion
 }
 
 // Validate validates the fields and sets the default values.
@@ -78,7 +90,7 @@ func (o *CloneOptions) Validate() error {
 		o.Tags = AllTags
 	}
 
-	return nil
+	return o.Filter.Validate()
 }
 
 // PullOptions describes how a pull should be performed.
@@ -103,8 +115,45 @@ type PullOptions struct {
 	// Force allows the pull to update a local branch even when the remote
 	// branch does not descend from it.
 	Force bool
+	// ProtocolVersion is the version of the protocol requested to the server,
+	// by default the version 0. The version 2 lists only the references
+	// matching the refspecs, a server not speaking it answers with the
+	// version 0, which is used instead.
+	ProtocolVersion transport.ProtocolVersion
+	// Strategy defines how the fetched changes are integrated into the
+	// current branch, by default PullFastForwardOnly.
+	Strategy PullStrategy
+	// Author is the author's signature of the merge commit, it is required by
+	// the PullMerge and PullNoFastForward strategies.
+	Author *object.Signature
+	// Committer is the committer's signature of the merge commit and of the
+	// rebased commits, it is required by the PullRebase strategy. If Committer
+	// is nil the Author signature is used.
+	Committer *object.Signature
 }
 
+// PullStrategy defines how a pull integrates the fetched changes into the
+// current branch.
+type PullStrategy int8
+
+const (
+	// PullFastForwardOnly only updates the current branch when it can be
+	// fast-forwarded, like `git pull --ff-only`. ErrNonFastForwardUpdate is
+	// returned otherwise.
+	PullFastForwardOnly PullStrategy = iota
+	// PullMerge fast-forwards the current branch when possible, otherwise the
+	// fetched changes are merged in a new merge commit, like `git pull
+	// --no-rebase`.
+	PullMerge
+	// PullNoFastForward always merges the fetched changes in a new merge
+	// commit, like `git pull --no-ff`.
+	PullNoFastForward
+	// PullRebase replays the local commits on top of the fetched ones, like
+	// `git pull --rebase`. A rebase stopped on conflicts is left in progress,
+	// see Repository.Rebase.
+	PullRebase
+)
+
 // Validate validates the fields and sets the default values.
 func (o *PullOptions) Validate() error {
 	if o.RemoteName == "" {
@@ -115,6 +164,21 @@ func (o *PullOptions) Validate() error {
 		o.ReferenceName = plumbing.HEAD
 	}
 
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	switch o.Strategy {
+	case PullMerge, PullNoFastForward:
+		if o.Author == nil {
+			return ErrMissingAuthor
+		}
+	case PullRebase:
+		if o.Committer == nil {
+			return ErrMissingCommitter
+		}
+	}
+
 	return nil
 }
 
@@ -133,6 +197,11 @@ const (
 	NoTags
 )
 
+var (
+	ErrNegativeDepth           = errors.New("Depth and Deepen can't be negative")
+	ErrShallowOptionsExclusive = errors.New("Depth, Deepen, Unshallow and ShallowSince or ShallowExclude are mutually exclusive")
+)
+
 // FetchOptions describes how a fetch should be performed
 type FetchOptions struct {
 	// Name of the remote to fetch from. Defaults to origin.
@@ -141,6 +210,23 @@ type FetchOptions struct {
 	// Depth limit fetching to the specified number of commits from the tip of
 	// each remote branch history.
 	Depth int
+	// Deepen deepens a shallow repository by the specified number of commits
+	// from its current shallow boundary, instead of the tip of each remote
+	// branch history.
+	Deepen int
+	// ShallowSince limits fetching to the commits more recent than the given
+	// time.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from the
+	// given remote branches or tags.
+	ShallowExclude []string
+	// Unshallow fetches the whole history of a shallow repository, making it
+	// complete. It does nothing on a complete repository.
+	Unshallow bool
+	// Filter requests a partial fetch, the objects not matching the filter
+	// are omitted and the remote becomes a promisor remote. By default, the
+	// partial clone filter of a promisor remote is used.
+	Filter packp.Filter
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
 	// Progress is where the human readable information sent by the server is
@@ -153,6 +239,15 @@ type FetchOptions struct {
 	// Force allows the fetch to update a local branch even when the remote
 	// branch does not descend from it.
 	Force bool
+	// NegotiationAlgorithm is the algorithm choosing the haves sent to the
+	// server, when it negotiates in multiple rounds. By default, the
+	// consecutive one.
+	NegotiationAlgorithm negotiator.Algorithm
+	// ProtocolVersion is the version of the protocol requested to the server,
+	// by default the version 0. The version 2 lists only the references
+	// matching the refspecs, a server not speaking it answers with the
+	// version 0, which is used instead.
+	ProtocolVersion transport.ProtocolVersion
 }
 
 // Validate validates the fields and sets the default values.
@@ -171,6 +266,40 @@ func (o *FetchOptions) Validate() error {
 		}
 	}
 
+	if err := o.NegotiationAlgorithm.Validate(); err != nil {
+		return err
+	}
+
+	if err := o.validateShallow(); err != nil {
+		return err
+	}
+
+	return o.Filter.Validate()
+}
+
+// validateShallow checks that only one way to move the shallow boundary is
+// used, the server can't combine them.
+func (o *FetchOptions) validateShallow() error {
+	if o.Depth < 0 || o.Deepen < 0 {
+		return ErrNegativeDepth
+	}
+
+	var n int
+	for _, set := range []bool{
+		o.Depth != 0,
+		o.Deepen != 0,
+		o.Unshallow,
+		!o.ShallowSince.IsZero() || len(o.ShallowExclude) != 0,
+	} {
+		if set {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return ErrShallowOptionsExclusive
+	}
+
 	return nil
 }
 
@@ -186,6 +315,11 @@ type PushOptions struct {
 	// Progress is where the human readable information sent by the server is
 	// stored, if nil nothing is stored.
 	Progress sideband.Progress
+	// Atomic requests the server to update all the references or none of
+	// them, if any of the updates is rejected.
+	Atomic bool
+	// Options are the push options sent to the server, passed to its hooks.
+	Options []string
 }
 
 // Validate validates the fields and sets the default values.
@@ -266,6 +400,41 @@ func (o *CheckoutOptions) Validate() error {
 	return nil
 }
 
+// AddWorktreeOptions describes how a linked worktree should be added.
+type AddWorktreeOptions struct {
+	// Name of the worktree in the worktrees directory of the repository, by
+	// default the base name of its path.
+	Name string
+	// Branch to be checked out in the worktree. If Branch and Hash are
+	// empty, a branch named after the base name of the path is checked out,
+	// and created from HEAD if it does not exist.
+	Branch plumbing.ReferenceName
+	// Hash is the hash of the commit to be checked out. If used without
+	// Create, HEAD will be in detached mode.
+	Hash plumbing.Hash
+	// Create a new branch named Branch and start it at Hash, or at HEAD if
+	// Hash is empty.
+	Create bool
+	// Force checks out Branch even if it is checked out by another worktree.
+	Force bool
+	// Lock the worktree once added, with the optional LockReason.
+	Lock       bool
+	LockReason string
+}
+
+// Validate validates the fields and sets the default values.
+func (o *AddWorktreeOptions) Validate() error {
+	if !o.Create && !o.Hash.IsZero() && o.Branch != "" {
+		return ErrBranchHashExclusive
+	}
+
+	if o.Create && o.Branch == "" {
+		return ErrCreateRequiresBranch
+	}
+
+	return nil
+}
+
 // ResetMode defines the mode of a reset operation.
 type ResetMode int8
 
@@ -323,6 +492,12 @@ const (
 	LogOrderDFSPost
 	LogOrderBSF
 	LogOrderCommitterTime
+	// LogOrderTopo never lists a commit before all its children, keeping
+	// together the commits of a line of history, as `git log --topo-order`.
+	LogOrderTopo
+	// LogOrderAuthorDate never lists a commit before all its children, the
+	// others being listed by author time, as `git log --author-date-order`.
+	LogOrderAuthorDate
 )
 
 // LogOptions describes how a log action should be performed.
@@ -335,20 +510,163 @@ type LogOptions struct {
 	// The default traversal algorithm is Depth-first search
 	// set Order=LogOrderCommitterTime for ordering by committer time (more compatible with `git log`)
 	// set Order=LogOrderBSF for Breadth-first search
+	// set Order=LogOrderTopo or Order=LogOrderAuthorDate for a topological order,
+	// using the generation numbers of the commit-graph file if there is one
 	Order LogOrder
 
 	// Show only those commits in which the specified file was inserted/updated.
 	// It is equivalent to running `git log -- <file-name>`.
 	FileName *string
 
+	// Follow continues listing the history of FileName beyond renames,
+	// detected by content similarity. It requires FileName to be set.
+	// It is equivalent to running `git log --follow -- <file-name>`.
+	Follow bool
+
 	// Pretend as if all the refs in refs/, along with HEAD, are listed on the command line as <commit>.
 	// It is equivalent to running `git log --all`.
 	// If set on true, the From option will be ignored.
 	All bool
+
+	// Revisions limits the log to the commits reachable from the given
+	// revisions and not from the excluded ones, as the arguments of git log:
+	// <rev>, ^<rev>, <rev1>..<rev2>, <rev1>...<rev2> and --not. If set, the
+	// From and All options are ignored and the commits are listed by
+	// committer time. With FileName, the commits are kept if they change the
+	// file from each of their parents, as git log does.
+	Revisions []string
+
+	// Boundary lists, after the commits of Revisions, the excluded commits
+	// that are parents of them. It is equivalent to running
+	// `git log --boundary`.
+	Boundary bool
+
+	// Since and Until limit the log to the commits committed after Since
+	// and before Until. They are equivalent to `git log --since` and
+	// `git log --until`. With the committer time Order, or FirstParent, the
+	// walk stops at the first commit older than Since.
+	Since *time.Time
+	Until *time.Time
+
+	// Author and Committer limit the log to the commits whose author, or
+	// committer, formatted as "name <email>", matches the regular
+	// expression. They are equivalent to `git log --author` and
+	// `git log --committer`.
+	Author    *regexp.Regexp
+	Committer *regexp.Regexp
+
+	// Grep limits the log to the commits whose message matches the regular
+	// expression. It is equivalent to running `git log --grep`.
+	Grep *regexp.Regexp
+
+	// FirstParent follows only the first parent of the merge commits, the
+	// Order being ignored. It is equivalent to running
+	// `git log --first-parent`.
+	FirstParent bool
+
+	// NoMerges skips the merge commits, as `git log --no-merges` does, and
+	// MinParents and MaxParents, if not nil, limit the log to the commits
+	// with at least and at most the given number of parents, as
+	// `git log --min-parents` and `git log --max-parents` do.
+	NoMerges   bool
+	MinParents int
+	MaxParents *int
+
+	// Paths limits the log to the commits changing, from each of their
+	// parents, a file matching one of the pathspecs: a file, a directory or
+	// a glob pattern where * and ? also match a /. It is equivalent to
+	// running `git log -- <pathspec>...`.
+	Paths []string
+
+	// Pickaxe limits the log to the commits changing the number of
+	// occurrences of the string in a file. It is equivalent to running
+	// `git log -S <string>`.
+	Pickaxe string
+
+	// PickaxeRegexp limits the log to the commits adding or removing a line
+	// matching the regular expression. It is equivalent to running
+	// `git log -G <regexp>`.
+	PickaxeRegexp *regexp.Regexp
+}
+
+var (
+	ErrFollowRequiresFileName = errors.New("Follow requires FileName to be set")
+	ErrRevisionsOrder         = errors.New("Revisions requires the default or the committer time Order")
+	ErrRevisionsFollow        = errors.New("Follow can't be used with Revisions")
+	ErrNegativeParents        = errors.New("MinParents and MaxParents can't be negative")
+)
+
+// Validate validates the fields and sets the default values.
+func (o *LogOptions) Validate() error {
+	if o.Follow && o.FileName == nil {
+		return ErrFollowRequiresFileName
+	}
+
+	if len(o.Revisions) != 0 && o.Order != LogOrderDefault && o.Order != LogOrderCommitterTime {
+		return ErrRevisionsOrder
+	}
+
+	if len(o.Revisions) != 0 && o.Follow {
+		return ErrRevisionsFollow
+	}
+
+	if o.MinParents < 0 || o.MaxParents != nil && *o.MaxParents < 0 {
+		return ErrNegativeParents
+	}
+
+	return nil
+}
+
+// RevListOptions describes how a rev-list operation should be performed.
+type RevListOptions struct {
+	// Revisions are the revisions listed, as the arguments of git rev-list:
+	// <rev>, ^<rev>, <rev1>..<rev2>, <rev1>...<rev2> and --not. By default
+	// HEAD.
+	Revisions []string
+	// Boundary lists the excluded commits that are parents of the listed
+	// ones. It is equivalent to running `git rev-list --boundary`.
+	Boundary bool
+	// FirstParent follows only the first parent of the merge commits. It is
+	// equivalent to running `git rev-list --first-parent`.
+	FirstParent bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RevListOptions) Validate() error {
+	if len(o.Revisions) == 0 {
+		o.Revisions = []string{"HEAD"}
+	}
+
+	return nil
+}
+
+// DefaultBlameMoveScore is the minimum number of alphanumeric characters of a
+// block of lines to be detected as moved, as in git.
+const DefaultBlameMoveScore = 20
+
+// BlameOptions describes how a blame operation should be performed.
+type BlameOptions struct {
+	// DetectMoves blames the lines moved within the file on the commit that
+	// introduced them instead of the one that moved them. It is equivalent
+	// to running `git blame -M`.
+	DetectMoves bool
+	// MoveScore is the minimum number of alphanumeric characters a block of
+	// moved lines must have to be detected, by default DefaultBlameMoveScore.
+	MoveScore int
+}
+
+// Validate validates the fields and sets the default values.
+func (o *BlameOptions) Validate() error {
+	if o.MoveScore <= 0 {
+		o.MoveScore = DefaultBlameMoveScore
+	}
+
+	return nil
 }
 
 var (
-	ErrMissingAuthor = errors.New("author field is required")
+	ErrMissingAuthor    = errors.New("author field is required")
+	ErrMissingCommitter = errors.New("committer field is required")
 )
 
 // CommitOptions describes how a commit operation should be performed.
@@ -362,7 +680,8 @@ type CommitOptions struct {
 	// nil the Author signature is used.
 	Committer *object.Signature
 	// Parents are the parents commits for the new commit, by default when
-	// len(Parents) is zero, the hash of HEAD reference is used.
+	// len(Parents) is zero, the hash of HEAD reference is used. If a merge
+	// stopped with conflicts, the merged commit is used as second parent.
 	Parents []plumbing.Hash
 	// SignKey denotes a key to sign the commit with. A nil value here means the
 	// commit will not be signed. The private key must be present and already
@@ -389,6 +708,241 @@ func (o *CommitOptions) Validate(r *Repository) error {
 		if head != nil {
 			o.Parents = []plumbing.Hash{head.Hash()}
 		}
+
+		merge, err := r.Storer.Reference(mergeHead)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		if head != nil && merge != nil {
+			o.Parents = append(o.Parents, merge.Hash())
+		}
+	}
+
+	return nil
+}
+
+var (
+	ErrMissingMergeCommit = errors.New("CommitHash or ReferenceName is required")
+)
+
+// FastForwardMode defines how a merge behaves when HEAD is an ancestor of the
+// merged commit.
+type FastForwardMode int8
+
+const (
+	// FastForwardAllowed updates HEAD to the merged commit, without creating
+	// a merge commit, when possible. This is the default mode.
+	FastForwardAllowed FastForwardMode = iota
+	// FastForwardOnly refuses to merge unless HEAD can be fast-forwarded to
+	// the merged commit, like `git merge --ff-only`.
+	FastForwardOnly
+	// NoFastForward always creates a merge commit, like `git merge --no-ff`.
+	NoFastForward
+)
+
+// MergeOptions describes how a merge should be performed.
+type MergeOptions struct {
+	// CommitHash is the hash of the commit to be merged into HEAD.
+	CommitHash plumbing.Hash
+	// ReferenceName is the branch or tag to be merged into HEAD. Only one of
+	// CommitHash or ReferenceName can be passed.
+	ReferenceName plumbing.ReferenceName
+	// Message is the message of the merge commit, if empty a message like
+	// `Merge branch 'feature'` is used.
+	Message string
+	// Author is the author's signature of the merge commit.
+	Author *object.Signature
+	// Committer is the committer's signature of the merge commit. If Committer
+	// is nil the Author signature is used.
+	Committer *object.Signature
+	// SignKey denotes a key to sign the merge commit with. A nil value here
+	// means the commit will not be signed. The private key must be present and
+	// already decrypted.
+	SignKey *openpgp.Entity
+	// FastForward defines what to do when HEAD can be fast-forwarded to the
+	// merged commit, by default FastForwardAllowed.
+	FastForward FastForwardMode
+}
+
+// Validate validates the fields and sets the default values.
+func (o *MergeOptions) Validate() error {
+	if !o.CommitHash.IsZero() && o.ReferenceName != "" {
+		return ErrHashOrReference
+	}
+
+	if o.CommitHash.IsZero() && o.ReferenceName == "" {
+		return ErrMissingMergeCommit
+	}
+
+	if o.Author == nil {
+		return ErrMissingAuthor
+	}
+
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	if o.Message == "" {
+		o.Message = o.defaultMessage()
+	}
+
+	return nil
+}
+
+// StashOptions describes how a stash should be created.
+type StashOptions struct {
+	// Message describes the stashed changes, if empty a message like
+	// `WIP on master: 6ecf0ef vendor stuff` is used.
+	Message string
+	// Committer is the author and committer's signature of the stash commits.
+	Committer *object.Signature
+	// IncludeUntracked stashes the untracked files too, removing them from
+	// the worktree.
+	IncludeUntracked bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *StashOptions) Validate() error {
+	if o.Committer == nil {
+		return ErrMissingCommitter
+	}
+
+	return nil
+}
+
+// StashApplyOptions describes how a stash should be applied.
+type StashApplyOptions struct {
+	// Index restores the changes of the index too, otherwise only the changes
+	// of the worktree are restored.
+	Index bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *StashApplyOptions) Validate() error {
+	return nil
+}
+
+var (
+	ErrInvalidRebaseTodo = errors.New("invalid rebase todo list")
+)
+
+// RebaseOptions describes how a rebase should be performed.
+type RebaseOptions struct {
+	// Committer is the committer's signature of the rewritten commits, the
+	// author of each commit is kept.
+	Committer *object.Signature
+	// Todo is the list of steps to perform. If empty, every commit reachable
+	// from HEAD and not from upstream is picked, oldest first. It is ignored
+	// when a stopped rebase is continued or skipped.
+	Todo []RebaseStep
+	// SignKey denotes a key to sign the rewritten commits with. A nil value
+	// here means the commits will not be signed. The private key must be
+	// present and already decrypted.
+	SignKey *openpgp.Entity
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RebaseOptions) Validate() error {
+	if o.Committer == nil {
+		return ErrMissingCommitter
+	}
+
+	first := true
+	for _, step := range o.Todo {
+		if step.Action < RebasePick || step.Action > RebaseDrop {
+			return ErrInvalidRebaseTodo
+		}
+
+		if step.Commit.IsZero() {
+			return ErrInvalidRebaseTodo
+		}
+
+		if step.Action == RebaseDrop {
+			continue
+		}
+
+		if first && (step.Action == RebaseSquash || step.Action == RebaseFixup) {
+			return ErrInvalidRebaseTodo
+		}
+
+		first = false
+	}
+
+	return nil
+}
+
+var (
+	ErrMainlineRequired = errors.New("mainline is required to pick a merge commit")
+	ErrInvalidMainline  = errors.New("mainline does not match a parent of the commit")
+)
+
+// CherryPickOptions describes how a cherry-pick should be performed.
+type CherryPickOptions struct {
+	// Committer is the committer's signature of the new commit, the author
+	// and message of the picked commit are kept.
+	Committer *object.Signature
+	// Mainline is the number, starting from 1, of the parent used as base
+	// when the picked commit is a merge. It is required for merge commits
+	// and must be zero otherwise.
+	Mainline int
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+	// SignKey denotes a key to sign the new commit with. A nil value here
+	// means the commit will not be signed. The private key must be present
+	// and already decrypted.
+	SignKey *openpgp.Entity
+}
+
+// Validate validates the fields and sets the default values.
+func (o *CherryPickOptions) Validate() error {
+	if o.Committer == nil && !o.NoCommit {
+		return ErrMissingCommitter
+	}
+
+	if o.Mainline < 0 {
+		return ErrInvalidMainline
+	}
+
+	return nil
+}
+
+// RevertOptions describes how a revert should be performed.
+type RevertOptions struct {
+	// Author is the author's signature of the revert commit.
+	Author *object.Signature
+	// Committer is the committer's signature of the revert commit. If
+	// Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// Message is the message of the revert commit, if empty a message like
+	// `Revert "subject"` is used.
+	Message string
+	// Mainline is the number, starting from 1, of the parent the changes are
+	// reverted to when the commit is a merge. It is required for merge
+	// commits and must be zero otherwise.
+	Mainline int
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+	// SignKey denotes a key to sign the revert commit with. A nil value here
+	// means the commit will not be signed. The private key must be present
+	// and already decrypted.
+	SignKey *openpgp.Entity
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RevertOptions) Validate() error {
+	if o.Author == nil && !o.NoCommit {
+		return ErrMissingAuthor
+	}
+
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	if o.Mainline < 0 {
+		return ErrInvalidMainline
 	}
 
 	return nil
@@ -1,6 +1,8 @@
 package git
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 )
@@ -33,3 +35,23 @@ func (s *OptionsSuite) TestCommitOptionsCommitter(c *C) {
 
 	c.Assert(o.Committer, Equals, o.Author)
 }
+
+func (s *OptionsSuite) TestFetchOptionsShallowExclusive(c *C) {
+	since := time.Now()
+	for _, o := range []*FetchOptions{
+		{Depth: 1, Deepen: 1},
+		{Depth: 1, Unshallow: true},
+		{Deepen: 1, ShallowSince: since},
+		{Unshallow: true, ShallowExclude: []string{"master"}},
+	} {
+		c.Assert(o.Validate(), Equals, ErrShallowOptionsExclusive)
+	}
+
+	o := &FetchOptions{ShallowSince: since, ShallowExclude: []string{"master"}}
+	c.Assert(o.Validate(), IsNil)
+}
+
+func (s *OptionsSuite) TestFetchOptionsNegativeDepth(c *C) {
+	c.Assert((&FetchOptions{Depth: -1}).Validate(), Equals, ErrNegativeDepth)
+	c.Assert((&FetchOptions{Deepen: -1}).Validate(), Equals, ErrNegativeDepth)
+}
@@ -39,6 +39,18 @@ type FilePatch interface {
 	Chunks() []Chunk
 }
 
+// RenamedFilePatch is implemented by the FilePatches of files renamed or
+// copied, "from" is the source of the rename or copy and "to" its
+// destination.
+type RenamedFilePatch interface {
+	FilePatch
+	// Similarity returns the percentage of the content of the source present
+	// in the destination, zero if it is unknown.
+	Similarity() int
+	// IsCopy returns true if the source is copied instead of renamed.
+	IsCopy() bool
+}
+
 // File contains all the file metadata necessary to print some patch formats.
 type File interface {
 	// Hash returns the File Hash.
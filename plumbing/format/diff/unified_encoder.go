@@ -34,9 +34,11 @@ const (
 	deletedFileMode = "deleted file mode %o\n"
 	newFileMode     = "new file mode %o\n"
 
-	renameFrom     = "from"
-	renameTo       = "to"
-	renameFileMode = "rename %s %s\n"
+	renameFrom      = "from"
+	renameTo        = "to"
+	renameFileMode  = "rename %s %s\n"
+	copyFileMode    = "copy %s %s\n"
+	similarityIndex = "similarity index %d%%\n"
 
 	indexAndMode = "index %s..%s %o\n"
 	indexNoMode  = "index %s..%s\n"
@@ -46,7 +48,6 @@ const (
 
 // UnifiedEncoder encodes an unified diff into the provided Writer.
 // There are some unsupported features:
-//     - Similarity index for renames
 //     - Sort hash representation
 type UnifiedEncoder struct {
 	io.Writer
@@ -76,8 +77,7 @@ func (e *UnifiedEncoder) Encode(patch Patch) error {
 
 func (e *UnifiedEncoder) encodeFilePatch(filePatches []FilePatch) error {
 	for _, p := range filePatches {
-		f, t := p.Files()
-		if err := e.header(f, t, p.IsBinary()); err != nil {
+		if err := e.header(p); err != nil {
 			return err
 		}
 
@@ -100,7 +100,10 @@ func (e *UnifiedEncoder) printMessage(message string) {
 	e.buf.WriteString(message)
 }
 
-func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
+func (e *UnifiedEncoder) header(p FilePatch) error {
+	from, to := p.Files()
+	isBinary := p.IsBinary()
+
 	switch {
 	case from == nil && to == nil:
 		return nil
@@ -114,9 +117,7 @@ func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
 		}
 
 		if from.Path() != to.Path() {
-			fmt.Fprintf(&e.buf,
-				renameFileMode+renameFileMode,
-				renameFrom, from.Path(), renameTo, to.Path())
+			e.renameLines(p, from.Path(), to.Path())
 		}
 
 		if from.Mode() != to.Mode() && !hashEquals {
@@ -143,6 +144,21 @@ func (e *UnifiedEncoder) header(from, to File, isBinary bool) error {
 	return nil
 }
 
+func (e *UnifiedEncoder) renameLines(p FilePatch, fromPath, toPath string) {
+	format := renameFileMode + renameFileMode
+	if rp, ok := p.(RenamedFilePatch); ok {
+		if s := rp.Similarity(); s != 0 {
+			fmt.Fprintf(&e.buf, similarityIndex, s)
+		}
+
+		if rp.IsCopy() {
+			format = copyFileMode + copyFileMode
+		}
+	}
+
+	fmt.Fprintf(&e.buf, format, renameFrom, fromPath, renameTo, toPath)
+}
+
 func (e *UnifiedEncoder) pathLines(isBinary bool, fromPath, toPath string) {
 	format := fPath + tPath
 	if isBinary {
@@ -40,13 +40,31 @@ func UpdateObjectStorage(s storer.Storer, packfile io.Reader) error {
 	return err
 }
 
+// UpdatePromisorObjectStorage updates the storer with the objects in the given
+// packfile, received from a promisor remote. The packfile is marked as such if
+// the storer implements storer.PromisorPackfileWriter.
+func UpdatePromisorObjectStorage(s storer.Storer, packfile io.Reader) error {
+	if pw, ok := s.(storer.PromisorPackfileWriter); ok {
+		return writePackfile(pw.PromisorPackfileWriter, packfile)
+	}
+
+	return UpdateObjectStorage(s, packfile)
+}
+
 // WritePackfileToObjectStorage writes all the packfile objects into the given
 // object storage.
 func WritePackfileToObjectStorage(
 	sw storer.PackfileWriter,
 	packfile io.Reader,
+) error {
+	return writePackfile(sw.PackfileWriter, packfile)
+}
+
+func writePackfile(
+	newWriter func() (io.WriteCloser, error),
+	packfile io.Reader,
 ) (err error) {
-	w, err := sw.PackfileWriter()
+	w, err := newWriter()
 	if err != nil {
 		return err
 	}
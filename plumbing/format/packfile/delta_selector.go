@@ -46,6 +46,58 @@ func (dw *deltaSelector) ObjectsToPack(
 		return otp, nil
 	}
 
+	return dw.deltify(otp, packWindow)
+}
+
+// ThinObjectsToPack creates a list of ObjectToPack as ObjectsToPack does,
+// including the objects referenced in bases, which are marked as external and
+// are only used as delta bases of the others. The bases also referenced in
+// hashes are ignored.
+func (dw *deltaSelector) ThinObjectsToPack(
+	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
+	packWindow uint,
+) ([]*ObjectToPack, error) {
+	if packWindow == 0 || len(bases) == 0 {
+		return dw.ObjectsToPack(hashes, packWindow)
+	}
+
+	otp, err := dw.objectsToPack(hashes, packWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	packed := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		packed[h] = true
+	}
+
+	for _, h := range bases {
+		if packed[h] {
+			continue
+		}
+
+		packed[h] = true
+		o, err := dw.encodedObject(h)
+		if err != nil {
+			return nil, err
+		}
+
+		base := newObjectToPack(o)
+		base.external = true
+		otp = append(otp, base)
+	}
+
+	return dw.deltify(otp, packWindow)
+}
+
+// deltify finds deltas for the objects, comparing them by type in windows of
+// packWindow objects.
+func (dw *deltaSelector) deltify(
+	otp []*ObjectToPack,
+	packWindow uint,
+) ([]*ObjectToPack, error) {
+
 	dw.sort(otp)
 
 	var objectGroups [][]*ObjectToPack
@@ -61,6 +113,7 @@ func (dw *deltaSelector) ObjectsToPack(
 		}
 	}
 
+	var err error
 	var wg sync.WaitGroup
 	var once sync.Once
 	for _, objs := range objectGroups {
@@ -240,8 +293,9 @@ func (dw *deltaSelector) walk(
 
 		// If we already have a delta, we don't try to find a new one for this
 		// object. This happens when a delta is set to be reused from an existing
-		// packfile.
-		if target.IsDelta() {
+		// packfile. The external objects are not written, so they are never
+		// deltified.
+		if target.IsDelta() || target.external {
 			continue
 		}
 
@@ -365,5 +419,11 @@ func (a byTypeAndSize) Less(i, j int) bool {
 		return true
 	}
 
+	// the external objects go first, as git does with the preferred bases,
+	// since they can only be used as bases of the following objects
+	if a[i].external != a[j].external {
+		return a[i].external
+	}
+
 	return a[i].Size() > a[j].Size()
 }
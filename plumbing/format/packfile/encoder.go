@@ -57,12 +57,41 @@ func (e *Encoder) Encode(
 	return e.encode(objects)
 }
 
+// EncodeThin creates a thin packfile containing all the objects referenced
+// in hashes, as Encode does, but the objects may be deltified against the
+// objects referenced in bases, which are known to exist at the receiving end
+// and are not written to the packfile. The deltas against them are always
+// reference deltas.
+func (e *Encoder) EncodeThin(
+	hashes []plumbing.Hash,
+	bases []plumbing.Hash,
+	packWindow uint,
+) (plumbing.Hash, error) {
+	objects, err := e.selector.ThinObjectsToPack(hashes, bases, packWindow)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return e.encode(objects)
+}
+
 func (e *Encoder) encode(objects []*ObjectToPack) (plumbing.Hash, error) {
-	if err := e.head(len(objects)); err != nil {
+	var count int
+	for _, o := range objects {
+		if !o.external {
+			count++
+		}
+	}
+
+	if err := e.head(count); err != nil {
 		return plumbing.ZeroHash, err
 	}
 
 	for _, o := range objects {
+		if o.external {
+			continue
+		}
+
 		if err := e.entry(o); err != nil {
 			return plumbing.ZeroHash, err
 		}
@@ -133,7 +162,7 @@ func (e *Encoder) entry(o *ObjectToPack) error {
 }
 
 func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
-	if o.IsDelta() && !o.Base.IsWritten() {
+	if o.IsDelta() && !o.Base.external && !o.Base.IsWritten() {
 		// We must write base first
 		return e.entry(o.Base)
 	}
@@ -142,9 +171,11 @@ func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
 }
 
 func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
-	// Write offset deltas by default
+	// Write offset deltas by default, the bases not written to the packfile
+	// can only be referenced by hash
+	useRefDeltas := e.useRefDeltas || o.Base.external
 	t := plumbing.OFSDeltaObject
-	if e.useRefDeltas {
+	if useRefDeltas {
 		t = plumbing.REFDeltaObject
 	}
 
@@ -152,7 +183,7 @@ func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
 		return err
 	}
 
-	if e.useRefDeltas {
+	if useRefDeltas {
 		return e.writeRefDeltaHeader(o.Base.Hash())
 	} else {
 		return e.writeOfsDeltaHeader(o)
@@ -175,6 +206,12 @@ func (e *Encoder) writeOfsDeltaHeader(o *ObjectToPack) error {
 }
 
 func (e *Encoder) entryHead(typeNum plumbing.ObjectType, size int64) error {
+	_, err := e.w.Write(entryHeader(typeNum, size))
+	return err
+}
+
+// entryHeader returns the header of an entry of the given type and size.
+func entryHeader(typeNum plumbing.ObjectType, size int64) []byte {
 	t := int64(typeNum)
 	header := []byte{}
 	c := (t << firstLengthBits) | (size & maskFirstLength)
@@ -188,10 +225,7 @@ func (e *Encoder) entryHead(typeNum plumbing.ObjectType, size int64) error {
 		size >>= lengthBits
 	}
 
-	header = append(header, byte(c))
-	_, err := e.w.Write(header)
-
-	return err
+	return append(header, byte(c))
 }
 
 func (e *Encoder) footer() (plumbing.Hash, error) {
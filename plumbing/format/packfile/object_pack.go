@@ -24,6 +24,10 @@ type ObjectToPack struct {
 	// has not been written yet
 	Offset int64
 
+	// external is true for the objects known to exist at the receiving end
+	// of a thin pack, they are only used as delta bases and never written
+	external bool
+
 	// Information from the original object
 	resolvedOriginal bool
 	originalType     plumbing.ObjectType
@@ -39,6 +39,7 @@ type Observer interface {
 // to generate indexes.
 type Parser struct {
 	storage    storer.EncodedObjectStorer
+	bases      storer.EncodedObjectStorer
 	scanner    *Scanner
 	count      uint32
 	oi         []*objectInfo
@@ -46,6 +47,8 @@ type Parser struct {
 	oiByOffset map[int64]*objectInfo
 	hashOffset map[plumbing.Hash]int64
 	checksum   plumbing.Hash
+	// external are the placeholders of the bases missing in a thin pack
+	external []*objectInfo
 
 	cache *cache.BufferLRU
 	// delta content by offset, only used if source is not seekable
@@ -86,6 +89,24 @@ func NewParserWithStorage(
 	}, nil
 }
 
+// NewParserWithThinPackBases creates a new Parser able to parse a thin
+// packfile, the bases of the reference deltas missing in the packfile are read
+// from bases, without writing anything to it. The scanner source must be
+// seekable.
+func NewParserWithThinPackBases(
+	scanner *Scanner,
+	bases storer.EncodedObjectStorer,
+	ob ...Observer,
+) (*Parser, error) {
+	p, err := NewParser(scanner, ob...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.bases = bases
+	return p, nil
+}
+
 func (p *Parser) forEachObserver(f func(o Observer) error) error {
 	for _, o := range p.ob {
 		if err := f(o); err != nil {
@@ -210,6 +231,7 @@ func (p *Parser) indexObjects() error {
 					DiskType:    plumbing.AnyObject,
 				}
 				p.oiByHash[oh.Reference] = parent
+				p.external = append(p.external, parent)
 			}
 			ota = newDeltaObject(oh.Offset, oh.Length, t, parent)
 			parent.Children = append(parent.Children, ota)
@@ -234,6 +256,11 @@ func (p *Parser) indexObjects() error {
 			}
 
 			ota.SHA1 = sha1
+			if parent, ok := p.oiByHash[ota.SHA1]; ok && parent.ExternalRef {
+				// a base referenced before, as in a fixed thin pack
+				ota.adopt(parent)
+			}
+
 			p.oiByHash[ota.SHA1] = ota
 		}
 
@@ -324,6 +351,10 @@ func (p *Parser) get(o *objectInfo) (b []byte, err error) {
 		return b, nil
 	}
 
+	if o.ExternalRef && p.bases != nil {
+		return p.getExternal(o)
+	}
+
 	if o.ExternalRef {
 		// we were not able to resolve a ref in a thin pack
 		return nil, ErrReferenceDeltaNotFound
@@ -354,6 +385,49 @@ func (p *Parser) get(o *objectInfo) (b []byte, err error) {
 	return data, nil
 }
 
+// getExternal reads a base missing in a thin pack from the bases.
+func (p *Parser) getExternal(o *objectInfo) ([]byte, error) {
+	e, err := p.bases.EncodedObject(plumbing.AnyObject, o.SHA1)
+	if err == plumbing.ErrObjectNotFound {
+		return nil, ErrReferenceDeltaNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.Type = e.Type()
+	o.Length = e.Size()
+
+	r, err := e.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	b := make([]byte, e.Size())
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// externalBases returns the bases missing in the packfile, resolved from
+// outside of it. The packfile is thin if there is any.
+func (p *Parser) externalBases() []*objectInfo {
+	var bases []*objectInfo
+	for _, o := range p.external {
+		// the base may be found later in the packfile
+		if p.oiByHash[o.SHA1] == o && len(o.Children) > 0 {
+			bases = append(bases, o)
+		}
+	}
+
+	return bases
+}
+
 func (p *Parser) resolveObject(
 	o *objectInfo,
 	base []byte,
@@ -474,6 +548,16 @@ func newDeltaObject(
 	return obj
 }
 
+// adopt takes the children of the placeholder of an external reference.
+func (o *objectInfo) adopt(placeholder *objectInfo) {
+	for _, child := range placeholder.Children {
+		child.Parent = o
+	}
+
+	o.Children = append(o.Children, placeholder.Children...)
+	placeholder.Children = nil
+}
+
 func (o *objectInfo) IsDelta() bool {
 	return o.Type.IsDelta()
 }
@@ -0,0 +1,117 @@
+package packfile
+
+import (
+	"compress/zlib"
+	"crypto/sha1"
+	"hash/crc32"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/utils/binary"
+)
+
+// countOffset is the offset of the number of objects in the header of a
+// packfile, after the signature and the version.
+const countOffset = 8
+
+// ThinPackFile is a file containing a packfile, which can be completed in
+// place when it is thin.
+type ThinPackFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+// FixThinPack makes self-contained the thin packfile in f, parsed by p,
+// appending to it the bases resolved from outside of it, as the
+// "index-pack --fix-thin" command of git does. The number of objects in the
+// header and the checksum of the packfile are rewritten, the observers of p are
+// notified of the objects added and of the new checksum, which is returned.
+//
+// If the packfile is not thin, it is left untouched and its checksum is
+// returned. FixThinPack must be called after a successful Parse.
+func (p *Parser) FixThinPack(f ThinPackFile) (plumbing.Hash, error) {
+	bases := p.externalBases()
+	if len(bases) == 0 {
+		return p.checksum, nil
+	}
+
+	end, err := f.Seek(-sha1.Size, io.SeekEnd)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, o := range bases {
+		content, err := p.get(o)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		n, crc, err := writeEntry(f, o.Type, content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if err := p.onInflatedObjectHeader(o.Type, int64(len(content)), end); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if err := p.onInflatedObjectContent(o.SHA1, end, crc, content); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		end += n
+	}
+
+	if _, err := f.Seek(countOffset, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := binary.WriteUint32(f, p.count+uint32(len(bases))); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := plumbing.Hasher{Hash: sha1.New()}
+	if _, err := io.Copy(h, f); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	p.checksum = h.Sum()
+	if err := binary.Write(f, p.checksum); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := p.onFooter(p.checksum); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return p.checksum, nil
+}
+
+// writeEntry writes a non-delta entry with the given content, it returns the
+// number of bytes written and their CRC32.
+func writeEntry(w io.Writer, t plumbing.ObjectType, content []byte) (int64, uint32, error) {
+	crc := crc32.NewIEEE()
+	ow := newOffsetWriter(io.MultiWriter(w, crc))
+	if _, err := ow.Write(entryHeader(t, int64(len(content)))); err != nil {
+		return 0, 0, err
+	}
+
+	zw := zlib.NewWriter(ow)
+	if _, err := zw.Write(content); err != nil {
+		return 0, 0, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	return ow.Offset(), crc.Sum32(), nil
+}
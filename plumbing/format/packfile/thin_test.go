@@ -0,0 +1,169 @@
+package packfile_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type ThinPackSuite struct {
+	fixtures.Suite
+	store  *memory.Storage
+	base   plumbing.Hash
+	target plumbing.Hash
+}
+
+var _ = Suite(&ThinPackSuite{})
+
+func (s *ThinPackSuite) SetUpTest(c *C) {
+	s.store = memory.NewStorage()
+
+	content := strings.Repeat("a line of the file, long enough to be worth a delta\n", 100)
+	s.base = s.blob(c, content)
+	s.target = s.blob(c, content+"and a new line\n")
+}
+
+func (s *ThinPackSuite) blob(c *C, content string) plumbing.Hash {
+	o := s.store.NewEncodedObject()
+	o.SetType(plumbing.BlobObject)
+	w, err := o.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h, err := s.store.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+	return h
+}
+
+// encodeThin returns a thin pack of the target, deltified against the base.
+func (s *ThinPackSuite) encodeThin(c *C) []byte {
+	buf := bytes.NewBuffer(nil)
+	e := packfile.NewEncoder(buf, s.store, false)
+	_, err := e.EncodeThin([]plumbing.Hash{s.target}, []plumbing.Hash{s.base}, 10)
+	c.Assert(err, IsNil)
+
+	return buf.Bytes()
+}
+
+func (s *ThinPackSuite) TestEncodeThin(c *C) {
+	scanner := packfile.NewScanner(bytes.NewReader(s.encodeThin(c)))
+	_, count, err := scanner.Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(1))
+
+	oh, err := scanner.NextObjectHeader()
+	c.Assert(err, IsNil)
+	c.Assert(oh.Type, Equals, plumbing.REFDeltaObject)
+	c.Assert(oh.Reference, Equals, s.base)
+}
+
+func (s *ThinPackSuite) TestEncodeThinBaseInHashes(c *C) {
+	buf := bytes.NewBuffer(nil)
+	e := packfile.NewEncoder(buf, s.store, false)
+	_, err := e.EncodeThin(
+		[]plumbing.Hash{s.target, s.base}, []plumbing.Hash{s.base}, 10,
+	)
+	c.Assert(err, IsNil)
+
+	obs := new(testObserver)
+	parser, err := packfile.NewParser(packfile.NewScanner(bytes.NewReader(buf.Bytes())), obs)
+	c.Assert(err, IsNil)
+
+	_, err = parser.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(obs.count, Equals, uint32(2))
+}
+
+func (s *ThinPackSuite) TestParseThinWithoutBases(c *C) {
+	scanner := packfile.NewScanner(bytes.NewReader(s.encodeThin(c)))
+	parser, err := packfile.NewParser(scanner)
+	c.Assert(err, IsNil)
+
+	_, err = parser.Parse()
+	c.Assert(err, Equals, packfile.ErrReferenceDeltaNotFound)
+}
+
+func (s *ThinPackSuite) TestParseThinMissingBase(c *C) {
+	scanner := packfile.NewScanner(bytes.NewReader(s.encodeThin(c)))
+	parser, err := packfile.NewParserWithThinPackBases(scanner, memory.NewStorage())
+	c.Assert(err, IsNil)
+
+	_, err = parser.Parse()
+	c.Assert(err, Equals, packfile.ErrReferenceDeltaNotFound)
+}
+
+func (s *ThinPackSuite) TestFixThinPack(c *C) {
+	f, err := os.Create(filepath.Join(c.MkDir(), "thin.pack"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	_, err = f.Write(s.encodeThin(c))
+	c.Assert(err, IsNil)
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	w := new(idxfile.Writer)
+	parser, err := packfile.NewParserWithThinPackBases(packfile.NewScanner(f), s.store, w)
+	c.Assert(err, IsNil)
+
+	thin, err := parser.Parse()
+	c.Assert(err, IsNil)
+
+	checksum, err := parser.FixThinPack(f)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Not(Equals), thin)
+
+	idx, err := w.Index()
+	c.Assert(err, IsNil)
+	count, err := idx.Count()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(2))
+
+	// the fixed packfile is self-contained
+	_, err = f.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	obs := new(testObserver)
+	parser, err = packfile.NewParser(packfile.NewScanner(f), obs)
+	c.Assert(err, IsNil)
+
+	h, err := parser.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, checksum)
+	c.Assert(obs.count, Equals, uint32(2))
+
+	for _, o := range obs.objects {
+		offset, err := idx.FindOffset(plumbing.NewHash(o.hash))
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, o.offset)
+
+		crc, err := idx.FindCRC32(plumbing.NewHash(o.hash))
+		c.Assert(err, IsNil)
+		c.Assert(crc, Equals, o.crc)
+	}
+}
+
+func (s *ThinPackSuite) TestFixThinPackNotThin(c *C) {
+	f := fixtures.Basic().One()
+	parser, err := packfile.NewParserWithThinPackBases(packfile.NewScanner(f.Packfile()), s.store)
+	c.Assert(err, IsNil)
+
+	h, err := parser.Parse()
+	c.Assert(err, IsNil)
+
+	checksum, err := parser.FixThinPack(nil)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, h)
+}
@@ -29,6 +29,9 @@ var (
 	Flush = []byte{}
 	// FlushString is the payload to use with the EncodeString method to encode a flush-pkt.
 	FlushString = ""
+	// DelimPkt are the contents of a delim-pkt pkt-line, used by the version 2
+	// of the protocol to separate the sections of a message.
+	DelimPkt = []byte{'0', '0', '0', '1'}
 	// ErrPayloadTooLong is returned by the Encode methods when any of the
 	// provided payloads is bigger than MaxPayloadSize.
 	ErrPayloadTooLong = errors.New("payload is too long")
@@ -47,6 +50,12 @@ func (e *Encoder) Flush() error {
 	return err
 }
 
+// Delim encodes a delim-pkt to the output stream.
+func (e *Encoder) Delim() error {
+	_, err := e.w.Write(DelimPkt)
+	return err
+}
+
 // Encode encodes a pkt-line with the payload specified and write it to
 // the output stream.  If several payloads are specified, each of them
 // will get streamed in their own pkt-lines.
@@ -23,10 +23,12 @@ var ErrInvalidPktLen = errors.New("invalid pkt-len found")
 //
 // Scanning stops at EOF or the first I/O error.
 type Scanner struct {
-	r       io.Reader     // The reader provided by the client
-	err     error         // Sticky error
-	payload []byte        // Last pkt-payload
-	len     [lenSize]byte // Last pkt-len
+	r           io.Reader     // The reader provided by the client
+	err         error         // Sticky error
+	payload     []byte        // Last pkt-payload
+	len         [lenSize]byte // Last pkt-len
+	acceptDelim bool          // Whether delim-pkts are valid pkt-lines
+	delim       bool          // Whether the last pkt-line was a delim-pkt
 }
 
 // NewScanner returns a new Scanner to read from r.
@@ -36,6 +38,17 @@ func NewScanner(r io.Reader) *Scanner {
 	}
 }
 
+// NewScannerWithDelim returns a new Scanner to read from r, that also accepts
+// the delim-pkts used by the version 2 of the protocol. As flush-pkts, they
+// are represented by empty byte slices, use the Delim method to tell them
+// apart.
+func NewScannerWithDelim(r io.Reader) *Scanner {
+	return &Scanner{
+		r:           r,
+		acceptDelim: true,
+	}
+}
+
 // Err returns the first error encountered by the Scanner.
 func (s *Scanner) Err() error {
 	return s.err
@@ -47,6 +60,8 @@ func (s *Scanner) Err() error {
 // will return any error that occurred during scanning, except that if
 // it was io.EOF, Err will return nil.
 func (s *Scanner) Scan() bool {
+	s.delim = false
+
 	var l int
 	l, s.err = s.readPayloadLen()
 	if s.err == io.EOF {
@@ -76,6 +91,12 @@ func (s *Scanner) Bytes() []byte {
 	return s.payload
 }
 
+// Delim returns true if the most recent pkt-line generated by a call to Scan
+// was a delim-pkt.
+func (s *Scanner) Delim() bool {
+	return s.delim
+}
+
 // Method readPayloadLen returns the payload length by reading the
 // pkt-len and subtracting the pkt-len size.
 func (s *Scanner) readPayloadLen() (int, error) {
@@ -95,6 +116,9 @@ func (s *Scanner) readPayloadLen() (int, error) {
 	switch {
 	case n == 0:
 		return 0, nil
+	case n == 1 && s.acceptDelim:
+		s.delim = true
+		return 0, nil
 	case n <= lenSize:
 		return 0, ErrInvalidPktLen
 	case n > OversizePayloadMax+lenSize:
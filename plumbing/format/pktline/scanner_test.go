@@ -69,6 +69,26 @@ func (s *SuiteScanner) TestFlush(c *C) {
 	c.Assert(len(payload), Equals, 0)
 }
 
+func (s *SuiteScanner) TestDelim(c *C) {
+	r := strings.NewReader("0008foo\n00010008bar\n0000")
+	sc := pktline.NewScannerWithDelim(r)
+
+	var obtained []string
+	for sc.Scan() {
+		switch {
+		case sc.Delim():
+			obtained = append(obtained, "delim")
+		case len(sc.Bytes()) == 0:
+			obtained = append(obtained, "flush")
+		default:
+			obtained = append(obtained, string(sc.Bytes()))
+		}
+	}
+
+	c.Assert(sc.Err(), IsNil)
+	c.Assert(obtained, DeepEquals, []string{"foo\n", "delim", "bar\n", "flush"})
+}
+
 func (s *SuiteScanner) TestPktLineTooShort(c *C) {
 	r := strings.NewReader("010cfoobar")
 
@@ -0,0 +1,90 @@
+package reflog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ErrMalformedEntry is returned by Decode when an entry can not be parsed.
+var ErrMalformedEntry = errors.New("malformed reflog entry")
+
+// A Decoder reads and decodes reflog entries from an input stream.
+type Decoder struct {
+	s *bufio.Scanner
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{s: bufio.NewScanner(r)}
+}
+
+// Decode reads all the entries from the stream, the oldest first.
+func (d *Decoder) Decode() ([]*Entry, error) {
+	var entries []*Entry
+	for d.s.Scan() {
+		line := d.s.Text()
+		if line == "" {
+			continue
+		}
+
+		e, err := decodeEntry(line)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, d.s.Err()
+}
+
+func decodeEntry(line string) (*Entry, error) {
+	hashes := 2*40 + 2
+	if len(line) < hashes || line[40] != ' ' || line[81] != ' ' {
+		return nil, ErrMalformedEntry
+	}
+
+	e := &Entry{
+		Old: plumbing.NewHash(line[:40]),
+		New: plumbing.NewHash(line[41:81]),
+	}
+
+	sig := line[hashes:]
+	if tab := strings.IndexByte(sig, '\t'); tab != -1 {
+		e.Message = sig[tab+1:]
+		sig = sig[:tab]
+	}
+
+	open := strings.LastIndexByte(sig, '<')
+	close := strings.LastIndexByte(sig, '>')
+	if open == -1 || close < open {
+		return nil, ErrMalformedEntry
+	}
+
+	e.Committer.Name = strings.TrimSpace(sig[:open])
+	e.Committer.Email = sig[open+1 : close]
+
+	fields := strings.Fields(sig[close+1:])
+	if len(fields) != 2 {
+		return nil, ErrMalformedEntry
+	}
+
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, ErrMalformedEntry
+	}
+
+	tz, err := time.Parse("2006 -0700", "1970 "+fields[1])
+	if err != nil {
+		return nil, ErrMalformedEntry
+	}
+
+	e.Committer.When = time.Unix(ts, 0).In(tz.Location())
+	return e, nil
+}
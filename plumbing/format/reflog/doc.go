@@ -0,0 +1,10 @@
+// Package reflog implements encoding and decoding of reflog files.
+//
+// The reflog of a reference records every update of its value, one entry per
+// line, the oldest first:
+//
+//    <old hash> SP <new hash> SP <name> SP '<' <email> '>' SP <timestamp> SP <tz> TAB <message> LF
+//
+// The message can not contain newlines, the tab and the message are omitted
+// when the message is empty.
+package reflog
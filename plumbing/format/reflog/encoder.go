@@ -0,0 +1,53 @@
+package reflog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An Encoder writes reflog entries to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the given entries to the stream, one per line.
+func (e *Encoder) Encode(entries ...*Entry) error {
+	for _, entry := range entries {
+		if err := e.encodeEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodeEntry(entry *Entry) error {
+	s := entry.Committer
+	u := s.When.Unix()
+	if u < 0 {
+		u = 0
+	}
+
+	_, err := fmt.Fprintf(e.w, "%s %s %s <%s> %d %s",
+		entry.Old, entry.New, s.Name, s.Email, u, s.When.Format("-0700"),
+	)
+	if err != nil {
+		return err
+	}
+
+	if msg := strings.Replace(entry.Message, "\n", " ", -1); msg != "" {
+		_, err = fmt.Fprintf(e.w, "\t%s", msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(e.w, "\n")
+	return err
+}
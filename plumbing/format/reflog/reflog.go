@@ -0,0 +1,31 @@
+package reflog
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Entry is an entry of a reflog, an update of the value of a reference.
+type Entry struct {
+	// Old is the previous value of the reference, the zero hash when the
+	// reference was created.
+	Old plumbing.Hash
+	// New is the new value of the reference, the zero hash when the
+	// reference was deleted.
+	New plumbing.Hash
+	// Committer is the identity that updated the reference.
+	Committer Signature
+	// Message describes the update.
+	Message string
+}
+
+// Signature is the identity that performed an update and when it happened.
+type Signature struct {
+	// Name represents a person name. It is an arbitrary string.
+	Name string
+	// Email is an email, but it cannot be assumed to be well-formed.
+	Email string
+	// When is the timestamp of the update.
+	When time.Time
+}
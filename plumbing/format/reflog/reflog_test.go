@@ -0,0 +1,81 @@
+package reflog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReflogSuite struct{}
+
+var _ = Suite(&ReflogSuite{})
+
+const reflogFixture = "0000000000000000000000000000000000000000 " +
+	"a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69 John Doe <john@doe.com> " +
+	"1494850000 +0200\tclone: from https://github.com/git-fixtures/basic.git\n" +
+	"a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69 " +
+	"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 Jane <jane@doe.com> " +
+	"1494851000 -0130\n"
+
+func (s *ReflogSuite) TestDecode(c *C) {
+	entries, err := NewDecoder(bytes.NewBufferString(reflogFixture)).Decode()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	e := entries[0]
+	c.Assert(e.Old, Equals, plumbing.ZeroHash)
+	c.Assert(e.New, Equals, plumbing.NewHash("a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69"))
+	c.Assert(e.Committer.Name, Equals, "John Doe")
+	c.Assert(e.Committer.Email, Equals, "john@doe.com")
+	c.Assert(e.Committer.When.Unix(), Equals, int64(1494850000))
+	c.Assert(e.Committer.When.Format("-0700"), Equals, "+0200")
+	c.Assert(e.Message, Equals, "clone: from https://github.com/git-fixtures/basic.git")
+
+	e = entries[1]
+	c.Assert(e.Committer.Name, Equals, "Jane")
+	c.Assert(e.Committer.When.Format("-0700"), Equals, "-0130")
+	c.Assert(e.Message, Equals, "")
+}
+
+func (s *ReflogSuite) TestDecodeMalformed(c *C) {
+	for _, line := range []string{
+		"foo\n",
+		"0000000000000000000000000000000000000000 a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69 John Doe 1494850000 +0200\n",
+		"0000000000000000000000000000000000000000 a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69 John Doe <john@doe.com> foo +0200\n",
+	} {
+		_, err := NewDecoder(bytes.NewBufferString(line)).Decode()
+		c.Assert(err, Equals, ErrMalformedEntry, Commentf("line: %q", line))
+	}
+}
+
+func (s *ReflogSuite) TestEncode(c *C) {
+	entries, err := NewDecoder(bytes.NewBufferString(reflogFixture)).Decode()
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err = NewEncoder(buf).Encode(entries...)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, reflogFixture)
+}
+
+func (s *ReflogSuite) TestEncodeMultilineMessage(c *C) {
+	buf := bytes.NewBuffer(nil)
+	err := NewEncoder(buf).Encode(&Entry{
+		Committer: Signature{
+			Name:  "foo",
+			Email: "foo@foo.com",
+			When:  time.Unix(0, 0).UTC(),
+		},
+		Message: "commit: foo\nbar",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "0000000000000000000000000000000000000000 "+
+		"0000000000000000000000000000000000000000 foo <foo@foo.com> 0 +0000\tcommit: foo bar\n",
+	)
+}
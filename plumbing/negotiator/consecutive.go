@@ -0,0 +1,149 @@
+package negotiator
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+type consecutive struct {
+	g *graph
+	q *queue
+	// nonCommon is the number of queued commits not known to be common.
+	nonCommon int
+}
+
+// NewConsecutive returns a Negotiator sending every commit reachable from
+// the tips as have, from the most recent to the oldest one, and stopping at
+// the commits known to be common.
+func NewConsecutive(s storer.EncodedObjectStorer) Negotiator {
+	return &consecutive{g: newGraph(s), q: newQueue()}
+}
+
+func (n *consecutive) push(c *node, mark flag) {
+	if c.has(mark) {
+		return
+	}
+
+	c.flags |= mark
+	n.q.push(c)
+	if !c.has(common) {
+		n.nonCommon++
+	}
+}
+
+// markCommon marks c, unless ancestorsOnly, and its ancestors as common.
+func (n *consecutive) markCommon(c *node, ancestorsOnly bool) error {
+	if c.has(common) {
+		return nil
+	}
+
+	if !ancestorsOnly {
+		c.flags |= common
+	}
+
+	if !c.has(seen) {
+		n.push(c, seen)
+		return nil
+	}
+
+	if !ancestorsOnly && !c.has(popped) {
+		n.nonCommon--
+	}
+
+	parents, err := n.g.parents(c)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parents {
+		if err := n.markCommon(p, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// KnownCommon marks the commit and its ancestors as common, the commit is
+// sent as have anyway.
+func (n *consecutive) KnownCommon(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil || c.has(seen) {
+		return err
+	}
+
+	n.push(c, advertised|seen)
+	return n.markCommon(c, true)
+}
+
+// AddTip adds a commit to walk from.
+func (n *consecutive) AddTip(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil {
+		return err
+	}
+
+	n.push(c, seen)
+	return nil
+}
+
+// Next returns the next commit to send as have, or plumbing.ZeroHash once
+// every commit not known to be common is sent.
+func (n *consecutive) Next() (plumbing.Hash, error) {
+	for {
+		if n.q.empty() || n.nonCommon == 0 {
+			return plumbing.ZeroHash, nil
+		}
+
+		e, _ := n.q.pop()
+		c := e.node
+		c.flags |= popped
+		send := !c.has(common)
+		if send {
+			n.nonCommon--
+		}
+
+		var mark flag
+		switch {
+		case !send:
+			// do not send it, and ignore its ancestors
+			mark = common | seen
+		case c.has(advertised):
+			// send it, and ignore its ancestors
+			mark = common | seen
+		default:
+			mark = seen
+		}
+
+		parents, err := n.g.parents(c)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		for _, p := range parents {
+			if !p.has(seen) {
+				n.push(p, mark)
+			}
+
+			if mark&common != 0 {
+				if err := n.markCommon(p, true); err != nil {
+					return plumbing.ZeroHash, err
+				}
+			}
+		}
+
+		if send {
+			return c.hash, nil
+		}
+	}
+}
+
+// Ack marks the commit and its ancestors as common.
+func (n *consecutive) Ack(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil {
+		return err
+	}
+
+	return n.markCommon(c, false)
+}
@@ -0,0 +1,81 @@
+package negotiator
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConsecutiveSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ConsecutiveSuite{})
+
+func (s *ConsecutiveSuite) TestNext(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 5)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.AddTip(commits[4]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{
+		commits[4], commits[3], commits[2], commits[1], commits[0],
+	})
+}
+
+func (s *ConsecutiveSuite) TestNextByCommitterDate(c *C) {
+	base := s.chain(c, plumbing.ZeroHash, 1)
+	a := s.chain(c, base[0], 2)
+	b := s.chain(c, base[0], 2)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.AddTip(a[1]), IsNil)
+	c.Assert(n.AddTip(b[1]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{
+		b[1], b[0], a[1], a[0], base[0],
+	})
+}
+
+func (s *ConsecutiveSuite) TestKnownCommon(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 5)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.KnownCommon(commits[2]), IsNil)
+	c.Assert(n.AddTip(commits[4]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{
+		commits[4], commits[3], commits[2],
+	})
+}
+
+func (s *ConsecutiveSuite) TestAck(c *C) {
+	base := s.chain(c, plumbing.ZeroHash, 3)
+	b := s.chain(c, plumbing.ZeroHash, 1)
+	a := s.chain(c, base[2], 3)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.AddTip(a[2]), IsNil)
+	c.Assert(n.AddTip(b[0]), IsNil)
+
+	for _, expected := range []plumbing.Hash{a[2], a[1]} {
+		h, err := n.Next()
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, expected)
+	}
+
+	c.Assert(n.Ack(a[1]), IsNil)
+	// the ancestors of a[1] are common, the unrelated b[0] is still sent
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{b[0]})
+}
+
+func (s *ConsecutiveSuite) TestAckEverything(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 5)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.AddTip(commits[4]), IsNil)
+
+	h, err := n.Next()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, commits[4])
+
+	c.Assert(n.Ack(commits[4]), IsNil)
+	c.Assert(haves(c, n), HasLen, 0)
+}
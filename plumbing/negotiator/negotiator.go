@@ -0,0 +1,231 @@
+// Package negotiator implements the algorithms choosing the haves sent to the
+// server while fetching, in a similar way as the fetch.negotiationAlgorithm
+// of git does.
+//
+// The commit graph is walked from the tips of the local references, by
+// committer date, and the walk stops at the commits known to be common with
+// the server, either because they are advertised by it or acknowledged
+// during the negotiation.
+package negotiator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown negotiation algorithm")
+)
+
+// Negotiator is a transport.Negotiator walking the commit graph of a
+// repository.
+type Negotiator interface {
+	transport.Negotiator
+	// KnownCommon marks a commit as known to be common with the server,
+	// typically an advertised reference present locally. It must be
+	// called before AddTip.
+	KnownCommon(plumbing.Hash) error
+	// AddTip adds a commit to walk from, typically a local reference.
+	AddTip(plumbing.Hash) error
+}
+
+// Algorithm is a negotiation algorithm.
+type Algorithm int
+
+const (
+	// Consecutive walks every commit, the default algorithm of git.
+	Consecutive Algorithm = iota
+	// Skipping skips commits, increasingly far from the last ones sent, in
+	// order to converge faster on long histories.
+	Skipping
+)
+
+// Validate returns ErrUnknownAlgorithm if a is not a known algorithm.
+func (a Algorithm) Validate() error {
+	if a != Consecutive && a != Skipping {
+		return ErrUnknownAlgorithm
+	}
+
+	return nil
+}
+
+// String returns the name of the algorithm, as used by git.
+func (a Algorithm) String() string {
+	switch a {
+	case Consecutive:
+		return "consecutive"
+	case Skipping:
+		return "skipping"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+// New returns a new Negotiator using the given algorithm, walking the commits
+// of s.
+func New(a Algorithm, s storer.EncodedObjectStorer) (Negotiator, error) {
+	switch a {
+	case Consecutive:
+		return NewConsecutive(s), nil
+	case Skipping:
+		return NewSkipping(s), nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+type flag uint8
+
+const (
+	// common is set on the commits known to be common with the server.
+	common flag = 1 << iota
+	// advertised is set on the commits advertised by the server.
+	advertised
+	// seen is set on the commits pushed to the queue.
+	seen
+	// popped is set on the commits popped from the queue.
+	popped
+)
+
+// node is a commit of the graph walked by the negotiators.
+type node struct {
+	hash    plumbing.Hash
+	commit  *object.Commit
+	flags   flag
+	parents []*node
+	loaded  bool
+}
+
+func (n *node) has(f flag) bool {
+	return n.flags&f != 0
+}
+
+// graph loads lazily the commits walked by the negotiators.
+type graph struct {
+	s     storer.EncodedObjectStorer
+	nodes map[plumbing.Hash]*node
+}
+
+func newGraph(s storer.EncodedObjectStorer) *graph {
+	return &graph{s: s, nodes: make(map[plumbing.Hash]*node)}
+}
+
+// node returns the node of the commit with the given hash, peeling the tags.
+// It returns nil if the object is missing, e.g. beyond a shallow boundary,
+// or is not a commit.
+func (g *graph) node(h plumbing.Hash) (*node, error) {
+	if n, ok := g.nodes[h]; ok {
+		return n, nil
+	}
+
+	c, err := g.commit(h)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	if n, ok := g.nodes[c.Hash]; ok {
+		return n, nil
+	}
+
+	n := &node{hash: c.Hash, commit: c}
+	g.nodes[c.Hash] = n
+	return n, nil
+}
+
+func (g *graph) commit(h plumbing.Hash) (*object.Commit, error) {
+	o, err := object.GetObject(g.s, h)
+	if err == plumbing.ErrObjectNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := o.(type) {
+	case *object.Commit:
+		return o, nil
+	case *object.Tag:
+		return g.commit(o.Target)
+	default:
+		return nil, nil
+	}
+}
+
+// parents returns the parents of n present in the storage.
+func (g *graph) parents(n *node) ([]*node, error) {
+	if n.loaded {
+		return n.parents, nil
+	}
+
+	for _, h := range n.commit.ParentHashes {
+		p, err := g.node(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if p != nil {
+			n.parents = append(n.parents, p)
+		}
+	}
+
+	n.loaded = true
+	return n.parents, nil
+}
+
+// entry is an element of a queue.
+type entry struct {
+	node        *node
+	ttl         uint16
+	originalTTL uint16
+	seq         int
+}
+
+// queue is a priority queue of commits, by committer date, the most recent
+// first, and by insertion order for the same dates.
+type queue struct {
+	heap *binaryheap.Heap
+	seq  int
+}
+
+func newQueue() *queue {
+	return &queue{heap: binaryheap.NewWith(func(a, b interface{}) int {
+		ea, eb := a.(*entry), b.(*entry)
+		wa, wb := ea.node.commit.Committer.When, eb.node.commit.Committer.When
+		switch {
+		case wa.After(wb):
+			return -1
+		case wa.Before(wb):
+			return 1
+		default:
+			return ea.seq - eb.seq
+		}
+	})}
+}
+
+func (q *queue) push(n *node) *entry {
+	e := &entry{node: n, seq: q.seq}
+	q.seq++
+	q.heap.Push(e)
+	return e
+}
+
+func (q *queue) pop() (*entry, bool) {
+	e, ok := q.heap.Pop()
+	if !ok {
+		return nil, false
+	}
+
+	return e.(*entry), true
+}
+
+func (q *queue) empty() bool {
+	return q.heap.Empty()
+}
@@ -0,0 +1,144 @@
+package negotiator
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BaseSuite struct {
+	Storer *memory.Storage
+	when   time.Time
+}
+
+func (s *BaseSuite) SetUpTest(c *C) {
+	s.Storer = memory.NewStorage()
+	s.when = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// chain commits n commits on top of the given parent, each one a minute after
+// the previous one, and returns them from the oldest to the most recent.
+func (s *BaseSuite) chain(c *C, parent plumbing.Hash, n int) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	for i := 0; i < n; i++ {
+		var parents []plumbing.Hash
+		if !parent.IsZero() {
+			parents = append(parents, parent)
+		}
+
+		parent = s.commit(c, parents...)
+		hashes = append(hashes, parent)
+	}
+
+	return hashes
+}
+
+func (s *BaseSuite) commit(c *C, parents ...plumbing.Hash) plumbing.Hash {
+	s.when = s.when.Add(time.Minute)
+	sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: s.when}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      s.when.String(),
+		ParentHashes: parents,
+	}
+
+	o := s.Storer.NewEncodedObject()
+	c.Assert(commit.Encode(o), IsNil)
+	h, err := s.Storer.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+	return h
+}
+
+// haves returns the haves sent by n until it is exhausted.
+func haves(c *C, n Negotiator) []plumbing.Hash {
+	var result []plumbing.Hash
+	for {
+		h, err := n.Next()
+		c.Assert(err, IsNil)
+		if h.IsZero() {
+			return result
+		}
+
+		result = append(result, h)
+	}
+}
+
+type NegotiatorSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&NegotiatorSuite{})
+
+func (s *NegotiatorSuite) TestNew(c *C) {
+	n, err := New(Consecutive, s.Storer)
+	c.Assert(err, IsNil)
+	c.Assert(n, FitsTypeOf, &consecutive{})
+
+	n, err = New(Skipping, s.Storer)
+	c.Assert(err, IsNil)
+	c.Assert(n, FitsTypeOf, &skipping{})
+
+	_, err = New(Algorithm(42), s.Storer)
+	c.Assert(err, Equals, ErrUnknownAlgorithm)
+}
+
+func (s *NegotiatorSuite) TestAlgorithmValidate(c *C) {
+	c.Assert(Consecutive.Validate(), IsNil)
+	c.Assert(Skipping.Validate(), IsNil)
+	c.Assert(Algorithm(42).Validate(), Equals, ErrUnknownAlgorithm)
+}
+
+func (s *NegotiatorSuite) TestMissingAndNonCommitTips(c *C) {
+	blob := s.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	h, err := s.Storer.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	for _, a := range []Algorithm{Consecutive, Skipping} {
+		n, err := New(a, s.Storer)
+		c.Assert(err, IsNil)
+		c.Assert(n.AddTip(h), IsNil)
+		c.Assert(n.AddTip(plumbing.NewHash("1111111111111111111111111111111111111111")), IsNil)
+		c.Assert(haves(c, n), HasLen, 0)
+	}
+}
+
+func (s *NegotiatorSuite) TestTagTip(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 2)
+
+	tag := &object.Tag{
+		Name:       "foo",
+		Tagger:     object.Signature{Name: "foo", Email: "foo@foo.foo", When: s.when},
+		Target:     commits[1],
+		TargetType: plumbing.CommitObject,
+	}
+
+	o := s.Storer.NewEncodedObject()
+	c.Assert(tag.Encode(o), IsNil)
+	h, err := s.Storer.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+
+	n := NewConsecutive(s.Storer)
+	c.Assert(n.AddTip(h), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{commits[1], commits[0]})
+}
+
+func (s *NegotiatorSuite) TestMissingParent(c *C) {
+	// the parent is missing, as beyond the boundary of a shallow repository
+	h := s.commit(c, plumbing.NewHash("1111111111111111111111111111111111111111"))
+
+	for _, a := range []Algorithm{Consecutive, Skipping} {
+		n, err := New(a, s.Storer)
+		c.Assert(err, IsNil)
+		c.Assert(n.AddTip(h), IsNil)
+		c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{h})
+	}
+}
@@ -0,0 +1,165 @@
+package negotiator
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+type skipping struct {
+	g *graph
+	q *queue
+	// entries are the queued entries, by commit.
+	entries map[*node]*entry
+	// nonCommon is the number of queued commits not known to be common.
+	nonCommon int
+}
+
+// NewSkipping returns a Negotiator sending the commits reachable from the
+// tips as have, from the most recent to the oldest one, skipping an
+// increasing number of them after each one sent, as the skipping algorithm
+// of git does: 1, 2, 4, 7, 11, 17... commits are skipped.
+func NewSkipping(s storer.EncodedObjectStorer) Negotiator {
+	return &skipping{
+		g:       newGraph(s),
+		q:       newQueue(),
+		entries: make(map[*node]*entry),
+	}
+}
+
+func (n *skipping) push(c *node, mark flag) *entry {
+	c.flags |= mark | seen
+	e := n.q.push(c)
+	n.entries[c] = e
+	if mark&common == 0 {
+		n.nonCommon++
+	}
+
+	return e
+}
+
+// markCommon marks c and its ancestors already seen as common.
+func (n *skipping) markCommon(c *node) {
+	if c.has(common) {
+		return
+	}
+
+	c.flags |= common
+	pending := []*node{c}
+	for len(pending) > 0 {
+		c, pending = pending[0], pending[1:]
+		if !c.has(popped) {
+			n.nonCommon--
+		}
+
+		for _, p := range c.parents {
+			if !p.has(seen) || p.has(common) {
+				continue
+			}
+
+			p.flags |= common
+			pending = append(pending, p)
+		}
+	}
+}
+
+// pushParent ensures that p is queued, with the ttl following the one of e.
+// It returns false if p was already popped.
+func (n *skipping) pushParent(e *entry, p *node) bool {
+	pe, ok := n.entries[p]
+	switch {
+	case ok:
+	case p.has(seen):
+		// already popped, due to clock skew, ignore it
+		return false
+	default:
+		pe = n.push(p, 0)
+	}
+
+	if e.node.has(common | advertised) {
+		n.markCommon(p)
+		return true
+	}
+
+	originalTTL, ttl := e.originalTTL, e.ttl-1
+	if e.ttl == 0 {
+		originalTTL = e.originalTTL*3/2 + 1
+		ttl = originalTTL
+	}
+
+	if pe.originalTTL < originalTTL {
+		pe.originalTTL = originalTTL
+		pe.ttl = ttl
+	}
+
+	return true
+}
+
+// KnownCommon marks the commit as advertised, its ancestors are not sent.
+func (n *skipping) KnownCommon(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil || c.has(seen) {
+		return err
+	}
+
+	n.push(c, advertised)
+	return nil
+}
+
+// AddTip adds a commit to walk from.
+func (n *skipping) AddTip(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil || c.has(seen) {
+		return err
+	}
+
+	n.push(c, 0)
+	return nil
+}
+
+// Next returns the next commit to send as have, or plumbing.ZeroHash once
+// every commit not known to be common is sent or skipped.
+func (n *skipping) Next() (plumbing.Hash, error) {
+	for {
+		if n.q.empty() || n.nonCommon == 0 {
+			return plumbing.ZeroHash, nil
+		}
+
+		e, _ := n.q.pop()
+		c := e.node
+		delete(n.entries, c)
+		c.flags |= popped
+		if !c.has(common) {
+			n.nonCommon--
+		}
+
+		send := !c.has(common) && e.ttl == 0
+
+		parents, err := n.g.parents(c)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		var pushed bool
+		for _, p := range parents {
+			if n.pushParent(e, p) {
+				pushed = true
+			}
+		}
+
+		// without parents left to walk, the commit is sent anyway
+		if send || !c.has(common) && !pushed {
+			return c.hash, nil
+		}
+	}
+}
+
+// Ack marks the commit and its ancestors already seen as common.
+func (n *skipping) Ack(h plumbing.Hash) error {
+	c, err := n.g.node(h)
+	if err != nil || c == nil || !c.has(seen) {
+		return err
+	}
+
+	n.markCommon(c)
+	return nil
+}
@@ -0,0 +1,60 @@
+package negotiator
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type SkippingSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&SkippingSuite{})
+
+func (s *SkippingSuite) TestNext(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 30)
+
+	n := NewSkipping(s.Storer)
+	c.Assert(n.AddTip(commits[29]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{
+		commits[29], commits[27], commits[24], commits[19], commits[11],
+		commits[0],
+	})
+}
+
+func (s *SkippingSuite) TestKnownCommon(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 10)
+
+	n := NewSkipping(s.Storer)
+	c.Assert(n.KnownCommon(commits[6]), IsNil)
+	c.Assert(n.AddTip(commits[9]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{
+		commits[9], commits[7],
+	})
+}
+
+func (s *SkippingSuite) TestAck(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 30)
+
+	n := NewSkipping(s.Storer)
+	c.Assert(n.AddTip(commits[29]), IsNil)
+
+	for _, expected := range []plumbing.Hash{commits[29], commits[27]} {
+		h, err := n.Next()
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, expected)
+	}
+
+	c.Assert(n.Ack(commits[27]), IsNil)
+	c.Assert(haves(c, n), HasLen, 0)
+}
+
+func (s *SkippingSuite) TestAckNotSent(c *C) {
+	commits := s.chain(c, plumbing.ZeroHash, 3)
+
+	n := NewSkipping(s.Storer)
+	c.Assert(n.AddTip(commits[2]), IsNil)
+	c.Assert(n.Ack(commits[0]), IsNil)
+	c.Assert(haves(c, n), DeepEquals, []plumbing.Hash{commits[2], commits[0]})
+}
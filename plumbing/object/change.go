@@ -12,10 +12,17 @@ import (
 // Change values represent a detected change between two git trees.  For
 // modifications, From is the original status of the node and To is its
 // final status.  For insertions, From is the zero value and for
-// deletions To is the zero value.
+// deletions To is the zero value.  Renames and copies, detected by
+// DiffTreeWithOptions, are modifications where From and To have different
+// names.
 type Change struct {
 	From ChangeEntry
 	To   ChangeEntry
+	// Similarity is the percentage of the content of From present in To, for
+	// renames and copies. It is zero for any other change.
+	Similarity int
+	// Copy is true if the change is a copy, From is kept along with To.
+	Copy bool
 }
 
 var empty = ChangeEntry{}
@@ -76,6 +83,13 @@ func (c *Change) String() string {
 		return fmt.Sprintf("malformed change")
 	}
 
+	switch {
+	case c.Copy:
+		return fmt.Sprintf("<Action: Copy, Path: %s => %s>", c.From.Name, c.To.Name)
+	case c.Similarity != 0:
+		return fmt.Sprintf("<Action: Rename, Path: %s => %s>", c.From.Name, c.To.Name)
+	}
+
 	return fmt.Sprintf("<Action: %s, Path: %s>", action, c.name())
 }
 
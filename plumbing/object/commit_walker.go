@@ -184,6 +184,65 @@ func (w *commitPostIterator) ForEach(cb func(*Commit) error) error {
 
 func (w *commitPostIterator) Close() {}
 
+type commitFirstParentIterator struct {
+	seenExternal map[plumbing.Hash]bool
+	next         *Commit
+	err          error
+}
+
+// NewCommitFirstParentIter returns a CommitIter that walks the commit history
+// starting at the given commit and following only the first parent of each
+// commit, as git log --first-parent does. The walk stops at the first commit
+// in seenExternal.
+func NewCommitFirstParentIter(c *Commit, seenExternal map[plumbing.Hash]bool) CommitIter {
+	return &commitFirstParentIterator{
+		seenExternal: seenExternal,
+		next:         c,
+	}
+}
+
+func (w *commitFirstParentIterator) Next() (*Commit, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	c := w.next
+	if c == nil || w.seenExternal[c.Hash] {
+		return nil, io.EOF
+	}
+
+	w.next = nil
+	if c.NumParents() > 0 {
+		w.next, w.err = GetCommit(c.s, c.ParentHashes[0])
+	}
+
+	return c, nil
+}
+
+func (w *commitFirstParentIterator) ForEach(cb func(*Commit) error) error {
+	for {
+		c, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cb(c)
+		if err == storer.ErrStop {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *commitFirstParentIterator) Close() {}
+
 // commitAllIterator stands for commit iterator for all refs.
 type commitAllIterator struct {
 	// currCommit points to the current commit.
@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"io"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -13,6 +14,7 @@ type commitFileIter struct {
 	sourceIter    CommitIter
 	currentCommit *Commit
 	checkParent   bool
+	follow        bool
 }
 
 // NewCommitFileIterFromIter returns a commit iterator which performs diffTree between
@@ -28,6 +30,17 @@ func NewCommitFileIterFromIter(fileName string, commitIter CommitIter, checkPare
 	return iterator
 }
 
+// NewCommitFileFollowIterFromIter returns a commit iterator like
+// NewCommitFileIterFromIter which also follows the history of the file beyond
+// renames, detected by content similarity. When the file was renamed in a
+// commit, the commit is returned and the iteration continues with the
+// previous name of the file. It is equivalent to `git log --follow`.
+func NewCommitFileFollowIterFromIter(fileName string, commitIter CommitIter, checkParent bool) CommitIter {
+	iterator := NewCommitFileIterFromIter(fileName, commitIter, checkParent).(*commitFileIter)
+	iterator.follow = true
+	return iterator
+}
+
 func (c *commitFileIter) Next() (*Commit, error) {
 	if c.currentCommit == nil {
 		var err error
@@ -86,6 +99,12 @@ func (c *commitFileIter) getNextFileCommit() (*Commit, error) {
 		c.currentCommit = parentCommit
 
 		if found {
+			if c.follow && parentTree != nil {
+				if err := c.followRename(changes, parentTree, currentTree); err != nil {
+					return nil, err
+				}
+			}
+
 			return prevCommit, nil
 		}
 
@@ -116,6 +135,43 @@ func (c *commitFileIter) hasFileChange(changes Changes, parent *Commit) bool {
 	return false
 }
 
+// followRename looks for the previous name of the file when it was inserted
+// in the current commit, detecting a rename from the parent tree. If found,
+// the iteration continues with the previous name.
+func (c *commitFileIter) followRename(changes Changes, parentTree, currentTree *Tree) error {
+	var inserted bool
+	for _, change := range changes {
+		// changes go from the current tree to the parent one, so the file
+		// inserted by the current commit shows up as deleted.
+		if change.From.Name == c.fileName && change.To == empty {
+			inserted = true
+			break
+		}
+	}
+
+	if !inserted {
+		return nil
+	}
+
+	renames, err := DiffTreeWithOptions(context.Background(), parentTree, currentTree, &DiffTreeOptions{
+		DetectRenames: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, change := range renames {
+		if change.To.Name != c.fileName || change.From == empty || change.Copy {
+			continue
+		}
+
+		c.fileName = change.From.Name
+		return nil
+	}
+
+	return nil
+}
+
 func isParentHash(hash plumbing.Hash, commit *Commit) bool {
 	for _, h := range commit.ParentHashes {
 		if h == hash {
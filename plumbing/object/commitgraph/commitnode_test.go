@@ -63,6 +63,53 @@ func testWalker(c *C, nodeIndex CommitNodeIndex) {
 	}
 }
 
+func testTopoOrderWalker(c *C, nodeIndex CommitNodeIndex) {
+	head, err := nodeIndex.Get(plumbing.NewHash("b9d69064b190e7aedccf84731ca1d917871f8a1c"))
+	c.Assert(err, IsNil)
+
+	expected := []string{
+		"b9d69064b190e7aedccf84731ca1d917871f8a1c",
+		"6f6c5d2be7852c782be1dd13e36496dd7ad39560",
+		"a45273fe2d63300e1962a9e26a6b15c276cd7082",
+		"c0edf780dd0da6a65a7a49a86032fcf8a0c2d467",
+		"bb13916df33ed23004c3ce9ed3b8487528e655c1",
+		"03d2c021ff68954cf3ef0a36825e194a4b98f981",
+		"ce275064ad67d51e99f026084e20827901a8361c",
+		"e713b52d7e13807e87a002e812041f248db3f643",
+		"347c91919944a68e9413581a1bc15519550a3afe",
+	}
+
+	for _, newIter := range []func([]CommitNode, map[plumbing.Hash]bool, []plumbing.Hash) (CommitNodeIter, error){
+		NewCommitNodeIterTopoOrder,
+		NewCommitNodeIterAuthorDateOrder,
+	} {
+		iter, err := newIter([]CommitNode{head}, nil, nil)
+		c.Assert(err, IsNil)
+
+		var commits []string
+		err = iter.ForEach(func(c CommitNode) error {
+			commits = append(commits, c.ID().String())
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(commits, DeepEquals, expected)
+	}
+
+	// a tip reachable from another one is visited after its children
+	merge3, err := nodeIndex.Get(plumbing.NewHash("6f6c5d2be7852c782be1dd13e36496dd7ad39560"))
+	c.Assert(err, IsNil)
+	iter, err := NewCommitNodeIterTopoOrder([]CommitNode{merge3, head}, nil, nil)
+	c.Assert(err, IsNil)
+
+	var commits []string
+	err = iter.ForEach(func(c CommitNode) error {
+		commits = append(commits, c.ID().String())
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(commits, DeepEquals, expected)
+}
+
 func testParents(c *C, nodeIndex CommitNodeIndex) {
 	merge3, err := nodeIndex.Get(plumbing.NewHash("6f6c5d2be7852c782be1dd13e36496dd7ad39560"))
 	c.Assert(err, IsNil)
@@ -102,6 +149,7 @@ func (s *CommitNodeSuite) TestObjectGraph(c *C) {
 
 	nodeIndex := NewObjectCommitNodeIndex(storer)
 	testWalker(c, nodeIndex)
+	testTopoOrderWalker(c, nodeIndex)
 	testParents(c, nodeIndex)
 	testCommitAndTree(c, nodeIndex)
 }
@@ -117,6 +165,7 @@ func (s *CommitNodeSuite) TestCommitGraph(c *C) {
 
 	nodeIndex := NewGraphCommitNodeIndex(index, storer)
 	testWalker(c, nodeIndex)
+	testTopoOrderWalker(c, nodeIndex)
 	testParents(c, nodeIndex)
 	testCommitAndTree(c, nodeIndex)
 }
@@ -142,6 +191,37 @@ func (s *CommitNodeSuite) TestMixedGraph(c *C) {
 
 	nodeIndex := NewGraphCommitNodeIndex(memoryIndex, storer)
 	testWalker(c, nodeIndex)
+	testTopoOrderWalker(c, nodeIndex)
 	testParents(c, nodeIndex)
 	testCommitAndTree(c, nodeIndex)
 }
+
+func (s *CommitNodeSuite) TestTopoOrderWalkerGeneration(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	storer := unpackRepositry(f)
+	reader, err := storer.Filesystem().Open(path.Join("objects", "info", "commit-graph"))
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	index, err := commitgraph.OpenFileIndex(reader)
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		nodeIndex  CommitNodeIndex
+		unexplored bool
+	}{
+		{NewObjectCommitNodeIndex(storer), false},
+		{NewGraphCommitNodeIndex(index, storer), true},
+	} {
+		head, err := t.nodeIndex.Get(plumbing.NewHash("b9d69064b190e7aedccf84731ca1d917871f8a1c"))
+		c.Assert(err, IsNil)
+
+		iter, err := NewCommitNodeIterTopoOrder([]CommitNode{head}, nil, nil)
+		c.Assert(err, IsNil)
+
+		// with generation numbers, the history is explored as it is walked
+		node, err := iter.Next()
+		c.Assert(err, IsNil)
+		c.Assert(node.ID(), Equals, head.ID())
+		c.Assert(iter.(*commitNodeIteratorTopoOrder).explore.Empty(), Equals, !t.unexplored)
+	}
+}
@@ -0,0 +1,286 @@
+package commitgraph
+
+import (
+	"io"
+	"time"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// commitNodeQueue holds the commits ready to be returned by a
+// commitNodeIteratorTopoOrder, all their children having been returned.
+type commitNodeQueue interface {
+	Push(CommitNode) error
+	Pop() (CommitNode, bool)
+}
+
+type commitNodeIteratorTopoOrder struct {
+	// explore holds the commits found but not explored yet, the highest
+	// generation first.
+	explore  *binaryheap.Heap
+	ready    commitNodeQueue
+	indegree map[plumbing.Hash]int
+	found    map[plumbing.Hash]bool
+	seen     map[plumbing.Hash]bool
+
+	seenExternal map[plumbing.Hash]bool
+}
+
+// NewCommitNodeIterTopoOrder returns a CommitNodeIter that walks the commit
+// history, starting at the given commits, in topological order: a commit is
+// never visited before all its children, and the commits of a line of history
+// are visited together, as `git log --topo-order` does.
+//
+// To know that all the children of a commit were visited, the history is
+// explored only down to the generation number of the commit, if the nodes
+// come from a commit-graph file. Otherwise, the whole history is explored
+// before the first commit is returned. Each commit will be visited only once.
+// Commits in seenExternal are not traversed and ignore allows to skip some
+// commits from being iterated.
+func NewCommitNodeIterTopoOrder(
+	tips []CommitNode,
+	seenExternal map[plumbing.Hash]bool,
+	ignore []plumbing.Hash,
+) (CommitNodeIter, error) {
+	return newCommitNodeIterTopoOrder(tips, &commitNodeStack{}, seenExternal, ignore)
+}
+
+// NewCommitNodeIterAuthorDateOrder returns a CommitNodeIter that walks the
+// commit history as NewCommitNodeIterTopoOrder does, but visiting the commits
+// by author time, the newest first, when all their children were visited, as
+// `git log --author-date-order` does.
+func NewCommitNodeIterAuthorDateOrder(
+	tips []CommitNode,
+	seenExternal map[plumbing.Hash]bool,
+	ignore []plumbing.Hash,
+) (CommitNodeIter, error) {
+	return newCommitNodeIterTopoOrder(tips, newCommitNodeAuthorDateQueue(), seenExternal, ignore)
+}
+
+func newCommitNodeIterTopoOrder(
+	tips []CommitNode,
+	ready commitNodeQueue,
+	seenExternal map[plumbing.Hash]bool,
+	ignore []plumbing.Hash,
+) (CommitNodeIter, error) {
+	seen := make(map[plumbing.Hash]bool)
+	for _, h := range ignore {
+		seen[h] = true
+	}
+
+	w := &commitNodeIteratorTopoOrder{
+		explore: binaryheap.NewWith(func(a, b interface{}) int {
+			ga, gb := a.(CommitNode).Generation(), b.(CommitNode).Generation()
+			switch {
+			case ga > gb:
+				return -1
+			case ga < gb:
+				return 1
+			case a.(CommitNode).CommitTime().Before(b.(CommitNode).CommitTime()):
+				return 1
+			}
+			return -1
+		}),
+		ready:        ready,
+		indegree:     make(map[plumbing.Hash]int),
+		found:        make(map[plumbing.Hash]bool),
+		seen:         seen,
+		seenExternal: seenExternal,
+	}
+
+	// the ready commits are pushed in reverse order, the first tip being
+	// the first one visited
+	for i := len(tips) - 1; i >= 0; i-- {
+		c := tips[i]
+		if w.skip(c.ID()) {
+			continue
+		}
+
+		w.find(c)
+		if err := w.ready.Push(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *commitNodeIteratorTopoOrder) skip(h plumbing.Hash) bool {
+	return w.seen[h] || w.seenExternal[h]
+}
+
+func (w *commitNodeIteratorTopoOrder) find(c CommitNode) {
+	if w.found[c.ID()] {
+		return
+	}
+
+	w.found[c.ID()] = true
+	w.explore.Push(c)
+}
+
+// exploreTo explores the commits with a generation not lower than the given
+// one, counting the children of their parents. All the children of a commit
+// of that generation are explored then, having a higher generation.
+func (w *commitNodeIteratorTopoOrder) exploreTo(generation uint64) error {
+	for {
+		v, ok := w.explore.Peek()
+		if !ok || v.(CommitNode).Generation() < generation {
+			return nil
+		}
+
+		w.explore.Pop()
+		c := v.(CommitNode)
+		for i, h := range c.ParentHashes() {
+			if w.skip(h) {
+				continue
+			}
+
+			w.indegree[h]++
+			if w.found[h] {
+				continue
+			}
+
+			p, err := c.ParentNode(i)
+			if err != nil {
+				return err
+			}
+
+			w.find(p)
+		}
+	}
+}
+
+func (w *commitNodeIteratorTopoOrder) Next() (CommitNode, error) {
+	for {
+		c, ok := w.ready.Pop()
+		if !ok {
+			return nil, io.EOF
+		}
+
+		if w.seen[c.ID()] {
+			continue
+		}
+
+		if err := w.exploreTo(c.Generation()); err != nil {
+			return nil, err
+		}
+
+		// a child of the commit was found since it was ready, it will be
+		// ready again once that child is visited
+		if w.indegree[c.ID()] > 0 {
+			continue
+		}
+
+		w.seen[c.ID()] = true
+		for i, h := range c.ParentHashes() {
+			if w.skip(h) {
+				continue
+			}
+
+			w.indegree[h]--
+			if w.indegree[h] > 0 {
+				continue
+			}
+
+			p, err := c.ParentNode(i)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := w.ready.Push(p); err != nil {
+				return nil, err
+			}
+		}
+
+		return c, nil
+	}
+}
+
+func (w *commitNodeIteratorTopoOrder) ForEach(cb func(CommitNode) error) error {
+	for {
+		c, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cb(c)
+		if err == storer.ErrStop {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *commitNodeIteratorTopoOrder) Close() {}
+
+// commitNodeStack returns the last ready commit first, keeping together the
+// commits of a line of history.
+type commitNodeStack struct {
+	commits []CommitNode
+}
+
+func (s *commitNodeStack) Push(c CommitNode) error {
+	s.commits = append(s.commits, c)
+	return nil
+}
+
+func (s *commitNodeStack) Pop() (CommitNode, bool) {
+	if len(s.commits) == 0 {
+		return nil, false
+	}
+
+	c := s.commits[len(s.commits)-1]
+	s.commits = s.commits[:len(s.commits)-1]
+	return c, true
+}
+
+// commitNodeAuthorDateQueue returns the ready commit with the newest author
+// time first.
+type commitNodeAuthorDateQueue struct {
+	heap *binaryheap.Heap
+}
+
+type authorDateCommitNode struct {
+	CommitNode
+	when time.Time
+}
+
+func newCommitNodeAuthorDateQueue() *commitNodeAuthorDateQueue {
+	return &commitNodeAuthorDateQueue{
+		heap: binaryheap.NewWith(func(a, b interface{}) int {
+			if a.(*authorDateCommitNode).when.Before(b.(*authorDateCommitNode).when) {
+				return 1
+			}
+			return -1
+		}),
+	}
+}
+
+func (q *commitNodeAuthorDateQueue) Push(c CommitNode) error {
+	commit, err := c.Commit()
+	if err != nil {
+		return err
+	}
+
+	q.heap.Push(&authorDateCommitNode{CommitNode: c, when: commit.Author.When})
+	return nil
+}
+
+func (q *commitNodeAuthorDateQueue) Pop() (CommitNode, bool) {
+	v, ok := q.heap.Pop()
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*authorDateCommitNode).CommitNode, true
+}
@@ -0,0 +1,204 @@
+// Package graph lays out a commit history in the lanes drawn by
+// `git log --graph`, to be rendered as ASCII in a terminal, or from the lanes
+// and edges of each commit in other user interfaces.
+package graph
+
+import (
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Graph lays out the commits added to it in lanes, a lane being the line of
+// history going down to the next commit expected in it. The commits must be
+// added with the children before their parents, as in topological order.
+type Graph struct {
+	lanes []plumbing.Hash
+}
+
+// New returns an empty Graph.
+func New() *Graph {
+	return &Graph{}
+}
+
+// Row is the layout of a commit in a Graph.
+type Row struct {
+	// Hash is the hash of the commit.
+	Hash plumbing.Hash
+	// Parents is the number of parents of the commit.
+	Parents int
+	// Lanes are the commits expected in each lane at the row, the commit
+	// itself being the one in its Column.
+	Lanes []plumbing.Hash
+	// Column is the lane of the commit.
+	Column int
+	// Edges link the lanes of the row to the lanes of the next one.
+	Edges []Edge
+}
+
+// Edge is a line of history from a lane of a row to a lane of the next row.
+type Edge struct {
+	// From is the lane in the row.
+	From int
+	// To is the lane in the next row.
+	To int
+	// Hash is the commit expected in the lane To.
+	Hash plumbing.Hash
+}
+
+// AddCommit lays out the next commit of the history.
+func (g *Graph) AddCommit(c *object.Commit) *Row {
+	return g.Add(c.Hash, c.ParentHashes)
+}
+
+// Add lays out the next commit of the history, from its hash and the ones of
+// its parents. A commit not expected in any lane starts a new lane, on the
+// right. The lanes of the next row are the lanes of the row, with the parents
+// of the commit in place of its lane, a commit expected in several lanes
+// being kept only in the leftmost one.
+func (g *Graph) Add(h plumbing.Hash, parents []plumbing.Hash) *Row {
+	row := &Row{Hash: h, Parents: len(parents), Column: -1}
+	for i, l := range g.lanes {
+		if l == h {
+			row.Column = i
+			break
+		}
+	}
+
+	if row.Column == -1 {
+		row.Column = len(g.lanes)
+		g.lanes = append(g.lanes, h)
+	}
+
+	row.Lanes = g.lanes
+
+	var next []plumbing.Hash
+	insert := func(from int, h plumbing.Hash) {
+		to := len(next)
+		for i, l := range next {
+			if l == h {
+				to = i
+				break
+			}
+		}
+
+		if to == len(next) {
+			next = append(next, h)
+		}
+
+		row.Edges = append(row.Edges, Edge{From: from, To: to, Hash: h})
+	}
+
+	for i, l := range g.lanes {
+		if i != row.Column {
+			insert(i, l)
+			continue
+		}
+
+		for _, p := range parents {
+			insert(i, p)
+		}
+	}
+
+	g.lanes = next
+	return row
+}
+
+// Width returns the number of lanes of the row, or of the next one if it has
+// more.
+func (r *Row) Width() int {
+	width := len(r.Lanes)
+	for _, e := range r.Edges {
+		if e.To >= width {
+			width = e.To + 1
+		}
+	}
+
+	return width
+}
+
+// Lines returns the ASCII lines of the row, as `git log --graph` draws them,
+// padded to the same width: the commit line, where the commit is marked with
+// a *, and, if the lanes change from the row to the next one, a line with its
+// edges, drawn with |, /, \ and _.
+func (r *Row) Lines() []string {
+	width := 2 * r.Width()
+	lines := []string{pad(r.commitLine(), width)}
+	if r.Parents < 2 && r.straight() {
+		return lines
+	}
+
+	return append(lines, pad(r.edgesLine(width), width))
+}
+
+func (r *Row) commitLine() string {
+	var b strings.Builder
+	for i := range r.Lanes {
+		if i != r.Column {
+			b.WriteString("| ")
+			continue
+		}
+
+		b.WriteString("*")
+		// an octopus merge leads with dashes to its parents after the
+		// first two ones
+		if r.Parents > 2 {
+			b.WriteString(strings.Repeat("-", (r.Parents-2)*2-1))
+			b.WriteString(".")
+		}
+
+		b.WriteString(" ")
+	}
+
+	return b.String()
+}
+
+func (r *Row) straight() bool {
+	for _, e := range r.Edges {
+		if e.From != e.To {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Row) edgesLine(width int) string {
+	line := []byte(strings.Repeat(" ", width))
+	for _, e := range r.Edges {
+		if e.From == e.To {
+			line[2*e.To] = '|'
+		}
+	}
+
+	for _, e := range r.Edges {
+		switch {
+		case e.To > e.From:
+			setEdge(line, 2*e.To-1, '\\')
+		case e.To < e.From:
+			setEdge(line, 2*e.From-1, '/')
+			for i := 2*e.To + 1; i < 2*e.From-1; i++ {
+				if line[i] == ' ' {
+					line[i] = '_'
+				}
+			}
+		}
+	}
+
+	return string(line)
+}
+
+func setEdge(line []byte, i int, ch byte) {
+	if line[i] == ' ' || line[i] == '_' {
+		line[i] = ch
+	}
+}
+
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+
+	return s + strings.Repeat(" ", width-len(s))
+}
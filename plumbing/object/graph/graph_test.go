@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object/commitgraph"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type GraphSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&GraphSuite{})
+
+// draw returns the lines of the graph of the commits reachable from the tips,
+// in topological order, each commit line followed by the commit short hash.
+func (s *GraphSuite) draw(c *C, st storer.EncodedObjectStorer, tips ...string) string {
+	index := commitgraph.NewObjectCommitNodeIndex(st)
+
+	var nodes []commitgraph.CommitNode
+	for _, h := range tips {
+		node, err := index.Get(plumbing.NewHash(h))
+		c.Assert(err, IsNil)
+		nodes = append(nodes, node)
+	}
+
+	iter, err := commitgraph.NewCommitNodeIterTopoOrder(nodes, nil, nil)
+	c.Assert(err, IsNil)
+
+	g := New()
+	var lines []string
+	err = iter.ForEach(func(node commitgraph.CommitNode) error {
+		commit, err := node.Commit()
+		if err != nil {
+			return err
+		}
+
+		row := g.AddCommit(commit)
+		for i, line := range row.Lines() {
+			if i == 0 {
+				line += row.Hash.String()[:7]
+			}
+
+			lines = append(lines, line)
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	return strings.Join(lines, "\n")
+}
+
+func (s *GraphSuite) TestLines(c *C) {
+	f := fixtures.Basic().One()
+	st := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+
+	c.Assert(s.draw(c, st,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), Equals, strings.Join([]string{
+		"* 6ecf0ef",
+		"| * e8d3ffa",
+		"|/  ",
+		"* 918c48b",
+		"* af2d6a6",
+		"*   1669dce",
+		"|\\  ",
+		"| *   a5b8b09",
+		"| |\\  ",
+		"| | * b8e471f",
+		"| |/  ",
+		"* | 35e8510",
+		"|/  ",
+		"* b029517",
+	}, "\n"))
+}
+
+func (s *GraphSuite) TestLinesOctopusMerge(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	st := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	p := f.Packfile()
+	defer p.Close()
+	c.Assert(packfile.UpdateObjectStorage(st, p), IsNil)
+
+	c.Assert(s.draw(c, st, f.Head.String()), Equals, strings.Join([]string{
+		"* b9d6906",
+		"*-.   6f6c5d2",
+		"|\\ \\  ",
+		"| | * a45273f",
+		"| | * c0edf78",
+		"| * | bb13916",
+		"| * | 03d2c02",
+		"| |/  ",
+		"* | ce27506",
+		"* | e713b52",
+		"|/  ",
+		"* 347c919",
+	}, "\n"))
+}
+
+func (s *GraphSuite) TestAdd(c *C) {
+	a, b, m, r := plumbing.NewHash("aa"), plumbing.NewHash("bb"), plumbing.NewHash("0e"), plumbing.NewHash("0f")
+
+	g := New()
+	row := g.Add(m, []plumbing.Hash{a, b})
+	c.Assert(row.Column, Equals, 0)
+	c.Assert(row.Lanes, DeepEquals, []plumbing.Hash{m})
+	c.Assert(row.Edges, DeepEquals, []Edge{{0, 0, a}, {0, 1, b}})
+	c.Assert(row.Width(), Equals, 2)
+
+	row = g.Add(b, []plumbing.Hash{r})
+	c.Assert(row.Column, Equals, 1)
+	c.Assert(row.Lanes, DeepEquals, []plumbing.Hash{a, b})
+	c.Assert(row.Edges, DeepEquals, []Edge{{0, 0, a}, {1, 1, r}})
+	c.Assert(row.Lines(), DeepEquals, []string{"| * "})
+
+	row = g.Add(a, []plumbing.Hash{r})
+	c.Assert(row.Edges, DeepEquals, []Edge{{0, 0, r}, {1, 0, r}})
+	c.Assert(row.Lines(), DeepEquals, []string{"* | ", "|/  "})
+
+	row = g.Add(r, nil)
+	c.Assert(row.Edges, HasLen, 0)
+	c.Assert(row.Lines(), DeepEquals, []string{"* "})
+}
+
+func (s *GraphSuite) TestLinesLongEdge(c *C) {
+	r, x, y := plumbing.NewHash("0f"), plumbing.NewHash("01"), plumbing.NewHash("02")
+
+	g := New()
+	g.Add(plumbing.NewHash("aa"), []plumbing.Hash{r})
+	g.Add(plumbing.NewHash("bb"), []plumbing.Hash{x})
+	g.Add(plumbing.NewHash("cc"), []plumbing.Hash{y})
+	row := g.Add(plumbing.NewHash("dd"), []plumbing.Hash{r})
+	c.Assert(row.Column, Equals, 3)
+	c.Assert(row.Lines(), DeepEquals, []string{"| | | * ", "|_|_|/  "})
+}
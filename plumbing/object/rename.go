@@ -0,0 +1,501 @@
+package object
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+const (
+	// DefaultRenameScore is the minimum similarity used to detect renames
+	// and copies when none is given, as in git.
+	DefaultRenameScore = 50
+	// DefaultRenameLimit is the maximum number of files compared by content
+	// used when none is given.
+	DefaultRenameLimit = 1000
+
+	// similarityChunkSize is the maximum size of the chunks used to compute
+	// the similarity of two files, the content is split in lines or in
+	// chunks of this size for long lines, as git does.
+	similarityChunkSize = 64
+)
+
+// DiffTreeOptions are the options of DiffTreeWithOptions.
+type DiffTreeOptions struct {
+	// DetectRenames detects the deleted files inserted again with a
+	// different name, like the -M option of git diff.
+	DetectRenames bool
+	// DetectCopies detects the inserted files that are copies of the
+	// modified or deleted files, like the -C option of git diff. It implies
+	// DetectRenames.
+	DetectCopies bool
+	// FindCopiesHarder uses every file of the source tree as the possible
+	// source of a copy, not only the changed ones, like the
+	// --find-copies-harder option of git diff.
+	FindCopiesHarder bool
+	// OnlyExactRenames only detects the renames and copies of files whose
+	// content is not changed, the content of the files is not compared.
+	OnlyExactRenames bool
+	// RenameScore is the minimum similarity, a percentage of the content of
+	// the source present in the destination, to detect a rename or a copy.
+	// If zero, DefaultRenameScore is used.
+	RenameScore uint
+	// RenameLimit is the maximum number of sources and of destinations
+	// compared by content, when exceeded only exact renames and copies are
+	// detected. If zero, DefaultRenameLimit is used.
+	RenameLimit uint
+}
+
+// DiffTreeWithOptions compares the content and mode of the blobs found via
+// two tree objects, like DiffTree, detecting the renamed and copied files as
+// requested in the options. Renames and copies are returned as a single
+// Change with both From and To, and their similarity. Provided context must
+// be non-nil.
+func DiffTreeWithOptions(ctx context.Context, a, b *Tree, opts *DiffTreeOptions) (Changes, error) {
+	changes, err := DiffTreeContext(ctx, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil || !opts.DetectRenames && !opts.DetectCopies {
+		return changes, nil
+	}
+
+	d := newRenameDetector(ctx, a, b, opts)
+	if d.s == nil {
+		return changes, nil
+	}
+
+	return d.detect(changes, a)
+}
+
+// renameDetector pairs the deleted and the inserted files of a list of
+// changes into renames, and the inserted files with the copy sources into
+// copies.
+type renameDetector struct {
+	ctx     context.Context
+	opts    *DiffTreeOptions
+	score   int
+	limit   int
+	s       storer.EncodedObjectStorer
+	indexes map[plumbing.Hash]*similarityIndex
+
+	// usedSources are the deleted files already renamed.
+	usedSources map[*Change]bool
+	// results are the renames and copies of each inserted file.
+	results map[*Change]*Change
+}
+
+func newRenameDetector(ctx context.Context, a, b *Tree, opts *DiffTreeOptions) *renameDetector {
+	d := &renameDetector{
+		ctx:         ctx,
+		opts:        opts,
+		score:       int(opts.RenameScore),
+		limit:       int(opts.RenameLimit),
+		indexes:     make(map[plumbing.Hash]*similarityIndex),
+		usedSources: make(map[*Change]bool),
+		results:     make(map[*Change]*Change),
+	}
+
+	if d.score == 0 {
+		d.score = DefaultRenameScore
+	}
+
+	if d.limit == 0 {
+		d.limit = DefaultRenameLimit
+	}
+
+	switch {
+	case a != nil:
+		d.s = a.s
+	case b != nil:
+		d.s = b.s
+	}
+
+	return d
+}
+
+func (d *renameDetector) detect(changes Changes, source *Tree) (Changes, error) {
+	var deleted, inserted, modified []*Change
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case action == merkletrie.Delete && c.From.TreeEntry.Mode.IsFile():
+			deleted = append(deleted, c)
+		case action == merkletrie.Insert && c.To.TreeEntry.Mode.IsFile():
+			inserted = append(inserted, c)
+		case action == merkletrie.Modify:
+			modified = append(modified, c)
+		}
+	}
+
+	d.detectExactRenames(deleted, inserted)
+
+	var sources []ChangeEntry
+	if d.opts.DetectCopies {
+		var err error
+		if sources, err = d.copySources(deleted, modified, source); err != nil {
+			return nil, err
+		}
+
+		d.detectExactCopies(sources, inserted)
+	}
+
+	if !d.opts.OnlyExactRenames {
+		if err := d.detectRenames(deleted, inserted); err != nil {
+			return nil, err
+		}
+
+		if err := d.detectCopies(sources, inserted); err != nil {
+			return nil, err
+		}
+	}
+
+	var result Changes
+	for _, c := range changes {
+		if d.usedSources[c] {
+			continue
+		}
+
+		if r, ok := d.results[c]; ok {
+			c = r
+		}
+
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+func (d *renameDetector) detectExactRenames(deleted, inserted []*Change) {
+	byHash := make(map[plumbing.Hash][]*Change)
+	for _, c := range deleted {
+		byHash[c.From.TreeEntry.Hash] = append(byHash[c.From.TreeEntry.Hash], c)
+	}
+
+	for _, ins := range inserted {
+		var best *Change
+		for _, del := range byHash[ins.To.TreeEntry.Hash] {
+			if d.usedSources[del] || !compatibleModes(del.From, ins.To) {
+				continue
+			}
+
+			if best == nil || sameBaseName(del.From, ins.To) && !sameBaseName(best.From, ins.To) {
+				best = del
+			}
+		}
+
+		if best != nil {
+			d.rename(best, ins, 100)
+		}
+	}
+}
+
+func (d *renameDetector) detectExactCopies(sources []ChangeEntry, inserted []*Change) {
+	for _, ins := range inserted {
+		if d.results[ins] != nil {
+			continue
+		}
+
+		for _, src := range sources {
+			if src.TreeEntry.Hash == ins.To.TreeEntry.Hash && compatibleModes(src, ins.To) {
+				d.copy(src, ins, 100)
+				break
+			}
+		}
+	}
+}
+
+// candidate is a possible rename or copy of an inserted file.
+type candidate struct {
+	source ChangeEntry
+	del    *Change
+	ins    *Change
+	score  int
+}
+
+func (d *renameDetector) detectRenames(deleted, inserted []*Change) error {
+	var sources []ChangeEntry
+	var dels []*Change
+	for _, c := range deleted {
+		if !d.usedSources[c] {
+			sources = append(sources, c.From)
+			dels = append(dels, c)
+		}
+	}
+
+	candidates, err := d.candidates(sources, dels, inserted)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		if d.usedSources[c.del] || d.results[c.ins] != nil {
+			continue
+		}
+
+		d.rename(c.del, c.ins, c.score)
+	}
+
+	return nil
+}
+
+func (d *renameDetector) detectCopies(sources []ChangeEntry, inserted []*Change) error {
+	candidates, err := d.candidates(sources, nil, inserted)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		if d.results[c.ins] == nil {
+			d.copy(c.source, c.ins, c.score)
+		}
+	}
+
+	return nil
+}
+
+// candidates returns the pairs of source and inserted file, still not
+// renamed or copied, with a similarity over the score, the most similar
+// first. dels are the deleted changes of the sources, if any.
+func (d *renameDetector) candidates(sources []ChangeEntry, dels []*Change, inserted []*Change) ([]*candidate, error) {
+	var pending []*Change
+	for _, c := range inserted {
+		if d.results[c] == nil {
+			pending = append(pending, c)
+		}
+	}
+
+	if len(sources) == 0 || len(pending) == 0 ||
+		len(sources) > d.limit || len(pending) > d.limit {
+		return nil, nil
+	}
+
+	var candidates []*candidate
+	for _, ins := range pending {
+		for i, src := range sources {
+			select {
+			case <-d.ctx.Done():
+				return nil, ErrCanceled
+			default:
+			}
+
+			if !compatibleModes(src, ins.To) {
+				continue
+			}
+
+			score, err := d.similarity(src.TreeEntry.Hash, ins.To.TreeEntry.Hash)
+			if err != nil {
+				return nil, err
+			}
+
+			if score < d.score {
+				continue
+			}
+
+			c := &candidate{source: src, ins: ins, score: score}
+			if dels != nil {
+				c.del = dels[i]
+			}
+
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+
+		return sameBaseName(a.source, a.ins.To) && !sameBaseName(b.source, b.ins.To)
+	})
+
+	return candidates, nil
+}
+
+func (d *renameDetector) rename(del, ins *Change, score int) {
+	d.usedSources[del] = true
+	d.results[ins] = &Change{From: del.From, To: ins.To, Similarity: score}
+}
+
+func (d *renameDetector) copy(src ChangeEntry, ins *Change, score int) {
+	d.results[ins] = &Change{From: src, To: ins.To, Similarity: score, Copy: true}
+}
+
+// copySources returns the files that can be the source of a copy: the
+// deleted and modified files or, with FindCopiesHarder, every file of the
+// source tree.
+func (d *renameDetector) copySources(deleted, modified []*Change, source *Tree) ([]ChangeEntry, error) {
+	var sources []ChangeEntry
+	if !d.opts.FindCopiesHarder {
+		for _, c := range append(deleted, modified...) {
+			if c.From.TreeEntry.Mode.IsFile() {
+				sources = append(sources, c.From)
+			}
+		}
+
+		return sources, nil
+	}
+
+	if source == nil {
+		return nil, nil
+	}
+
+	trees := map[string]*Tree{".": source}
+	w := NewTreeWalker(source, true, nil)
+	defer w.Close()
+
+	for {
+		name, e, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.Mode.IsFile() {
+			continue
+		}
+
+		dir := path.Dir(name)
+		parent, ok := trees[dir]
+		if !ok {
+			if parent, err = source.Tree(dir); err != nil {
+				return nil, err
+			}
+
+			trees[dir] = parent
+		}
+
+		sources = append(sources, ChangeEntry{Name: name, Tree: parent, TreeEntry: e})
+	}
+
+	return sources, nil
+}
+
+// similarity returns the percentage of the content of the blob a present in
+// the blob b, relative to the size of the biggest one.
+func (d *renameDetector) similarity(a, b plumbing.Hash) (int, error) {
+	if a == b {
+		return 100, nil
+	}
+
+	ia, err := d.similarityIndex(a)
+	if err != nil {
+		return 0, err
+	}
+
+	ib, err := d.similarityIndex(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return ia.score(ib), nil
+}
+
+func (d *renameDetector) similarityIndex(h plumbing.Hash) (*similarityIndex, error) {
+	if idx, ok := d.indexes[h]; ok {
+		return idx, nil
+	}
+
+	blob, err := GetBlob(d.s, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+
+	idx := newSimilarityIndex(content)
+	d.indexes[h] = idx
+	return idx, nil
+}
+
+// similarityIndex is the fingerprint of a file used to compare it with
+// others, the count of bytes of each distinct chunk of its content.
+type similarityIndex struct {
+	size   int
+	chunks map[uint64]int
+}
+
+func newSimilarityIndex(content []byte) *similarityIndex {
+	idx := &similarityIndex{size: len(content), chunks: make(map[uint64]int)}
+	for len(content) != 0 {
+		n := 0
+		for n < len(content) && n < similarityChunkSize {
+			n++
+			if content[n-1] == '\n' {
+				break
+			}
+		}
+
+		h := fnv.New64a()
+		h.Write(content[:n])
+		idx.chunks[h.Sum64()] += n
+		content = content[n:]
+	}
+
+	return idx
+}
+
+// score returns the percentage of the bytes common to both files, relative
+// to the size of the biggest one.
+func (idx *similarityIndex) score(other *similarityIndex) int {
+	max := idx.size
+	if other.size > max {
+		max = other.size
+	}
+
+	if max == 0 {
+		return 100
+	}
+
+	var common int
+	for h, n := range idx.chunks {
+		m := other.chunks[h]
+		if m < n {
+			n = m
+		}
+
+		common += n
+	}
+
+	return common * 100 / max
+}
+
+// compatibleModes returns if a file can be renamed or copied to another, the
+// symlinks are only paired with symlinks.
+func compatibleModes(a, b ChangeEntry) bool {
+	return (a.TreeEntry.Mode == filemode.Symlink) == (b.TreeEntry.Mode == filemode.Symlink)
+}
+
+func sameBaseName(a, b ChangeEntry) bool {
+	return path.Base(a.Name) == path.Base(b.Name)
+}
@@ -0,0 +1,214 @@
+package object_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type RenameSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&RenameSuite{})
+
+var renameContent = strings.Repeat("line of the original file\n", 20)
+
+// trees commits the given states of the worktree, a file with empty content
+// is removed, and returns the tree of each commit.
+func (s *RenameSuite) trees(c *C, states ...map[string]string) []*object.Tree {
+	fs := memfs.New()
+	r, err := git.Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	var trees []*object.Tree
+	for _, files := range states {
+		for name, content := range files {
+			if content == "" {
+				_, err = w.Remove(name)
+				c.Assert(err, IsNil)
+				continue
+			}
+
+			c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+			_, err = w.Add(name)
+			c.Assert(err, IsNil)
+		}
+
+		h, err := w.Commit("foo\n", &git.CommitOptions{
+			Author: &object.Signature{Name: "Foo", Email: "foo@example.local", When: time.Now()},
+		})
+		c.Assert(err, IsNil)
+
+		commit, err := r.CommitObject(h)
+		c.Assert(err, IsNil)
+
+		tree, err := commit.Tree()
+		c.Assert(err, IsNil)
+		trees = append(trees, tree)
+	}
+
+	return trees
+}
+
+func (s *RenameSuite) diff(c *C, a, b *object.Tree, opts *object.DiffTreeOptions) object.Changes {
+	changes, err := object.DiffTreeWithOptions(context.Background(), a, b, opts)
+	c.Assert(err, IsNil)
+	return changes
+}
+
+func (s *RenameSuite) TestExactRename(c *C) {
+	t := s.trees(c,
+		map[string]string{"foo": renameContent, "bar": "bar\n"},
+		map[string]string{"foo": "", "dir/foo": renameContent},
+	)
+
+	changes := s.diff(c, t[0], t[1], nil)
+	c.Assert(changes, HasLen, 2)
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectRenames: true})
+	c.Assert(changes, HasLen, 1)
+	c.Assert(changes[0].From.Name, Equals, "foo")
+	c.Assert(changes[0].To.Name, Equals, "dir/foo")
+	c.Assert(changes[0].Similarity, Equals, 100)
+	c.Assert(changes[0].Copy, Equals, false)
+	c.Assert(changes[0].String(), Equals, "<Action: Rename, Path: foo => dir/foo>")
+
+	patch, err := changes.Patch()
+	c.Assert(err, IsNil)
+	c.Assert(patch.String(), Equals, "diff --git a/foo b/dir/foo\n"+
+		"similarity index 100%\n"+
+		"rename from foo\n"+
+		"rename to dir/foo\n",
+	)
+}
+
+func (s *RenameSuite) TestRenameWithChanges(c *C) {
+	changed := strings.Replace(renameContent, "original", "modified", 2)
+	t := s.trees(c,
+		map[string]string{"foo": renameContent},
+		map[string]string{"foo": "", "bar": changed},
+	)
+
+	changes := s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectRenames: true})
+	c.Assert(changes, HasLen, 1)
+	c.Assert(changes[0].From.Name, Equals, "foo")
+	c.Assert(changes[0].To.Name, Equals, "bar")
+	c.Assert(changes[0].Similarity, Equals, 90)
+
+	patch, err := changes.Patch()
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(patch.String(), "diff --git a/foo b/bar\n"+
+		"similarity index 90%\n"+
+		"rename from foo\n"+
+		"rename to bar\n"+
+		"index ",
+	), Equals, true)
+
+	stats := patch.Stats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].Name, Equals, "foo => bar")
+	c.Assert(stats[0].Addition, Equals, 2)
+	c.Assert(stats[0].Deletion, Equals, 2)
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   95,
+	})
+	c.Assert(changes, HasLen, 2)
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{
+		DetectRenames:    true,
+		OnlyExactRenames: true,
+	})
+	c.Assert(changes, HasLen, 2)
+}
+
+func (s *RenameSuite) TestRenameLimit(c *C) {
+	t := s.trees(c,
+		map[string]string{
+			"foo":  renameContent,
+			"bar":  "bar\n" + renameContent,
+			"quux": "quux\n" + renameContent,
+		},
+		map[string]string{
+			"foo":   "",
+			"bar":   "",
+			"quux":  "",
+			"baz":   "bar\n" + renameContent + "baz\n",
+			"corge": "quux\n" + renameContent + "corge\n",
+			"qux":   renameContent,
+		},
+	)
+
+	changes := s.diff(c, t[0], t[1], &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameLimit:   1,
+	})
+	c.Assert(changes.String(), Equals, "[<Action: Delete, Path: bar>, "+
+		"<Action: Insert, Path: baz>, <Action: Insert, Path: corge>, "+
+		"<Action: Delete, Path: quux>, <Action: Rename, Path: foo => qux>]")
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectRenames: true})
+	c.Assert(changes.String(), Equals, "[<Action: Rename, Path: bar => baz>, "+
+		"<Action: Rename, Path: quux => corge>, <Action: Rename, Path: foo => qux>]")
+}
+
+func (s *RenameSuite) TestCopy(c *C) {
+	t := s.trees(c,
+		map[string]string{"foo": renameContent, "bar": "bar\n"},
+		map[string]string{"foo": renameContent + "more\n", "baz": renameContent},
+	)
+
+	changes := s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectRenames: true})
+	c.Assert(changes, HasLen, 2)
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectCopies: true})
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[0].From.Name, Equals, "foo")
+	c.Assert(changes[0].To.Name, Equals, "baz")
+	c.Assert(changes[0].Copy, Equals, true)
+	c.Assert(changes[0].Similarity, Equals, 100)
+	c.Assert(changes[0].String(), Equals, "<Action: Copy, Path: foo => baz>")
+
+	patch, err := changes[0].Patch()
+	c.Assert(err, IsNil)
+	c.Assert(patch.String(), Equals, "diff --git a/foo b/baz\n"+
+		"similarity index 100%\n"+
+		"copy from foo\n"+
+		"copy to baz\n",
+	)
+}
+
+func (s *RenameSuite) TestFindCopiesHarder(c *C) {
+	t := s.trees(c,
+		map[string]string{"foo": renameContent, "bar": "bar\n"},
+		map[string]string{"bar": "baz\n", "dir/baz": renameContent + "baz\n"},
+	)
+
+	changes := s.diff(c, t[0], t[1], &object.DiffTreeOptions{DetectCopies: true})
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[1].Copy, Equals, false)
+
+	changes = s.diff(c, t[0], t[1], &object.DiffTreeOptions{
+		DetectCopies:     true,
+		FindCopiesHarder: true,
+	})
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[1].From.Name, Equals, "foo")
+	c.Assert(changes[1].To.Name, Equals, "dir/baz")
+	c.Assert(changes[1].Copy, Equals, true)
+	c.Assert(changes[1].Similarity, Equals, 99)
+}
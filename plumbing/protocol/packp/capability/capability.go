@@ -84,6 +84,9 @@ const (
 	// understood thin packs. Adding 'no-thin' later allowed receive-pack
 	// to disable the feature in a backwards-compatible manner.
 	ThinPack Capability = "thin-pack"
+	// NoThin is advertised by a receive-pack server not accepting thin packs,
+	// see ThinPack.
+	NoThin Capability = "no-thin"
 	// Sideband means that server can send, and client understand multiplexed
 	// progress reports and error info interleaved with the packfile itself.
 	//
@@ -230,17 +233,38 @@ const (
 	PushCert Capability = "push-cert"
 	// SymRef symbolic reference support for better negotiation.
 	SymRef Capability = "symref"
+	// Filter if the upload-pack server advertises this capability, fetch-pack
+	// may send "filter" commands to request a partial clone or partial fetch
+	// and request that the server omit various objects from the packfile.
+	Filter Capability = "filter"
+)
+
+const (
+	// LsRefs is advertised by the version 2 of the protocol when the server
+	// supports the ls-refs command, used to list the references with an
+	// optional ref-prefix filter.
+	LsRefs Capability = "ls-refs"
+	// Fetch is advertised by the version 2 of the protocol when the server
+	// supports the fetch command, its value lists the features of the
+	// command supported by the server (e.g. "shallow").
+	Fetch Capability = "fetch"
+	// ServerOption is advertised by the version 2 of the protocol when the
+	// server accepts server-option lines on its commands.
+	ServerOption Capability = "server-option"
+	// ObjectFormat is advertised by the version 2 of the protocol with the
+	// hash algorithm used by the repository.
+	ObjectFormat Capability = "object-format"
 )
 
 const DefaultAgent = "go-git/4.x"
 
 var known = map[Capability]bool{
 	MultiACK: true, MultiACKDetailed: true, NoDone: true, ThinPack: true,
-	Sideband: true, Sideband64k: true, OFSDelta: true, Agent: true,
+	NoThin: true, Sideband: true, Sideband64k: true, OFSDelta: true, Agent: true,
 	Shallow: true, DeepenSince: true, DeepenNot: true, DeepenRelative: true,
 	NoProgress: true, IncludeTag: true, ReportStatus: true, DeleteRefs: true,
 	Quiet: true, Atomic: true, PushOptions: true, AllowTipSHA1InWant: true,
-	AllowReachableSHA1InWant: true, PushCert: true, SymRef: true,
+	AllowReachableSHA1InWant: true, PushCert: true, SymRef: true, Filter: true,
 }
 
 var requiresArgument = map[Capability]bool{
@@ -0,0 +1,123 @@
+package packp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+// CapabilityAdvertisement values represent the information transmitted on a
+// capability-advertisement message, sent by the servers speaking the version
+// 2 of the protocol instead of the advertised-refs message. Values from this
+// type are not zero-value safe, use the New function instead.
+type CapabilityAdvertisement struct {
+	// Capabilities are the capabilities and the commands supported by the
+	// server, e.g. ls-refs or fetch.
+	Capabilities *capability.List
+}
+
+// NewCapabilityAdvertisement returns a pointer to a new
+// CapabilityAdvertisement value, ready to be used.
+func NewCapabilityAdvertisement() *CapabilityAdvertisement {
+	return &CapabilityAdvertisement{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// IsVersion2 returns true if the payload of a pkt-line is the first line of a
+// capability-advertisement, announcing the version 2 of the protocol.
+func IsVersion2(payload []byte) bool {
+	return bytes.Equal(bytes.TrimSuffix(payload, eol), version2)
+}
+
+// Decode reads the next capability-advertisement message from r, including
+// its version line.
+func (a *CapabilityAdvertisement) Decode(r io.Reader) error {
+	s := pktline.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		return ErrEmptyInput
+	}
+
+	if !IsVersion2(s.Bytes()) {
+		return NewErrUnexpectedData("unexpected protocol version", s.Bytes())
+	}
+
+	return a.decodeCapabilities(s)
+}
+
+func (a *CapabilityAdvertisement) decodeCapabilities(s *pktline.Scanner) error {
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return nil
+		}
+
+		pair := bytes.SplitN(line, eq, 2)
+		c := capability.Capability(pair[0])
+
+		var err error
+		if len(pair) == 1 {
+			err = a.Capabilities.Add(c)
+		} else {
+			err = a.Capabilities.Add(c, string(pair[1]))
+		}
+
+		if err != nil {
+			return NewErrUnexpectedData("invalid capability", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return NewErrUnexpectedData("missing flush-pkt", nil)
+}
+
+// Encode writes the capability-advertisement encoding of a to w.
+func (a *CapabilityAdvertisement) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if err := e.Encodef("%s\n", version2); err != nil {
+		return err
+	}
+
+	for _, c := range a.Capabilities.All() {
+		values := a.Capabilities.Get(c)
+		if len(values) == 0 {
+			if err := e.Encodef("%s\n", c); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, v := range values {
+			if err := e.Encodef("%s=%s\n", c, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return e.Flush()
+}
+
+// SupportsFetchFeature returns true if the server supports the given feature
+// of the fetch command, e.g. "shallow" or "filter".
+func (a *CapabilityAdvertisement) SupportsFetchFeature(feature string) bool {
+	for _, v := range a.Capabilities.Get(capability.Fetch) {
+		for _, f := range strings.Fields(v) {
+			if f == feature {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package packp
+
+import (
+	"bytes"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type CapabilityAdvertisementSuite struct{}
+
+var _ = Suite(&CapabilityAdvertisementSuite{})
+
+func (s *CapabilityAdvertisementSuite) TestDecode(c *C) {
+	raw := pktlines(c,
+		"version 2\n",
+		"agent=git/2.39.5\n",
+		"ls-refs=unborn\n",
+		"fetch=shallow wait-for-done\n",
+		"server-option\n",
+		"object-format=sha1\n",
+		pktline.FlushString,
+	)
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(bytes.NewReader(raw)), IsNil)
+	c.Assert(adv.Capabilities.Get(capability.Agent), DeepEquals, []string{"git/2.39.5"})
+	c.Assert(adv.Capabilities.Supports(capability.LsRefs), Equals, true)
+	c.Assert(adv.Capabilities.Supports(capability.ServerOption), Equals, true)
+	c.Assert(adv.Capabilities.Get(capability.ObjectFormat), DeepEquals, []string{"sha1"})
+	c.Assert(adv.SupportsFetchFeature("shallow"), Equals, true)
+	c.Assert(adv.SupportsFetchFeature("wait-for-done"), Equals, true)
+	c.Assert(adv.SupportsFetchFeature("filter"), Equals, false)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeVersion0(c *C) {
+	raw := pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00ofs-delta\n",
+		pktline.FlushString,
+	)
+
+	adv := NewCapabilityAdvertisement()
+	err := adv.Decode(bytes.NewReader(raw))
+	c.Assert(err, FitsTypeOf, &ErrUnexpectedData{})
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeEmpty(c *C) {
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(bytes.NewReader(nil)), Equals, ErrEmptyInput)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeMissingFlush(c *C) {
+	raw := pktlines(c, "version 2\n", "ls-refs\n")
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(bytes.NewReader(raw)), ErrorMatches, "missing flush-pkt")
+}
+
+func (s *CapabilityAdvertisementSuite) TestEncode(c *C) {
+	adv := NewCapabilityAdvertisement()
+	adv.Capabilities.Add(capability.Agent, "go-git/4.x")
+	adv.Capabilities.Add(capability.LsRefs)
+	adv.Capabilities.Add(capability.Fetch, "shallow")
+
+	var buf bytes.Buffer
+	c.Assert(adv.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"version 2\n",
+		"agent=go-git/4.x\n",
+		"ls-refs\n",
+		"fetch=shallow\n",
+		pktline.FlushString,
+	))
+
+	decoded := NewCapabilityAdvertisement()
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded.Capabilities.String(), Equals, adv.Capabilities.String())
+}
+
+func (s *CapabilityAdvertisementSuite) TestIsVersion2(c *C) {
+	c.Assert(IsVersion2([]byte("version 2\n")), Equals, true)
+	c.Assert(IsVersion2([]byte("version 2")), Equals, true)
+	c.Assert(IsVersion2([]byte("version 1\n")), Equals, false)
+}
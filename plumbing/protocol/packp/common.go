@@ -28,11 +28,14 @@ var (
 
 	// upload-request
 	want            = []byte("want ")
+	have            = []byte("have ")
 	shallow         = []byte("shallow ")
 	deepen          = []byte("deepen")
 	deepenCommits   = []byte("deepen ")
 	deepenSince     = []byte("deepen-since ")
 	deepenReference = []byte("deepen-not ")
+	filter          = []byte("filter ")
+	done            = []byte("done")
 
 	// shallow-update
 	unshallow = []byte("unshallow ")
@@ -43,6 +46,16 @@ var (
 
 	// updreq
 	shallowNoSp = []byte("shallow")
+
+	// protocol version 2
+	version2            = []byte("version 2")
+	errPrefix           = []byte("ERR ")
+	symrefTargetAttr    = []byte("symref-target:")
+	peeledAttr          = []byte("peeled:")
+	readyLine           = []byte("ready")
+	acknowledgmentsLine = []byte("acknowledgments")
+	shallowInfoLine     = []byte("shallow-info")
+	packfileLine        = []byte("packfile")
 )
 
 func isFlush(payload []byte) bool {
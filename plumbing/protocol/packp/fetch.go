@@ -0,0 +1,296 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+// FetchRequest values represent the fetch command of the version 2 of the
+// protocol, used to request a packfile. Values from this type are not
+// zero-value safe, use the New functions instead.
+type FetchRequest struct {
+	// Capabilities are the capabilities sent along with the command, e.g.
+	// agent.
+	Capabilities *capability.List
+	Wants        []plumbing.Hash
+	Haves        []plumbing.Hash
+	Shallows     []plumbing.Hash
+	Depth        Depth
+	// DeepenRelative requests the depth to be counted from the shallow
+	// commits instead of the wanted ones.
+	DeepenRelative bool
+	// Filter requests a partial packfile, as UploadRequest.Filter does.
+	Filter Filter
+	// ThinPack, NoProgress, IncludeTag and OFSDelta request the features of
+	// the packfile with the same name as the capabilities of the version 0
+	// of the protocol.
+	ThinPack   bool
+	NoProgress bool
+	IncludeTag bool
+	OFSDelta   bool
+	// Done requests the packfile, ending the negotiation.
+	Done bool
+}
+
+// NewFetchRequest returns a pointer to a new FetchRequest value, ready to be
+// used. It has no wants, haves or shallows and an infinite depth.
+func NewFetchRequest() *FetchRequest {
+	return &FetchRequest{
+		Capabilities: capability.NewList(),
+		Depth:        DepthCommits(0),
+	}
+}
+
+// NewFetchRequestFromUploadPackRequest returns a pointer to a new FetchRequest
+// value equivalent to the given upload-pack request of the version 0 of the
+// protocol, its capabilities become the arguments of the command. As in the
+// version 0, no progress is requested without a sideband capability. The
+// request is done, the packfile is sent in the response.
+func NewFetchRequestFromUploadPackRequest(req *UploadPackRequest) *FetchRequest {
+	r := NewFetchRequest()
+	r.Wants = req.Wants
+	r.Haves = req.Haves
+	r.Shallows = req.Shallows
+	r.Depth = req.Depth
+	r.DeepenRelative = req.Capabilities.Supports(capability.DeepenRelative)
+	r.Filter = req.Filter
+	r.ThinPack = req.Capabilities.Supports(capability.ThinPack)
+	r.NoProgress = req.Capabilities.Supports(capability.NoProgress) ||
+		!(req.Capabilities.Supports(capability.Sideband) ||
+			req.Capabilities.Supports(capability.Sideband64k))
+	r.IncludeTag = req.Capabilities.Supports(capability.IncludeTag)
+	r.OFSDelta = req.Capabilities.Supports(capability.OFSDelta)
+	r.Done = true
+
+	if agent := req.Capabilities.Get(capability.Agent); len(agent) != 0 {
+		r.Capabilities.Set(capability.Agent, agent[0])
+	}
+
+	return r
+}
+
+// Encode writes the fetch command encoding of r to w.
+func (r *FetchRequest) Encode(w io.Writer) error {
+	if len(r.Wants) == 0 {
+		return fmt.Errorf("empty wants provided")
+	}
+
+	e := pktline.NewEncoder(w)
+	if err := encodeCommand(e, capability.Fetch, r.Capabilities); err != nil {
+		return err
+	}
+
+	for _, arg := range []struct {
+		enabled bool
+		name    capability.Capability
+	}{
+		{r.ThinPack, capability.ThinPack},
+		{r.NoProgress, capability.NoProgress},
+		{r.IncludeTag, capability.IncludeTag},
+		{r.OFSDelta, capability.OFSDelta},
+	} {
+		if !arg.enabled {
+			continue
+		}
+
+		if err := e.Encodef("%s\n", arg.name); err != nil {
+			return err
+		}
+	}
+
+	for _, list := range []struct {
+		prefix []byte
+		hashes []plumbing.Hash
+	}{
+		{want, r.Wants},
+		{have, r.Haves},
+		{shallow, r.Shallows},
+	} {
+		if err := encodeHashes(e, list.prefix, list.hashes); err != nil {
+			return err
+		}
+	}
+
+	if err := encodeDepth(e, r.Depth); err != nil {
+		return err
+	}
+
+	if r.DeepenRelative {
+		if err := e.Encodef("%s\n", capability.DeepenRelative); err != nil {
+			return err
+		}
+	}
+
+	if !r.Filter.IsZero() {
+		if err := e.Encodef("filter %s\n", r.Filter); err != nil {
+			return err
+		}
+	}
+
+	if r.Done {
+		if err := e.EncodeString("done\n"); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+func encodeHashes(e *pktline.Encoder, prefix []byte, hashes []plumbing.Hash) error {
+	sorted := make([]plumbing.Hash, len(hashes))
+	copy(sorted, hashes)
+	plumbing.HashesSort(sorted)
+
+	var last plumbing.Hash
+	for i, h := range sorted {
+		if i > 0 && h == last {
+			continue
+		}
+
+		if err := e.Encodef("%s%s\n", prefix, h); err != nil {
+			return err
+		}
+
+		last = h
+	}
+
+	return nil
+}
+
+func encodeDepth(e *pktline.Encoder, d Depth) error {
+	switch depth := d.(type) {
+	case nil:
+		return nil
+	case DepthCommits:
+		if depth == 0 {
+			return nil
+		}
+
+		return e.Encodef("deepen %d\n", int(depth))
+	case DepthSince:
+		return e.Encodef("deepen-since %d\n", time.Time(depth).UTC().Unix())
+	case DepthReference:
+		return e.Encodef("deepen-not %s\n", string(depth))
+	case Depths:
+		for _, d := range depth {
+			if err := encodeDepth(e, d); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported depth type")
+	}
+}
+
+// FetchResponse values represent the sections of the response to the fetch
+// command of the version 2 of the protocol preceding the packfile.
+type FetchResponse struct {
+	ShallowUpdate
+	// ACKs are the common objects acknowledged by the server.
+	ACKs []plumbing.Hash
+	// Ready is true when the server is ready to send the packfile without
+	// further negotiation.
+	Ready bool
+	// Packfile is true when the packfile section follows, the rest of the
+	// reader is then its content, multiplexed as side-band-64k does.
+	Packfile bool
+}
+
+// Decode reads the sections of the response from r, stopping at the
+// beginning of the packfile, if any.
+func (r *FetchResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScannerWithDelim(reader)
+	for {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return err
+			}
+
+			return NewErrUnexpectedData("missing flush-pkt", nil)
+		}
+
+		header := bytes.TrimSuffix(s.Bytes(), eol)
+		switch {
+		case bytes.HasPrefix(header, errPrefix):
+			return fmt.Errorf("remote error: %s", header[len(errPrefix):])
+		case bytes.Equal(header, packfileLine):
+			r.Packfile = true
+			return nil
+		case isFlush(header):
+			return nil
+		}
+
+		// the payload of the scanner is overwritten by the lines of the section
+		end, err := r.decodeSection(s, append([]byte(nil), header...))
+		if err != nil {
+			return err
+		}
+
+		if end {
+			return nil
+		}
+	}
+}
+
+// decodeSection reads the lines of the section with the given header, it
+// returns true if the section was the last one of the response.
+func (r *FetchResponse) decodeSection(s *pktline.Scanner, header []byte) (bool, error) {
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return !s.Delim(), nil
+		}
+
+		var err error
+		switch {
+		case bytes.Equal(header, acknowledgmentsLine):
+			err = r.decodeAcknowledgment(line)
+		case bytes.Equal(header, shallowInfoLine):
+			err = r.decodeShallowInfo(line)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+
+	return false, NewErrUnexpectedData("missing flush-pkt", nil)
+}
+
+func (r *FetchResponse) decodeAcknowledgment(line []byte) error {
+	switch {
+	case bytes.Equal(line, nak):
+		return nil
+	case bytes.Equal(line, readyLine):
+		r.Ready = true
+		return nil
+	case bytes.HasPrefix(line, ack) && len(line) == ackLineLen:
+		r.ACKs = append(r.ACKs, plumbing.NewHash(string(line[4:])))
+		return nil
+	default:
+		return NewErrUnexpectedData("malformed acknowledgment", line)
+	}
+}
+
+func (r *FetchResponse) decodeShallowInfo(line []byte) error {
+	switch {
+	case bytes.HasPrefix(line, shallow):
+		return r.decodeShallowLine(line)
+	case bytes.HasPrefix(line, unshallow):
+		return r.decodeUnshallowLine(line)
+	default:
+		return NewErrUnexpectedData("malformed shallow-info", line)
+	}
+}
@@ -0,0 +1,201 @@
+package packp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type FetchSuite struct{}
+
+var _ = Suite(&FetchSuite{})
+
+func (s *FetchSuite) TestEncodeRequest(c *C) {
+	req := NewFetchRequest()
+	req.Capabilities.Add(capability.Agent, "go-git/4.x")
+	req.Wants = []plumbing.Hash{
+		plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	}
+	req.Haves = []plumbing.Hash{plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")}
+	req.Depth = DepthCommits(1)
+	req.OFSDelta = true
+	req.NoProgress = true
+	req.Done = true
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=fetch\n", "agent=go-git/4.x\n")) +
+		string(pktline.DelimPkt) +
+		string(pktlines(c,
+			"no-progress\n",
+			"ofs-delta\n",
+			"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+			"want b029517f6300c2da0f4b651b8642506cd6aaf45d\n",
+			"have 1669dce138d9b841a518c64b10914d88f5e488ea\n",
+			"deepen 1\n",
+			"done\n",
+			pktline.FlushString,
+		))
+
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *FetchSuite) TestEncodeRequestDepth(c *C) {
+	since := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		depth    Depth
+		expected string
+	}{
+		{DepthSince(since), "deepen-since 1483326245\n"},
+		{DepthReference("refs/heads/master"), "deepen-not refs/heads/master\n"},
+	} {
+		req := NewFetchRequest()
+		req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+		req.Depth = test.depth
+
+		var buf bytes.Buffer
+		c.Assert(req.Encode(&buf), IsNil)
+		c.Assert(bytes.Contains(buf.Bytes(), pktlines(c, test.expected)), Equals, true)
+	}
+}
+
+func (s *FetchSuite) TestEncodeRequestDepths(c *C) {
+	req := NewFetchRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Depth = Depths{
+		DepthSince(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)),
+		DepthReference("refs/heads/master"),
+	}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), pktlines(c,
+		"deepen-since 1483326245\n", "deepen-not refs/heads/master\n",
+	)), Equals, true)
+}
+
+func (s *FetchSuite) TestEncodeRequestDeepenRelative(c *C) {
+	req := NewFetchRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Depth = DepthCommits(2)
+	req.DeepenRelative = true
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), pktlines(c, "deepen 2\n", "deepen-relative\n")), Equals, true)
+}
+
+func (s *FetchSuite) TestEncodeRequestFilter(c *C) {
+	req := NewFetchRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Depth = DepthCommits(1)
+	req.Filter = FilterBlobNone()
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), pktlines(c,
+		"deepen 1\n",
+		"filter blob:none\n",
+		pktline.FlushString,
+	)), Equals, true)
+}
+
+func (s *FetchSuite) TestEncodeRequestEmptyWants(c *C) {
+	var buf bytes.Buffer
+	c.Assert(NewFetchRequest().Encode(&buf), ErrorMatches, "empty wants provided")
+}
+
+func (s *FetchSuite) TestNewFetchRequestFromUploadPackRequest(c *C) {
+	ur := NewUploadPackRequest()
+	ur.Capabilities.Set(capability.Agent, "go-git/4.x")
+	ur.Capabilities.Set(capability.OFSDelta)
+	ur.Capabilities.Set(capability.Sideband64k)
+	ur.Capabilities.Set(capability.IncludeTag)
+	ur.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	ur.Haves = []plumbing.Hash{plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")}
+	ur.Depth = DepthCommits(2)
+	ur.Filter = FilterTreeDepth(1)
+
+	req := NewFetchRequestFromUploadPackRequest(ur)
+	c.Assert(req.Capabilities.String(), Equals, "agent=go-git/4.x")
+	c.Assert(req.Wants, DeepEquals, ur.Wants)
+	c.Assert(req.Haves, DeepEquals, ur.Haves)
+	c.Assert(req.Depth, Equals, DepthCommits(2))
+	c.Assert(req.Filter, Equals, FilterTreeDepth(1))
+	c.Assert(req.OFSDelta, Equals, true)
+	c.Assert(req.IncludeTag, Equals, true)
+	c.Assert(req.ThinPack, Equals, false)
+	c.Assert(req.NoProgress, Equals, false)
+	c.Assert(req.Done, Equals, true)
+}
+
+func (s *FetchSuite) TestDecodeResponse(c *C) {
+	raw := string(pktlines(c,
+		"acknowledgments\n",
+		"ACK 1669dce138d9b841a518c64b10914d88f5e488ea\n",
+		"ready\n",
+	)) + string(pktline.DelimPkt) + string(pktlines(c,
+		"shallow-info\n",
+		"shallow 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"unshallow b029517f6300c2da0f4b651b8642506cd6aaf45d\n",
+	)) + string(pktline.DelimPkt) + string(pktlines(c,
+		"wanted-refs\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+	)) + string(pktline.DelimPkt) + string(pktlines(c,
+		"packfile\n",
+	)) + "PACK"
+
+	r := bytes.NewReader([]byte(raw))
+	res := &FetchResponse{}
+	c.Assert(res.Decode(r), IsNil)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea"),
+	})
+	c.Assert(res.Ready, Equals, true)
+	c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+	c.Assert(res.Unshallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(res.Packfile, Equals, true)
+
+	rest, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(rest), Equals, "PACK")
+}
+
+func (s *FetchSuite) TestDecodeResponseWithoutPackfile(c *C) {
+	raw := pktlines(c,
+		"acknowledgments\n",
+		"NAK\n",
+		pktline.FlushString,
+	)
+
+	res := &FetchResponse{}
+	c.Assert(res.Decode(bytes.NewReader(raw)), IsNil)
+	c.Assert(res.ACKs, HasLen, 0)
+	c.Assert(res.Ready, Equals, false)
+	c.Assert(res.Packfile, Equals, false)
+}
+
+func (s *FetchSuite) TestDecodeResponseErrors(c *C) {
+	for _, raw := range [][]byte{
+		pktlines(c, "ERR upload-pack: not our ref\n"),
+		pktlines(c, "acknowledgments\n", "ACK foo\n", pktline.FlushString),
+		pktlines(c, "shallow-info\n", "foo\n", pktline.FlushString),
+		pktlines(c, "acknowledgments\n", "NAK\n"),
+	} {
+		res := &FetchResponse{}
+		c.Assert(res.Decode(bytes.NewReader(raw)), NotNil, Commentf("%q", raw))
+	}
+}
@@ -0,0 +1,257 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+)
+
+// LsRefsRequest values represent the ls-refs command of the version 2 of the
+// protocol, used to list the references of the server. Values from this type
+// are not zero-value safe, use the New function instead.
+type LsRefsRequest struct {
+	// Capabilities are the capabilities sent along with the command, e.g.
+	// agent.
+	Capabilities *capability.List
+	// Symrefs requests the targets of the symbolic references.
+	Symrefs bool
+	// Peel requests the objects pointed by the annotated tags.
+	Peel bool
+	// RefPrefixes restricts the listed references to the ones starting with
+	// any of the prefixes, every reference is listed if empty.
+	RefPrefixes []string
+}
+
+// NewLsRefsRequest returns a pointer to a new LsRefsRequest value, requesting
+// the symbolic references and the peeled tags.
+func NewLsRefsRequest() *LsRefsRequest {
+	return &LsRefsRequest{
+		Capabilities: capability.NewList(),
+		Symrefs:      true,
+		Peel:         true,
+	}
+}
+
+// Encode writes the ls-refs command encoding of r to w.
+func (r *LsRefsRequest) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if err := encodeCommand(e, capability.LsRefs, r.Capabilities); err != nil {
+		return err
+	}
+
+	if r.Symrefs {
+		if err := e.EncodeString("symrefs\n"); err != nil {
+			return err
+		}
+	}
+
+	if r.Peel {
+		if err := e.EncodeString("peel\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range r.RefPrefixes {
+		if err := e.Encodef("ref-prefix %s\n", p); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// encodeCommand writes the command and the capabilities sections of a
+// request of the version 2 of the protocol, the arguments must follow.
+func encodeCommand(e *pktline.Encoder, cmd capability.Capability, caps *capability.List) error {
+	if err := e.Encodef("command=%s\n", cmd); err != nil {
+		return err
+	}
+
+	if caps != nil {
+		for _, c := range caps.All() {
+			values := caps.Get(c)
+			if len(values) == 0 {
+				if err := e.Encodef("%s\n", c); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			for _, v := range values {
+				if err := e.Encodef("%s=%s\n", c, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return e.Delim()
+}
+
+// LsRefsResponse values represent the references listed by the ls-refs
+// command of the version 2 of the protocol. Values from this type are not
+// zero-value safe, use the New function instead.
+type LsRefsResponse struct {
+	// References are the listed references, the symbolic ones are listed by
+	// the hash they resolve to.
+	References []*plumbing.Reference
+	// Symrefs are the targets of the symbolic references, by name.
+	Symrefs map[plumbing.ReferenceName]plumbing.ReferenceName
+	// Peeled are the objects pointed by the annotated tags, by name.
+	Peeled map[plumbing.ReferenceName]plumbing.Hash
+}
+
+// NewLsRefsResponse returns a pointer to a new LsRefsResponse value, ready to
+// be used.
+func NewLsRefsResponse() *LsRefsResponse {
+	return &LsRefsResponse{
+		Symrefs: make(map[plumbing.ReferenceName]plumbing.ReferenceName),
+		Peeled:  make(map[plumbing.ReferenceName]plumbing.Hash),
+	}
+}
+
+// Decode reads the next ls-refs response from r.
+func (r *LsRefsResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if isFlush(line) {
+			return nil
+		}
+
+		if err := r.decodeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return NewErrUnexpectedData("missing flush-pkt", nil)
+}
+
+func (r *LsRefsResponse) decodeLine(line []byte) error {
+	if bytes.HasPrefix(line, errPrefix) {
+		return fmt.Errorf("remote error: %s", line[len(errPrefix):])
+	}
+
+	chunks := bytes.Split(line, sp)
+	if len(chunks) < 2 || len(chunks[0]) != hashSize {
+		return NewErrUnexpectedData("malformed ls-refs line", line)
+	}
+
+	name := plumbing.ReferenceName(chunks[1])
+	r.References = append(r.References,
+		plumbing.NewHashReference(name, plumbing.NewHash(string(chunks[0]))),
+	)
+
+	for _, attr := range chunks[2:] {
+		switch {
+		case bytes.HasPrefix(attr, symrefTargetAttr):
+			target := attr[len(symrefTargetAttr):]
+			r.Symrefs[name] = plumbing.ReferenceName(target)
+		case bytes.HasPrefix(attr, peeledAttr):
+			hash := attr[len(peeledAttr):]
+			if len(hash) != hashSize {
+				return NewErrUnexpectedData("malformed peeled attribute", line)
+			}
+
+			r.Peeled[name] = plumbing.NewHash(string(hash))
+		}
+	}
+
+	return nil
+}
+
+// Encode writes the ls-refs response encoding of r to w.
+func (r *LsRefsResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	for _, ref := range r.References {
+		line := fmt.Sprintf("%s %s", ref.Hash(), ref.Name())
+		if target, ok := r.Symrefs[ref.Name()]; ok {
+			line += fmt.Sprintf(" %s%s", symrefTargetAttr, target)
+		}
+
+		if peeled, ok := r.Peeled[ref.Name()]; ok {
+			line += fmt.Sprintf(" %s%s", peeledAttr, peeled)
+		}
+
+		if err := e.Encodef("%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// NewAdvRefsFromLsRefs returns a pointer to a new AdvRefs value, holding the
+// references listed by an ls-refs command. Its capabilities are the ones
+// of the version 0 of the protocol equivalent to the features supported by
+// the fetch command of the server, so it can be used as the response of a
+// reference discovery.
+func NewAdvRefsFromLsRefs(adv *CapabilityAdvertisement, refs *LsRefsResponse) (*AdvRefs, error) {
+	ar := NewAdvRefs()
+	for _, ref := range refs.References {
+		if ref.Name() == plumbing.HEAD {
+			h := ref.Hash()
+			ar.Head = &h
+			continue
+		}
+
+		ar.References[ref.Name().String()] = ref.Hash()
+	}
+
+	for name, h := range refs.Peeled {
+		ar.Peeled[name.String()] = h
+	}
+
+	if target, ok := refs.Symrefs[plumbing.HEAD]; ok {
+		v := fmt.Sprintf("%s:%s", plumbing.HEAD, target)
+		if err := ar.Capabilities.Add(capability.SymRef, v); err != nil {
+			return nil, err
+		}
+	}
+
+	// the fetch command negotiates in multiple rounds, acknowledging the
+	// common objects, as multi_ack_detailed does
+	for _, c := range []capability.Capability{
+		capability.MultiACKDetailed, capability.OFSDelta,
+		capability.Sideband64k, capability.ThinPack,
+		capability.NoProgress, capability.IncludeTag,
+	} {
+		if err := ar.Capabilities.Add(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if adv.SupportsFetchFeature("shallow") {
+		for _, c := range []capability.Capability{
+			capability.Shallow, capability.DeepenSince,
+			capability.DeepenNot, capability.DeepenRelative,
+		} {
+			if err := ar.Capabilities.Add(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if adv.SupportsFetchFeature("filter") {
+		if err := ar.Capabilities.Add(capability.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if agent := adv.Capabilities.Get(capability.Agent); len(agent) != 0 {
+		if err := ar.Capabilities.Add(capability.Agent, agent[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return ar, nil
+}
@@ -0,0 +1,114 @@
+package packp
+
+import (
+	"bytes"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type LsRefsSuite struct{}
+
+var _ = Suite(&LsRefsSuite{})
+
+func (s *LsRefsSuite) TestEncodeRequest(c *C) {
+	req := NewLsRefsRequest()
+	req.Capabilities.Add(capability.Agent, "go-git/4.x")
+	req.RefPrefixes = []string{"HEAD", "refs/heads/"}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=ls-refs\n", "agent=go-git/4.x\n")) +
+		string(pktline.DelimPkt) +
+		string(pktlines(c,
+			"symrefs\n",
+			"peel\n",
+			"ref-prefix HEAD\n",
+			"ref-prefix refs/heads/\n",
+			pktline.FlushString,
+		))
+
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *LsRefsSuite) TestDecodeResponse(c *C) {
+	raw := pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+		"b029517f6300c2da0f4b651b8642506cd6aaf45d refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		pktline.FlushString,
+	)
+
+	res := NewLsRefsResponse()
+	c.Assert(res.Decode(bytes.NewReader(raw)), IsNil)
+	c.Assert(res.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(res.Symrefs, DeepEquals, map[plumbing.ReferenceName]plumbing.ReferenceName{
+		plumbing.HEAD: plumbing.Master,
+	})
+	c.Assert(res.Peeled, DeepEquals, map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/tags/v1.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, raw)
+}
+
+func (s *LsRefsSuite) TestDecodeResponseErrors(c *C) {
+	for _, raw := range [][]byte{
+		pktlines(c, "ERR access denied\n", pktline.FlushString),
+		pktlines(c, "foo refs/heads/master\n", pktline.FlushString),
+		pktlines(c, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n"),
+	} {
+		res := NewLsRefsResponse()
+		c.Assert(res.Decode(bytes.NewReader(raw)), NotNil, Commentf("%q", raw))
+	}
+}
+
+func (s *LsRefsSuite) TestNewAdvRefsFromLsRefs(c *C) {
+	adv := NewCapabilityAdvertisement()
+	adv.Capabilities.Add(capability.Agent, "git/2.39.5")
+	adv.Capabilities.Add(capability.LsRefs)
+	adv.Capabilities.Add(capability.Fetch, "shallow wait-for-done")
+
+	res := NewLsRefsResponse()
+	c.Assert(res.Decode(bytes.NewReader(pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+		"b029517f6300c2da0f4b651b8642506cd6aaf45d refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		pktline.FlushString,
+	))), IsNil)
+
+	ar, err := NewAdvRefsFromLsRefs(adv, res)
+	c.Assert(err, IsNil)
+	c.Assert(*ar.Head, Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(ar.References, DeepEquals, map[string]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		"refs/tags/v1.0.0":  plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(ar.Peeled, DeepEquals, map[string]plumbing.Hash{
+		"refs/tags/v1.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+	c.Assert(ar.Capabilities.String(), Equals, "symref=HEAD:refs/heads/master "+
+		"multi_ack_detailed ofs-delta side-band-64k thin-pack no-progress include-tag "+
+		"shallow deepen-since deepen-not deepen-relative agent=git/2.39.5")
+
+	adv.Capabilities.Set(capability.Fetch, "shallow filter")
+	ar, err = NewAdvRefsFromLsRefs(adv, res)
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.Filter), Equals, true)
+
+	refs, err := ar.AllReferences()
+	c.Assert(err, IsNil)
+	head, err := refs.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+}
@@ -21,9 +21,8 @@ type ServerResponse struct {
 // Decode decodes the response into the struct, isMultiACK should be true, if
 // the request was done with multi_ack or multi_ack_detailed capabilities.
 func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
-	// TODO: implement support for multi_ack or multi_ack_detailed responses
 	if isMultiACK {
-		return errors.New("multi_ack and multi_ack_detailed are not supported")
+		return r.decodeMultiACK(reader)
 	}
 
 	s := pktline.NewScanner(reader)
@@ -51,6 +50,35 @@ func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
 	return s.Err()
 }
 
+// decodeMultiACK reads the response sent after the 'done' of a negotiation in
+// multi_ack or multi_ack_detailed mode: the acknowledgments of the last haves
+// followed by a final 'ACK obj-id' or 'NAK'.
+func (r *ServerResponse) decodeMultiACK(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if bytes.Equal(line, nak) {
+			return nil
+		}
+
+		a, err := decodeAcknowledgment(line)
+		if err != nil {
+			return err
+		}
+
+		r.ACKs = append(r.ACKs, a.Hash)
+		if a.Status == ACKFinal {
+			return nil
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return NewErrUnexpectedData("missing final ACK or NAK", nil)
+}
+
 // stopReading detects when a valid command such as ACK or NAK is found to be
 // read in the buffer without moving the read pointer.
 func (r *ServerResponse) stopReading(reader *bufio.Reader) (bool, error) {
@@ -125,3 +153,123 @@ func (r *ServerResponse) Encode(w io.Writer) error {
 
 	return e.Encodef("%s %s\n", ack, r.ACKs[0].String())
 }
+
+// ACKStatus is the status of an acknowledgment sent by the server during a
+// negotiation in multi_ack or multi_ack_detailed mode.
+type ACKStatus int
+
+const (
+	// ACKFinal is the status of the 'ACK obj-id' sent after 'done', or on
+	// the first common object without multi_ack.
+	ACKFinal ACKStatus = iota
+	// ACKContinue is sent in multi_ack mode for the common objects and, once
+	// the server is ready to send the packfile, for any other object.
+	ACKContinue
+	// ACKCommon is sent in multi_ack_detailed mode for the common objects.
+	ACKCommon
+	// ACKReady is sent in multi_ack_detailed mode once the server is ready
+	// to send the packfile.
+	ACKReady
+)
+
+var ackStatuses = map[ACKStatus]string{
+	ACKContinue: "continue",
+	ACKCommon:   "common",
+	ACKReady:    "ready",
+}
+
+// Acknowledgment is an object acknowledged by the server, with its status.
+type Acknowledgment struct {
+	Hash   plumbing.Hash
+	Status ACKStatus
+}
+
+func decodeAcknowledgment(line []byte) (Acknowledgment, error) {
+	if !bytes.HasPrefix(line, ack) || len(line) < ackLineLen {
+		return Acknowledgment{}, NewErrUnexpectedData("malformed ACK", line)
+	}
+
+	a := Acknowledgment{Hash: plumbing.NewHash(string(line[4:ackLineLen]))}
+	if len(line) == ackLineLen {
+		return a, nil
+	}
+
+	status := string(bytes.TrimPrefix(line[ackLineLen:], sp))
+	for st, name := range ackStatuses {
+		if name == status {
+			a.Status = st
+			return a, nil
+		}
+	}
+
+	return Acknowledgment{}, NewErrUnexpectedData("unknown ACK status", line)
+}
+
+// NegotiationResponse values represent the response of the upload-pack
+// service to a round of haves ended by a flush-pkt, during a negotiation in
+// multi_ack or multi_ack_detailed mode.
+type NegotiationResponse struct {
+	// Acknowledgments are the haves acknowledged by the server.
+	Acknowledgments []Acknowledgment
+}
+
+// Ready returns true if the server is ready to send the packfile.
+func (r *NegotiationResponse) Ready() bool {
+	for _, a := range r.Acknowledgments {
+		if a.Status == ACKReady {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decode reads the acknowledgments of a round from reader, up to the 'NAK'
+// ending it.
+func (r *NegotiationResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if bytes.Equal(line, nak) {
+			return nil
+		}
+
+		if bytes.HasPrefix(line, errPrefix) {
+			return fmt.Errorf("remote error: %s", line[len(errPrefix):])
+		}
+
+		a, err := decodeAcknowledgment(line)
+		if err != nil {
+			return err
+		}
+
+		if a.Status == ACKFinal {
+			return NewErrUnexpectedData("unexpected final ACK", line)
+		}
+
+		r.Acknowledgments = append(r.Acknowledgments, a)
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return NewErrUnexpectedData("missing NAK", nil)
+}
+
+// Encode writes the acknowledgments of r to w, followed by a 'NAK'.
+func (r *NegotiationResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	for _, a := range r.Acknowledgments {
+		status, ok := ackStatuses[a.Status]
+		if !ok {
+			return fmt.Errorf("invalid ACK status for %s", a.Hash)
+		}
+
+		if err := e.Encodef("%s %s %s\n", ack, a.Hash, status); err != nil {
+			return err
+		}
+	}
+
+	return e.Encodef("%s\n", nak)
+}
@@ -73,7 +73,114 @@ func (s *ServerResponseSuite) TestDecodeMalformed(c *C) {
 }
 
 func (s *ServerResponseSuite) TestDecodeMultiACK(c *C) {
+	raw := "" +
+		"0038ACK 1111111111111111111111111111111111111111 common\n" +
+		"0031ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n" +
+		"PACK"
+
+	r := bufio.NewReader(bytes.NewBufferString(raw))
+	sr := &ServerResponse{}
+	err := sr.Decode(r, true)
+	c.Assert(err, IsNil)
+
+	c.Assert(sr.ACKs, HasLen, 2)
+	c.Assert(sr.ACKs[0], Equals, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	c.Assert(sr.ACKs[1], Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	rest, err := r.Peek(4)
+	c.Assert(err, IsNil)
+	c.Assert(string(rest), Equals, "PACK")
+}
+
+func (s *ServerResponseSuite) TestDecodeMultiACKNAK(c *C) {
+	raw := "0008NAK\n"
+
+	sr := &ServerResponse{}
+	err := sr.Decode(bufio.NewReader(bytes.NewBufferString(raw)), true)
+	c.Assert(err, IsNil)
+	c.Assert(sr.ACKs, HasLen, 0)
+}
+
+func (s *ServerResponseSuite) TestDecodeMultiACKEmpty(c *C) {
 	sr := &ServerResponse{}
 	err := sr.Decode(bufio.NewReader(bytes.NewBuffer(nil)), true)
 	c.Assert(err, NotNil)
 }
+
+type NegotiationResponseSuite struct{}
+
+var _ = Suite(&NegotiationResponseSuite{})
+
+func (s *NegotiationResponseSuite) TestDecode(c *C) {
+	raw := "" +
+		"0038ACK 1111111111111111111111111111111111111111 common\n" +
+		"0037ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 ready\n" +
+		"003aACK 2222222222222222222222222222222222222222 continue\n" +
+		"0008NAK\n"
+
+	r := &NegotiationResponse{}
+	c.Assert(r.Decode(bytes.NewBufferString(raw)), IsNil)
+	c.Assert(r.Acknowledgments, DeepEquals, []Acknowledgment{
+		{plumbing.NewHash("1111111111111111111111111111111111111111"), ACKCommon},
+		{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), ACKReady},
+		{plumbing.NewHash("2222222222222222222222222222222222222222"), ACKContinue},
+	})
+	c.Assert(r.Ready(), Equals, true)
+}
+
+func (s *NegotiationResponseSuite) TestDecodeNAK(c *C) {
+	r := &NegotiationResponse{}
+	c.Assert(r.Decode(bytes.NewBufferString("0008NAK\n")), IsNil)
+	c.Assert(r.Acknowledgments, HasLen, 0)
+	c.Assert(r.Ready(), Equals, false)
+}
+
+func (s *NegotiationResponseSuite) TestDecodeMissingNAK(c *C) {
+	raw := "0038ACK 1111111111111111111111111111111111111111 common\n"
+
+	r := &NegotiationResponse{}
+	c.Assert(r.Decode(bytes.NewBufferString(raw)), NotNil)
+}
+
+func (s *NegotiationResponseSuite) TestDecodeUnknownStatus(c *C) {
+	raw := "0035ACK 1111111111111111111111111111111111111111 foo\n"
+
+	r := &NegotiationResponse{}
+	c.Assert(r.Decode(bytes.NewBufferString(raw)), NotNil)
+}
+
+func (s *NegotiationResponseSuite) TestDecodeError(c *C) {
+	raw := "0010ERR foo bar\n"
+
+	r := &NegotiationResponse{}
+	err := r.Decode(bytes.NewBufferString(raw))
+	c.Assert(err, ErrorMatches, "remote error: foo bar")
+}
+
+func (s *NegotiationResponseSuite) TestEncode(c *C) {
+	r := &NegotiationResponse{Acknowledgments: []Acknowledgment{
+		{plumbing.NewHash("1111111111111111111111111111111111111111"), ACKCommon},
+		{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), ACKReady},
+	}}
+
+	b := bytes.NewBuffer(nil)
+	c.Assert(r.Encode(b), IsNil)
+
+	expected := "" +
+		"0038ACK 1111111111111111111111111111111111111111 common\n" +
+		"0037ACK 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 ready\n" +
+		"0008NAK\n"
+	c.Assert(b.String(), Equals, expected)
+
+	decoded := &NegotiationResponse{}
+	c.Assert(decoded.Decode(b), IsNil)
+	c.Assert(decoded, DeepEquals, r)
+}
+
+func (s *NegotiationResponseSuite) TestEncodeInvalidStatus(c *C) {
+	r := &NegotiationResponse{Acknowledgments: []Acknowledgment{
+		{plumbing.NewHash("1111111111111111111111111111111111111111"), ACKFinal},
+	}}
+
+	c.Assert(r.Encode(bytes.NewBuffer(nil)), NotNil)
+}
@@ -2,6 +2,8 @@ package packp
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -17,10 +19,13 @@ type UploadRequest struct {
 	Wants        []plumbing.Hash
 	Shallows     []plumbing.Hash
 	Depth        Depth
+	// Filter requests a partial packfile, omitting the objects not matching
+	// the filter. The zero value requests every object.
+	Filter Filter
 }
 
 // Depth values stores the desired depth of the requested packfile: see
-// DepthCommit, DepthSince and DepthReference.
+// DepthCommit, DepthSince, DepthReference and Depths.
 type Depth interface {
 	isDepth()
 	IsZero() bool
@@ -55,6 +60,83 @@ func (d DepthReference) IsZero() bool {
 	return string(d) == ""
 }
 
+// Depths values requests only commits matching all the given depths, e.g. a
+// DepthSince and several DepthReference values.
+type Depths []Depth
+
+func (d Depths) isDepth() {}
+
+func (d Depths) IsZero() bool {
+	for _, depth := range d {
+		if !depth.IsZero() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// InfiniteDepth is the depth requested to fetch the whole history of a shallow
+// repository, as git does.
+const InfiniteDepth = DepthCommits(0x7fffffff)
+
+// Filter values stores the filter-spec of a partial packfile, requesting the
+// server to omit some objects from it: see FilterBlobNone, FilterBlobLimit
+// and FilterTreeDepth.
+type Filter string
+
+// FilterBlobNone requests a packfile without blobs.
+func FilterBlobNone() Filter {
+	return Filter("blob:none")
+}
+
+// FilterBlobLimit requests a packfile without the blobs of the given size in
+// bytes or bigger.
+func FilterBlobLimit(size int64) Filter {
+	return Filter(fmt.Sprintf("blob:limit=%d", size))
+}
+
+// FilterTreeDepth requests a packfile without the trees and blobs deeper than
+// the given depth from the root trees, a depth of 0 omits every tree and
+// blob.
+func FilterTreeDepth(depth int) Filter {
+	return Filter(fmt.Sprintf("tree:%d", depth))
+}
+
+// IsZero returns true if f does not filter any object.
+func (f Filter) IsZero() bool {
+	return f == ""
+}
+
+// Validate returns an error if f is not a supported filter-spec: blob:none,
+// blob:limit=<n>[kmg] or tree:<depth>.
+func (f Filter) Validate() error {
+	s := string(f)
+	switch {
+	case f.IsZero(), s == "blob:none":
+		return nil
+	case strings.HasPrefix(s, "blob:limit="):
+		n := strings.TrimPrefix(s, "blob:limit=")
+		if len(n) > 0 && strings.ContainsRune("kKmMgG", rune(n[len(n)-1])) {
+			n = n[:len(n)-1]
+		}
+
+		if _, err := strconv.ParseUint(n, 10, 64); err != nil {
+			return fmt.Errorf("invalid filter %q: malformed size", s)
+		}
+
+		return nil
+	case strings.HasPrefix(s, "tree:"):
+		if _, err := strconv.ParseUint(strings.TrimPrefix(s, "tree:"), 10, 64); err != nil {
+			return fmt.Errorf("invalid filter %q: malformed depth", s)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported filter %q", s)
+	}
+}
+
 // NewUploadRequest returns a pointer to a new UploadRequest value, ready to be
 // used. It has no capabilities, wants or shallows and an infinite depth. Please
 // note that to encode an upload-request it has to have at least one wanted hash.
@@ -101,12 +183,21 @@ func NewUploadRequestFromCapabilities(adv *capability.List) *UploadRequest {
 	return r
 }
 
+// IsShallow returns true if the request sends shallow commits or requests a
+// depth, the server answers it with a shallow-update then.
+func (r *UploadRequest) IsShallow() bool {
+	return len(r.Shallows) != 0 || !r.Depth.IsZero()
+}
+
 // Validate validates the content of UploadRequest, following the next rules:
 //   - Wants MUST have at least one reference
 //   - capability.Shallow MUST be present if Shallows is not empty
 //   - is a non-zero DepthCommits is given capability.Shallow MUST be present
 //   - is a DepthSince is given capability.Shallow MUST be present
 //   - is a DepthReference is given capability.DeepenNot MUST be present
+//   - is a Depths is given the rules above apply to each of its depths
+//   - is capability.DeepenRelative given capability.Shallow MUST be present
+//   - is a Filter is given capability.Filter MUST be present
 //   - MUST contain only maximum of one of capability.Sideband and capability.Sideband64k
 //   - MUST contain only maximum of one of capability.MultiACK and capability.MultiACKDetailed
 func (r *UploadRequest) Validate() error {
@@ -132,9 +223,28 @@ func (r *UploadRequest) validateRequiredCapabilities() error {
 		return fmt.Errorf(msg, capability.Shallow)
 	}
 
-	switch r.Depth.(type) {
+	if err := r.validateDepth(r.Depth); err != nil {
+		return err
+	}
+
+	if r.Capabilities.Supports(capability.DeepenRelative) &&
+		!r.Capabilities.Supports(capability.Shallow) {
+		return fmt.Errorf(msg, capability.Shallow)
+	}
+
+	if !r.Filter.IsZero() && !r.Capabilities.Supports(capability.Filter) {
+		return fmt.Errorf(msg, capability.Filter)
+	}
+
+	return nil
+}
+
+func (r *UploadRequest) validateDepth(d Depth) error {
+	msg := "missing capability %s"
+
+	switch depth := d.(type) {
 	case DepthCommits:
-		if r.Depth != DepthCommits(0) {
+		if depth != 0 {
 			if !r.Capabilities.Supports(capability.Shallow) {
 				return fmt.Errorf(msg, capability.Shallow)
 			}
@@ -147,6 +257,12 @@ func (r *UploadRequest) validateRequiredCapabilities() error {
 		if !r.Capabilities.Supports(capability.DeepenNot) {
 			return fmt.Errorf(msg, capability.DeepenNot)
 		}
+	case Depths:
+		for _, d := range depth {
+			if err := r.validateDepth(d); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
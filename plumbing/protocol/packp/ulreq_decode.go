@@ -136,6 +136,10 @@ func (d *ulReqDecoder) decodeOtherWants() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -161,6 +165,10 @@ func (d *ulReqDecoder) decodeShallow() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -217,9 +225,9 @@ func (d *ulReqDecoder) decodeDeepenCommits() stateFn {
 		d.err = fmt.Errorf("negative depth")
 		return nil
 	}
-	d.data.Depth = DepthCommits(n)
+	d.addDepth(DepthCommits(n))
 
-	return d.decodeFlush
+	return d.decodeDeepenOrFilterOrFlush
 }
 
 func (d *ulReqDecoder) decodeDeepenSince() stateFn {
@@ -231,15 +239,68 @@ func (d *ulReqDecoder) decodeDeepenSince() stateFn {
 		return nil
 	}
 	t := time.Unix(secs, 0).UTC()
-	d.data.Depth = DepthSince(t)
+	d.addDepth(DepthSince(t))
 
-	return d.decodeFlush
+	return d.decodeDeepenOrFilterOrFlush
 }
 
 func (d *ulReqDecoder) decodeDeepenReference() stateFn {
 	d.line = bytes.TrimPrefix(d.line, deepenReference)
 
-	d.data.Depth = DepthReference(string(d.line))
+	d.addDepth(DepthReference(string(d.line)))
+
+	return d.decodeDeepenOrFilterOrFlush
+}
+
+// addDepth sets the depth of the request, the depths are combined if there
+// are several deepen lines.
+func (d *ulReqDecoder) addDepth(depth Depth) {
+	switch current := d.data.Depth.(type) {
+	case nil:
+		d.data.Depth = depth
+	case DepthCommits:
+		if current == 0 {
+			d.data.Depth = depth
+		} else {
+			d.data.Depth = Depths{current, depth}
+		}
+	case Depths:
+		d.data.Depth = append(current, depth)
+	default:
+		d.data.Depth = Depths{current, depth}
+	}
+}
+
+// Expected format: deepen-* <value> / filter <filter-spec> / flush-pkt
+func (d *ulReqDecoder) decodeDeepenOrFilterOrFlush() stateFn {
+	if ok := d.nextLine(); !ok {
+		return nil
+	}
+
+	if bytes.HasPrefix(d.line, deepen) {
+		return d.decodeDeepen
+	}
+
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
+	if len(d.line) != 0 {
+		d.err = fmt.Errorf("unexpected payload while expecting a filter or a flush-pkt: %q", d.line)
+	}
+
+	return nil
+}
+
+// Expected format: filter <filter-spec>
+func (d *ulReqDecoder) decodeFilter() stateFn {
+	d.line = bytes.TrimPrefix(d.line, filter)
+	if len(d.line) == 0 {
+		d.error("empty filter specification")
+		return nil
+	}
+
+	d.data.Filter = Filter(d.line)
 
 	return d.decodeFlush
 }
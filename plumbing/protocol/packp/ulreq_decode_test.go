@@ -477,6 +477,61 @@ func (s *UlReqDecodeSuite) TestDeepenReference(c *C) {
 	c.Assert(string(reference), Equals, expected)
 }
 
+func (s *UlReqDecodeSuite) TestDeepenSeveral(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
+		"deepen-since 1420167845",
+		"deepen-not refs/heads/master",
+		"deepen-not refs/heads/foo",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+
+	since := time.Date(2015, time.January, 2, 3, 4, 5, 0, time.UTC)
+	c.Assert(ur.Depth, DeepEquals, Depths{
+		DepthSince(since),
+		DepthReference("refs/heads/master"),
+		DepthReference("refs/heads/foo"),
+	})
+}
+
+func (s *UlReqDecodeSuite) TestFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta filter",
+		"filter blob:none",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+
+	c.Assert(ur.Filter, Equals, FilterBlobNone())
+	c.Assert(ur.Depth, Equals, DepthCommits(0))
+}
+
+func (s *UlReqDecodeSuite) TestFilterWithShallowAndDepth(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 shallow filter",
+		"shallow aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"deepen 1",
+		"filter tree:0",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+
+	c.Assert(ur.Shallows, HasLen, 1)
+	c.Assert(ur.Depth, Equals, DepthCommits(1))
+	c.Assert(ur.Filter, Equals, FilterTreeDepth(0))
+}
+
+func (s *UlReqDecodeSuite) TestMalformedFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 filter",
+		"filter ",
+		pktline.FlushString,
+	}
+	r := toPktLines(c, payloads)
+	s.testDecoderErrorMatches(c, r, ".*empty filter.*")
+}
+
 func (s *UlReqDecodeSuite) TestAll(c *C) {
 	payloads := []string{
 		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
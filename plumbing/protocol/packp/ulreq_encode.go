@@ -14,7 +14,7 @@ import (
 //
 // All the payloads will end with a newline character.  Wants and
 // shallows are sorted alphabetically.  A depth of 0 means no depth
-// request is sent.
+// request is sent, as an empty filter means no filter request is sent.
 func (u *UploadRequest) Encode(w io.Writer) error {
 	e := newUlReqEncoder(w)
 	return e.Encode(u)
@@ -106,30 +106,51 @@ func (e *ulReqEncoder) encodeShallows() stateFn {
 }
 
 func (e *ulReqEncoder) encodeDepth() stateFn {
-	switch depth := e.data.Depth.(type) {
+	if e.err = e.encodeDepthValue(e.data.Depth); e.err != nil {
+		return nil
+	}
+
+	return e.encodeFilter
+}
+
+func (e *ulReqEncoder) encodeDepthValue(d Depth) error {
+	switch depth := d.(type) {
 	case DepthCommits:
 		if depth != 0 {
 			commits := int(depth)
 			if err := e.pe.Encodef("deepen %d\n", commits); err != nil {
-				e.err = fmt.Errorf("encoding depth %d: %s", depth, err)
-				return nil
+				return fmt.Errorf("encoding depth %d: %s", depth, err)
 			}
 		}
 	case DepthSince:
 		when := time.Time(depth).UTC()
 		if err := e.pe.Encodef("deepen-since %d\n", when.Unix()); err != nil {
-			e.err = fmt.Errorf("encoding depth %s: %s", when, err)
-			return nil
+			return fmt.Errorf("encoding depth %s: %s", when, err)
 		}
 	case DepthReference:
 		reference := string(depth)
 		if err := e.pe.Encodef("deepen-not %s\n", reference); err != nil {
-			e.err = fmt.Errorf("encoding depth %s: %s", reference, err)
-			return nil
+			return fmt.Errorf("encoding depth %s: %s", reference, err)
+		}
+	case Depths:
+		for _, d := range depth {
+			if err := e.encodeDepthValue(d); err != nil {
+				return err
+			}
 		}
 	default:
-		e.err = fmt.Errorf("unsupported depth type")
-		return nil
+		return fmt.Errorf("unsupported depth type")
+	}
+
+	return nil
+}
+
+func (e *ulReqEncoder) encodeFilter() stateFn {
+	if f := e.data.Filter; !f.IsZero() {
+		if err := e.pe.Encodef("filter %s\n", f); err != nil {
+			e.err = fmt.Errorf("encoding filter %s: %s", f, err)
+			return nil
+		}
 	}
 
 	return e.encodeFlush
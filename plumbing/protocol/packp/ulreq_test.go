@@ -94,6 +94,63 @@ func (s *UlReqSuite) TestValidateDepthSince(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *UlReqSuite) TestValidateDepths(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Depth = Depths{DepthSince(time.Now()), DepthReference("refs/heads/master")}
+	r.Capabilities.Set(capability.DeepenSince)
+
+	err := r.Validate()
+	c.Assert(err, NotNil)
+
+	r.Capabilities.Set(capability.DeepenNot)
+	err = r.Validate()
+	c.Assert(err, IsNil)
+}
+
+func (s *UlReqSuite) TestValidateDeepenRelative(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Depth = DepthCommits(1)
+	r.Capabilities.Set(capability.DeepenRelative)
+
+	err := r.Validate()
+	c.Assert(err, NotNil)
+
+	r.Capabilities.Set(capability.Shallow)
+	err = r.Validate()
+	c.Assert(err, IsNil)
+}
+
+func (s *UlReqSuite) TestValidateFilter(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Filter = FilterBlobNone()
+
+	err := r.Validate()
+	c.Assert(err, NotNil)
+
+	r.Capabilities.Set(capability.Filter)
+	err = r.Validate()
+	c.Assert(err, IsNil)
+}
+
+func (s *UlReqSuite) TestFilterValidate(c *C) {
+	for _, f := range []Filter{
+		"", FilterBlobNone(), FilterBlobLimit(0), "blob:limit=10k",
+		"blob:limit=1M", FilterTreeDepth(0), FilterTreeDepth(3),
+	} {
+		c.Assert(f.Validate(), IsNil, Commentf("filter %q", f))
+	}
+
+	for _, f := range []Filter{
+		"blob", "blob:limit=", "blob:limit=-1", "blob:limit=1x",
+		"tree:", "tree:a", "sparse:oid=master:.sparse",
+	} {
+		c.Assert(f.Validate(), NotNil, Commentf("filter %q", f))
+	}
+}
+
 func (s *UlReqSuite) TestValidateConflictSideband(c *C) {
 	r := NewUploadRequest()
 	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
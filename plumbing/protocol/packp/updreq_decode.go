@@ -10,6 +10,7 @@ import (
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 )
 
 var (
@@ -101,6 +102,7 @@ func (d *updReqDecoder) Decode(req *ReferenceUpdateRequest) error {
 		d.decodeShallow,
 		d.decodeCommandAndCapabilities,
 		d.decodeCommands,
+		d.decodeOptions,
 		d.setPackfile,
 		req.validate,
 	}
@@ -167,6 +169,27 @@ func (d *updReqDecoder) decodeCommands() error {
 	}
 }
 
+// decodeOptions reads the push options, sent after the commands if the
+// push-options capability is requested.
+func (d *updReqDecoder) decodeOptions() error {
+	if !d.req.Capabilities.Supports(capability.PushOptions) {
+		return nil
+	}
+
+	for {
+		if ok := d.s.Scan(); !ok {
+			return d.scanErrorOr(errMalformedRequest("missing push options flush"))
+		}
+
+		b := d.s.Bytes()
+		if bytes.Equal(b, pktline.Flush) {
+			return nil
+		}
+
+		d.req.Options = append(d.req.Options, string(b))
+	}
+}
+
 func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 	b := d.s.Bytes()
 	i := bytes.IndexByte(b, 0)
@@ -44,6 +44,12 @@ func (r *UploadPackRequest) IsEmpty() bool {
 	return isSubset(r.Wants, r.Haves)
 }
 
+// Decode decodes the upload-request from r, the haves sent after it are
+// decoded by UploadHaves.Decode, round by round.
+func (r *UploadPackRequest) Decode(rd io.Reader) error {
+	return r.UploadRequest.Decode(rd)
+}
+
 func isSubset(needle []plumbing.Hash, haystack []plumbing.Hash) bool {
 	for _, h := range needle {
 		found := false
@@ -96,3 +102,34 @@ func (u *UploadHaves) Encode(w io.Writer, flush bool) error {
 
 	return nil
 }
+
+// Decode decodes the haves of a round of negotiation from r, sent after an
+// upload-request, up to a flush-pkt or a done line. It returns true if the
+// round ends with a done line.
+func (u *UploadHaves) Decode(r io.Reader) (bool, error) {
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		switch {
+		case isFlush(line):
+			return false, nil
+		case bytes.Equal(line, done):
+			return true, nil
+		case bytes.HasPrefix(line, have):
+			h := line[len(have):]
+			if len(h) != hashSize {
+				return false, NewErrUnexpectedData("malformed have", line)
+			}
+
+			u.Haves = append(u.Haves, plumbing.NewHash(string(h)))
+		default:
+			return false, NewErrUnexpectedData("unexpected line", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+
+	return false, io.ErrUnexpectedEOF
+}
@@ -2,6 +2,7 @@ package packp
 
 import (
 	"bytes"
+	"io"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
@@ -67,3 +68,46 @@ func (s *UploadHavesSuite) TestEncode(c *C) {
 		"0000",
 	)
 }
+
+func (s *UploadHavesSuite) TestDecode(c *C) {
+	buf := bytes.NewBufferString("" +
+		"0032have 1111111111111111111111111111111111111111\n" +
+		"0032have 2222222222222222222222222222222222222222\n" +
+		"0000" +
+		"0032have 3333333333333333333333333333333333333333\n" +
+		"0009done\n",
+	)
+
+	uh := &UploadHaves{}
+	done, err := uh.Decode(buf)
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, false)
+	c.Assert(uh.Haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1111111111111111111111111111111111111111"),
+		plumbing.NewHash("2222222222222222222222222222222222222222"),
+	})
+
+	done, err = uh.Decode(buf)
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, true)
+	c.Assert(uh.Haves, HasLen, 3)
+}
+
+func (s *UploadHavesSuite) TestDecodeUnexpectedEOF(c *C) {
+	buf := bytes.NewBufferString(
+		"0032have 1111111111111111111111111111111111111111\n",
+	)
+
+	uh := &UploadHaves{}
+	_, err := uh.Decode(buf)
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *UploadHavesSuite) TestDecodeMalformed(c *C) {
+	uh := &UploadHaves{}
+	_, err := uh.Decode(bytes.NewBufferString("000ahave 11\n"))
+	c.Assert(err, ErrorMatches, "malformed have.*")
+
+	_, err = uh.Decode(bytes.NewBufferString("0009want\n"))
+	c.Assert(err, ErrorMatches, "unexpected line.*")
+}
@@ -0,0 +1,301 @@
+package revlist
+
+import (
+	"io"
+	"sort"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// Tip is a commit a walk of Commits starts from.
+type Tip struct {
+	Hash plumbing.Hash
+	// Exclude excludes the commits reachable from the tip, as ^<rev> does.
+	Exclude bool
+	// Left marks the tip as the left side of a symmetric difference, as
+	// <rev1> in <rev1>...<rev2>.
+	Left bool
+}
+
+// Commit is a commit listed by Commits.
+type Commit struct {
+	*object.Commit
+	// Boundary is true for the excluded commits listed as parents of the
+	// included ones, as git rev-list --boundary does.
+	Boundary bool
+	// Left is true for the commits reachable from a left tip, the ones marked
+	// with < by git rev-list --left-right.
+	Left bool
+}
+
+// CommitsOptions describes how the commits are listed by Commits.
+type CommitsOptions struct {
+	// Boundary lists, after the other commits, the excluded commits that are
+	// parents of them, as git rev-list --boundary does.
+	Boundary bool
+	// FirstParent follows only the first parent of the included commits, as
+	// git rev-list --first-parent does.
+	FirstParent bool
+}
+
+// CommitIter is a generic closable interface for iterating over the commits
+// listed by Commits.
+type CommitIter interface {
+	Next() (*Commit, error)
+	ForEach(func(*Commit) error) error
+	Close()
+}
+
+const (
+	flagExcluded = 1 << iota
+	flagLeft
+	flagQueued
+	flagWalked
+)
+
+type queuedCommit struct {
+	*object.Commit
+	seq int
+}
+
+type commitsIter struct {
+	s     storer.EncodedObjectStorer
+	o     *CommitsOptions
+	queue *binaryheap.Heap
+	seq   int
+	flags map[plumbing.Hash]int
+	// walked are the commits taken from the queue, to mark their parents as
+	// excluded when they are excluded after being walked.
+	walked map[plumbing.Hash]*object.Commit
+	// included is the number of queued commits not excluded, the walk ends
+	// when every commit left is excluded.
+	included int
+	// parents are the parents of the listed commits, the boundary candidates.
+	parents    []plumbing.Hash
+	boundaries []*Commit
+	done       bool
+}
+
+// Commits returns an iterator of the commits reachable from the tips not
+// excluded and not from the excluded ones, as git rev-list does, sorted by
+// committer time, the newest first.
+//
+// The history is walked by committer time, from all the tips at once, and the
+// walk ends as soon as only excluded commits are left, so only the history
+// down to the commits shared with the excluded tips is read. As in git, a
+// commit older than one of its ancestors may be listed even if it is
+// reachable from an excluded tip.
+func Commits(s storer.EncodedObjectStorer, tips []Tip, o *CommitsOptions) (CommitIter, error) {
+	iter := &commitsIter{
+		s:      s,
+		o:      o,
+		flags:  make(map[plumbing.Hash]int),
+		walked: make(map[plumbing.Hash]*object.Commit),
+		queue: binaryheap.NewWith(func(a, b interface{}) int {
+			ca, cb := a.(*queuedCommit), b.(*queuedCommit)
+			switch {
+			case ca.Committer.When.After(cb.Committer.When):
+				return -1
+			case ca.Committer.When.Before(cb.Committer.When):
+				return 1
+			case ca.seq < cb.seq:
+				return -1
+			}
+
+			return 1
+		}),
+	}
+
+	// the excluded tips are queued first, so commits with the same time are
+	// known to be excluded before they are listed, then the left ones
+	var ordered []Tip
+	for _, t := range tips {
+		if t.Exclude {
+			ordered = append(ordered, t)
+		}
+	}
+
+	for _, left := range []bool{true, false} {
+		for _, t := range tips {
+			if !t.Exclude && t.Left == left {
+				ordered = append(ordered, t)
+			}
+		}
+	}
+
+	for _, t := range ordered {
+		flags := 0
+		switch {
+		case t.Exclude:
+			flags = flagExcluded
+		case t.Left:
+			flags = flagLeft
+		}
+
+		if err := iter.push(t.Hash, flags); err != nil {
+			return nil, err
+		}
+	}
+
+	return iter, nil
+}
+
+// push queues the commit with the given flags, or adds the flags to it if it
+// was already found.
+func (iter *commitsIter) push(h plumbing.Hash, flags int) error {
+	_, found := iter.flags[h]
+	if flags&flagExcluded != 0 {
+		iter.exclude(h)
+	}
+
+	iter.flags[h] |= flags
+	if found {
+		return nil
+	}
+
+	c, err := object.GetCommit(iter.s, h)
+	if err != nil {
+		return err
+	}
+
+	iter.seq++
+	iter.queue.Push(&queuedCommit{Commit: c, seq: iter.seq})
+	iter.flags[h] |= flagQueued
+	if iter.flags[h]&flagExcluded == 0 {
+		iter.included++
+	}
+
+	return nil
+}
+
+// exclude marks the commit as excluded, and the ancestors already walked.
+func (iter *commitsIter) exclude(h plumbing.Hash) {
+	pending := []plumbing.Hash{h}
+	for len(pending) != 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		flags := iter.flags[h]
+		if flags&flagExcluded != 0 {
+			continue
+		}
+
+		iter.flags[h] = flags | flagExcluded
+		if flags&flagQueued != 0 && flags&flagWalked == 0 {
+			iter.included--
+		}
+
+		if c, ok := iter.walked[h]; ok {
+			pending = append(pending, c.ParentHashes...)
+		}
+	}
+}
+
+func (iter *commitsIter) Next() (*Commit, error) {
+	for !iter.done {
+		if iter.included == 0 {
+			iter.done = true
+			break
+		}
+
+		v, ok := iter.queue.Pop()
+		if !ok {
+			iter.done = true
+			break
+		}
+
+		c := v.(*queuedCommit).Commit
+		flags := iter.flags[c.Hash]
+		iter.flags[c.Hash] = flags | flagWalked
+		iter.walked[c.Hash] = c
+
+		parents := c.ParentHashes
+		excluded := flags&flagExcluded != 0
+		if !excluded {
+			iter.included--
+			if iter.o.FirstParent && len(parents) > 1 {
+				parents = parents[:1]
+			}
+		}
+
+		for _, h := range parents {
+			if err := iter.push(h, flags&(flagExcluded|flagLeft)); err != nil {
+				return nil, err
+			}
+		}
+
+		if excluded {
+			continue
+		}
+
+		iter.parents = append(iter.parents, parents...)
+		return &Commit{Commit: c, Left: flags&flagLeft != 0}, nil
+	}
+
+	return iter.nextBoundary()
+}
+
+// nextBoundary returns the boundary commits, once the walk is done, sorted by
+// committer time.
+func (iter *commitsIter) nextBoundary() (*Commit, error) {
+	if !iter.o.Boundary {
+		return nil, io.EOF
+	}
+
+	if iter.parents != nil {
+		seen := make(map[plumbing.Hash]bool)
+		for _, h := range iter.parents {
+			if iter.flags[h]&flagExcluded == 0 || seen[h] {
+				continue
+			}
+
+			c, err := object.GetCommit(iter.s, h)
+			if err != nil {
+				return nil, err
+			}
+
+			seen[h] = true
+			iter.boundaries = append(iter.boundaries, &Commit{Commit: c, Boundary: true})
+		}
+
+		iter.parents = nil
+		sort.SliceStable(iter.boundaries, func(i, j int) bool {
+			return iter.boundaries[i].Committer.When.After(iter.boundaries[j].Committer.When)
+		})
+	}
+
+	if len(iter.boundaries) == 0 {
+		return nil, io.EOF
+	}
+
+	c := iter.boundaries[0]
+	iter.boundaries = iter.boundaries[1:]
+	return c, nil
+}
+
+func (iter *commitsIter) ForEach(cb func(*Commit) error) error {
+	for {
+		c, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := cb(c); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+func (iter *commitsIter) Close() {}
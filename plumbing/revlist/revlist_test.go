@@ -298,3 +298,131 @@ func (s *RevListSuite) TestReachableObjectsNoRevisit(c *C) {
 		plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"),
 	})
 }
+
+func (s *RevListSuite) assertCommits(c *C, iter CommitIter, expected []string) {
+	var result []string
+	err := iter.ForEach(func(cm *Commit) error {
+		mark := ""
+		switch {
+		case cm.Boundary:
+			mark = "-"
+		case cm.Left:
+			mark = "<"
+		}
+
+		result = append(result, mark+cm.Hash.String())
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, expected)
+}
+
+func (s *RevListSuite) TestCommits(c *C) {
+	commits, err := Commits(s.Storer, []Tip{
+		{Hash: plumbing.NewHash(someCommitBranch)},
+		{Hash: plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"), Exclude: true},
+	}, &CommitsOptions{})
+	c.Assert(err, IsNil)
+
+	s.assertCommits(c, commits, []string{
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"af2d6a6954d532f8ffb47615169c8fdf9d383a1a",
+		"1669dce138d9b841a518c64b10914d88f5e488ea",
+		"a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69",
+		"b8e471f58bcbca63b07bda20e428190409c2db47",
+	})
+}
+
+func (s *RevListSuite) TestCommitsBoundary(c *C) {
+	commits, err := Commits(s.Storer, []Tip{
+		{Hash: plumbing.NewHash(someCommitBranch)},
+		{Hash: plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"), Exclude: true},
+	}, &CommitsOptions{Boundary: true})
+	c.Assert(err, IsNil)
+
+	s.assertCommits(c, commits, []string{
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"af2d6a6954d532f8ffb47615169c8fdf9d383a1a",
+		"1669dce138d9b841a518c64b10914d88f5e488ea",
+		"a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69",
+		"b8e471f58bcbca63b07bda20e428190409c2db47",
+		"-35e85108805c84807bc66a02d91535e1e24b38b9",
+		"-b029517f6300c2da0f4b651b8642506cd6aaf45d",
+	})
+}
+
+func (s *RevListSuite) TestCommitsLeftRight(c *C) {
+	commits, err := Commits(s.Storer, []Tip{
+		{Hash: plumbing.NewHash(someCommitOtherBranch), Left: true},
+		{Hash: plumbing.NewHash(someCommitBranch)},
+		{Hash: plumbing.NewHash(someCommit), Exclude: true},
+	}, &CommitsOptions{Boundary: true})
+	c.Assert(err, IsNil)
+
+	s.assertCommits(c, commits, []string{
+		"<6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"-918c48b83bd081e863dbe1b80f8998f058cd8294",
+	})
+}
+
+func (s *RevListSuite) TestCommitsFirstParent(c *C) {
+	commits, err := Commits(s.Storer, []Tip{
+		{Hash: plumbing.NewHash(someCommitOtherBranch)},
+		{Hash: plumbing.NewHash(secondCommit), Exclude: true},
+	}, &CommitsOptions{Boundary: true, FirstParent: true})
+	c.Assert(err, IsNil)
+
+	s.assertCommits(c, commits, []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"918c48b83bd081e863dbe1b80f8998f058cd8294",
+		"af2d6a6954d532f8ffb47615169c8fdf9d383a1a",
+		"1669dce138d9b841a518c64b10914d88f5e488ea",
+		"35e85108805c84807bc66a02d91535e1e24b38b9",
+		"-b029517f6300c2da0f4b651b8642506cd6aaf45d",
+	})
+}
+
+func (s *RevListSuite) TestCommitsExcludedTip(c *C) {
+	commits, err := Commits(s.Storer, []Tip{
+		{Hash: plumbing.NewHash(someCommit)},
+		{Hash: plumbing.NewHash(someCommitBranch), Exclude: true},
+	}, &CommitsOptions{Boundary: true})
+	c.Assert(err, IsNil)
+	s.assertCommits(c, commits, nil)
+}
+
+// missingObjectStorer is a storer where an object is missing.
+type missingObjectStorer struct {
+	storer.EncodedObjectStorer
+	missing plumbing.Hash
+}
+
+func (s *missingObjectStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if h == s.missing {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return s.EncodedObjectStorer.EncodedObject(t, h)
+}
+
+func (s *RevListSuite) TestCommitsStopsAtExcluded(c *C) {
+	// the history below the commits shared with the excluded tip is not read
+	st := &missingObjectStorer{
+		EncodedObjectStorer: s.Storer,
+		missing:             plumbing.NewHash("af2d6a6954d532f8ffb47615169c8fdf9d383a1a"),
+	}
+
+	commits, err := Commits(st, []Tip{
+		{Hash: plumbing.NewHash(someCommitOtherBranch)},
+		{Hash: plumbing.NewHash(someCommitBranch), Exclude: true},
+	}, &CommitsOptions{Boundary: true})
+	c.Assert(err, IsNil)
+
+	s.assertCommits(c, commits, []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"-918c48b83bd081e863dbe1b80f8998f058cd8294",
+	})
+}
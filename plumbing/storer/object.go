@@ -97,6 +97,16 @@ type PackfileWriter interface {
 	PackfileWriter() (io.WriteCloser, error)
 }
 
+// PromisorPackfileWriter is a optional method for ObjectStorer, it enable
+// direct write of a packfile received from a promisor remote, marking it as
+// such. The objects of these packfiles may reference objects missing from the
+// storage, as the result of a partial clone.
+type PromisorPackfileWriter interface {
+	// PromisorPackfileWriter returns a writer for writing a packfile to the
+	// storage, marked as received from a promisor remote.
+	PromisorPackfileWriter() (io.WriteCloser, error)
+}
+
 // EncodedObjectIter is a generic closable interface for iterating over objects.
 type EncodedObjectIter interface {
 	Next() (plumbing.EncodedObject, error)
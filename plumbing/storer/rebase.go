@@ -0,0 +1,20 @@
+package storer
+
+import "errors"
+
+// ErrRebaseFileNotFound is returned by RebaseFile when the file is not found.
+var ErrRebaseFileNotFound = errors.New("rebase file not found")
+
+// RebaseStorer is a storage of the state of a rebase in progress, kept as a
+// set of named files, like the .git/rebase-merge directory. It is an optional
+// interface of the storage, since it is only required by the worktree
+// operations.
+type RebaseStorer interface {
+	// SetRebaseFile writes the content of the given file of the rebase state.
+	SetRebaseFile(name string, content []byte) error
+	// RebaseFile returns the content of the given file of the rebase state,
+	// ErrRebaseFileNotFound is returned if the file does not exist.
+	RebaseFile(name string) ([]byte, error)
+	// RemoveRebase removes the whole rebase state.
+	RemoveRebase() error
+}
@@ -0,0 +1,30 @@
+package storer
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+)
+
+// ReflogStorer is a storage of the reflogs of the references, the log of the
+// updates of each reference. It is an optional interface of the storage.
+type ReflogStorer interface {
+	// Reflog returns the entries of the reflog of the given reference, the
+	// oldest first. No entries are returned if the reflog does not exist.
+	Reflog(plumbing.ReferenceName) ([]*reflog.Entry, error)
+	// AppendReflog appends an entry to the reflog of the given reference.
+	AppendReflog(plumbing.ReferenceName, *reflog.Entry) error
+	// SetReflog replaces the entries of the reflog of the given reference.
+	SetReflog(plumbing.ReferenceName, []*reflog.Entry) error
+	// RemoveReflog removes the reflog of the given reference.
+	RemoveReflog(plumbing.ReferenceName) error
+}
+
+// LoggedReferenceStorer is a ReferenceStorer recording the updates of the
+// references in their reflogs. It is an optional interface of the storage.
+type LoggedReferenceStorer interface {
+	// SetLoggedReference sets the reference, as CheckAndSetReference does,
+	// and records the update in the reflog with the given committer and
+	// message. If the committer is nil, the identity of the configuration is
+	// used.
+	SetLoggedReference(new, old *plumbing.Reference, committer *reflog.Signature, msg string) error
+}
@@ -92,6 +92,70 @@ type ReceivePackSession interface {
 	ReceivePack(context.Context, *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error)
 }
 
+// ReferencePrefixesSession is implemented by the sessions able to restrict the
+// reference discovery to the references with the given prefixes, as the
+// ls-refs command of the version 2 of the protocol does. The servers not
+// speaking the version 2 advertise every reference anyway.
+type ReferencePrefixesSession interface {
+	Session
+	// AdvertisedReferencesWithPrefixes retrieves the advertised references
+	// starting with any of the given prefixes.
+	AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error)
+}
+
+// Negotiator chooses the haves sent to the server during the negotiation of
+// a fetch, in multiple rounds, from the acknowledgments of the server.
+type Negotiator interface {
+	// Next returns the next object to send as have, or plumbing.ZeroHash
+	// when there is none left.
+	Next() (plumbing.Hash, error)
+	// Ack marks an object as common with the server.
+	Ack(plumbing.Hash) error
+}
+
+// NegotiatorSession is implemented by the upload-pack sessions able to
+// negotiate the haves of a request in multiple rounds, as the servers
+// speaking the version 2 of the protocol or supporting the multi_ack or
+// multi_ack_detailed capabilities allow.
+type NegotiatorSession interface {
+	UploadPackSession
+	// UploadPackWithNegotiator performs the request as UploadPack does,
+	// the haves are obtained from the negotiator instead of the request.
+	// Without multi_ack or multi_ack_detailed in the version 0 of the
+	// protocol, every have of the negotiator is sent in a single round.
+	UploadPackWithNegotiator(context.Context, *packp.UploadPackRequest, Negotiator) (*packp.UploadPackResponse, error)
+}
+
+// ProtocolVersion is a version of the git wire protocol.
+type ProtocolVersion int
+
+const (
+	// ProtocolV0 is the original version of the protocol, the server starts
+	// advertising every reference.
+	ProtocolV0 ProtocolVersion = 0
+	// ProtocolV2 is the command based version of the protocol, the server
+	// starts advertising its capabilities, and the references are listed on
+	// demand by the ls-refs command.
+	ProtocolV2 ProtocolVersion = 2
+)
+
+// ProtocolVersionTransport is a Transport able to request a given version of
+// the protocol on git-upload-pack sessions. The sessions created by
+// NewUploadPackSession, and the git-receive-pack ones, use the version 0.
+type ProtocolVersionTransport interface {
+	Transport
+	// NewUploadPackSessionVersion is like NewUploadPackSession, requesting
+	// the given version of the protocol. A server not supporting it answers
+	// with the version 0, which is used instead.
+	NewUploadPackSessionVersion(*Endpoint, AuthMethod, ProtocolVersion) (UploadPackSession, error)
+}
+
+// Parameter returns the value requesting the version to the server, as sent
+// in the GIT_PROTOCOL environment variable or the Git-Protocol HTTP header.
+func (v ProtocolVersion) Parameter() string {
+	return fmt.Sprintf("version=%d", v)
+}
+
 // Endpoint represents a Git URL in any supported protocol.
 type Endpoint struct {
 	// Protocol is the protocol of the endpoint (e.g. git, https, file).
@@ -258,11 +322,7 @@ func parseFile(endpoint string) (*Endpoint, bool) {
 
 // UnsupportedCapabilities are the capabilities not supported by any client
 // implementation
-var UnsupportedCapabilities = []capability.Capability{
-	capability.MultiACK,
-	capability.MultiACKDetailed,
-	capability.ThinPack,
-}
+var UnsupportedCapabilities = []capability.Capability{}
 
 // FilterUnsupportedCapabilities it filter out all the UnsupportedCapabilities
 // from a capability.List, the intended usage is on the client implementation
@@ -108,6 +108,13 @@ func (c *command) Start() error {
 	return c.cmd.Start()
 }
 
+// SetProtocolVersion requests the given version of the protocol through the
+// GIT_PROTOCOL environment variable.
+func (c *command) SetProtocolVersion(v transport.ProtocolVersion) error {
+	c.cmd.Env = append(os.Environ(), "GIT_PROTOCOL="+v.Parameter())
+	return nil
+}
+
 func (c *command) StderrPipe() (io.Reader, error) {
 	// Pipe returned by Command.StderrPipe has a race with Read + Command.Wait.
 	// We use an io.Pipe and close it after the command finishes.
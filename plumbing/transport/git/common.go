@@ -37,11 +37,12 @@ type command struct {
 	connected bool
 	command   string
 	endpoint  *transport.Endpoint
+	version   transport.ProtocolVersion
 }
 
 // Start executes the command sending the required message to the TCP connection
 func (c *command) Start() error {
-	cmd := endpointToCommand(c.command, c.endpoint)
+	cmd := endpointToCommand(c.command, c.endpoint, c.version)
 
 	e := pktline.NewEncoder(c.conn)
 	return e.Encode([]byte(cmd))
@@ -89,13 +90,24 @@ func (c *command) StdoutPipe() (io.Reader, error) {
 	return c.conn, nil
 }
 
-func endpointToCommand(cmd string, ep *transport.Endpoint) string {
+// SetProtocolVersion requests the given version of the protocol as an extra
+// parameter of the request.
+func (c *command) SetProtocolVersion(v transport.ProtocolVersion) error {
+	c.version = v
+	return nil
+}
+
+func endpointToCommand(cmd string, ep *transport.Endpoint, v transport.ProtocolVersion) string {
 	host := ep.Host
 	if ep.Port != DefaultPort {
 		host = fmt.Sprintf("%s:%d", ep.Host, ep.Port)
 	}
 
-	return fmt.Sprintf("%s %s%chost=%s%c", cmd, ep.Path, 0, host, 0)
+	if v == transport.ProtocolV0 {
+		return fmt.Sprintf("%s %s%chost=%s%c", cmd, ep.Path, 0, host, 0)
+	}
+
+	return fmt.Sprintf("%s %s%chost=%s%c%c%s%c", cmd, ep.Path, 0, host, 0, 0, v.Parameter(), 0)
 }
 
 // Close closes the TCP connection and connection.
@@ -0,0 +1,199 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// ErrDaemonClosed is returned by the Serve and ListenAndServe methods of a
+// Daemon after a call to Shutdown or Close.
+var ErrDaemonClosed = errors.New("git: daemon closed")
+
+// Daemon serves git repositories with the git protocol, as the git daemon
+// command does. The repositories are resolved by a server.Loader, from the
+// path and the host sent in the request of each connection.
+type Daemon struct {
+	// ReceivePack enables the git-receive-pack service, only git-upload-pack
+	// is served by default.
+	ReceivePack bool
+	// MaxConnections is the maximum number of connections served at the
+	// same time, the connections accepted beyond it are closed. Zero means
+	// no limit.
+	MaxConnections int
+	// Timeout is the maximum duration of a connection without reading or
+	// writing anything, the connection is closed after it. Zero means no
+	// timeout.
+	Timeout time.Duration
+	// Hooks are run on the pushes received, once ReceivePack is enabled.
+	Hooks *server.Hooks
+
+	loader server.Loader
+	conns  *common.ConnServer
+}
+
+// NewDaemon returns a new Daemon serving the repositories of the given
+// loader.
+func NewDaemon(loader server.Loader) *Daemon {
+	return &Daemon{
+		loader: loader,
+		conns:  common.NewConnServer(),
+	}
+}
+
+// ListenAndServe listens on the given TCP address, on the default port if
+// it has none, and serves the connections accepted. It always returns a
+// non-nil error.
+func (d *Daemon) ListenAndServe(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(DefaultPort))
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return d.Serve(l)
+}
+
+// Serve accepts the connections of l, serving each one in a new goroutine.
+// The listener is closed when Serve returns, it always returns a non-nil
+// error, ErrDaemonClosed after a call to Shutdown or Close.
+func (d *Daemon) Serve(l net.Listener) error {
+	err := d.conns.Serve(l, d.MaxConnections, d.Timeout, d.serveConn)
+	if err == common.ErrServerClosed {
+		return ErrDaemonClosed
+	}
+
+	return err
+}
+
+// Shutdown stops accepting connections and waits for the ones being served
+// to end, until the context is done, then the remaining ones are closed and
+// the error of the context is returned.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	return d.conns.Shutdown(ctx)
+}
+
+// Close stops accepting connections and closes the ones being served.
+func (d *Daemon) Close() error {
+	d.conns.Close()
+	return nil
+}
+
+// newServer returns the server of the sessions, running the hooks.
+func (d *Daemon) newServer() transport.Transport {
+	return server.NewServerWithHooks(d.loader, d.Hooks)
+}
+
+func (d *Daemon) serveConn(conn net.Conn) {
+	req, err := decodeRequest(conn)
+	if err != nil {
+		return
+	}
+
+	ep, err := req.endpoint(conn.LocalAddr())
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	cmd := common.ServerCommand{
+		Stdin:  conn,
+		Stdout: ioutil.WriteNopCloser(conn),
+	}
+
+	switch {
+	case req.service == transport.UploadPackServiceName:
+		s, err := d.newServer().NewUploadPackSession(ep, nil)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+
+		_ = common.ServeUploadPack(cmd, s)
+	case req.service == transport.ReceivePackServiceName && d.ReceivePack:
+		s, err := d.newServer().NewReceivePackSession(ep, nil)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+
+		_ = common.ServeReceivePack(cmd, s)
+	default:
+		writeError(conn, fmt.Errorf("service not enabled: %s", req.service))
+	}
+}
+
+// request is the request sent by a client at the beginning of a connection:
+// "git-upload-pack /project.git\0host=myserver.com\0".
+type request struct {
+	service string
+	path    string
+	host    string
+}
+
+func decodeRequest(r io.Reader) (*request, error) {
+	s := pktline.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	line := s.Bytes()
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed request: %q", line)
+	}
+
+	params := bytes.Split(line[i+1:], []byte{0})
+	req := &request{
+		service: string(line[:i]),
+		path:    string(params[0]),
+	}
+
+	for _, p := range params[1:] {
+		if bytes.HasPrefix(p, []byte("host=")) {
+			req.host = string(p[len("host="):])
+		}
+	}
+
+	return req, nil
+}
+
+// endpoint returns the endpoint of the requested repository, on the given
+// address if the client did not send its host.
+func (r *request) endpoint(addr net.Addr) (*transport.Endpoint, error) {
+	host := r.host
+	if host == "" {
+		host = addr.String()
+	}
+
+	return transport.NewEndpoint(fmt.Sprintf("git://%s%s", host, path.Clean("/"+r.path)))
+}
+
+// writeError sends an error to the client, the error of a repository not
+// found is sent as git daemon does.
+func writeError(w io.Writer, err error) {
+	msg := err.Error()
+	if err == transport.ErrRepositoryNotFound {
+		msg = "no such repository"
+	}
+
+	_ = pktline.NewEncoder(w).Encodef("ERR %s\n", msg)
+}
@@ -0,0 +1,434 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/test"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type BaseDaemonSuite struct {
+	fixtures.Suite
+
+	base   string
+	daemon *Daemon
+	addr   string
+	served chan error
+}
+
+func (s *BaseDaemonSuite) SetUpTest(c *C) {
+	s.base = c.MkDir()
+	s.daemon = NewDaemon(server.NewFilesystemLoader(osfs.New(s.base)))
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	s.addr = l.Addr().String()
+	s.served = make(chan error, 1)
+	go func() { s.served <- s.daemon.Serve(l) }()
+}
+
+func (s *BaseDaemonSuite) TearDownTest(c *C) {
+	c.Assert(s.daemon.Close(), IsNil)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+}
+
+func (s *BaseDaemonSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+
+	err := fixtures.EnsureIsBare(fs)
+	c.Assert(err, IsNil)
+
+	err = os.Rename(fs.Root(), filepath.Join(s.base, name))
+	c.Assert(err, IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *BaseDaemonSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("git://%s/%s", s.addr, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+type DaemonSuite struct {
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonSuite{})
+
+// git runs the git command in the given directory.
+func (s *DaemonSuite) git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.com",
+		"-c", "protocol.version=0",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+	return strings.TrimSpace(string(out))
+}
+
+// request sends the request of a service to the daemon, returning the
+// connection.
+func (s *DaemonSuite) request(c *C, service, path string) net.Conn {
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+
+	err = pktline.NewEncoder(conn).Encodef("%s %s\x00host=%s\x00", service, path, s.addr)
+	c.Assert(err, IsNil)
+
+	return conn
+}
+
+func (s *DaemonSuite) TestGitClone(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+	c.Assert(s.git(c, filepath.Join(dir, "basic"), "rev-parse", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	s.git(c, filepath.Join(dir, "basic"), "fsck")
+}
+
+func (s *DaemonSuite) TestGitFetchNegotiation(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "--single-branch", "--branch", "master", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	s.git(c, repo, "fetch", "origin", "branch")
+	c.Assert(s.git(c, repo, "rev-parse", "FETCH_HEAD"), Equals,
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	s.git(c, repo, "fsck")
+}
+
+func (s *DaemonSuite) TestGitShallowClone(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "--depth", "1", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	c.Assert(s.git(c, repo, "rev-list", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(s.git(c, repo, "rev-parse", "--is-shallow-repository"), Equals, "true")
+	s.git(c, repo, "fsck")
+}
+
+// TestGitShallowFetch compares the shallow clones and fetches from the daemon
+// with the ones from git-upload-pack.
+func (s *DaemonSuite) TestGitShallowFetch(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	reference := "file://" + filepath.Join(s.base, "basic.git")
+
+	for _, t := range []struct {
+		clone   []string
+		fetches [][]string
+	}{{
+		clone:   []string{"--depth", "2"},
+		fetches: [][]string{{"--deepen", "1"}, {"--depth", "1"}, {"--unshallow"}},
+	}, {
+		clone:   []string{"--shallow-since", "2015-03-31 13:47:00 +0200"},
+		fetches: [][]string{{"--shallow-since", "2015-03-31 13:45:00 +0200"}, {"--unshallow"}},
+	}, {
+		clone:   []string{"--shallow-exclude", "branch"},
+		fetches: [][]string{{"--unshallow"}},
+	}} {
+		dir := c.MkDir()
+		s.git(c, dir, append(append([]string{"clone"}, t.clone...), reference, "reference")...)
+		s.git(c, dir, append(append([]string{"clone"}, t.clone...), ep.String(), "daemon")...)
+		s.assertSameHistory(c, dir)
+
+		for _, fetch := range t.fetches {
+			s.git(c, filepath.Join(dir, "reference"), append([]string{"fetch"}, fetch...)...)
+			s.git(c, filepath.Join(dir, "daemon"), append([]string{"fetch"}, fetch...)...)
+			s.assertSameHistory(c, dir)
+		}
+	}
+}
+
+// assertSameHistory asserts that the "daemon" and "reference" clones in dir
+// have the same history and shallow commits.
+func (s *DaemonSuite) assertSameHistory(c *C, dir string) {
+	daemon, reference := filepath.Join(dir, "daemon"), filepath.Join(dir, "reference")
+	s.git(c, daemon, "fsck")
+	c.Assert(s.git(c, daemon, "rev-list", "--all"), Equals, s.git(c, reference, "rev-list", "--all"))
+
+	shallow, _ := ioutil.ReadFile(filepath.Join(daemon, ".git", "shallow"))
+	expected, _ := ioutil.ReadFile(filepath.Join(reference, ".git", "shallow"))
+	c.Assert(string(shallow), Equals, string(expected))
+}
+
+func (s *DaemonSuite) TestGitFetchIncludeTag(c *C) {
+	ep := s.prepareRepository(c, fixtures.ByTag("tags").One(), "tags.git")
+
+	repo := c.MkDir()
+	s.git(c, repo, "init")
+	s.git(c, repo, "remote", "add", "origin", ep.String())
+	s.git(c, repo, "fetch", "origin")
+	c.Assert(s.git(c, repo, "tag"), Equals,
+		"annotated-tag\nblob-tag\ncommit-tag\nlightweight-tag\ntree-tag")
+	c.Assert(s.git(c, repo, "cat-file", "-t", "annotated-tag"), Equals, "tag")
+	s.git(c, repo, "fsck")
+}
+
+func (s *DaemonSuite) TestGitPush(c *C) {
+	s.daemon.ReceivePack = true
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	c.Assert(ioutil.WriteFile(filepath.Join(repo, "foo"), []byte("foo"), 0644), IsNil)
+	s.git(c, repo, "add", "foo")
+	s.git(c, repo, "commit", "-m", "foo")
+	s.git(c, repo, "push", "origin", "master", "master:new")
+	s.git(c, repo, "push", "origin", ":branch")
+
+	head := s.git(c, repo, "rev-parse", "HEAD")
+	refs := s.git(c, repo, "ls-remote", "origin")
+	c.Assert(refs, Matches, "(?s).*"+head+"\trefs/heads/master.*")
+	c.Assert(refs, Matches, "(?s).*"+head+"\trefs/heads/new.*")
+	c.Assert(strings.Contains(refs, "refs/heads/branch"), Equals, false)
+}
+
+func (s *DaemonSuite) TestGitPushRejectedByHook(c *C) {
+	s.daemon.ReceivePack = true
+	s.daemon.Hooks = &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			fmt.Fprintln(req.Messages, "checking the push")
+			return errors.New("pushes are frozen")
+		}),
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	c.Assert(ioutil.WriteFile(filepath.Join(repo, "foo"), []byte("foo"), 0644), IsNil)
+	s.git(c, repo, "add", "foo")
+	s.git(c, repo, "commit", "-m", "foo")
+
+	cmd := exec.Command("git", "push", "origin", "master")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, NotNil)
+	c.Assert(string(out), Matches, "(?s).*remote: checking the push.*")
+	c.Assert(string(out), Matches, `(?s).*\[remote rejected\] master -> master \(pushes are frozen\).*`)
+
+	refs := s.git(c, repo, "ls-remote", "origin", "refs/heads/master")
+	c.Assert(refs, Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\trefs/heads/master")
+}
+
+func (s *DaemonSuite) TestGitPushAtomic(c *C) {
+	s.daemon.ReceivePack = true
+	s.daemon.Hooks = &server.Hooks{
+		Update: server.UpdateFunc(func(ctx context.Context, req *server.ReceiveRequest, cmd *packp.Command) error {
+			if cmd.Name == "refs/heads/rejected" {
+				return errors.New("protected branch")
+			}
+
+			return nil
+		}),
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	cmd := exec.Command("git", "push", "--atomic", "origin", "master:accepted", "master:rejected")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, NotNil)
+	c.Assert(string(out), Matches, `(?s).*\[remote rejected\] master -> rejected \(protected branch\).*`)
+	c.Assert(string(out), Matches, `(?s).*\[remote rejected\] master -> accepted \(atomic push failure\).*`)
+
+	refs := s.git(c, repo, "ls-remote", "origin")
+	c.Assert(strings.Contains(refs, "refs/heads/accepted"), Equals, false)
+}
+
+func (s *DaemonSuite) TestGitPushOptions(c *C) {
+	var options []string
+	s.daemon.ReceivePack = true
+	s.daemon.Hooks = &server.Hooks{
+		PostReceive: server.PostReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) {
+			options = req.Options
+		}),
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	s.git(c, repo, "push", "-o", "ci.skip", "-o", "reviewer=foo", "origin", "master:new")
+	c.Assert(options, DeepEquals, []string{"ci.skip", "reviewer=foo"})
+}
+
+func (s *DaemonSuite) TestReceivePackNotEnabled(c *C) {
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	conn := s.request(c, transport.ReceivePackServiceName, "/basic.git")
+	defer conn.Close()
+
+	out, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "002eERR service not enabled: git-receive-pack\n")
+}
+
+func (s *DaemonSuite) TestNotFound(c *C) {
+	conn := s.request(c, transport.UploadPackServiceName, "/non-existent.git")
+	defer conn.Close()
+
+	out, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "001bERR no such repository\n")
+}
+
+func (s *DaemonSuite) TestMaxConnections(c *C) {
+	s.daemon.MaxConnections = 1
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	// the first connection waits for the request of the client
+	first := s.request(c, transport.UploadPackServiceName, "/basic.git")
+	defer first.Close()
+
+	c.Assert(pktline.NewScanner(first).Scan(), Equals, true)
+
+	second := s.request(c, transport.UploadPackServiceName, "/basic.git")
+	defer second.Close()
+
+	out, _ := ioutil.ReadAll(second)
+	c.Assert(out, HasLen, 0)
+}
+
+func (s *DaemonSuite) TestTimeout(c *C) {
+	s.daemon.Timeout = 100 * time.Millisecond
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	conn := s.request(c, transport.UploadPackServiceName, "/basic.git")
+	defer conn.Close()
+
+	c.Assert(conn.SetDeadline(time.Now().Add(5*time.Second)), IsNil)
+
+	// the advertised references are received, then the client stays silent
+	out, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Matches, "(?s).*refs/heads/master.*0000")
+}
+
+func (s *DaemonSuite) TestShutdown(c *C) {
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the connection never sends its request, so it is closed on timeout
+	c.Assert(s.daemon.Shutdown(ctx), Equals, context.DeadlineExceeded)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+	s.served <- ErrDaemonClosed
+
+	_, err = net.Dial("tcp", s.addr)
+	c.Assert(err, NotNil)
+}
+
+func (s *DaemonSuite) TestShutdownIdle(c *C) {
+	c.Assert(s.daemon.Shutdown(context.Background()), IsNil)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+	s.served <- ErrDaemonClosed
+}
+
+type DaemonUploadPackSuite struct {
+	test.UploadPackSuite
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonUploadPackSuite{})
+
+func (s *DaemonUploadPackSuite) SetUpTest(c *C) {
+	s.BaseDaemonSuite.SetUpTest(c)
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+func (s *DaemonUploadPackSuite) TestAdvertisedReferencesWithPrefixes(c *C) {
+	c.Skip("the version 2 of the protocol is not supported by the server")
+}
+
+type DaemonReceivePackSuite struct {
+	test.ReceivePackSuite
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonReceivePackSuite{})
+
+func (s *DaemonReceivePackSuite) SetUpTest(c *C) {
+	s.BaseDaemonSuite.SetUpTest(c)
+	s.daemon.ReceivePack = true
+	s.ReceivePackSuite.Client = &syncClient{DefaultClient, s.daemon}
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// syncClient is a client waiting for the daemon to end serving its
+// receive-pack sessions, since without report-status it does not wait for the
+// references to be updated.
+type syncClient struct {
+	transport.Transport
+	daemon *Daemon
+}
+
+func (c *syncClient) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	s, err := c.Transport.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncSession{s, c.daemon}, nil
+}
+
+type syncSession struct {
+	transport.ReceivePackSession
+	daemon *Daemon
+}
+
+func (s *syncSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	rs, err := s.ReceivePackSession.ReceivePack(ctx, req)
+	for i := 0; i < 100 && s.daemon.conns.ActiveConns() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return rs, err
+}
@@ -12,6 +12,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
@@ -30,9 +31,23 @@ func applyHeadersToRequest(req *http.Request, content *bytes.Buffer, host string
 	req.Header.Add("Content-Length", strconv.Itoa(content.Len()))
 }
 
+// applyProtocolVersionToRequest requests the given version of the protocol
+// to the server, unless it is the version 0.
+func applyProtocolVersionToRequest(req *http.Request, v transport.ProtocolVersion) {
+	if v == transport.ProtocolV0 {
+		return
+	}
+
+	req.Header.Add("Git-Protocol", v.Parameter())
+}
+
 const infoRefsPath = "/info/refs"
 
-func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, err error) {
+// advertisedReferences retrieves the advertised references of the given
+// service. The version of the protocol of the session is requested for
+// git-upload-pack, if the server speaks the version 2, only the references
+// starting with any of the given prefixes are retrieved.
+func advertisedReferences(s *session, serviceName string, prefixes []string) (ref *packp.AdvRefs, err error) {
 	url := fmt.Sprintf(
 		"%s%s?service=%s",
 		s.endpoint.String(), infoRefsPath, serviceName,
@@ -45,6 +60,10 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 
 	s.ApplyAuthToRequest(req)
 	applyHeadersToRequest(req, nil, s.endpoint.Host, serviceName)
+	if serviceName == transport.UploadPackServiceName {
+		applyProtocolVersionToRequest(req, s.version)
+	}
+
 	res, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -57,8 +76,22 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 		return nil, err
 	}
 
+	adv, r, err := common.DecodeCapabilityAdvertisement(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if adv != nil {
+		s.capAdv = adv
+		if s.advRefs, err = listReferences(s, adv, prefixes); err != nil {
+			return nil, err
+		}
+
+		return s.advRefs, nil
+	}
+
 	ar := packp.NewAdvRefs()
-	if err = ar.Decode(res.Body); err != nil {
+	if err = ar.Decode(r); err != nil {
 		if err == packp.ErrEmptyAdvRefs {
 			err = transport.ErrEmptyRemoteRepository
 		}
@@ -72,6 +105,43 @@ func advertisedReferences(s *session, serviceName string) (ref *packp.AdvRefs, e
 	return ar, nil
 }
 
+// listReferences retrieves the references of a server speaking the version 2
+// of the protocol with an ls-refs command.
+func listReferences(s *session, adv *packp.CapabilityAdvertisement, prefixes []string) (
+	ar *packp.AdvRefs, err error) {
+
+	content := bytes.NewBuffer(nil)
+	if err := common.EncodeLsRefsRequest(content, adv, prefixes); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf(
+		"%s/%s",
+		s.endpoint.String(), transport.UploadPackServiceName,
+	)
+
+	req, err := http.NewRequest(http.MethodPost, url, content)
+	if err != nil {
+		return nil, plumbing.NewPermanentError(err)
+	}
+
+	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
+	applyProtocolVersionToRequest(req, transport.ProtocolV2)
+	s.ApplyAuthToRequest(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, plumbing.NewUnexpectedError(err)
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+	if err = NewErr(res); err != nil {
+		return nil, err
+	}
+
+	return common.DecodeLsRefsResponse(res.Body, adv)
+}
+
 type client struct {
 	c *http.Client
 }
@@ -100,7 +170,13 @@ func NewClient(c *http.Client) transport.Transport {
 func (c *client) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
 	transport.UploadPackSession, error) {
 
-	return newUploadPackSession(c.c, ep, auth)
+	return newUploadPackSession(c.c, ep, auth, transport.ProtocolV0)
+}
+
+func (c *client) NewUploadPackSessionVersion(ep *transport.Endpoint, auth transport.AuthMethod, v transport.ProtocolVersion) (
+	transport.UploadPackSession, error) {
+
+	return newUploadPackSession(c.c, ep, auth, v)
 }
 
 func (c *client) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
@@ -114,6 +190,8 @@ type session struct {
 	client   *http.Client
 	endpoint *transport.Endpoint
 	advRefs  *packp.AdvRefs
+	capAdv   *packp.CapabilityAdvertisement
+	version  transport.ProtocolVersion
 }
 
 func newSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
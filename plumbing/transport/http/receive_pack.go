@@ -25,7 +25,7 @@ func newReceivePackSession(c *http.Client, ep *transport.Endpoint, auth transpor
 }
 
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
-	return advertisedReferences(s.session, transport.ReceivePackServiceName)
+	return advertisedReferences(s.session, transport.ReceivePackServiceName, nil)
 }
 
 func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (
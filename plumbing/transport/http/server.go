@@ -0,0 +1,434 @@
+package http
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+)
+
+// Handler is an http.Handler serving git repositories with the smart HTTP
+// protocol, as the git-http-backend command does. The repositories are
+// resolved by a server.Loader, from the path of the URL without the
+// "/info/refs" or service suffix.
+type Handler struct {
+	// Authenticate authenticates a request, if it returns an error the
+	// request is answered with a 401 Unauthorized status, asking for basic
+	// credentials. By default, the requests are not authenticated.
+	Authenticate func(r *http.Request) error
+	// Authorize grants the access to the given service of a repository, if it
+	// returns an error the request is answered with a 403 Forbidden status.
+	// By default, only the git-upload-pack service is granted.
+	Authorize func(r *http.Request, ep *transport.Endpoint, service string) error
+	// Hooks are run by the git-receive-pack sessions, to accept or reject
+	// the pushes. By default, every push is accepted.
+	Hooks *server.Hooks
+
+	loader server.Loader
+}
+
+// NewHandler returns a new Handler serving the repositories of the given
+// loader.
+func NewHandler(loader server.Loader) *Handler {
+	return &Handler{
+		loader: loader,
+	}
+}
+
+// badRequest is an error in a request sent by the client.
+type badRequest struct {
+	error
+}
+
+// ServeHTTP serves the advertised references of a repository and the
+// stateless requests of the git-upload-pack and git-receive-pack services.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repo, service, advertise := parseServiceURL(r.URL)
+	if service == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	method := http.MethodPost
+	if advertise {
+		method = http.MethodGet
+	}
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Authenticate != nil {
+		if err := h.Authenticate(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	ep, err := requestEndpoint(r, repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorize(r, ep, service); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case advertise:
+		err = h.advertisedReferences(w, ep, service)
+	case r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", service):
+		err = badRequest{fmt.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))}
+	case service == transport.UploadPackServiceName:
+		err = h.uploadPack(w, r, ep)
+	default:
+		err = h.receivePack(w, r, ep)
+	}
+
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// newServer returns the server of the sessions, running the hooks.
+func (h *Handler) newServer() transport.Transport {
+	return server.NewServerWithHooks(h.loader, h.Hooks)
+}
+
+func (h *Handler) authorize(r *http.Request, ep *transport.Endpoint, service string) error {
+	if h.Authorize != nil {
+		return h.Authorize(r, ep, service)
+	}
+
+	if service != transport.UploadPackServiceName {
+		return fmt.Errorf("service %s not enabled", service)
+	}
+
+	return nil
+}
+
+func (h *Handler) advertisedReferences(w http.ResponseWriter, ep *transport.Endpoint, service string) error {
+	var s transport.Session
+	var err error
+	if service == transport.UploadPackServiceName {
+		s, err = h.newServer().NewUploadPackSession(ep, nil)
+	} else {
+		s, err = h.newServer().NewReceivePackSession(ep, nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer s.Close()
+
+	ar, err := s.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+
+	setResponseHeaders(w, fmt.Sprintf("application/x-%s-advertisement", service))
+
+	// once the response is started, an error can only truncate it
+	e := pktline.NewEncoder(w)
+	_ = e.Encodef("# service=%s\n", service)
+	_ = e.Flush()
+
+	// as git-upload-pack does, nothing is advertised by an empty repository
+	if service == transport.UploadPackServiceName && ar.Head == nil && len(ar.References) == 0 {
+		_ = e.Flush()
+		return nil
+	}
+
+	_ = ar.Encode(w)
+	return nil
+}
+
+func (h *Handler) uploadPack(w http.ResponseWriter, r *http.Request, ep *transport.Endpoint) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(body); err != nil {
+		return badRequest{err}
+	}
+
+	// a shallow request is sent first without haves, to get the shallow-update
+	done, err := req.UploadHaves.Decode(body)
+	deepenOnly := err == io.ErrUnexpectedEOF && req.IsShallow() && len(req.Haves) == 0
+	if err != nil && !deepenOnly {
+		return badRequest{err}
+	}
+
+	s, err := h.newServer().NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer s.Close()
+
+	if deepenOnly {
+		return h.sendShallowUpdate(w, s, req)
+	}
+
+	if done {
+		resp, err := s.UploadPack(r.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		setResponseHeaders(w, "application/x-git-upload-pack-result")
+		_ = resp.Encode(w)
+		return nil
+	}
+
+	return h.negotiate(w, r, ep, s, req)
+}
+
+// negotiate answers a round of negotiation, not ended by done. The client
+// sends its haves again in each round, the stateless sessions acknowledge all
+// of them, in multi_ack or multi_ack_detailed mode, and send the packfile if
+// they are ready with no-done. Without multi_ack, the first have found in the
+// repository is acknowledged, then the client sends the request again, with
+// done.
+func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request, ep *transport.Endpoint,
+	s transport.UploadPackSession, req *packp.UploadPackRequest) error {
+
+	var shallow *packp.ShallowUpdate
+	su, isShallowUpdater := s.(shallowUpdater)
+	if req.IsShallow() && isShallowUpdater {
+		var err error
+		if shallow, err = su.ShallowUpdate(req); err != nil {
+			return err
+		}
+	}
+
+	a, ok := s.(havesAcknowledger)
+	if !ok || !common.IsMultiACK(req) {
+		sr, err := h.acknowledgeFirstHave(ep, req.Haves)
+		if err != nil {
+			return err
+		}
+
+		setResponseHeaders(w, "application/x-git-upload-pack-result")
+		if shallow != nil {
+			_ = shallow.Encode(w)
+		}
+
+		_ = sr.Encode(w)
+		return nil
+	}
+
+	res, err := a.AcknowledgeHaves(req, req.Haves)
+	if err != nil {
+		return err
+	}
+
+	var resp *packp.UploadPackResponse
+	if res.Ready() && req.Capabilities.Supports(capability.NoDone) {
+		if resp, err = s.UploadPack(r.Context(), req); err != nil {
+			return err
+		}
+
+		defer resp.Close()
+	}
+
+	setResponseHeaders(w, "application/x-git-upload-pack-result")
+	if shallow != nil {
+		_ = shallow.Encode(w)
+	}
+
+	if err := res.Encode(w); err != nil || resp == nil {
+		return nil
+	}
+
+	if err := resp.ServerResponse.Encode(w); err != nil {
+		return nil
+	}
+
+	_, _ = io.Copy(w, resp)
+	return nil
+}
+
+// sendShallowUpdate answers a shallow request without haves with its
+// shallow-update, as git-upload-pack does.
+func (h *Handler) sendShallowUpdate(w http.ResponseWriter, s transport.UploadPackSession, req *packp.UploadPackRequest) error {
+	su, ok := s.(shallowUpdater)
+	if !ok {
+		return badRequest{fmt.Errorf("shallow requests are not supported")}
+	}
+
+	shallow, err := su.ShallowUpdate(req)
+	if err != nil {
+		return err
+	}
+
+	setResponseHeaders(w, "application/x-git-upload-pack-result")
+	_ = shallow.Encode(w)
+	return nil
+}
+
+// acknowledgeFirstHave returns the acknowledgment of the first have found in
+// the repository, as upload-pack does without multi_ack.
+func (h *Handler) acknowledgeFirstHave(ep *transport.Endpoint, haves []plumbing.Hash) (*packp.ServerResponse, error) {
+	s, err := h.loader.Load(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &packp.ServerResponse{}
+	for _, have := range haves {
+		err := s.HasEncodedObject(have)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sr.ACKs = append(sr.ACKs, have)
+		break
+	}
+
+	return sr, nil
+}
+
+func (h *Handler) receivePack(w http.ResponseWriter, r *http.Request, ep *transport.Endpoint) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(body); err != nil {
+		return badRequest{err}
+	}
+
+	s, err := h.newServer().NewReceivePackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer s.Close()
+
+	// the errors of the commands are sent in the report status, if requested
+	rs, err := s.ReceivePack(r.Context(), req)
+	if rs == nil && err != nil {
+		return err
+	}
+
+	setResponseHeaders(w, "application/x-git-receive-pack-result")
+	if e, ok := s.(reportStatusEncoder); ok {
+		_ = e.EncodeReportStatus(w, rs)
+	} else if rs != nil {
+		_ = rs.Encode(w)
+	}
+
+	return nil
+}
+
+// shallowUpdater is implemented by the sessions computing the shallow-update
+// of a request, sent in every round of a negotiation.
+type shallowUpdater interface {
+	ShallowUpdate(req *packp.UploadPackRequest) (*packp.ShallowUpdate, error)
+}
+
+// havesAcknowledger is implemented by the sessions acknowledging the haves of
+// a negotiation in multi_ack or multi_ack_detailed mode.
+type havesAcknowledger interface {
+	AcknowledgeHaves(req *packp.UploadPackRequest, haves []plumbing.Hash) (*packp.NegotiationResponse, error)
+}
+
+// reportStatusEncoder is implemented by the sessions encoding the report
+// status themselves, multiplexed with their messages in a sideband.
+type reportStatusEncoder interface {
+	EncodeReportStatus(w io.Writer, rs *packp.ReportStatus) error
+}
+
+// parseServiceURL returns the path of the repository and the service of a
+// smart HTTP request to the given URL, and whether it requests the advertised
+// references. The service is empty if the URL is not a smart HTTP one.
+func parseServiceURL(u *url.URL) (repo, service string, advertise bool) {
+	p := u.Path
+	switch {
+	case strings.HasSuffix(p, infoRefsPath):
+		repo, service, advertise = strings.TrimSuffix(p, infoRefsPath), u.Query().Get("service"), true
+	case strings.HasSuffix(p, "/"+transport.UploadPackServiceName):
+		repo, service = strings.TrimSuffix(p, "/"+transport.UploadPackServiceName), transport.UploadPackServiceName
+	case strings.HasSuffix(p, "/"+transport.ReceivePackServiceName):
+		repo, service = strings.TrimSuffix(p, "/"+transport.ReceivePackServiceName), transport.ReceivePackServiceName
+	}
+
+	if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+		return "", "", false
+	}
+
+	return path.Clean("/" + repo), service, advertise
+}
+
+// requestEndpoint returns the endpoint of the repository at the given path of
+// the server receiving r.
+func requestEndpoint(r *http.Request, repo string) (*transport.Endpoint, error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return transport.NewEndpoint(fmt.Sprintf("%s://%s%s", scheme, r.Host, repo))
+}
+
+// requestBody returns the body of r, decompressed if it is compressed with
+// gzip, as git does with the large requests.
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return r.Body, nil
+	}
+
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, badRequest{err}
+	}
+
+	return zr, nil
+}
+
+func setResponseHeaders(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if _, ok := err.(badRequest); ok {
+		code = http.StatusBadRequest
+	} else if err == transport.ErrRepositoryNotFound {
+		code = http.StatusNotFound
+	}
+
+	http.Error(w, err.Error(), code)
+}
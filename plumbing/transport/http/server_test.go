@@ -0,0 +1,367 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/test"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type BaseHandlerSuite struct {
+	fixtures.Suite
+
+	base    string
+	handler *Handler
+	server  *httptest.Server
+}
+
+func (s *BaseHandlerSuite) SetUpTest(c *C) {
+	s.base = c.MkDir()
+	s.handler = NewHandler(server.NewFilesystemLoader(osfs.New(s.base)))
+	s.server = httptest.NewServer(s.handler)
+}
+
+func (s *BaseHandlerSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *BaseHandlerSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+
+	err := fixtures.EnsureIsBare(fs)
+	c.Assert(err, IsNil)
+
+	err = os.Rename(fs.Root(), filepath.Join(s.base, name))
+	c.Assert(err, IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *BaseHandlerSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("%s/%s", s.server.URL, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+func (s *BaseHandlerSuite) allowReceivePack() {
+	s.handler.Authorize = func(*http.Request, *transport.Endpoint, string) error {
+		return nil
+	}
+}
+
+type HandlerSuite struct {
+	BaseHandlerSuite
+}
+
+var _ = Suite(&HandlerSuite{})
+
+// git runs the git command in the given directory.
+func (s *HandlerSuite) git(c *C, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.com",
+		"-c", "protocol.version=0",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+	return strings.TrimSpace(string(out))
+}
+
+func (s *HandlerSuite) TestGitClone(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+	c.Assert(s.git(c, filepath.Join(dir, "basic"), "rev-parse", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	s.git(c, filepath.Join(dir, "basic"), "fsck")
+}
+
+func (s *HandlerSuite) TestGitFetchNegotiation(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "--single-branch", "--branch", "master", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	s.git(c, repo, "fetch", "origin", "branch")
+	c.Assert(s.git(c, repo, "rev-parse", "FETCH_HEAD"), Equals,
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	s.git(c, repo, "fsck")
+}
+
+func (s *HandlerSuite) TestGitShallowFetch(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", "--depth", "1", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	c.Assert(s.git(c, repo, "rev-list", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	s.git(c, repo, "fetch", "--deepen", "1")
+	c.Assert(s.git(c, repo, "rev-list", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	s.git(c, repo, "fetch", "--unshallow")
+	c.Assert(s.git(c, repo, "rev-parse", "--is-shallow-repository"), Equals, "false")
+	s.git(c, repo, "fsck")
+}
+
+func (s *HandlerSuite) TestGitPush(c *C) {
+	s.allowReceivePack()
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	s.git(c, repo, "commit", "--allow-empty", "-m", "foo")
+	s.git(c, repo, "push", "origin", "master:master", "master:refs/heads/new")
+	head := s.git(c, repo, "rev-parse", "HEAD")
+
+	remote := filepath.Join(s.base, "basic.git")
+	c.Assert(s.git(c, remote, "rev-parse", "refs/heads/master"), Equals, head)
+	c.Assert(s.git(c, remote, "rev-parse", "refs/heads/new"), Equals, head)
+
+	s.git(c, repo, "push", "origin", ":refs/heads/new")
+	_, err := os.Stat(filepath.Join(remote, "refs", "heads", "new"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *HandlerSuite) TestReceivePackNotEnabled(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	res, err := http.Get(ep.String() + "/info/refs?service=git-receive-pack")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+}
+
+func (s *HandlerSuite) TestNotFound(c *C) {
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	for _, url := range []string{
+		"/basic.git/info/refs",
+		"/basic.git/info/refs?service=foo",
+		"/basic.git/HEAD",
+		"/non-existent.git/info/refs?service=git-upload-pack",
+	} {
+		res, err := http.Get(s.server.URL + url)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusNotFound, Commentf(url))
+	}
+}
+
+func (s *HandlerSuite) TestMethodNotAllowed(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	res, err := http.Get(ep.String() + "/git-upload-pack")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusMethodNotAllowed)
+	c.Assert(res.Header.Get("Allow"), Equals, http.MethodPost)
+}
+
+func (s *HandlerSuite) TestAuthenticate(c *C) {
+	s.handler.Authenticate = func(r *http.Request) error {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "foo" || pass != "bar" {
+			return errors.New("invalid credentials")
+		}
+
+		return nil
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	res, err := http.Get(ep.String() + "/info/refs?service=git-upload-pack")
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(res.Header.Get("WWW-Authenticate"), Equals, `Basic realm="git"`)
+
+	_, err = DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	sess, err := DefaultClient.NewUploadPackSession(ep, &BasicAuth{Username: "foo", Password: "baz"})
+	c.Assert(err, IsNil)
+	_, err = sess.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrAuthenticationRequired)
+
+	sess, err = DefaultClient.NewUploadPackSession(ep, &BasicAuth{Username: "foo", Password: "bar"})
+	c.Assert(err, IsNil)
+	ar, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.References, HasLen, 5)
+}
+
+func (s *HandlerSuite) TestAuthorize(c *C) {
+	s.handler.Authorize = func(r *http.Request, ep *transport.Endpoint, service string) error {
+		if ep.Path != "/basic.git" || service != transport.UploadPackServiceName {
+			return errors.New("access denied")
+		}
+
+		return nil
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	other := s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+
+	for url, code := range map[string]int{
+		ep.String() + "/info/refs?service=git-upload-pack":     http.StatusOK,
+		ep.String() + "/info/refs?service=git-receive-pack":    http.StatusForbidden,
+		other.String() + "/info/refs?service=git-upload-pack":  http.StatusForbidden,
+		other.String() + "/info/refs?service=git-receive-pack": http.StatusForbidden,
+	} {
+		res, err := http.Get(url)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		c.Assert(res.StatusCode, Equals, code, Commentf(url))
+	}
+}
+
+func (s *HandlerSuite) TestUploadPackGzip(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	content, err := uploadPackRequestToReader(req)
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	_, err = zw.Write(content.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(zw.Close(), IsNil)
+
+	hreq, err := http.NewRequest(http.MethodPost, ep.String()+"/git-upload-pack", buf)
+	c.Assert(err, IsNil)
+	hreq.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	hreq.Header.Set("Content-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(hreq)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-result")
+
+	body, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(body), "0008NAK\nPACK"), Equals, true)
+}
+
+func (s *HandlerSuite) TestUploadPackBadRequest(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	res, err := http.Post(ep.String()+"/git-upload-pack",
+		"application/x-git-upload-pack-request", strings.NewReader("foo"))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+
+	res, err = http.Post(ep.String()+"/git-upload-pack",
+		"text/plain", strings.NewReader(""))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *HandlerSuite) TestUploadPackSidebandProgress(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	sess, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	ar, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.Sideband64k), Equals, true)
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	res, err := sess.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer res.Close()
+
+	progress := bytes.NewBuffer(nil)
+	d := sideband.NewDemuxer(sideband.Sideband64k, res)
+	d.Progress = progress
+
+	pack, err := ioutil.ReadAll(d)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(pack), "PACK"), Equals, true)
+	c.Assert(progress.String(), Equals, "Enumerating objects: 28, done.\n")
+}
+
+type HandlerUploadPackSuite struct {
+	test.UploadPackSuite
+	BaseHandlerSuite
+}
+
+var _ = Suite(&HandlerUploadPackSuite{})
+
+func (s *HandlerUploadPackSuite) SetUpTest(c *C) {
+	s.BaseHandlerSuite.SetUpTest(c)
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// Overwritten, different behaviour for HTTP.
+func (s *HandlerUploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(info, IsNil)
+}
+
+func (s *HandlerUploadPackSuite) TestAdvertisedReferencesWithPrefixes(c *C) {
+	c.Skip("the version 2 of the protocol is not supported by the server")
+}
+
+func (s *HandlerUploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
+	c.Skip("the response can be received before the context is canceled")
+}
+
+type HandlerReceivePackSuite struct {
+	test.ReceivePackSuite
+	BaseHandlerSuite
+}
+
+var _ = Suite(&HandlerReceivePackSuite{})
+
+func (s *HandlerReceivePackSuite) SetUpTest(c *C) {
+	s.BaseHandlerSuite.SetUpTest(c)
+	s.allowReceivePack()
+	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
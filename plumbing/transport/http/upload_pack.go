@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -19,13 +20,22 @@ type upSession struct {
 	*session
 }
 
-func newUploadPackSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+func newUploadPackSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMethod, v transport.ProtocolVersion) (transport.UploadPackSession, error) {
 	s, err := newSession(c, ep, auth)
-	return &upSession{s}, err
+	if err != nil {
+		return nil, err
+	}
+
+	s.version = v
+	return &upSession{s}, nil
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
-	return advertisedReferences(s.session, transport.UploadPackServiceName)
+	return s.AdvertisedReferencesWithPrefixes(nil)
+}
+
+func (s *upSession) AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error) {
+	return advertisedReferences(s.session, transport.UploadPackServiceName, prefixes)
 }
 
 func (s *upSession) UploadPack(
@@ -40,12 +50,16 @@ func (s *upSession) UploadPack(
 		return nil, err
 	}
 
-	url := fmt.Sprintf(
-		"%s/%s",
-		s.endpoint.String(), transport.UploadPackServiceName,
-	)
+	url := s.uploadPackURL()
+
+	var content *bytes.Buffer
+	var err error
+	if s.capAdv != nil {
+		content, err = fetchRequestToReader(s.capAdv, req)
+	} else {
+		content, err = uploadPackRequestToReader(req)
+	}
 
-	content, err := uploadPackRequestToReader(req)
 	if err != nil {
 		return nil, err
 	}
@@ -65,9 +79,129 @@ func (s *upSession) UploadPack(
 	}
 
 	rc := ioutil.NewReadCloser(r, res.Body)
+	if s.capAdv != nil {
+		return common.DecodeFetchResponse(rc, req)
+	}
+
 	return common.DecodeUploadPackResponse(rc, req)
 }
 
+// UploadPackWithNegotiator performs the request negotiating the haves in
+// multiple rounds, each one being a POST request, as the stateless-rpc mode of
+// the smart HTTP protocol does. The haves known to be common are sent again in
+// every round, and the last request sends the 'done'.
+func (s *upSession) UploadPackWithNegotiator(
+	ctx context.Context, req *packp.UploadPackRequest, n transport.Negotiator,
+) (*packp.UploadPackResponse, error) {
+
+	if len(req.Wants) == 0 {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := *req
+	if s.capAdv == nil && !common.IsMultiACK(req) {
+		haves, err := common.DrainNegotiator(n)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Haves = haves
+		return s.UploadPack(ctx, &r)
+	}
+
+	neg := common.NewNegotiation(n, true)
+	for {
+		haves, err := neg.Haves()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		haves = append(append([]plumbing.Hash(nil), neg.Common()...), haves...)
+		res, err := s.negotiationRound(ctx, req, haves, neg)
+		if res != nil || err != nil {
+			return res, err
+		}
+	}
+
+	r.Haves = neg.Common()
+	return s.UploadPack(ctx, &r)
+}
+
+// negotiationRound sends a round of haves and processes the acknowledgments
+// of the server. With the version 2 of the protocol, the server may send the
+// packfile once ready, the response is then returned.
+func (s *upSession) negotiationRound(
+	ctx context.Context, req *packp.UploadPackRequest,
+	haves []plumbing.Hash, neg *common.Negotiation,
+) (_ *packp.UploadPackResponse, err error) {
+
+	content := bytes.NewBuffer(nil)
+	if s.capAdv != nil {
+		err = common.EncodeFetchRound(content, s.capAdv, req, haves, false)
+	} else {
+		err = negotiationRequestToWriter(content, req, haves)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.capAdv != nil {
+		fr := &packp.FetchResponse{}
+		if err := fr.Decode(res.Body); err != nil {
+			_ = res.Body.Close()
+			return nil, fmt.Errorf("error decoding fetch response: %s", err)
+		}
+
+		if err := neg.AckFetchResponse(fr); err != nil {
+			_ = res.Body.Close()
+			return nil, err
+		}
+
+		if fr.Packfile {
+			return common.NewUploadPackResponseFromFetch(fr, res.Body, req), nil
+		}
+
+		return nil, res.Body.Close()
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+	r := bufio.NewReader(res.Body)
+	if req.IsShallow() {
+		var shallow packp.ShallowUpdate
+		if err := shallow.Decode(r); err != nil {
+			return nil, fmt.Errorf("error decoding shallow-update: %s", err)
+		}
+	}
+
+	nr := &packp.NegotiationResponse{}
+	if err := nr.Decode(r); err != nil {
+		return nil, fmt.Errorf("error decoding negotiation response: %s", err)
+	}
+
+	return nil, neg.Ack(nr.Acknowledgments)
+}
+
+func (s *upSession) uploadPackURL() string {
+	return fmt.Sprintf(
+		"%s/%s",
+		s.endpoint.String(), transport.UploadPackServiceName,
+	)
+}
+
 // Close does nothing.
 func (s *upSession) Close() error {
 	return nil
@@ -88,6 +222,10 @@ func (s *upSession) doRequest(
 	}
 
 	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
+	if s.capAdv != nil {
+		applyProtocolVersionToRequest(req, transport.ProtocolV2)
+	}
+
 	s.ApplyAuthToRequest(req)
 
 	res, err := s.client.Do(req.WithContext(ctx))
@@ -121,3 +259,27 @@ func uploadPackRequestToReader(req *packp.UploadPackRequest) (*bytes.Buffer, err
 
 	return buf, nil
 }
+
+// negotiationRequestToWriter writes to w a request of a round of negotiation,
+// the haves are followed by a flush-pkt instead of 'done'.
+func negotiationRequestToWriter(w io.Writer, req *packp.UploadPackRequest, haves []plumbing.Hash) error {
+	if err := req.UploadRequest.Encode(w); err != nil {
+		return fmt.Errorf("sending upload-req message: %s", err)
+	}
+
+	uh := &packp.UploadHaves{Haves: haves}
+	if err := uh.Encode(w, true); err != nil {
+		return fmt.Errorf("sending haves message: %s", err)
+	}
+
+	return nil
+}
+
+func fetchRequestToReader(adv *packp.CapabilityAdvertisement, req *packp.UploadPackRequest) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := common.EncodeFetchRequest(buf, adv, req); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -86,14 +86,22 @@ func NewClient(runner Commander) transport.Transport {
 func (c *client) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
 	transport.UploadPackSession, error) {
 
-	return c.newSession(transport.UploadPackServiceName, ep, auth)
+	return c.newSession(transport.UploadPackServiceName, ep, auth, transport.ProtocolV0)
+}
+
+// NewUploadPackSessionVersion creates a new UploadPackSession requesting the
+// given version of the protocol.
+func (c *client) NewUploadPackSessionVersion(ep *transport.Endpoint, auth transport.AuthMethod, v transport.ProtocolVersion) (
+	transport.UploadPackSession, error) {
+
+	return c.newSession(transport.UploadPackServiceName, ep, auth, v)
 }
 
 // NewReceivePackSession creates a new ReceivePackSession.
 func (c *client) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
 	transport.ReceivePackSession, error) {
 
-	return c.newSession(transport.ReceivePackServiceName, ep, auth)
+	return c.newSession(transport.ReceivePackServiceName, ep, auth, transport.ProtocolV0)
 }
 
 type session struct {
@@ -103,12 +111,14 @@ type session struct {
 
 	isReceivePack bool
 	advRefs       *packp.AdvRefs
+	capAdv        *packp.CapabilityAdvertisement
+	advReader     io.Reader
 	packRun       bool
 	finished      bool
 	firstErrLine  chan string
 }
 
-func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
+func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.AuthMethod, version transport.ProtocolVersion) (*session, error) {
 	cmd, err := c.cmdr.Command(s, ep, auth)
 	if err != nil {
 		return nil, err
@@ -129,6 +139,12 @@ func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.Aut
 		return nil, err
 	}
 
+	if v, ok := cmd.(ProtocolVersionCommand); ok && version != transport.ProtocolV0 {
+		if err := v.SetProtocolVersion(version); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
@@ -164,12 +180,27 @@ func (c *client) listenFirstError(r io.Reader) chan string {
 
 // AdvertisedReferences retrieves the advertised references from the server.
 func (s *session) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesWithPrefixes(nil)
+}
+
+// AdvertisedReferencesWithPrefixes retrieves the advertised references
+// starting with any of the given prefixes from the server, if it speaks the
+// version 2 of the protocol, otherwise every reference is retrieved.
+func (s *session) AdvertisedReferencesWithPrefixes(prefixes []string) (*packp.AdvRefs, error) {
 	if s.advRefs != nil {
 		return s.advRefs, nil
 	}
 
+	if err := s.detectVersion(); err != nil {
+		return nil, err
+	}
+
+	if s.capAdv != nil {
+		return s.listReferences(prefixes)
+	}
+
 	ar := packp.NewAdvRefs()
-	if err := ar.Decode(s.Stdout); err != nil {
+	if err := ar.Decode(s.advReader); err != nil {
 		if err := s.handleAdvRefDecodeError(err); err != nil {
 			return nil, err
 		}
@@ -180,6 +211,45 @@ func (s *session) AdvertisedReferences() (*packp.AdvRefs, error) {
 	return ar, nil
 }
 
+// detectVersion reads the capability advertisement if the server speaks the
+// version 2 of the protocol, otherwise the advertised-refs message is left to
+// be read from advReader.
+func (s *session) detectVersion() error {
+	if s.capAdv != nil || s.advReader != nil {
+		return nil
+	}
+
+	adv, r, err := DecodeCapabilityAdvertisement(s.Stdout)
+	if err != nil {
+		return err
+	}
+
+	s.capAdv = adv
+	s.advReader = r
+	return nil
+}
+
+// listReferences retrieves the references with an ls-refs command.
+func (s *session) listReferences(prefixes []string) (*packp.AdvRefs, error) {
+	if err := EncodeLsRefsRequest(s.Stdin, s.capAdv, prefixes); err != nil {
+		return nil, err
+	}
+
+	ar, err := DecodeLsRefsResponse(s.Stdout, s.capAdv)
+	if err == transport.ErrEmptyRemoteRepository {
+		if err := s.finish(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.advRefs = ar
+	return ar, nil
+}
+
 func (s *session) handleAdvRefDecodeError(err error) error {
 	// If repository is not found, we get empty stdout and server writes an
 	// error to stderr.
@@ -230,6 +300,14 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 		return nil, err
 	}
 
+	if err := s.detectVersion(); err != nil {
+		return nil, err
+	}
+
+	if s.capAdv != nil {
+		return s.uploadPackV2(ctx, req)
+	}
+
 	if _, err := s.AdvertisedReferences(); err != nil {
 		return nil, err
 	}
@@ -260,6 +338,25 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 	return DecodeUploadPackResponse(rc, req)
 }
 
+// uploadPackV2 sends a fetch command, ending the session of the version 2 of
+// the protocol with a flush-pkt after it.
+func (s *session) uploadPackV2(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	s.packRun = true
+
+	in := s.StdinContext(ctx)
+	out := s.StdoutContext(ctx)
+
+	if err := EncodeFetchRequest(in, s.capAdv, req); err != nil {
+		return nil, err
+	}
+
+	if err := endV2(in); err != nil {
+		return nil, err
+	}
+
+	return DecodeFetchResponse(ioutil.NewReadCloser(out, s), req)
+}
+
 func (s *session) StdinContext(ctx context.Context) io.WriteCloser {
 	return ioutil.NewWriteCloserOnError(
 		ioutil.NewContextWriteCloser(ctx, s.Stdin),
@@ -422,18 +519,16 @@ var (
 	eol = []byte("\n")
 )
 
-// uploadPack implements the git-upload-pack protocol.
+// uploadPack implements the git-upload-pack protocol, sending every have in
+// a single round. In multi_ack or multi_ack_detailed mode the haves are not
+// followed by a flush-pkt, the acknowledgments of the server are then sent
+// along with the one of 'done'.
 func uploadPack(w io.WriteCloser, r io.Reader, req *packp.UploadPackRequest) error {
-	// TODO support multi_ack mode
-	// TODO support multi_ack_detailed mode
-	// TODO support acks for common objects
-	// TODO build a proper state machine for all these processing options
-
 	if err := req.UploadRequest.Encode(w); err != nil {
 		return fmt.Errorf("sending upload-req message: %s", err)
 	}
 
-	if err := req.UploadHaves.Encode(w, true); err != nil {
+	if err := req.UploadHaves.Encode(w, !IsMultiACK(req)); err != nil {
 		return fmt.Errorf("sending haves message: %s", err)
 	}
 
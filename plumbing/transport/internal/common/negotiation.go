@@ -0,0 +1,348 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	// initialFlush is the number of haves sent in the first round.
+	initialFlush = 16
+	// pipeSafeFlush is the maximum number of haves added to a round over a
+	// full-duplex connection, keeping the acknowledgments of the server
+	// below the capacity of the pipes.
+	pipeSafeFlush = 32
+	// largeFlush is the number of haves sent over stateless connections
+	// from which the rounds grow by 10% instead of doubling.
+	largeFlush = 16384
+	// maxInVain is the number of haves sent without any new common one,
+	// once a common one was found, after which the negotiation gives up.
+	maxInVain = 256
+)
+
+// Negotiation holds the state of the negotiation of the haves of a fetch, in
+// multiple rounds. In stateless mode, as for the smart HTTP protocol and the
+// version 2 of the protocol, the server does not keep any state between the
+// rounds and the haves known to be common are sent again in every round.
+type Negotiation struct {
+	n         transport.Negotiator
+	stateless bool
+
+	count       int
+	inVain      int
+	gotContinue bool
+	ready       bool
+	exhausted   bool
+
+	common    []plumbing.Hash
+	commonSet map[plumbing.Hash]bool
+}
+
+// NewNegotiation returns a new Negotiation obtaining the haves from n.
+func NewNegotiation(n transport.Negotiator, stateless bool) *Negotiation {
+	return &Negotiation{
+		n:         n,
+		stateless: stateless,
+		commonSet: make(map[plumbing.Hash]bool),
+	}
+}
+
+// nextFlush returns the number of haves to send up to the end of the next
+// round, as git does.
+func (n *Negotiation) nextFlush() int {
+	switch {
+	case n.count == 0:
+		return initialFlush
+	case n.stateless && n.count < largeFlush:
+		return n.count * 2
+	case n.stateless:
+		return n.count * 11 / 10
+	case n.count < pipeSafeFlush:
+		return n.count * 2
+	default:
+		return n.count + pipeSafeFlush
+	}
+}
+
+// Done returns true when no more rounds are needed: the server is ready to
+// send the packfile, the negotiator has no haves left or too many of them
+// were sent in vain.
+func (n *Negotiation) Done() bool {
+	return n.ready || n.exhausted || n.gotContinue && n.inVain > maxInVain
+}
+
+// Haves returns the haves of the next round, none once the negotiation is
+// done.
+func (n *Negotiation) Haves() ([]plumbing.Hash, error) {
+	if n.Done() {
+		return nil, nil
+	}
+
+	var haves []plumbing.Hash
+	for flush := n.nextFlush(); n.count < flush; {
+		h, err := n.n.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if h.IsZero() {
+			n.exhausted = true
+			break
+		}
+
+		haves = append(haves, h)
+		n.count++
+		n.inVain++
+	}
+
+	return haves, nil
+}
+
+// Common returns the haves known to be common to send again in every round in
+// stateless mode, nil otherwise.
+func (n *Negotiation) Common() []plumbing.Hash {
+	if !n.stateless {
+		return nil
+	}
+
+	return n.common
+}
+
+// Ack processes the acknowledgments of a round.
+func (n *Negotiation) Ack(acks []packp.Acknowledgment) error {
+	for _, a := range acks {
+		n.gotContinue = true
+		if a.Status == packp.ACKReady {
+			n.ready = true
+			continue
+		}
+
+		if n.commonSet[a.Hash] {
+			continue
+		}
+
+		n.commonSet[a.Hash] = true
+		n.common = append(n.common, a.Hash)
+		n.inVain = 0
+		if err := n.n.Ack(a.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AckFetchResponse processes the acknowledgments of a round of the version 2
+// of the protocol.
+func (n *Negotiation) AckFetchResponse(fr *packp.FetchResponse) error {
+	acks := make([]packp.Acknowledgment, 0, len(fr.ACKs))
+	for _, h := range fr.ACKs {
+		acks = append(acks, packp.Acknowledgment{Hash: h, Status: packp.ACKCommon})
+	}
+
+	if err := n.Ack(acks); err != nil {
+		return err
+	}
+
+	n.ready = n.ready || fr.Ready
+	return nil
+}
+
+// DrainNegotiator returns every have of n, to send them in a single round.
+func DrainNegotiator(n transport.Negotiator) ([]plumbing.Hash, error) {
+	var haves []plumbing.Hash
+	for {
+		h, err := n.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if h.IsZero() {
+			return haves, nil
+		}
+
+		haves = append(haves, h)
+	}
+}
+
+// IsMultiACK returns true if the request negotiates in multi_ack or
+// multi_ack_detailed mode.
+func IsMultiACK(req *packp.UploadPackRequest) bool {
+	return req.Capabilities.Supports(capability.MultiACK) ||
+		req.Capabilities.Supports(capability.MultiACKDetailed)
+}
+
+// UploadPackWithNegotiator performs a request to the server to fetch a
+// packfile, negotiating the haves in multiple rounds with the given
+// negotiator.
+func (s *session) UploadPackWithNegotiator(ctx context.Context, req *packp.UploadPackRequest,
+	n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
+	if len(req.Wants) == 0 {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.detectVersion(); err != nil {
+		return nil, err
+	}
+
+	if s.capAdv != nil {
+		s.packRun = true
+		return negotiateV2(s.StdinContext(ctx), ioutil.NewReadCloser(s.StdoutContext(ctx), s), s.capAdv, req, n)
+	}
+
+	if _, err := s.AdvertisedReferences(); err != nil {
+		return nil, err
+	}
+
+	if !IsMultiACK(req) {
+		haves, err := DrainNegotiator(n)
+		if err != nil {
+			return nil, err
+		}
+
+		r := *req
+		r.Haves = haves
+		return s.UploadPack(ctx, &r)
+	}
+
+	s.packRun = true
+	return negotiate(s.StdinContext(ctx), ioutil.NewReadCloser(s.StdoutContext(ctx), s), req, n)
+}
+
+// negotiate performs a request over a full-duplex connection, in multi_ack or
+// multi_ack_detailed mode.
+func negotiate(w io.WriteCloser, rc io.ReadCloser, req *packp.UploadPackRequest,
+	n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
+	if err := req.UploadRequest.Encode(w); err != nil {
+		return nil, fmt.Errorf("sending upload-req message: %s", err)
+	}
+
+	r := bufio.NewReader(rc)
+	var shallow packp.ShallowUpdate
+	if req.IsShallow() {
+		if err := shallow.Decode(r); err != nil {
+			return nil, fmt.Errorf("error decoding shallow-update: %s", err)
+		}
+	}
+
+	neg := NewNegotiation(n, false)
+	for {
+		haves, err := neg.Haves()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		uh := &packp.UploadHaves{Haves: haves}
+		if err := uh.Encode(w, true); err != nil {
+			return nil, fmt.Errorf("sending haves message: %s", err)
+		}
+
+		res := &packp.NegotiationResponse{}
+		if err := res.Decode(r); err != nil {
+			return nil, fmt.Errorf("error decoding negotiation response: %s", err)
+		}
+
+		if err := neg.Ack(res.Acknowledgments); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sendDone(w); err != nil {
+		return nil, fmt.Errorf("sending done message: %s", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %s", err)
+	}
+
+	res := packp.NewUploadPackResponseWithPackfile(req, ioutil.NewReadCloser(r, rc))
+	res.ShallowUpdate = shallow
+	if err := res.ServerResponse.Decode(r, true); err != nil {
+		return nil, fmt.Errorf("error decoding upload-pack response: %s", err)
+	}
+
+	return res, nil
+}
+
+// negotiateV2 performs a request over a full-duplex connection speaking the
+// version 2 of the protocol, sending a fetch command per round.
+func negotiateV2(w io.WriteCloser, r io.ReadCloser, adv *packp.CapabilityAdvertisement,
+	req *packp.UploadPackRequest, n transport.Negotiator) (*packp.UploadPackResponse, error) {
+
+	neg := NewNegotiation(n, true)
+	for {
+		haves, err := neg.Haves()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		haves = append(append([]plumbing.Hash(nil), neg.Common()...), haves...)
+		if err := EncodeFetchRound(w, adv, req, haves, false); err != nil {
+			return nil, err
+		}
+
+		fr := &packp.FetchResponse{}
+		if err := fr.Decode(r); err != nil {
+			return nil, fmt.Errorf("error decoding fetch response: %s", err)
+		}
+
+		if err := neg.AckFetchResponse(fr); err != nil {
+			return nil, err
+		}
+
+		// the server sends the packfile once ready
+		if fr.Packfile {
+			if err := endV2(w); err != nil {
+				return nil, err
+			}
+
+			return NewUploadPackResponseFromFetch(fr, r, req), nil
+		}
+	}
+
+	if err := EncodeFetchRound(w, adv, req, neg.Common(), true); err != nil {
+		return nil, err
+	}
+
+	if err := endV2(w); err != nil {
+		return nil, err
+	}
+
+	return DecodeFetchResponse(r, req)
+}
+
+// endV2 ends a session of the version 2 of the protocol with a flush-pkt.
+func endV2(w io.WriteCloser) error {
+	if _, err := w.Write(pktline.FlushPkt); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing input: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+
+	. "gopkg.in/check.v1"
+)
+
+type NegotiationSuite struct{}
+
+var _ = Suite(&NegotiationSuite{})
+
+// countNegotiator is a transport.Negotiator sending n haves.
+type countNegotiator struct {
+	n    int
+	sent int
+	acks []plumbing.Hash
+}
+
+func (n *countNegotiator) Next() (plumbing.Hash, error) {
+	if n.sent == n.n {
+		return plumbing.ZeroHash, nil
+	}
+
+	n.sent++
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte{byte(n.sent)}), nil
+}
+
+func (n *countNegotiator) Ack(h plumbing.Hash) error {
+	n.acks = append(n.acks, h)
+	return nil
+}
+
+func (s *NegotiationSuite) rounds(c *C, neg *Negotiation) []int {
+	var rounds []int
+	for {
+		haves, err := neg.Haves()
+		c.Assert(err, IsNil)
+		if len(haves) == 0 {
+			return rounds
+		}
+
+		rounds = append(rounds, len(haves))
+	}
+}
+
+func (s *NegotiationSuite) TestRounds(c *C) {
+	neg := NewNegotiation(&countNegotiator{n: 200}, false)
+	c.Assert(s.rounds(c, neg), DeepEquals, []int{16, 16, 32, 32, 32, 32, 32, 8})
+	c.Assert(neg.Done(), Equals, true)
+}
+
+func (s *NegotiationSuite) TestRoundsStateless(c *C) {
+	neg := NewNegotiation(&countNegotiator{n: 200}, true)
+	c.Assert(s.rounds(c, neg), DeepEquals, []int{16, 16, 32, 64, 72})
+}
+
+func (s *NegotiationSuite) TestReady(c *C) {
+	n := &countNegotiator{n: 200}
+	neg := NewNegotiation(n, false)
+
+	haves, err := neg.Haves()
+	c.Assert(err, IsNil)
+	c.Assert(neg.Ack([]packp.Acknowledgment{
+		{Hash: haves[0], Status: packp.ACKCommon},
+		{Hash: haves[1], Status: packp.ACKReady},
+	}), IsNil)
+
+	c.Assert(neg.Done(), Equals, true)
+	c.Assert(n.acks, DeepEquals, []plumbing.Hash{haves[0]})
+	c.Assert(neg.Common(), HasLen, 0)
+
+	haves, err = neg.Haves()
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 0)
+}
+
+func (s *NegotiationSuite) TestCommonStateless(c *C) {
+	n := &countNegotiator{n: 200}
+	neg := NewNegotiation(n, true)
+
+	haves, err := neg.Haves()
+	c.Assert(err, IsNil)
+
+	acks := []packp.Acknowledgment{{Hash: haves[3], Status: packp.ACKCommon}}
+	c.Assert(neg.Ack(acks), IsNil)
+	c.Assert(neg.Ack(acks), IsNil)
+	c.Assert(n.acks, DeepEquals, []plumbing.Hash{haves[3]})
+	c.Assert(neg.Common(), DeepEquals, []plumbing.Hash{haves[3]})
+}
+
+func (s *NegotiationSuite) TestAckFetchResponse(c *C) {
+	n := &countNegotiator{n: 200}
+	neg := NewNegotiation(n, true)
+
+	haves, err := neg.Haves()
+	c.Assert(err, IsNil)
+
+	fr := &packp.FetchResponse{ACKs: []plumbing.Hash{haves[0]}, Ready: true}
+	c.Assert(neg.AckFetchResponse(fr), IsNil)
+	c.Assert(n.acks, DeepEquals, []plumbing.Hash{haves[0]})
+	c.Assert(neg.Done(), Equals, true)
+}
+
+func (s *NegotiationSuite) TestInVain(c *C) {
+	n := &countNegotiator{n: 1000}
+	neg := NewNegotiation(n, false)
+
+	haves, err := neg.Haves()
+	c.Assert(err, IsNil)
+	c.Assert(neg.Ack([]packp.Acknowledgment{
+		{Hash: haves[15], Status: packp.ACKCommon},
+	}), IsNil)
+
+	// the negotiation gives up after more than maxInVain haves without
+	// any new common one
+	c.Assert(s.rounds(c, neg), DeepEquals, []int{16, 32, 32, 32, 32, 32, 32, 32, 32})
+	c.Assert(n.sent, Equals, 288)
+}
+
+func (s *NegotiationSuite) TestDrainNegotiator(c *C) {
+	haves, err := DrainNegotiator(&countNegotiator{n: 42})
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 42)
+}
@@ -2,14 +2,220 @@ package common
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"sync"
+	"time"
 
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
+// ErrServerClosed is returned by ConnServer.Serve after a call to Shutdown or
+// Close.
+var ErrServerClosed = errors.New("server closed")
+
+// ConnServer accepts the connections of the servers of the git and ssh
+// transports, and tracks them and their listeners, to limit them and to shut
+// the server down. Zero values of this type are not safe to use, see
+// NewConnServer.
+type ConnServer struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	done      chan struct{}
+}
+
+// NewConnServer returns a new ConnServer.
+func NewConnServer() *ConnServer {
+	return &ConnServer{
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Serve accepts the connections of l, serving each one with serve in a new
+// goroutine, the connection is closed once serve returns. The connections
+// accepted beyond maxConns being served are closed, and a connection is
+// closed after timeout without reading or writing, zero meaning no limit.
+//
+// The listener is closed when Serve returns, it always returns a non-nil
+// error, ErrServerClosed after a call to Shutdown or Close.
+func (s *ConnServer) Serve(
+	l net.Listener, maxConns int, timeout time.Duration, serve func(net.Conn),
+) error {
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+
+	defer s.trackListener(l, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		if !s.trackConn(conn, true, maxConns) {
+			conn.Close()
+			continue
+		}
+
+		go s.serveConn(conn, timeout, serve)
+	}
+}
+
+func (s *ConnServer) serveConn(conn net.Conn, timeout time.Duration, serve func(net.Conn)) {
+	defer s.trackConn(conn, false, 0)
+	defer conn.Close()
+
+	if timeout > 0 {
+		serve(&timeoutConn{conn, timeout})
+		return
+	}
+
+	serve(conn)
+}
+
+// Shutdown stops accepting connections and waits for the ones being served
+// to end, until the context is done, then the remaining ones are closed and
+// the error of the context is returned.
+func (s *ConnServer) Shutdown(ctx context.Context) error {
+	s.close(false)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if s.ActiveConns() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.close(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops accepting connections and closes the ones being served.
+func (s *ConnServer) Close() {
+	s.close(true)
+}
+
+func (s *ConnServer) close(conns bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+
+	for l := range s.listeners {
+		l.Close()
+		delete(s.listeners, l)
+	}
+
+	if !conns {
+		return
+	}
+
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+// ActiveConns returns the number of connections being served.
+func (s *ConnServer) ActiveConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *ConnServer) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// trackConn adds or removes a connection being served, a connection is not
+// added if the server is closed or serving the maximum number of connections.
+func (s *ConnServer) trackConn(c net.Conn, add bool, maxConns int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+
+	if s.closed || maxConns > 0 && len(s.conns) >= maxConns {
+		return false
+	}
+
+	s.conns[c] = struct{}{}
+	return true
+}
+
+// timeoutConn is a net.Conn closed by a timeout after a given duration
+// without reading or writing.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(p)
+}
+
 // ServerCommand is used for a single server command execution.
 type ServerCommand struct {
 	Stderr io.Writer
@@ -25,6 +231,17 @@ func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err erro
 		return err
 	}
 
+	// as git-upload-pack does, nothing is advertised by an empty repository,
+	// then the flush-pkt of the client, requesting nothing, is awaited
+	if ar.Head == nil && len(ar.References) == 0 {
+		if err := pktline.NewEncoder(cmd.Stdout).Flush(); err != nil {
+			return err
+		}
+
+		_ = pktline.NewScanner(cmd.Stdin).Scan()
+		return nil
+	}
+
 	if err := ar.Encode(cmd.Stdout); err != nil {
 		return err
 	}
@@ -34,13 +251,95 @@ func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err erro
 		return err
 	}
 
+	// the shallow-update is sent before the negotiation
+	su, isShallowUpdater := s.(shallowUpdater)
+	if req.IsShallow() && isShallowUpdater {
+		shallow, err := su.ShallowUpdate(req)
+		if err != nil {
+			return err
+		}
+
+		if err := shallow.Encode(cmd.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if err := receiveHaves(cmd, s, req); err != nil {
+		return err
+	}
+
 	var resp *packp.UploadPackResponse
 	resp, err = s.UploadPack(context.TODO(), req)
 	if err != nil {
 		return err
 	}
 
-	return resp.Encode(cmd.Stdout)
+	if !req.IsShallow() || !isShallowUpdater {
+		return resp.Encode(cmd.Stdout)
+	}
+
+	defer ioutil.CheckClose(resp, &err)
+	if err := resp.ServerResponse.Encode(cmd.Stdout); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(cmd.Stdout, resp)
+	return err
+}
+
+// shallowUpdater is implemented by the sessions sending the shallow-update of
+// a request before the negotiation, as git-upload-pack does.
+type shallowUpdater interface {
+	ShallowUpdate(req *packp.UploadPackRequest) (*packp.ShallowUpdate, error)
+}
+
+// havesAcknowledger is implemented by the sessions acknowledging the haves of
+// each round of a negotiation in multi_ack or multi_ack_detailed mode.
+type havesAcknowledger interface {
+	AcknowledgeHaves(req *packp.UploadPackRequest, haves []plumbing.Hash) (*packp.NegotiationResponse, error)
+}
+
+// receiveHaves reads the rounds of haves sent by the client up to done. In
+// multi_ack or multi_ack_detailed mode, each round is answered with the
+// acknowledgments of the session, otherwise with a NAK, as a server without
+// multi_ack does until it finds a common object. With no-done, the haves end
+// with the round the session is ready in.
+func receiveHaves(cmd ServerCommand, s transport.UploadPackSession, req *packp.UploadPackRequest) error {
+	a, ok := s.(havesAcknowledger)
+	multiACK := ok && IsMultiACK(req)
+	for {
+		var round packp.UploadHaves
+		done, err := round.Decode(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+
+		req.Haves = append(req.Haves, round.Haves...)
+		if done {
+			return nil
+		}
+
+		if !multiACK {
+			if err := new(packp.ServerResponse).Encode(cmd.Stdout); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		res, err := a.AcknowledgeHaves(req, round.Haves)
+		if err != nil {
+			return err
+		}
+
+		if err := res.Encode(cmd.Stdout); err != nil {
+			return err
+		}
+
+		if res.Ready() && req.Capabilities.Supports(capability.NoDone) {
+			return nil
+		}
+	}
 }
 
 func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
@@ -58,9 +357,13 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 		return fmt.Errorf("error decoding: %s", err)
 	}
 
+	if req.Packfile != nil {
+		req.Packfile = newPackfileReader(req.Packfile)
+	}
+
 	rs, err := s.ReceivePack(context.TODO(), req)
-	if rs != nil {
-		if err := rs.Encode(cmd.Stdout); err != nil {
+	if rs != nil || err == nil {
+		if err := encodeReportStatus(cmd.Stdout, s, rs); err != nil {
 			return fmt.Errorf("error in encoding report status %s", err)
 		}
 	}
@@ -71,3 +374,70 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 
 	return nil
 }
+
+// reportStatusEncoder is implemented by the sessions encoding the report
+// status themselves, multiplexed with their messages in a sideband.
+type reportStatusEncoder interface {
+	EncodeReportStatus(w io.Writer, rs *packp.ReportStatus) error
+}
+
+func encodeReportStatus(w io.Writer, s transport.ReceivePackSession, rs *packp.ReportStatus) error {
+	if e, ok := s.(reportStatusEncoder); ok {
+		return e.EncodeReportStatus(w, rs)
+	}
+
+	if rs == nil {
+		return nil
+	}
+
+	return rs.Encode(w)
+}
+
+// packfileReader reads a packfile up to its checksum, without waiting for the
+// end of the underlying reader, since a client connected through a socket
+// keeps it open after sending the packfile, to receive the report status.
+type packfileReader struct {
+	r    io.Reader
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+	once sync.Once
+}
+
+func newPackfileReader(r io.Reader) *packfileReader {
+	pr, pw := io.Pipe()
+	return &packfileReader{r: r, pr: pr, pw: pw}
+}
+
+// Read reads the bytes of the packfile as they are scanned, the scan starts
+// with the first read, since no packfile is sent by a delete-only request.
+func (p *packfileReader) Read(b []byte) (int, error) {
+	p.once.Do(func() {
+		go func() {
+			_ = p.pw.CloseWithError(scanPackfile(io.TeeReader(p.r, p.pw)))
+		}()
+	})
+
+	return p.pr.Read(b)
+}
+
+func (p *packfileReader) Close() error {
+	return p.pr.Close()
+}
+
+// scanPackfile reads a whole packfile from r, without reading beyond it.
+func scanPackfile(r io.Reader) error {
+	s := packfile.NewScanner(r)
+	_, count, err := s.Header()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		if _, err := s.NextObjectHeader(); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.Checksum()
+	return err
+}
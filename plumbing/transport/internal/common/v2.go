@@ -0,0 +1,180 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// ProtocolVersionCommand expands the Command interface, enabling it to
+// request a version of the protocol to the server. It is called before
+// Start, the commands not implementing it always speak the version 0.
+type ProtocolVersionCommand interface {
+	// SetProtocolVersion requests the given version of the protocol. A
+	// server not accepting the request speaks the version 0, an error is
+	// returned only if the request could not be made.
+	SetProtocolVersion(transport.ProtocolVersion) error
+}
+
+var servicePrefix = []byte("# service=")
+
+// DecodeCapabilityAdvertisement reads the beginning of the response of the
+// server to detect the version of the protocol it speaks. For the version 2,
+// its capability advertisement is decoded and returned. Otherwise, the
+// returned reader yields the whole response, to decode the advertised-refs
+// message of the version 0 from it.
+func DecodeCapabilityAdvertisement(r io.Reader) (*packp.CapabilityAdvertisement, io.Reader, error) {
+	var buf bytes.Buffer
+	s := pktline.NewScanner(io.TeeReader(r, &buf))
+
+	var service bool
+	for {
+		start := buf.Len()
+		if !s.Scan() {
+			break
+		}
+
+		line := s.Bytes()
+		// smart HTTP servers may send the service name before the message,
+		// followed by a flush-pkt
+		if bytes.HasPrefix(line, servicePrefix) {
+			service = true
+			continue
+		}
+
+		if service && len(line) == 0 {
+			service = false
+			continue
+		}
+
+		if !packp.IsVersion2(line) {
+			break
+		}
+
+		version := buf.Bytes()[start:]
+		adv := packp.NewCapabilityAdvertisement()
+		if err := adv.Decode(io.MultiReader(bytes.NewReader(version), r)); err != nil {
+			return nil, nil, err
+		}
+
+		return adv, nil, nil
+	}
+
+	return nil, io.MultiReader(&buf, r), nil
+}
+
+// EncodeLsRefsRequest writes to w an ls-refs command, listing the references
+// starting with any of the given prefixes, or all of them if none is given.
+func EncodeLsRefsRequest(w io.Writer, adv *packp.CapabilityAdvertisement, prefixes []string) error {
+	if !adv.Capabilities.Supports(capability.LsRefs) {
+		return fmt.Errorf("server does not support the ls-refs command")
+	}
+
+	req := packp.NewLsRefsRequest()
+	req.RefPrefixes = prefixes
+	if adv.Capabilities.Supports(capability.Agent) {
+		if err := req.Capabilities.Set(capability.Agent, capability.DefaultAgent); err != nil {
+			return err
+		}
+	}
+
+	return req.Encode(w)
+}
+
+// DecodeLsRefsResponse decodes the response to an ls-refs command from r,
+// into the advertised-refs message equivalent to it. If no reference is
+// listed transport.ErrEmptyRemoteRepository is returned.
+func DecodeLsRefsResponse(r io.Reader, adv *packp.CapabilityAdvertisement) (*packp.AdvRefs, error) {
+	res := packp.NewLsRefsResponse()
+	if err := res.Decode(r); err != nil {
+		return nil, fmt.Errorf("error decoding ls-refs response: %s", err)
+	}
+
+	if len(res.References) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	ar, err := packp.NewAdvRefsFromLsRefs(adv, res)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.FilterUnsupportedCapabilities(ar.Capabilities)
+	return ar, nil
+}
+
+// EncodeFetchRequest writes to w the fetch command equivalent to the given
+// upload-pack request.
+func EncodeFetchRequest(w io.Writer, adv *packp.CapabilityAdvertisement, req *packp.UploadPackRequest) error {
+	return encodeFetchRequest(w, adv, packp.NewFetchRequestFromUploadPackRequest(req))
+}
+
+// EncodeFetchRound writes to w the fetch command of a round of negotiation of
+// the given upload-pack request, with the given haves instead of its own. The
+// server sends the packfile once ready or if done is true.
+func EncodeFetchRound(w io.Writer, adv *packp.CapabilityAdvertisement, req *packp.UploadPackRequest,
+	haves []plumbing.Hash, done bool) error {
+
+	fr := packp.NewFetchRequestFromUploadPackRequest(req)
+	fr.Haves = haves
+	fr.Done = done
+	return encodeFetchRequest(w, adv, fr)
+}
+
+func encodeFetchRequest(w io.Writer, adv *packp.CapabilityAdvertisement, fr *packp.FetchRequest) error {
+	if !adv.Capabilities.Supports(capability.Fetch) {
+		return fmt.Errorf("server does not support the fetch command")
+	}
+
+	if !adv.Capabilities.Supports(capability.Agent) {
+		fr.Capabilities.Delete(capability.Agent)
+	}
+
+	if err := fr.Encode(w); err != nil {
+		return fmt.Errorf("sending fetch command: %s", err)
+	}
+
+	return nil
+}
+
+// DecodeFetchResponse decodes the response to a fetch command from r into a
+// new packp.UploadPackResponse. The packfile is multiplexed unless the
+// request has the side-band-64k capability, as the response to the version
+// 0 of the protocol would be.
+func DecodeFetchResponse(r io.ReadCloser, req *packp.UploadPackRequest) (
+	*packp.UploadPackResponse, error,
+) {
+	fr := &packp.FetchResponse{}
+	if err := fr.Decode(r); err != nil {
+		return nil, fmt.Errorf("error decoding fetch response: %s", err)
+	}
+
+	if !fr.Packfile {
+		return nil, fmt.Errorf("error decoding fetch response: missing packfile")
+	}
+
+	return NewUploadPackResponseFromFetch(fr, r, req), nil
+}
+
+// NewUploadPackResponseFromFetch returns a new packp.UploadPackResponse from
+// a decoded response to a fetch command, with the packfile following it in r.
+func NewUploadPackResponseFromFetch(fr *packp.FetchResponse, r io.ReadCloser,
+	req *packp.UploadPackRequest) *packp.UploadPackResponse {
+
+	var pf io.Reader = r
+	if !req.Capabilities.Supports(capability.Sideband64k) {
+		pf = sideband.NewDemuxer(sideband.Sideband64k, r)
+	}
+
+	res := packp.NewUploadPackResponseWithPackfile(req, ioutil.NewReadCloser(pf, r))
+	res.ShallowUpdate = fr.ShallowUpdate
+	return res
+}
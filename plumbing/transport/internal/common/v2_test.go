@@ -0,0 +1,100 @@
+package common
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	. "gopkg.in/check.v1"
+)
+
+type V2Suite struct{}
+
+var _ = Suite(&V2Suite{})
+
+const v2Advertisement = "000eversion 2\n" +
+	"0013agent=git/2.39\n" +
+	"000cls-refs\n" +
+	"0012fetch=shallow\n" +
+	"0000"
+
+func (s *V2Suite) TestDecodeCapabilityAdvertisement(c *C) {
+	r := strings.NewReader(v2Advertisement + "rest")
+	adv, v0, err := DecodeCapabilityAdvertisement(r)
+	c.Assert(err, IsNil)
+	c.Assert(v0, IsNil)
+	c.Assert(adv.Capabilities.Supports(capability.LsRefs), Equals, true)
+	c.Assert(adv.SupportsFetchFeature("shallow"), Equals, true)
+
+	rest, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(rest), Equals, "rest")
+}
+
+func (s *V2Suite) TestDecodeCapabilityAdvertisementService(c *C) {
+	r := strings.NewReader("001e# service=git-upload-pack\n0000" + v2Advertisement)
+	adv, _, err := DecodeCapabilityAdvertisement(r)
+	c.Assert(err, IsNil)
+	c.Assert(adv, NotNil)
+	c.Assert(adv.Capabilities.Get(capability.Agent), DeepEquals, []string{"git/2.39"})
+}
+
+func (s *V2Suite) TestDecodeCapabilityAdvertisementVersion0(c *C) {
+	for _, raw := range []string{
+		"001e# service=git-upload-pack\n0000" +
+			"00516ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00ofs-delta agent=git/2.39\n0000",
+		"00516ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00ofs-delta agent=git/2.39\n0000",
+		"0000",
+		"",
+	} {
+		adv, r, err := DecodeCapabilityAdvertisement(strings.NewReader(raw))
+		c.Assert(err, IsNil)
+		c.Assert(adv, IsNil)
+
+		read, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(string(read), Equals, raw)
+	}
+}
+
+func (s *V2Suite) TestLsRefs(c *C) {
+	adv, _, err := DecodeCapabilityAdvertisement(strings.NewReader(v2Advertisement))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(EncodeLsRefsRequest(&buf, adv, []string{"refs/heads/"}), IsNil)
+	c.Assert(strings.Contains(buf.String(), "ref-prefix refs/heads/\n"), Equals, true)
+	c.Assert(strings.Contains(buf.String(), "agent="+capability.DefaultAgent), Equals, true)
+
+	ar, err := DecodeLsRefsResponse(strings.NewReader(
+		"003f6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n0000",
+	), adv)
+	c.Assert(err, IsNil)
+	c.Assert(ar.References, HasLen, 1)
+	c.Assert(ar.Capabilities.Supports(capability.Shallow), Equals, true)
+	c.Assert(ar.Capabilities.Supports(capability.ThinPack), Equals, true)
+
+	_, err = DecodeLsRefsResponse(strings.NewReader("0000"), adv)
+	c.Assert(err, Equals, transport.ErrEmptyRemoteRepository)
+}
+
+func (s *V2Suite) TestFetch(c *C) {
+	adv, _, err := DecodeCapabilityAdvertisement(strings.NewReader(v2Advertisement))
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequest()
+	c.Assert(EncodeFetchRequest(&bytes.Buffer{}, adv, req), NotNil)
+
+	res, err := DecodeFetchResponse(ioutil.NopCloser(strings.NewReader(
+		"000dpackfile\n"+"0009\x01PACK"+"000d\x02progress"+"0009\x01DATA"+"0000",
+	)), req)
+	c.Assert(err, IsNil)
+
+	pack, err := ioutil.ReadAll(res)
+	c.Assert(err, IsNil)
+	c.Assert(string(pack), Equals, "PACKDATA")
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// ErrReadOnlyStorer is returned when a hook tries to write an object through
+// the storer of its ReceiveRequest.
+var ErrReadOnlyStorer = errors.New("the storer of the hooks is read-only")
+
+// Hooks are the callbacks run by the git-receive-pack sessions of a server,
+// as the pre-receive, update and post-receive hooks of git. Any of them can be
+// nil.
+type Hooks struct {
+	// PreReceive is run once the objects of a push are received, before
+	// updating any reference. If it returns an error, the whole push is
+	// rejected.
+	PreReceive PreReceiveHook
+	// Update is run for each command of a push, before updating its
+	// reference. If it returns an error, the command is rejected.
+	Update UpdateHook
+	// PostReceive is run once the references are updated.
+	PostReceive PostReceiveHook
+}
+
+// ReceiveRequest is a push received by a git-receive-pack session, as seen by
+// its hooks.
+type ReceiveRequest struct {
+	// Storer is a read-only view of the objects and the references of the
+	// repository. In the pre-receive hook, the objects received are read from
+	// it while in quarantine, they are stored in the repository only if the
+	// push is accepted.
+	Storer ReceiveStorer
+	// Commands are the commands of the push, in the post-receive hook only
+	// the ones applied.
+	Commands []*packp.Command
+	// Options are the push options sent by the client, if any.
+	Options []string
+	// Messages are shown to the client as remote messages, as the standard
+	// error of the git hooks, if it requested a sideband.
+	Messages io.Writer
+}
+
+// ReceiveStorer is the read-only view of a repository given to the hooks: its
+// objects, including the ones in quarantine, and its references. Writing
+// objects fails with ErrReadOnlyStorer.
+type ReceiveStorer interface {
+	storer.EncodedObjectStorer
+	Reference(plumbing.ReferenceName) (*plumbing.Reference, error)
+	IterReferences() (storer.ReferenceIter, error)
+}
+
+// receiveStorer is a ReceiveStorer reading the objects from a storer, the
+// repository or a quarantine, and the references from the repository.
+type receiveStorer struct {
+	storer.EncodedObjectStorer
+	refs storer.ReferenceStorer
+}
+
+func newReceiveStorer(objects storer.EncodedObjectStorer, refs storer.ReferenceStorer) ReceiveStorer {
+	return &receiveStorer{EncodedObjectStorer: objects, refs: refs}
+}
+
+func (s *receiveStorer) SetEncodedObject(plumbing.EncodedObject) (plumbing.Hash, error) {
+	return plumbing.ZeroHash, ErrReadOnlyStorer
+}
+
+func (s *receiveStorer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	return s.refs.Reference(name)
+}
+
+func (s *receiveStorer) IterReferences() (storer.ReferenceIter, error) {
+	return s.refs.IterReferences()
+}
+
+// PreReceiveHook is the hook run once the objects of a push are received.
+type PreReceiveHook interface {
+	PreReceive(ctx context.Context, req *ReceiveRequest) error
+}
+
+// PreReceiveFunc is a function implementing PreReceiveHook.
+type PreReceiveFunc func(ctx context.Context, req *ReceiveRequest) error
+
+// PreReceive calls f(ctx, req).
+func (f PreReceiveFunc) PreReceive(ctx context.Context, req *ReceiveRequest) error {
+	return f(ctx, req)
+}
+
+// UpdateHook is the hook run for each command of a push.
+type UpdateHook interface {
+	Update(ctx context.Context, req *ReceiveRequest, cmd *packp.Command) error
+}
+
+// UpdateFunc is a function implementing UpdateHook.
+type UpdateFunc func(ctx context.Context, req *ReceiveRequest, cmd *packp.Command) error
+
+// Update calls f(ctx, req, cmd).
+func (f UpdateFunc) Update(ctx context.Context, req *ReceiveRequest, cmd *packp.Command) error {
+	return f(ctx, req, cmd)
+}
+
+// PostReceiveHook is the hook run once the references of a push are updated.
+// It can't reject anything, the push is already done.
+type PostReceiveHook interface {
+	PostReceive(ctx context.Context, req *ReceiveRequest)
+}
+
+// PostReceiveFunc is a function implementing PostReceiveHook.
+type PostReceiveFunc func(ctx context.Context, req *ReceiveRequest)
+
+// PostReceive calls f(ctx, req).
+func (f PostReceiveFunc) PostReceive(ctx context.Context, req *ReceiveRequest) {
+	f(ctx, req)
+}
+
+// quarantine keeps the objects of a received packfile apart from the ones of
+// the repository, until they are accepted, as the incoming directory of git
+// does. The packfile is written to a temporary directory, completed if it is
+// thin, and its objects are read from there before the ones of the
+// repository.
+type quarantine struct {
+	s    storer.Storer
+	dir  string
+	file billy.File
+	pack *packfile.Packfile
+	idx  idxfile.Index
+}
+
+// newQuarantine writes the packfile read from r to a temporary directory,
+// keeping its objects in quarantine from the repository s. The quarantine
+// must be closed once its objects are migrated or discarded.
+func newQuarantine(s storer.Storer, r io.Reader) (q *quarantine, err error) {
+	dir, err := ioutil.TempDir("", "go-git-incoming-")
+	if err != nil {
+		return nil, err
+	}
+
+	q = &quarantine{s: s, dir: dir}
+	defer func() {
+		if err != nil {
+			_ = q.Close()
+		}
+	}()
+
+	fs := osfs.New(dir)
+	if q.file, err = fs.Create("incoming.pack"); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(q.file, r); err != nil {
+		return nil, err
+	}
+
+	if _, err := q.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// the bases of a thin packfile are read from the repository and appended
+	// to it, to be migrated as a self-contained packfile
+	w := new(idxfile.Writer)
+	p, err := packfile.NewParserWithThinPackBases(packfile.NewScanner(q.file), s, w)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.Parse(); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.FixThinPack(q.file); err != nil {
+		return nil, err
+	}
+
+	if q.idx, err = w.Index(); err != nil {
+		return nil, err
+	}
+
+	q.pack = packfile.NewPackfile(q.idx, fs, q.file)
+	return q, nil
+}
+
+// migrate stores the objects in quarantine in the repository.
+func (q *quarantine) migrate() error {
+	if _, err := q.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return packfile.UpdateObjectStorage(q.s, q.file)
+}
+
+// Close removes the packfile of the quarantine.
+func (q *quarantine) Close() error {
+	if q.file != nil {
+		_ = q.file.Close()
+	}
+
+	return os.RemoveAll(q.dir)
+}
+
+func (q *quarantine) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+func (q *quarantine) SetEncodedObject(plumbing.EncodedObject) (plumbing.Hash, error) {
+	return plumbing.ZeroHash, ErrReadOnlyStorer
+}
+
+func (q *quarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	o, err := q.pack.Get(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.s.EncodedObject(t, h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t != plumbing.AnyObject && o.Type() != t {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return o, nil
+}
+
+func (q *quarantine) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	quarantined, err := q.pack.GetByType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := q.s.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{quarantined, stored}), nil
+}
+
+func (q *quarantine) HasEncodedObject(h plumbing.Hash) error {
+	ok, err := q.idx.Contains(h)
+	if err != nil || ok {
+		return err
+	}
+
+	return q.s.HasEncodedObject(h)
+}
+
+func (q *quarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	offset, err := q.idx.FindOffset(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.s.EncodedObjectSize(h)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return q.pack.GetSizeByOffset(offset)
+}
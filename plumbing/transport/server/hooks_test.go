@@ -0,0 +1,461 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type HooksSuite struct {
+	fixtures.Suite
+
+	storage *memory.Storage
+	storer  storer.Storer
+	ep      *transport.Endpoint
+	fixture *fixtures.Fixture
+}
+
+var _ = Suite(&HooksSuite{})
+
+func (s *HooksSuite) SetUpTest(c *C) {
+	var err error
+	s.ep, err = transport.NewEndpoint("/repo.git")
+	c.Assert(err, IsNil)
+
+	s.storage = memory.NewStorage()
+	s.storer = s.storage
+	s.fixture = fixtures.Basic().ByTag("packfile").One()
+}
+
+// newRequest returns a request pushing the packfile of the fixture, creating
+// master and branch.
+func (s *HooksSuite) newRequest(caps ...capability.Capability) *packp.ReferenceUpdateRequest {
+	req := packp.NewReferenceUpdateRequest()
+	req.Capabilities.Set(capability.ReportStatus)
+	for _, cap := range caps {
+		req.Capabilities.Set(cap)
+	}
+
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/master", Old: plumbing.ZeroHash, New: s.fixture.Head},
+		{Name: "refs/heads/branch", Old: plumbing.ZeroHash, New: s.fixture.Head},
+	}
+	req.Packfile = s.fixture.Packfile()
+
+	return req
+}
+
+// receivePack sends the request to a server running the given hooks.
+func (s *HooksSuite) receivePack(c *C, hooks *server.Hooks, req *packp.ReferenceUpdateRequest) (transport.ReceivePackSession, *packp.ReportStatus, error) {
+	srv := server.NewServerWithHooks(server.MapLoader{s.ep.String(): s.storer}, hooks)
+	r, err := srv.NewReceivePackSession(s.ep, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	rs, err := r.ReceivePack(context.Background(), req)
+	return r, rs, err
+}
+
+func (s *HooksSuite) commandStatus(c *C, rs *packp.ReportStatus, name string) string {
+	for _, cs := range rs.CommandStatuses {
+		if cs.ReferenceName.String() == name {
+			return cs.Status
+		}
+	}
+
+	c.Fatalf("no status for %s", name)
+	return ""
+}
+
+func (s *HooksSuite) TestPreReceiveReject(c *C) {
+	var author string
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			c.Assert(req.Commands, HasLen, 2)
+
+			// the objects in quarantine are readable
+			commit, err := object.GetCommit(req.Storer, req.Commands[0].New)
+			if err != nil {
+				return err
+			}
+
+			author = commit.Author.Email
+			return fmt.Errorf("commit %s is not signed\nsecond line", commit.Hash)
+		}),
+	}
+
+	_, rs, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, NotNil)
+	c.Assert(author, Equals, "mcuadros@gmail.com")
+	c.Assert(rs.UnpackStatus, Equals, "ok")
+
+	msg := fmt.Sprintf("commit %s is not signed", s.fixture.Head)
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, msg)
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, msg)
+
+	// nothing leaves the quarantine
+	c.Assert(s.storage.HasEncodedObject(s.fixture.Head), Equals, plumbing.ErrObjectNotFound)
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestPreReceiveAccept(c *C) {
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			_, err := object.GetCommit(req.Storer, req.Commands[0].New)
+			return err
+		}),
+	}
+
+	_, rs, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, IsNil)
+	c.Assert(rs.Error(), IsNil)
+
+	c.Assert(s.storage.HasEncodedObject(s.fixture.Head), IsNil)
+	ref, err := s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, s.fixture.Head)
+}
+
+func (s *HooksSuite) TestPreReceiveReadOnly(c *C) {
+	before := incomingDirs(c)
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			// the quarantine is on disk until the push is done
+			c.Assert(incomingDirs(c), Equals, before+1)
+
+			_, ok := req.Storer.(storer.ReferenceStorer)
+			c.Assert(ok, Equals, false)
+
+			_, err := req.Storer.Reference("refs/heads/master")
+			c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+			c.Assert(req.Storer.HasEncodedObject(req.Commands[0].New), IsNil)
+			o, err := req.Storer.EncodedObject(plumbing.AnyObject, req.Commands[0].New)
+			c.Assert(err, IsNil)
+
+			_, err = req.Storer.SetEncodedObject(o)
+			return err
+		}),
+	}
+
+	_, _, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, Equals, server.ErrReadOnlyStorer)
+	c.Assert(incomingDirs(c), Equals, before)
+	c.Assert(s.storage.HasEncodedObject(s.fixture.Head), Equals, plumbing.ErrObjectNotFound)
+}
+
+// incomingDirs returns the number of quarantine directories in the temporary
+// directory.
+func incomingDirs(c *C) int {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), "go-git-incoming-*"))
+	c.Assert(err, IsNil)
+	return len(dirs)
+}
+
+func (s *HooksSuite) TestUpdateAndPostReceive(c *C) {
+	var updated, applied []string
+	hooks := &server.Hooks{
+		Update: server.UpdateFunc(func(ctx context.Context, req *server.ReceiveRequest, cmd *packp.Command) error {
+			updated = append(updated, cmd.Name.String())
+			if cmd.Name == "refs/heads/branch" {
+				return errors.New("branch is protected")
+			}
+
+			return nil
+		}),
+		PostReceive: server.PostReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) {
+			for _, cmd := range req.Commands {
+				applied = append(applied, cmd.Name.String())
+			}
+		}),
+	}
+
+	_, rs, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, ErrorMatches, "branch is protected")
+	c.Assert(updated, DeepEquals, []string{"refs/heads/master", "refs/heads/branch"})
+	c.Assert(applied, DeepEquals, []string{"refs/heads/master"})
+
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "ok")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "branch is protected")
+
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	_, err = s.storage.Reference("refs/heads/branch")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestPostReceiveNothingApplied(c *C) {
+	called := false
+	hooks := &server.Hooks{
+		Update: server.UpdateFunc(func(context.Context, *server.ReceiveRequest, *packp.Command) error {
+			return errors.New("read-only repository")
+		}),
+		PostReceive: server.PostReceiveFunc(func(context.Context, *server.ReceiveRequest) {
+			called = true
+		}),
+	}
+
+	_, _, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, NotNil)
+	c.Assert(called, Equals, false)
+}
+
+func (s *HooksSuite) TestMessagesSideband(c *C) {
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			fmt.Fprintln(req.Messages, "checking the commits")
+			return errors.New("commits rejected")
+		}),
+	}
+
+	r, rs, err := s.receivePack(c, hooks, s.newRequest(capability.Sideband64k))
+	c.Assert(err, NotNil)
+
+	e, ok := r.(interface {
+		EncodeReportStatus(io.Writer, *packp.ReportStatus) error
+	})
+	c.Assert(ok, Equals, true)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(e.EncodeReportStatus(buf, rs), IsNil)
+
+	progress := bytes.NewBuffer(nil)
+	d := sideband.NewDemuxer(sideband.Sideband64k, buf)
+	d.Progress = progress
+
+	report := packp.NewReportStatus()
+	c.Assert(report.Decode(d), IsNil)
+	c.Assert(report.Error(), ErrorMatches, ".*commits rejected")
+	c.Assert(progress.String(), Equals, "checking the commits\ncommits rejected\n")
+}
+
+func (s *HooksSuite) TestMessagesWithoutSideband(c *C) {
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			fmt.Fprintln(req.Messages, "checking the commits")
+			return errors.New("commits rejected")
+		}),
+	}
+
+	r, rs, err := s.receivePack(c, hooks, s.newRequest())
+	c.Assert(err, NotNil)
+
+	e := r.(interface {
+		EncodeReportStatus(io.Writer, *packp.ReportStatus) error
+	})
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(e.EncodeReportStatus(buf, rs), IsNil)
+
+	report := packp.NewReportStatus()
+	c.Assert(report.Decode(buf), IsNil)
+	c.Assert(report.CommandStatuses, HasLen, 2)
+}
+
+func (s *HooksSuite) TestOptions(c *C) {
+	var pre, post []string
+	hooks := &server.Hooks{
+		PreReceive: server.PreReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) error {
+			pre = req.Options
+			return nil
+		}),
+		PostReceive: server.PostReceiveFunc(func(ctx context.Context, req *server.ReceiveRequest) {
+			post = req.Options
+		}),
+	}
+
+	req := s.newRequest(capability.PushOptions)
+	req.Options = []string{"ci.skip", "reviewer=foo"}
+
+	_, _, err := s.receivePack(c, hooks, req)
+	c.Assert(err, IsNil)
+	c.Assert(pre, DeepEquals, []string{"ci.skip", "reviewer=foo"})
+	c.Assert(post, DeepEquals, []string{"ci.skip", "reviewer=foo"})
+}
+
+func (s *HooksSuite) TestAtomicRejected(c *C) {
+	applied := false
+	hooks := &server.Hooks{
+		Update: server.UpdateFunc(func(ctx context.Context, req *server.ReceiveRequest, cmd *packp.Command) error {
+			if cmd.Name == "refs/heads/branch" {
+				return errors.New("branch is protected")
+			}
+
+			return nil
+		}),
+		PostReceive: server.PostReceiveFunc(func(context.Context, *server.ReceiveRequest) {
+			applied = true
+		}),
+	}
+
+	_, rs, err := s.receivePack(c, hooks, s.newRequest(capability.Atomic))
+	c.Assert(err, ErrorMatches, "branch is protected")
+	c.Assert(applied, Equals, false)
+
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "atomic push failure")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "branch is protected")
+
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestAtomicRollback(c *C) {
+	s.storer = &failingStorage{Storage: s.storage, name: "refs/heads/branch"}
+
+	_, rs, err := s.receivePack(c, nil, s.newRequest(capability.Atomic))
+	c.Assert(err, ErrorMatches, "reference locked")
+
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "atomic push failure")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "reference locked")
+
+	// master was created before branch failed
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestAtomicRollbackPrevious(c *C) {
+	previous := plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	c.Assert(s.storage.SetReference(previous), IsNil)
+	s.storer = &failingStorage{Storage: s.storage, name: "refs/heads/branch"}
+
+	// master is deleted whatever its value
+	req := s.newRequest(capability.Atomic)
+	req.Commands[0].New = plumbing.ZeroHash
+
+	_, rs, err := s.receivePack(c, nil, req)
+	c.Assert(err, ErrorMatches, "reference locked")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "atomic push failure")
+
+	ref, err := s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, previous)
+}
+
+func (s *HooksSuite) TestAtomicRollbackConcurrent(c *C) {
+	current := plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	s.storer = &failingStorage{
+		Storage: s.storage,
+		name:    "refs/heads/branch",
+		// master is updated by another push once it is applied
+		failed: func() { c.Assert(s.storage.SetReference(current), IsNil) },
+	}
+
+	_, rs, err := s.receivePack(c, nil, s.newRequest(capability.Atomic))
+	c.Assert(err, ErrorMatches, "reference locked")
+
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "failed to revert ref: failed to lock")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "reference locked")
+
+	ref, err := s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, current)
+}
+
+func (s *HooksSuite) TestStaleReference(c *C) {
+	current := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference("refs/heads/master", current)), IsNil)
+
+	req := s.newRequest()
+	req.Commands[0].Old = plumbing.NewHash("af2d6a6954d532f8ffb47615169c8fdf9d383a1a")
+
+	_, rs, err := s.receivePack(c, nil, req)
+	c.Assert(err, Equals, server.ErrStaleReference)
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "failed to lock")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "ok")
+
+	ref, err := s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, current)
+}
+
+func (s *HooksSuite) TestStaleReferenceConcurrent(c *C) {
+	current := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	c.Assert(s.storage.SetReference(plumbing.NewHashReference("refs/heads/master", current)), IsNil)
+
+	// master is updated by another push once the command is checked
+	hooks := &server.Hooks{
+		Update: server.UpdateFunc(func(ctx context.Context, req *server.ReceiveRequest, cmd *packp.Command) error {
+			if cmd.Name != "refs/heads/master" {
+				return nil
+			}
+
+			return s.storage.SetReference(plumbing.NewHashReference(cmd.Name, s.fixture.Head))
+		}),
+	}
+
+	req := s.newRequest(capability.Atomic)
+	req.Commands[0].Old = current
+
+	_, rs, err := s.receivePack(c, hooks, req)
+	c.Assert(err, Equals, server.ErrStaleReference)
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "failed to lock")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "atomic push failure")
+
+	_, err = s.storage.Reference("refs/heads/branch")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HooksSuite) TestNotAtomic(c *C) {
+	s.storer = &failingStorage{Storage: s.storage, name: "refs/heads/branch"}
+
+	_, rs, err := s.receivePack(c, nil, s.newRequest())
+	c.Assert(err, ErrorMatches, "reference locked")
+
+	c.Assert(s.commandStatus(c, rs, "refs/heads/master"), Equals, "ok")
+	c.Assert(s.commandStatus(c, rs, "refs/heads/branch"), Equals, "reference locked")
+
+	_, err = s.storage.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+}
+
+// failingStorage is a storage failing to set the reference with the given
+// name, calling failed, if any, before failing.
+type failingStorage struct {
+	*memory.Storage
+	name   plumbing.ReferenceName
+	failed func()
+}
+
+func (s *failingStorage) fail() error {
+	if s.failed != nil {
+		s.failed()
+	}
+
+	return errors.New("reference locked")
+}
+
+func (s *failingStorage) SetReference(ref *plumbing.Reference) error {
+	if ref.Name() == s.name {
+		return s.fail()
+	}
+
+	return s.Storage.SetReference(ref)
+}
+
+func (s *failingStorage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	if ref.Name() == s.name {
+		return s.fail()
+	}
+
+	return s.Storage.CheckAndSetReference(ref, old)
+}
@@ -3,18 +3,26 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
 	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 )
 
@@ -28,9 +36,15 @@ type server struct {
 // NewServer returns a transport.Transport implementing a git server,
 // independent of transport. Each transport must wrap this.
 func NewServer(loader Loader) transport.Transport {
+	return NewServerWithHooks(loader, nil)
+}
+
+// NewServerWithHooks returns a transport.Transport implementing a git server,
+// as NewServer, running the given hooks in its git-receive-pack sessions.
+func NewServerWithHooks(loader Loader, hooks *Hooks) transport.Transport {
 	return &server{
 		loader,
-		&handler{asClient: false},
+		&handler{asClient: false, hooks: hooks},
 	}
 }
 
@@ -63,6 +77,7 @@ func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.Au
 
 type handler struct {
 	asClient bool
+	hooks    *Hooks
 }
 
 func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSession, error) {
@@ -72,10 +87,16 @@ func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSes
 }
 
 func (h *handler) NewReceivePackSession(s storer.Storer) (transport.ReceivePackSession, error) {
-	return &rpSession{
+	rs := &rpSession{
 		session:   session{storer: s, asClient: h.asClient},
 		cmdStatus: map[plumbing.ReferenceName]error{},
-	}, nil
+	}
+
+	if h.hooks != nil {
+		rs.hooks = *h.hooks
+	}
+
+	return rs, nil
 }
 
 type session struct {
@@ -105,6 +126,21 @@ func (s *session) checkSupportedCapabilities(cl *capability.List) error {
 
 type upSession struct {
 	session
+	// shallow is the shallow-update of a shallow request.
+	shallow *packp.ShallowUpdate
+	// boundary is the history requested by a shallow request.
+	boundary *shallowBoundary
+	// common are the haves found in the repository during a negotiation.
+	common map[plumbing.Hash]bool
+	// readyCommon is the number of common haves when ready was computed.
+	readyCommon int
+	ready       bool
+	// reach holds, for the commits explored by isReady, whether they have a
+	// common ancestor. The history of a commit without one was explored.
+	reach map[plumbing.Hash]bool
+	// children are the explored children of the explored commits, the ones
+	// to update when a commit gets a common ancestor.
+	children map[plumbing.Hash][]plumbing.Hash
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -132,10 +168,19 @@ func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 }
 
 func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
-	if req.IsEmpty() {
+	// a shallow request may deepen the history of commits the client has
+	if req.IsEmpty() && !req.IsShallow() {
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
 
+	// git does not request the shallow capability, it is implied by the
+	// shallow commits and the depth of a request
+	if req.IsShallow() && !req.Capabilities.Supports(capability.Shallow) {
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := req.Validate(); err != nil {
 		return nil, err
 	}
@@ -153,30 +198,336 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 
 	s.caps = req.Capabilities
 
-	if len(req.Shallows) > 0 {
-		return nil, fmt.Errorf("shallow not supported")
+	common, err := s.commonHaves(req.Haves)
+	if err != nil {
+		return nil, err
+	}
+
+	var shallow *packp.ShallowUpdate
+	if req.IsShallow() {
+		if shallow, err = s.ShallowUpdate(req); err != nil {
+			return nil, err
+		}
 	}
 
-	objs, err := s.objectsToUpload(req)
+	objs, err := s.objectsToUpload(req, common)
 	if err != nil {
 		return nil, err
 	}
 
+	if req.Capabilities.Supports(capability.IncludeTag) {
+		if objs, err = s.includeTags(objs, common); err != nil {
+			return nil, err
+		}
+	}
+
 	pr, pw := io.Pipe()
-	e := packfile.NewEncoder(pw, s.storer, false)
 	go func() {
-		// TODO: plumb through a pack window.
-		_, err := e.Encode(objs, 10)
-		pw.CloseWithError(err)
+		pw.CloseWithError(s.encodePackfile(pw, objs))
 	}()
 
-	return packp.NewUploadPackResponseWithPackfile(req,
+	resp := packp.NewUploadPackResponseWithPackfile(req,
 		ioutil.NewContextReadCloser(ctx, pr),
-	), nil
+	)
+
+	if shallow != nil {
+		resp.ShallowUpdate = *shallow
+	}
+
+	// the last common have is acknowledged after done in multi_ack mode
+	if isMultiACK(req.Capabilities) && len(common) > 0 {
+		resp.ACKs = []plumbing.Hash{common[len(common)-1]}
+	}
+
+	return resp, nil
 }
 
-func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, error) {
-	haves, err := revlist.Objects(s.storer, req.Haves, nil)
+// ShallowUpdate returns the shallow-update of a request with shallow commits
+// or a depth, sent before the negotiation. It is also set in the response of
+// UploadPack.
+func (s *upSession) ShallowUpdate(req *packp.UploadPackRequest) (*packp.ShallowUpdate, error) {
+	if s.shallow != nil {
+		return s.shallow, nil
+	}
+
+	b, err := deepen(s.storer, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.boundary = b
+	s.shallow = shallowUpdate(b, req.Shallows)
+	return s.shallow, nil
+}
+
+// AcknowledgeHaves returns the acknowledgments of a round of haves, ended by
+// a flush-pkt, in multi_ack or multi_ack_detailed mode. The session is ready
+// to send the packfile once every want has a common ancestor, as
+// git-upload-pack does.
+func (s *upSession) AcknowledgeHaves(req *packp.UploadPackRequest, haves []plumbing.Hash) (*packp.NegotiationResponse, error) {
+	detailed := req.Capabilities.Supports(capability.MultiACKDetailed)
+	commonStatus, readyStatus := packp.ACKContinue, packp.ACKContinue
+	if detailed {
+		commonStatus, readyStatus = packp.ACKCommon, packp.ACKReady
+	}
+
+	if s.common == nil {
+		s.common = make(map[plumbing.Hash]bool)
+	}
+
+	res := &packp.NegotiationResponse{}
+	var last plumbing.Hash
+	var gotOther bool
+	for _, h := range haves {
+		err := s.storer.HasEncodedObject(h)
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+
+		if err == nil {
+			s.addCommon(h)
+			last = h
+			res.Acknowledgments = append(res.Acknowledgments, packp.Acknowledgment{Hash: h, Status: commonStatus})
+			continue
+		}
+
+		gotOther = true
+		ready, err := s.isReady(req.Wants)
+		if err != nil {
+			return nil, err
+		}
+
+		if ready {
+			res.Acknowledgments = append(res.Acknowledgments, packp.Acknowledgment{Hash: h, Status: readyStatus})
+		}
+	}
+
+	if detailed && !last.IsZero() && !gotOther {
+		ready, err := s.isReady(req.Wants)
+		if err != nil {
+			return nil, err
+		}
+
+		if ready {
+			res.Acknowledgments = append(res.Acknowledgments, packp.Acknowledgment{Hash: last, Status: packp.ACKReady})
+		}
+	}
+
+	return res, nil
+}
+
+// isReady returns true if every commit wanted has a common ancestor, so no
+// more haves are needed.
+func (s *upSession) isReady(wants []plumbing.Hash) (bool, error) {
+	if len(s.common) == 0 {
+		return false, nil
+	}
+
+	if s.readyCommon == len(s.common) {
+		return s.ready, nil
+	}
+
+	commits, err := wantedCommits(s.storer, wants)
+	if err != nil {
+		return false, err
+	}
+
+	s.readyCommon = len(s.common)
+	s.ready = true
+	for _, c := range commits {
+		found, err := s.reachesCommon(c)
+		if err != nil {
+			return false, err
+		}
+
+		if !found {
+			s.ready = false
+			break
+		}
+	}
+
+	return s.ready, nil
+}
+
+// addCommon adds a common have, updating the explored commits it is an
+// ancestor of.
+func (s *upSession) addCommon(h plumbing.Hash) {
+	s.common[h] = true
+	if _, ok := s.reach[h]; ok {
+		s.markReach(h)
+	}
+}
+
+// reachesCommon returns true if the commit has a common ancestor. Only the
+// history not explored by a previous call is walked, so each commit is read
+// once during a negotiation, whatever the number of rounds.
+func (s *upSession) reachesCommon(c *object.Commit) (bool, error) {
+	if s.reach == nil {
+		s.reach = make(map[plumbing.Hash]bool)
+		s.children = make(map[plumbing.Hash][]plumbing.Hash)
+	}
+
+	if r, ok := s.reach[c.Hash]; ok {
+		return r, nil
+	}
+
+	s.reach[c.Hash] = false
+	pending := []*object.Commit{c}
+	var explored []*object.Commit
+	for len(pending) > 0 {
+		commit := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		explored = append(explored, commit)
+
+		// the history of a common commit does not matter
+		if s.common[commit.Hash] {
+			continue
+		}
+
+		for _, h := range commit.ParentHashes {
+			s.children[h] = append(s.children[h], commit.Hash)
+			if _, ok := s.reach[h]; ok {
+				continue
+			}
+
+			p, err := object.GetCommit(s.storer, h)
+			if err != nil {
+				return false, err
+			}
+
+			s.reach[h] = false
+			pending = append(pending, p)
+		}
+	}
+
+	for _, commit := range explored {
+		if s.common[commit.Hash] {
+			s.markReach(commit.Hash)
+			continue
+		}
+
+		for _, h := range commit.ParentHashes {
+			if s.reach[h] {
+				s.markReach(commit.Hash)
+				break
+			}
+		}
+	}
+
+	return s.reach[c.Hash], nil
+}
+
+// markReach records that the commit, and so all its explored descendants,
+// have a common ancestor.
+func (s *upSession) markReach(h plumbing.Hash) {
+	pending := []plumbing.Hash{h}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if s.reach[h] {
+			continue
+		}
+
+		s.reach[h] = true
+		pending = append(pending, s.children[h]...)
+	}
+}
+
+// includeTags adds to objs the annotated tags pointing to any of them, not
+// found in the haves.
+func (s *upSession) includeTags(objs, haves []plumbing.Hash) ([]plumbing.Hash, error) {
+	included := make(map[plumbing.Hash]bool)
+	for _, h := range objs {
+		included[h] = true
+	}
+
+	for _, h := range haves {
+		included[h] = true
+	}
+
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		// the tags of a chain of tags are added along with the last one
+		var chain []plumbing.Hash
+		for h := ref.Hash(); !included[h]; {
+			o, err := s.storer.EncodedObject(plumbing.AnyObject, h)
+			if err == plumbing.ErrObjectNotFound {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if o.Type() != plumbing.TagObject {
+				return nil
+			}
+
+			t, err := object.DecodeTag(s.storer, o)
+			if err != nil {
+				return err
+			}
+
+			chain = append(chain, h)
+			h = t.Target
+		}
+
+		for _, h := range chain {
+			included[h] = true
+			objs = append(objs, h)
+		}
+
+		return nil
+	})
+
+	return objs, err
+}
+
+// encodePackfile writes the packfile of the given objects to w, multiplexed
+// with the progress messages if a sideband capability was requested.
+func (s *upSession) encodePackfile(w io.Writer, objs []plumbing.Hash) error {
+	t, ok := sidebandType(s.caps)
+	if !ok {
+		// TODO: plumb through a pack window.
+		_, err := packfile.NewEncoder(w, s.storer, false).Encode(objs, 10)
+		return err
+	}
+
+	m := sideband.NewMuxer(t, w)
+	if !s.caps.Supports(capability.NoProgress) {
+		msg := fmt.Sprintf("Enumerating objects: %d, done.\n", len(objs))
+		if _, err := m.WriteChannel(sideband.ProgressMessage, []byte(msg)); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriterSize(m, pktline.MaxPayloadSize)
+	if _, err := packfile.NewEncoder(bw, s.storer, false).Encode(objs, 10); err != nil {
+		_, _ = m.WriteChannel(sideband.ErrorMessage, []byte(err.Error()))
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return pktline.NewEncoder(w).Flush()
+}
+
+func (s *upSession) objectsToUpload(req *packp.UploadPackRequest, common []plumbing.Hash) ([]plumbing.Hash, error) {
+	if req.IsShallow() {
+		return s.objectsToUploadWithBoundary(req, common)
+	}
+
+	haves, err := revlist.Objects(s.storer, common, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -184,6 +535,63 @@ func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Ha
 	return revlist.Objects(s.storer, req.Wants, haves)
 }
 
+// objectsToUploadWithBoundary returns the objects to upload to a shallow
+// client, the history of its haves ends at its shallow commits, and the one of
+// its wants at the shallow commits after the update.
+func (s *upSession) objectsToUploadWithBoundary(req *packp.UploadPackRequest, common []plumbing.Hash) ([]plumbing.Hash, error) {
+	clientShallows := make(map[plumbing.Hash]bool)
+	for _, h := range req.Shallows {
+		clientShallows[h] = true
+	}
+
+	boundary := make(map[plumbing.Hash]bool)
+	for h := range clientShallows {
+		boundary[h] = true
+	}
+
+	// the parents of the unshallowed commits are wanted, as git-upload-pack
+	// does, since the client may already have the commits
+	wants := append([]plumbing.Hash(nil), req.Wants...)
+	for _, h := range s.shallow.Unshallows {
+		delete(boundary, h)
+
+		c, err := object.GetCommit(s.storer, h)
+		if err != nil {
+			return nil, err
+		}
+
+		wants = append(wants, c.ParentHashes...)
+	}
+
+	if s.boundary != nil {
+		for _, h := range s.boundary.shallows {
+			boundary[h] = true
+		}
+	}
+
+	return objectsWithBoundary(s.storer, wants, common, boundary, clientShallows)
+}
+
+// commonHaves returns the haves found in the repository, the other ones are
+// ignored.
+func (s *upSession) commonHaves(haves []plumbing.Hash) ([]plumbing.Hash, error) {
+	var common []plumbing.Hash
+	for _, h := range haves {
+		err := s.storer.HasEncodedObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		common = append(common, h)
+	}
+
+	return common, nil
+}
+
 func (*upSession) setSupportedCapabilities(c *capability.List) error {
 	if err := c.Set(capability.Agent, capability.DefaultAgent); err != nil {
 		return err
@@ -193,14 +601,38 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
+	if err := c.Set(capability.Sideband); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.Sideband64k); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.NoProgress); err != nil {
+		return err
+	}
+
+	for _, cap := range []capability.Capability{
+		capability.MultiACK, capability.MultiACKDetailed, capability.NoDone,
+		capability.Shallow, capability.DeepenSince, capability.DeepenNot,
+		capability.DeepenRelative, capability.IncludeTag,
+	} {
+		if err := c.Set(cap); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 type rpSession struct {
 	session
+	hooks     Hooks
 	cmdStatus map[plumbing.ReferenceName]error
 	firstErr  error
 	unpackErr error
+	messages  bytes.Buffer
 }
 
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -225,6 +657,12 @@ func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 
 var (
 	ErrUpdateReference = errors.New("failed to update ref")
+	// ErrStaleReference is the status of a command whose old value is not the
+	// current value of the reference, updated since the client read it.
+	ErrStaleReference = errors.New("failed to lock")
+	// ErrAtomicPushFailure is the status of the commands of an atomic push not
+	// applied because another one failed.
+	ErrAtomicPushFailure = errors.New("atomic push failure")
 )
 
 func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
@@ -241,63 +679,314 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	s.caps = req.Capabilities
 
-	//TODO: Implement 'atomic' update of references.
+	if s.asClient && req.Progress != nil {
+		defer func() { _, _ = io.Copy(req.Progress, &s.messages) }()
+	}
 
-	r := ioutil.NewContextReadCloser(ctx, req.Packfile)
-	if err := s.writePackfile(r); err != nil {
-		s.unpackErr = err
-		s.firstErr = err
-		return s.reportStatus(), err
+	rr := &ReceiveRequest{
+		Storer:   newReceiveStorer(s.storer, s.storer),
+		Commands: req.Commands,
+		Options:  req.Options,
+		Messages: &s.messages,
 	}
 
-	s.updateReferences(req)
+	// no packfile is sent when every command is a delete
+	var q *quarantine
+	if !isDeleteOnly(req.Commands) {
+		r := ioutil.NewContextReadCloser(ctx, req.Packfile)
+		var err error
+		if q, err = s.receivePackfile(r); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+	}
+
+	if q != nil {
+		defer q.Close()
+		rr.Storer = newReceiveStorer(q, s.storer)
+	}
+
+	if err := s.preReceive(ctx, rr, q); err != nil {
+		for _, cmd := range req.Commands {
+			s.setStatus(cmd.Name, err)
+		}
+
+		return s.reportStatus(), s.firstErr
+	}
+
+	s.updateReferences(ctx, req, rr)
+	s.postReceive(ctx, req, rr)
 	return s.reportStatus(), s.firstErr
 }
 
-func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
+// receivePackfile stores the objects of the packfile in the repository, or in
+// quarantine, returned, if there is a pre-receive hook to accept them.
+func (s *rpSession) receivePackfile(r io.ReadCloser) (*quarantine, error) {
+	if s.hooks.PreReceive == nil {
+		return nil, s.writePackfile(r)
+	}
+
+	if r == nil {
+		return nil, nil
+	}
+
+	q, err := newQuarantine(s.storer, r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return q, r.Close()
+}
+
+// preReceive runs the pre-receive hook, if any, then the objects in
+// quarantine, if any, are stored in the repository, if accepted.
+func (s *rpSession) preReceive(ctx context.Context, rr *ReceiveRequest, q *quarantine) error {
+	if s.hooks.PreReceive == nil {
+		return nil
+	}
+
+	if err := s.hooks.PreReceive.PreReceive(ctx, rr); err != nil {
+		s.writeMessage(err)
+		return err
+	}
+
+	if q == nil {
+		return nil
+	}
+
+	rr.Storer = newReceiveStorer(s.storer, s.storer)
+	if err := q.migrate(); err != nil {
+		s.unpackErr = err
+		return err
+	}
+
+	return nil
+}
+
+// postReceive runs the post-receive hook, if any, with the commands applied.
+func (s *rpSession) postReceive(ctx context.Context, req *packp.ReferenceUpdateRequest, rr *ReceiveRequest) {
+	if s.hooks.PostReceive == nil {
+		return
+	}
+
+	var applied []*packp.Command
 	for _, cmd := range req.Commands {
-		exists, err := referenceExists(s.storer, cmd.Name)
-		if err != nil {
+		if s.cmdStatus[cmd.Name] == nil {
+			applied = append(applied, cmd)
+		}
+	}
+
+	if len(applied) == 0 {
+		return
+	}
+
+	s.hooks.PostReceive.PostReceive(ctx, &ReceiveRequest{
+		Storer:   newReceiveStorer(s.storer, s.storer),
+		Commands: applied,
+		Options:  rr.Options,
+		Messages: &s.messages,
+	})
+}
+
+// writeMessage writes the error of a hook to the messages for the client.
+func (s *rpSession) writeMessage(err error) {
+	s.messages.WriteString(strings.TrimSuffix(err.Error(), "\n") + "\n")
+}
+
+// updateReferences applies the commands accepted, all of them or none if the
+// atomic capability was requested.
+func (s *rpSession) updateReferences(ctx context.Context, req *packp.ReferenceUpdateRequest, rr *ReceiveRequest) {
+	var accepted []*packp.Command
+	for _, cmd := range req.Commands {
+		if err := s.checkCommand(ctx, rr, cmd); err != nil {
 			s.setStatus(cmd.Name, err)
 			continue
 		}
 
-		switch cmd.Action() {
-		case packp.Create:
-			if exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+		accepted = append(accepted, cmd)
+	}
 
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
+	if !s.caps.Supports(capability.Atomic) {
+		for _, cmd := range accepted {
+			_, err := s.applyCommand(cmd)
 			s.setStatus(cmd.Name, err)
-		case packp.Delete:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+		}
 
-			err := s.storer.RemoveReference(cmd.Name)
-			s.setStatus(cmd.Name, err)
-		case packp.Update:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
-			}
+		return
+	}
 
-			if err != nil {
-				s.setStatus(cmd.Name, err)
-				continue
+	if len(accepted) != len(req.Commands) {
+		for _, cmd := range accepted {
+			s.setStatus(cmd.Name, ErrAtomicPushFailure)
+		}
+
+		return
+	}
+
+	s.applyAtomic(accepted)
+}
+
+// checkCommand returns an error if the command can't be applied to the
+// current references, or if the update hook rejects it.
+func (s *rpSession) checkCommand(ctx context.Context, rr *ReceiveRequest, cmd *packp.Command) error {
+	if err := s.checkReference(cmd); err != nil {
+		return err
+	}
+
+	if s.hooks.Update == nil {
+		return nil
+	}
+
+	if err := s.hooks.Update.Update(ctx, rr, cmd); err != nil {
+		s.writeMessage(err)
+		return err
+	}
+
+	return nil
+}
+
+// checkReference returns an error if the command can't be applied to the
+// current value of the reference.
+func (s *rpSession) checkReference(cmd *packp.Command) error {
+	ref, err := currentReference(s.storer, cmd.Name)
+	if err != nil {
+		return err
+	}
+
+	switch cmd.Action() {
+	case packp.Create:
+		if ref != nil {
+			return ErrUpdateReference
+		}
+	case packp.Delete, packp.Update:
+		if ref == nil {
+			return ErrUpdateReference
+		}
+
+		if isStale(ref, cmd) {
+			return ErrStaleReference
+		}
+	}
+
+	return nil
+}
+
+// atomicMu serializes the atomic pushes, so every command of a push is
+// checked and applied with no other atomic push in between.
+var atomicMu sync.Mutex
+
+// applyAtomic applies the commands as a transaction. Every command is checked
+// before any of them is applied, and if any of them fails to be applied the
+// ones already applied are reverted.
+func (s *rpSession) applyAtomic(cmds []*packp.Command) {
+	atomicMu.Lock()
+	defer atomicMu.Unlock()
+
+	for _, cmd := range cmds {
+		if err := s.checkReference(cmd); err != nil {
+			s.setAtomicStatus(cmds, cmd, err)
+			return
+		}
+	}
+
+	previous := make([]*plumbing.Reference, len(cmds))
+	for i, cmd := range cmds {
+		var err error
+		previous[i], err = s.applyCommand(cmd)
+		if err == nil {
+			continue
+		}
+
+		s.setAtomicStatus(cmds, cmd, err)
+		for j := i - 1; j >= 0; j-- {
+			if err := s.revertCommand(cmds[j], previous[j]); err != nil {
+				s.setStatus(cmds[j].Name, fmt.Errorf("failed to revert ref: %s", err))
 			}
+		}
 
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
-			s.setStatus(cmd.Name, err)
+		return
+	}
+
+	for _, cmd := range cmds {
+		s.setStatus(cmd.Name, nil)
+	}
+}
+
+// setAtomicStatus sets the error of the failed command, and the atomic push
+// failure to the other ones.
+func (s *rpSession) setAtomicStatus(cmds []*packp.Command, failed *packp.Command, err error) {
+	s.setStatus(failed.Name, err)
+	for _, cmd := range cmds {
+		if cmd != failed {
+			s.setStatus(cmd.Name, ErrAtomicPushFailure)
 		}
 	}
 }
 
+// applyCommand applies the command if the reference still has the old value
+// of the command. The reference replaced is returned, nil if it didn't exist.
+func (s *rpSession) applyCommand(cmd *packp.Command) (*plumbing.Reference, error) {
+	ref, err := currentReference(s.storer, cmd.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref != nil && isStale(ref, cmd) {
+		return nil, ErrStaleReference
+	}
+
+	if cmd.Action() == packp.Delete {
+		return ref, s.storer.RemoveReference(cmd.Name)
+	}
+
+	var old *plumbing.Reference
+	if !cmd.Old.IsZero() {
+		old = plumbing.NewHashReference(cmd.Name, cmd.Old)
+	}
+
+	err = s.storer.CheckAndSetReference(plumbing.NewHashReference(cmd.Name, cmd.New), old)
+	if err == storage.ErrReferenceHasChanged {
+		return nil, ErrStaleReference
+	}
+
+	return ref, err
+}
+
+// revertCommand restores the reference replaced when the command was applied,
+// or removes it if it was created, only if the reference still has the value
+// written by the command.
+func (s *rpSession) revertCommand(cmd *packp.Command, previous *plumbing.Reference) error {
+	ref, err := currentReference(s.storer, cmd.Name)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Action() == packp.Delete {
+		if ref != nil {
+			return ErrStaleReference
+		}
+
+		return s.storer.SetReference(previous)
+	}
+
+	if ref == nil || ref.Hash() != cmd.New {
+		return ErrStaleReference
+	}
+
+	if previous == nil {
+		return s.storer.RemoveReference(cmd.Name)
+	}
+
+	err = s.storer.CheckAndSetReference(previous, ref)
+	if err == storage.ErrReferenceHasChanged {
+		return ErrStaleReference
+	}
+
+	return err
+}
+
 func (s *rpSession) writePackfile(r io.ReadCloser) error {
 	if r == nil {
 		return nil
@@ -337,7 +1026,8 @@ func (s *rpSession) reportStatus() *packp.ReportStatus {
 	for ref, err := range s.cmdStatus {
 		msg := "ok"
 		if err != nil {
-			msg = err.Error()
+			// the reason of a rejection takes a single line
+			msg = strings.SplitN(err.Error(), "\n", 2)[0]
 		}
 		status := &packp.CommandStatus{
 			ReferenceName: ref,
@@ -362,9 +1052,73 @@ func (*rpSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
+	if err := c.Set(capability.Sideband64k); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.Atomic); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.PushOptions); err != nil {
+		return err
+	}
+
 	return c.Set(capability.ReportStatus)
 }
 
+// EncodeReportStatus writes the report status rs to w, multiplexed with the
+// messages of the hooks if a sideband was requested, otherwise the messages
+// are dropped. rs is nil if the report status was not requested.
+func (s *rpSession) EncodeReportStatus(w io.Writer, rs *packp.ReportStatus) error {
+	t, ok := sidebandType(s.caps)
+	if !ok {
+		if rs == nil {
+			return nil
+		}
+
+		return rs.Encode(w)
+	}
+
+	m := sideband.NewMuxer(t, w)
+	if s.messages.Len() > 0 {
+		if _, err := m.WriteChannel(sideband.ProgressMessage, s.messages.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if rs != nil {
+		buf := bytes.NewBuffer(nil)
+		if err := rs.Encode(buf); err != nil {
+			return err
+		}
+
+		if _, err := m.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return pktline.NewEncoder(w).Flush()
+}
+
+func isMultiACK(caps *capability.List) bool {
+	return caps.Supports(capability.MultiACK) ||
+		caps.Supports(capability.MultiACKDetailed)
+}
+
+// sidebandType returns the type of the sideband requested in the given
+// capabilities, if any.
+func sidebandType(caps *capability.List) (sideband.Type, bool) {
+	switch {
+	case caps.Supports(capability.Sideband64k):
+		return sideband.Sideband64k, true
+	case caps.Supports(capability.Sideband):
+		return sideband.Sideband, true
+	default:
+		return 0, false
+	}
+}
+
 func setHEAD(s storer.Storer, ar *packp.AdvRefs) error {
 	ref, err := s.Reference(plumbing.HEAD)
 	if err == plumbing.ErrReferenceNotFound {
@@ -417,11 +1171,30 @@ func setReferences(s storer.Storer, ar *packp.AdvRefs) error {
 	})
 }
 
-func referenceExists(s storer.ReferenceStorer, n plumbing.ReferenceName) (bool, error) {
-	_, err := s.Reference(n)
+func isDeleteOnly(cmds []*packp.Command) bool {
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			return false
+		}
+	}
+
+	return true
+}
+
+// currentReference returns the reference with the given name, or nil if it
+// doesn't exist.
+func currentReference(s storer.ReferenceStorer, n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.Reference(n)
 	if err == plumbing.ErrReferenceNotFound {
-		return false, nil
+		return nil, nil
 	}
 
-	return err == nil, err
+	return ref, err
+}
+
+// isStale returns true if the reference doesn't have the old value of the
+// command. A command without old value, deleting the reference whatever its
+// value, is never stale.
+func isStale(ref *plumbing.Reference, cmd *packp.Command) bool {
+	return !cmd.Old.IsZero() && ref.Hash() != cmd.Old
 }
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// shallowBoundary is the history sent for a shallow request: the commits
+// included and, among them, the shallow ones, whose parents are not sent.
+type shallowBoundary struct {
+	included map[plumbing.Hash]bool
+	shallows []plumbing.Hash
+}
+
+// deepen returns the history requested by the depth of req, nil if it does not
+// request any. With deepen-relative, the depth is counted from the shallow
+// commits of the client.
+func deepen(s storer.Storer, req *packp.UploadPackRequest) (*shallowBoundary, error) {
+	var (
+		commits packp.DepthCommits
+		since   time.Time
+		refs    []packp.DepthReference
+	)
+
+	depths := []packp.Depth{req.Depth}
+	if d, ok := req.Depth.(packp.Depths); ok {
+		depths = d
+	}
+
+	for _, d := range depths {
+		switch d := d.(type) {
+		case packp.DepthCommits:
+			commits = d
+		case packp.DepthSince:
+			since = time.Time(d)
+		case packp.DepthReference:
+			refs = append(refs, d)
+		}
+	}
+
+	if commits != 0 && (!since.IsZero() || len(refs) != 0) {
+		return nil, fmt.Errorf("deepen and deepen-since (or deepen-not) cannot be used together")
+	}
+
+	wants, err := wantedCommits(s, req.Wants)
+	if err != nil {
+		return nil, err
+	}
+
+	if commits != 0 && req.Capabilities.Supports(capability.DeepenRelative) {
+		shallows, err := reachableShallows(wants, req.Shallows)
+		if err != nil {
+			return nil, err
+		}
+
+		return deepenByCommits(shallows, int(commits)+1)
+	}
+
+	if commits != 0 {
+		return deepenByCommits(wants, int(commits))
+	}
+
+	if since.IsZero() && len(refs) == 0 {
+		return nil, nil
+	}
+
+	excluded := make(map[plumbing.Hash]bool)
+	for _, ref := range refs {
+		if err := excludeReference(s, ref, excluded); err != nil {
+			return nil, err
+		}
+	}
+
+	return deepenByRevList(wants, since, excluded)
+}
+
+// deepenByCommits returns the history of the given depth from the wants, as
+// the deepen command of git. The wants are at depth 1.
+func deepenByCommits(wants []*object.Commit, depth int) (*shallowBoundary, error) {
+	b := &shallowBoundary{included: make(map[plumbing.Hash]bool)}
+
+	level := wants
+	for d := 1; len(level) > 0; d++ {
+		var next []*object.Commit
+		for _, c := range level {
+			if b.included[c.Hash] {
+				continue
+			}
+
+			b.included[c.Hash] = true
+			if d >= depth {
+				if c.NumParents() > 0 {
+					b.shallows = append(b.shallows, c.Hash)
+				}
+
+				continue
+			}
+
+			err := c.Parents().ForEach(func(p *object.Commit) error {
+				next = append(next, p)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		level = next
+	}
+
+	return b, nil
+}
+
+// reachableShallows returns the given shallow commits reachable from the
+// wants, the depth of a deepen-relative request is counted from them.
+func reachableShallows(wants []*object.Commit, shallows []plumbing.Hash) ([]*object.Commit, error) {
+	isShallow := make(map[plumbing.Hash]bool)
+	for _, h := range shallows {
+		isShallow[h] = true
+	}
+
+	var result []*object.Commit
+	seen := make(map[plumbing.Hash]bool)
+	pending := append([]*object.Commit(nil), wants...)
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[c.Hash] {
+			continue
+		}
+
+		seen[c.Hash] = true
+		if isShallow[c.Hash] {
+			result = append(result, c)
+			continue
+		}
+
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			pending = append(pending, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// deepenByRevList returns the history from the wants not older than since, if
+// not zero, and not in excluded, as the deepen-since and deepen-not commands
+// of git. The wants are always included.
+func deepenByRevList(wants []*object.Commit, since time.Time, excluded map[plumbing.Hash]bool) (*shallowBoundary, error) {
+	b := &shallowBoundary{included: make(map[plumbing.Hash]bool)}
+
+	var order []*object.Commit
+	pending := append([]*object.Commit(nil), wants...)
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if b.included[c.Hash] {
+			continue
+		}
+
+		b.included[c.Hash] = true
+		order = append(order, c)
+
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			if excluded[p.Hash] || !since.IsZero() && p.Committer.When.Before(since) {
+				return nil
+			}
+
+			pending = append(pending, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range order {
+		for _, p := range c.ParentHashes {
+			if !b.included[p] {
+				b.shallows = append(b.shallows, c.Hash)
+				break
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// excludeReference adds to excluded the history of the reference with the
+// given name, completed as git does with a short name.
+func excludeReference(s storer.Storer, name packp.DepthReference, excluded map[plumbing.Hash]bool) error {
+	var ref *plumbing.Reference
+	for _, format := range []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s"} {
+		var err error
+		ref, err = storer.ResolveReference(s, plumbing.ReferenceName(fmt.Sprintf(format, name)))
+		if err == nil {
+			break
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+	}
+
+	if ref == nil {
+		return fmt.Errorf("deepen-not is not a ref: %s", name)
+	}
+
+	commits, err := wantedCommits(s, []plumbing.Hash{ref.Hash()})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range commits {
+		iter := object.NewCommitPreorderIter(c, excluded, nil)
+		err := iter.ForEach(func(c *object.Commit) error {
+			excluded[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wantedCommits returns the commits of the given objects, peeling the tags,
+// the other objects are ignored.
+func wantedCommits(s storer.EncodedObjectStorer, hashes []plumbing.Hash) ([]*object.Commit, error) {
+	var commits []*object.Commit
+	for _, h := range hashes {
+		o, err := object.GetObject(s, h)
+		for err == nil {
+			t, ok := o.(*object.Tag)
+			if !ok {
+				break
+			}
+
+			o, err = t.Object()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if c, ok := o.(*object.Commit); ok {
+			commits = append(commits, c)
+		}
+	}
+
+	return commits, nil
+}
+
+// shallowUpdate returns the shallow-update moving the shallow boundary of the
+// client, its current shallow commits being clientShallows, to b.
+func shallowUpdate(b *shallowBoundary, clientShallows []plumbing.Hash) *packp.ShallowUpdate {
+	su := &packp.ShallowUpdate{}
+	if b == nil {
+		return su
+	}
+
+	isClientShallow := make(map[plumbing.Hash]bool)
+	for _, h := range clientShallows {
+		isClientShallow[h] = true
+	}
+
+	isShallow := make(map[plumbing.Hash]bool)
+	for _, h := range b.shallows {
+		isShallow[h] = true
+		if !isClientShallow[h] {
+			su.Shallows = append(su.Shallows, h)
+		}
+	}
+
+	for _, h := range clientShallows {
+		if b.included[h] && !isShallow[h] {
+			su.Unshallows = append(su.Unshallows, h)
+		}
+	}
+
+	return su
+}
+
+// objectsWithBoundary returns the objects reachable from objs and not from
+// ignore, as revlist.Objects does, without walking the parents of the shallow
+// commits: the ones in boundary from objs and the ones in ignoreBoundary
+// from ignore. The objects reachable from ignore may be missing.
+func objectsWithBoundary(
+	s storer.EncodedObjectStorer,
+	objs, ignore []plumbing.Hash,
+	boundary, ignoreBoundary map[plumbing.Hash]bool,
+) ([]plumbing.Hash, error) {
+	seen := make(map[plumbing.Hash]bool)
+	for _, h := range ignore {
+		err := walkObjects(s, h, ignoreBoundary, seen, func(plumbing.Hash) {})
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+	}
+
+	var result []plumbing.Hash
+	for _, h := range objs {
+		err := walkObjects(s, h, boundary, seen, func(h plumbing.Hash) {
+			result = append(result, h)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// walkObjects calls cb for each object reachable from h not seen yet, without
+// walking the parents of the commits in boundary.
+func walkObjects(
+	s storer.EncodedObjectStorer,
+	h plumbing.Hash,
+	boundary, seen map[plumbing.Hash]bool,
+	cb func(plumbing.Hash),
+) error {
+	pending := []plumbing.Hash{h}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		o, err := object.GetObject(s, h)
+		if err != nil {
+			return err
+		}
+
+		seen[h] = true
+		cb(h)
+
+		switch o := o.(type) {
+		case *object.Commit:
+			pending = append(pending, o.TreeHash)
+			if !boundary[h] {
+				pending = append(pending, o.ParentHashes...)
+			}
+		case *object.Tag:
+			pending = append(pending, o.Target)
+		case *object.Tree:
+			for _, e := range o.Entries {
+				switch {
+				case e.Mode == filemode.Submodule:
+				case e.Mode == filemode.Dir:
+					pending = append(pending, e.Hash)
+				case !seen[e.Hash]:
+					seen[e.Hash] = true
+					cb(e.Hash)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,9 +1,19 @@
 package server_test
 
 import (
+	"context"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
 )
 
 type UploadPackSuite struct {
@@ -48,3 +58,204 @@ func (s *ClientLikeUploadPackSuite) SetUpSuite(c *C) {
 func (s *ClientLikeUploadPackSuite) TestAdvertisedReferencesEmpty(c *C) {
 	s.UploadPackSuite.TestAdvertisedReferencesEmpty(c)
 }
+
+func (s *UploadPackSuite) TestUploadPackUnknownHave(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Haves = append(req.Haves,
+		plumbing.NewHash("0000000000000000000000000000000000000001"),
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	)
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	_, count, err := packfile.NewScanner(reader).Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(4))
+}
+
+func (s *UploadPackSuite) TestUploadPackShallow(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Depth = packp.DepthCommits(1)
+	c.Assert(req.Capabilities.Set(capability.Shallow), IsNil)
+
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer resp.Close()
+
+	c.Assert(resp.ShallowUpdate.Shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	_, count, err := packfile.NewScanner(resp).Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(15))
+}
+
+func (s *UploadPackSuite) TestUploadPackDeepenNot(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Depth = packp.DepthReference("branch")
+	c.Assert(req.Capabilities.Set(capability.DeepenNot), IsNil)
+
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer resp.Close()
+
+	c.Assert(resp.ShallowUpdate.Shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *UploadPackSuite) TestUploadPackUnshallow(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Shallows = append(req.Shallows, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Haves = append(req.Haves, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Depth = packp.DepthCommits(2)
+	c.Assert(req.Capabilities.Set(capability.Shallow), IsNil)
+
+	resp, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	defer resp.Close()
+
+	c.Assert(resp.ShallowUpdate.Shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+	c.Assert(resp.ShallowUpdate.Unshallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	// the parent commit and its tree, the client has the rest
+	_, count, err := packfile.NewScanner(resp).Header()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, uint32(2))
+}
+
+func (s *UploadPackSuite) TestAcknowledgeHaves(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	a, ok := r.(interface {
+		AcknowledgeHaves(*packp.UploadPackRequest, []plumbing.Hash) (*packp.NegotiationResponse, error)
+	})
+	c.Assert(ok, Equals, true)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+
+	unknown := plumbing.NewHash("0000000000000000000000000000000000000001")
+	common := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	res, err := a.AcknowledgeHaves(req, []plumbing.Hash{unknown})
+	c.Assert(err, IsNil)
+	c.Assert(res.Acknowledgments, HasLen, 0)
+
+	res, err = a.AcknowledgeHaves(req, []plumbing.Hash{common})
+	c.Assert(err, IsNil)
+	c.Assert(res.Acknowledgments, DeepEquals, []packp.Acknowledgment{
+		{Hash: common, Status: packp.ACKCommon},
+		{Hash: common, Status: packp.ACKReady},
+	})
+
+	res, err = a.AcknowledgeHaves(req, []plumbing.Hash{unknown})
+	c.Assert(err, IsNil)
+	c.Assert(res.Acknowledgments, DeepEquals, []packp.Acknowledgment{
+		{Hash: unknown, Status: packp.ACKReady},
+	})
+	c.Assert(res.Ready(), Equals, true)
+}
+
+func (s *UploadPackSuite) TestAcknowledgeHavesRounds(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	a, ok := r.(interface {
+		AcknowledgeHaves(*packp.UploadPackRequest, []plumbing.Hash) (*packp.NegotiationResponse, error)
+	})
+	c.Assert(ok, Equals, true)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+
+	unknown := plumbing.NewHash("0000000000000000000000000000000000000001")
+	other := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	root := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+
+	// a common commit which is not an ancestor of the want
+	res, err := a.AcknowledgeHaves(req, []plumbing.Hash{other, unknown})
+	c.Assert(err, IsNil)
+	c.Assert(res.Acknowledgments, DeepEquals, []packp.Acknowledgment{
+		{Hash: other, Status: packp.ACKCommon},
+	})
+
+	// the history explored in the previous round gets a common ancestor
+	res, err = a.AcknowledgeHaves(req, []plumbing.Hash{root})
+	c.Assert(err, IsNil)
+	c.Assert(res.Acknowledgments, DeepEquals, []packp.Acknowledgment{
+		{Hash: root, Status: packp.ACKCommon},
+		{Hash: root, Status: packp.ACKReady},
+	})
+}
+
+func (s *UploadPackSuite) TestUploadPackIncludeTag(c *C) {
+	fs := fixtures.ByTag("tags").One().DotGit()
+	ep, err := transport.NewEndpoint(fs.Root())
+	c.Assert(err, IsNil)
+	s.loader[ep.String()] = filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	for _, t := range []struct {
+		includeTag bool
+		tags       int
+	}{{false, 0}, {true, 4}} {
+		r, err := s.Client.NewUploadPackSession(ep, s.EmptyAuth)
+		c.Assert(err, IsNil)
+
+		req := packp.NewUploadPackRequest()
+		req.Wants = append(req.Wants, plumbing.NewHash("f7b877701fbf855b44c0a9e86f3fdce2c298b07f"))
+		if t.includeTag {
+			c.Assert(req.Capabilities.Set(capability.IncludeTag), IsNil)
+		}
+
+		resp, err := r.UploadPack(context.Background(), req)
+		c.Assert(err, IsNil)
+
+		st := memory.NewStorage()
+		c.Assert(packfile.UpdateObjectStorage(st, resp), IsNil)
+		c.Assert(resp.Close(), IsNil)
+		c.Assert(r.Close(), IsNil)
+
+		iter, err := st.IterEncodedObjects(plumbing.TagObject)
+		c.Assert(err, IsNil)
+
+		tags := 0
+		c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+			tags++
+			return nil
+		}), IsNil)
+		c.Assert(tags, Equals, t.tags)
+	}
+}
@@ -79,6 +79,18 @@ func (c *command) Start() error {
 	return c.Session.Start(endpointToCommand(c.command, c.endpoint))
 }
 
+// SetProtocolVersion requests the given version of the protocol through the
+// GIT_PROTOCOL environment variable. Many SSH servers only accept the
+// variables listed by AcceptEnv in their sshd_config, a server rejecting it
+// speaks the version 0, which is used instead.
+func (c *command) SetProtocolVersion(v transport.ProtocolVersion) error {
+	// the request is sent as Session.Setenv does, which returns the same error
+	// whether the variable is rejected or the request fails
+	req := struct{ Name, Value string }{"GIT_PROTOCOL", v.Parameter()}
+	_, err := c.Session.SendRequest("env", true, ssh.Marshal(&req))
+	return err
+}
+
 // Close closes the SSH session and connection.
 func (c *command) Close() error {
 	if !c.connected {
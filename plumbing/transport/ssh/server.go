@@ -0,0 +1,345 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/internal/common"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods of a
+// Server after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("ssh: server closed")
+
+// Server serves git repositories over SSH, running the git-upload-pack and
+// git-receive-pack commands requested by the clients in their sessions, as
+// "git-upload-pack '/project.git'". The repositories are resolved by a
+// server.Loader, from the path of the command.
+type Server struct {
+	// HostKeys are the private keys identifying the server, at least one is
+	// required.
+	HostKeys []ssh.Signer
+	// PublicKeyCallback authenticates a client by its public key, if it
+	// returns an error the client is rejected. The permissions returned are
+	// available to Authorize, through the connection. By default, every
+	// client is rejected.
+	PublicKeyCallback func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+	// Authorize grants the access to the given service of a repository, if it
+	// returns an error the command is refused, the client sees it as a
+	// repository not found. By default, only the git-upload-pack service is
+	// granted.
+	Authorize func(conn *ssh.ServerConn, ep *transport.Endpoint, service string) error
+	// MaxConnections is the maximum number of connections served at the
+	// same time, the connections accepted beyond it are closed. Zero means
+	// no limit.
+	MaxConnections int
+	// Timeout is the maximum duration of a connection without reading or
+	// writing anything, the connection is closed after it. Zero means no
+	// timeout.
+	Timeout time.Duration
+	// Hooks are run on the pushes granted by Authorize, to accept or reject
+	// their commands.
+	Hooks *server.Hooks
+
+	loader server.Loader
+	conns  *common.ConnServer
+}
+
+// NewServer returns a new Server serving the repositories of the given
+// loader.
+func NewServer(loader server.Loader) *Server {
+	return &Server{
+		loader: loader,
+		conns:  common.NewConnServer(),
+	}
+}
+
+// ListenAndServe listens on the given TCP address, on the default port if
+// it has none, and serves the connections accepted. It always returns a
+// non-nil error.
+func (s *Server) ListenAndServe(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(DefaultPort))
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts the connections of l, serving each one in a new goroutine.
+// The listener is closed when Serve returns, it always returns a non-nil
+// error, ErrServerClosed after a call to Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	if len(s.HostKeys) == 0 {
+		l.Close()
+		return errors.New("ssh: no host keys")
+	}
+
+	config := s.config()
+	err := s.conns.Serve(l, s.MaxConnections, s.Timeout, func(conn net.Conn) {
+		s.serveConn(conn, config)
+	})
+
+	if err == common.ErrServerClosed {
+		return ErrServerClosed
+	}
+
+	return err
+}
+
+// Shutdown stops accepting connections and waits for the ones being served
+// to end, until the context is done, then the remaining ones are closed and
+// the error of the context is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+// Close stops accepting connections and closes the ones being served.
+func (s *Server) Close() error {
+	s.conns.Close()
+	return nil
+}
+
+func (s *Server) config() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: s.PublicKeyCallback,
+	}
+
+	if config.PublicKeyCallback == nil {
+		config.PublicKeyCallback = func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("public key rejected")
+		}
+	}
+
+	for _, key := range s.HostKeys {
+		config.AddHostKey(key)
+	}
+
+	return config
+}
+
+// newServer returns the server of the sessions, running the hooks.
+func (s *Server) newServer() transport.Transport {
+	return server.NewServerWithHooks(s.loader, s.Hooks)
+}
+
+func (s *Server) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveSession(sconn, ch, reqs)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// serveSession serves the first command requested in a session, every other
+// request is refused.
+func (s *Server) serveSession(conn *ssh.ServerConn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+
+		status := s.runCommand(conn, ch, payload.Command)
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// runCommand runs a git command on a session, returning its exit status. The
+// errors are written to the standard error of the session, as git does.
+func (s *Server) runCommand(conn *ssh.ServerConn, ch ssh.Channel, command string) uint32 {
+	service, repo, err := parseCommand(command)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+		return 128
+	}
+
+	ep, err := commandEndpoint(conn, repo)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+		return 128
+	}
+
+	// a refused command is not told apart from a missing repository, not to
+	// disclose which repositories exist
+	if err := s.authorize(conn, ep, service); err != nil {
+		return notFound(ch, repo)
+	}
+
+	cmd := common.ServerCommand{
+		Stdin:  ch,
+		Stdout: ioutil.WriteNopCloser(ch),
+		Stderr: ch.Stderr(),
+	}
+
+	if service == transport.UploadPackServiceName {
+		err = s.uploadPack(cmd, ep)
+	} else {
+		err = s.receivePack(cmd, ep)
+	}
+
+	if err == transport.ErrRepositoryNotFound {
+		return notFound(ch, repo)
+	}
+
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+		return 128
+	}
+
+	return 0
+}
+
+// notFound writes the error of a missing repository, as git does, returning
+// the exit status of the command.
+func notFound(ch ssh.Channel, repo string) uint32 {
+	fmt.Fprintf(ch.Stderr(), "fatal: '%s' does not appear to be a git repository\n", repo)
+	return 128
+}
+
+func (s *Server) authorize(conn *ssh.ServerConn, ep *transport.Endpoint, service string) error {
+	if s.Authorize != nil {
+		return s.Authorize(conn, ep, service)
+	}
+
+	if service != transport.UploadPackServiceName {
+		return fmt.Errorf("service %s not enabled", service)
+	}
+
+	return nil
+}
+
+func (s *Server) uploadPack(cmd common.ServerCommand, ep *transport.Endpoint) error {
+	sess, err := s.newServer().NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+	return common.ServeUploadPack(cmd, sess)
+}
+
+func (s *Server) receivePack(cmd common.ServerCommand, ep *transport.Endpoint) error {
+	sess, err := s.newServer().NewReceivePackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+	return common.ServeReceivePack(cmd, sess)
+}
+
+// parseCommand returns the service and the path of the repository of a
+// command, as the one sent by git: "git-upload-pack '/project.git'".
+func parseCommand(command string) (service, repo string, err error) {
+	i := strings.IndexByte(command, ' ')
+	if i < 0 {
+		return "", "", fmt.Errorf("unsupported command: %s", command)
+	}
+
+	service = command[:i]
+	if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+		return "", "", fmt.Errorf("unsupported command: %s", service)
+	}
+
+	repo, err = unquote(strings.TrimSpace(command[i+1:]))
+	if err != nil {
+		return "", "", err
+	}
+
+	return service, repo, nil
+}
+
+// unquote unquotes an argument quoted for the shell as git does, enclosed
+// in single quotes, with the single quotes and the exclamation marks escaped
+// with a backslash out of them.
+func unquote(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "'") {
+		return arg, nil
+	}
+
+	var b strings.Builder
+	for s := arg; s != ""; {
+		switch {
+		case s[0] == '\'':
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("malformed argument: %s", arg)
+			}
+
+			b.WriteString(s[1 : end+1])
+			s = s[end+2:]
+		case s[0] == '\\' && len(s) > 1:
+			b.WriteByte(s[1])
+			s = s[2:]
+		default:
+			return "", fmt.Errorf("malformed argument: %s", arg)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// commandEndpoint returns the endpoint of the repository at the given path,
+// for the user of the connection.
+func commandEndpoint(conn *ssh.ServerConn, repo string) (*transport.Endpoint, error) {
+	u := &url.URL{
+		Scheme: "ssh",
+		User:   url.User(conn.User()),
+		Host:   conn.LocalAddr().String(),
+		Path:   path.Clean("/" + repo),
+	}
+
+	return transport.NewEndpoint(u.String())
+}
@@ -0,0 +1,410 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/test"
+
+	"golang.org/x/crypto/ssh"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type BaseServerSuite struct {
+	fixtures.Suite
+
+	base    string
+	server  *Server
+	addr    string
+	served  chan error
+	hostKey ssh.Signer
+	key     *ecdsa.PrivateKey
+	auth    *PublicKeys
+}
+
+func (s *BaseServerSuite) SetUpTest(c *C) {
+	var err error
+	s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	s.hostKey = newSigner(c)
+
+	signer, err := ssh.NewSignerFromKey(s.key)
+	c.Assert(err, IsNil)
+
+	s.base = c.MkDir()
+	s.server = NewServer(server.NewFilesystemLoader(osfs.New(s.base)))
+	s.server.HostKeys = []ssh.Signer{s.hostKey}
+	s.server.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+			return nil, errors.New("unknown public key")
+		}
+
+		return &ssh.Permissions{Extensions: map[string]string{"user": conn.User()}}, nil
+	}
+
+	s.auth = &PublicKeys{User: "git", Signer: signer}
+	s.auth.HostKeyCallback = ssh.FixedHostKey(s.hostKey.PublicKey())
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	s.addr = l.Addr().String()
+	s.served = make(chan error, 1)
+	go func() { s.served <- s.server.Serve(l) }()
+}
+
+func (s *BaseServerSuite) TearDownTest(c *C) {
+	c.Assert(s.server.Close(), IsNil)
+	c.Assert(<-s.served, Equals, ErrServerClosed)
+}
+
+func (s *BaseServerSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+
+	err := fixtures.EnsureIsBare(fs)
+	c.Assert(err, IsNil)
+
+	err = os.Rename(fs.Root(), filepath.Join(s.base, name))
+	c.Assert(err, IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *BaseServerSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("ssh://git@%s/%s", s.addr, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+func (s *BaseServerSuite) allowReceivePack() {
+	s.server.Authorize = func(*ssh.ServerConn, *transport.Endpoint, string) error {
+		return nil
+	}
+}
+
+func newSigner(c *C) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	c.Assert(err, IsNil)
+	return signer
+}
+
+type ServerSuite struct {
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerSuite{})
+
+// git runs the git command in the given directory, connecting with the key
+// of the suite.
+func (s *ServerSuite) git(c *C, dir string, args ...string) string {
+	b, err := x509.MarshalECPrivateKey(s.key)
+	c.Assert(err, IsNil)
+
+	key := filepath.Join(c.MkDir(), "id_ecdsa")
+	err = ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	c.Assert(err, IsNil)
+
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=foo", "-c", "user.email=foo@foo.com",
+		"-c", "protocol.version=0",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), fmt.Sprintf(
+		"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes "+
+			"-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR", key,
+	))
+
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+	return strings.TrimSpace(string(out))
+}
+
+func (s *ServerSuite) TestGitClone(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+	c.Assert(s.git(c, filepath.Join(dir, "basic"), "rev-parse", "HEAD"), Equals,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	s.git(c, filepath.Join(dir, "basic"), "fsck")
+}
+
+func (s *ServerSuite) TestGitPush(c *C) {
+	s.allowReceivePack()
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	dir := c.MkDir()
+	s.git(c, dir, "clone", ep.String(), "basic")
+
+	repo := filepath.Join(dir, "basic")
+	c.Assert(ioutil.WriteFile(filepath.Join(repo, "foo"), []byte("foo"), 0644), IsNil)
+	s.git(c, repo, "add", "foo")
+	s.git(c, repo, "commit", "-m", "foo")
+	s.git(c, repo, "push", "origin", "master", ":branch")
+
+	head := s.git(c, repo, "rev-parse", "HEAD")
+	refs := s.git(c, repo, "ls-remote", "origin")
+	c.Assert(refs, Matches, "(?s).*"+head+"\trefs/heads/master.*")
+	c.Assert(strings.Contains(refs, "refs/heads/branch"), Equals, false)
+}
+
+func (s *ServerSuite) TestPublicKeyRejected(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	auth := &PublicKeys{User: "git", Signer: newSigner(c)}
+	auth.HostKeyCallback = ssh.FixedHostKey(s.hostKey.PublicKey())
+
+	_, err := DefaultClient.NewUploadPackSession(ep, auth)
+	c.Assert(err, ErrorMatches, ".*unable to authenticate.*")
+}
+
+func (s *ServerSuite) TestAuthorize(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	var user, path, service string
+	s.server.Authorize = func(conn *ssh.ServerConn, ep *transport.Endpoint, srv string) error {
+		user, path, service = conn.Permissions.Extensions["user"], ep.Path, srv
+		return errors.New("denied")
+	}
+
+	r, err := DefaultClient.NewUploadPackSession(ep, s.auth)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(user, Equals, "git")
+	c.Assert(path, Equals, "/basic.git")
+	c.Assert(service, Equals, transport.UploadPackServiceName)
+}
+
+func (s *ServerSuite) TestReceivePackNotEnabled(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	r, err := DefaultClient.NewReceivePackSession(ep, s.auth)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *ServerSuite) TestUnsupportedCommand(c *C) {
+	config, err := s.auth.ClientConfig()
+	c.Assert(err, IsNil)
+
+	client, err := ssh.Dial("tcp", s.addr, config)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	out, err := session.CombinedOutput("sh -c 'echo foo'")
+	c.Assert(err, FitsTypeOf, &ssh.ExitError{})
+	c.Assert(err.(*ssh.ExitError).ExitStatus(), Equals, 128)
+	c.Assert(string(out), Equals, "fatal: unsupported command: sh\n")
+}
+
+// run runs the command in a new session, returning its output and exit
+// status.
+func (s *ServerSuite) run(c *C, command string) (string, int) {
+	config, err := s.auth.ClientConfig()
+	c.Assert(err, IsNil)
+
+	client, err := ssh.Dial("tcp", s.addr, config)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	out, err := session.CombinedOutput(command)
+	if err == nil {
+		return string(out), 0
+	}
+
+	c.Assert(err, FitsTypeOf, &ssh.ExitError{})
+	return string(out), err.(*ssh.ExitError).ExitStatus()
+}
+
+func (s *ServerSuite) TestAuthorizeNotFound(c *C) {
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.server.Authorize = func(*ssh.ServerConn, *transport.Endpoint, string) error {
+		return errors.New("denied")
+	}
+
+	// a refused command looks like a missing repository
+	out, status := s.run(c, "git-upload-pack '/basic.git'")
+	c.Assert(status, Equals, 128)
+	c.Assert(out, Equals, "fatal: '/basic.git' does not appear to be a git repository\n")
+
+	s.server.Authorize = nil
+	out, status = s.run(c, "git-upload-pack '/missing.git'")
+	c.Assert(status, Equals, 128)
+	c.Assert(out, Equals, "fatal: '/missing.git' does not appear to be a git repository\n")
+}
+
+func (s *ServerSuite) TestMaxConnections(c *C) {
+	s.server.MaxConnections = 1
+
+	// the first connection waits for the version of the client
+	first, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer first.Close()
+
+	version, err := bufio.NewReader(first).ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Assert(version, Matches, "SSH-2.0-.*\r\n")
+
+	second, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer second.Close()
+
+	c.Assert(second.SetDeadline(time.Now().Add(5*time.Second)), IsNil)
+	out, _ := ioutil.ReadAll(second)
+	c.Assert(out, HasLen, 0)
+}
+
+func (s *ServerSuite) TestTimeout(c *C) {
+	s.server.Timeout = 100 * time.Millisecond
+
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	c.Assert(conn.SetDeadline(time.Now().Add(5*time.Second)), IsNil)
+
+	// the version of the server is received, then the client stays silent
+	out, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Matches, "SSH-2.0-.*\r\n")
+}
+
+func (s *ServerSuite) TestShutdown(c *C) {
+	c.Assert(s.server.Shutdown(context.Background()), IsNil)
+	c.Assert(<-s.served, Equals, ErrServerClosed)
+	s.served <- ErrServerClosed
+}
+
+func (s *ServerSuite) TestParseCommand(c *C) {
+	for _, t := range []struct {
+		command, service, repo string
+	}{
+		{"git-upload-pack '/foo.git'", "git-upload-pack", "/foo.git"},
+		{"git-receive-pack 'foo.git'", "git-receive-pack", "foo.git"},
+		{"git-upload-pack /foo.git", "git-upload-pack", "/foo.git"},
+		{`git-upload-pack '/it'\''s'\!'.git'`, "git-upload-pack", "/it's!.git"},
+	} {
+		service, repo, err := parseCommand(t.command)
+		c.Assert(err, IsNil, Commentf(t.command))
+		c.Assert(service, Equals, t.service)
+		c.Assert(repo, Equals, t.repo)
+	}
+}
+
+func (s *ServerSuite) TestParseCommandErrors(c *C) {
+	for _, command := range []string{
+		"git-upload-pack",
+		"ls '/foo.git'",
+		"git-upload-pack '/foo.git",
+		"git-upload-pack '/foo'.git",
+	} {
+		_, _, err := parseCommand(command)
+		c.Assert(err, NotNil, Commentf(command))
+	}
+}
+
+type ServerUploadPackSuite struct {
+	test.UploadPackSuite
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerUploadPackSuite{})
+
+func (s *ServerUploadPackSuite) SetUpTest(c *C) {
+	s.BaseServerSuite.SetUpTest(c)
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.EmptyAuth = s.auth
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+func (s *ServerUploadPackSuite) TestAdvertisedReferencesWithPrefixes(c *C) {
+	c.Skip("the version 2 of the protocol is not supported by the server")
+}
+
+type ServerReceivePackSuite struct {
+	test.ReceivePackSuite
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerReceivePackSuite{})
+
+func (s *ServerReceivePackSuite) SetUpTest(c *C) {
+	s.BaseServerSuite.SetUpTest(c)
+	s.allowReceivePack()
+	s.ReceivePackSuite.Client = &syncClient{DefaultClient, s.server}
+	s.ReceivePackSuite.EmptyAuth = s.auth
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// syncClient is a client waiting for the server to end serving its
+// receive-pack sessions once closed, since without report-status it does not
+// wait for the references to be updated.
+type syncClient struct {
+	transport.Transport
+	server *Server
+}
+
+func (c *syncClient) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	s, err := c.Transport.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncSession{s, c.server}, nil
+}
+
+type syncSession struct {
+	transport.ReceivePackSession
+	server *Server
+}
+
+func (s *syncSession) Close() error {
+	err := s.ReceivePackSession.Close()
+	for i := 0; i < 100 && s.server.conns.ActiveConns() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return err
+}
@@ -88,6 +88,8 @@ func handlerSSH(s ssh.Session) {
 		return
 	}
 
+	// the variables sent by the client, as GIT_PROTOCOL, are passed on
+	cmd.Env = append(os.Environ(), s.Environ()...)
 	if err := cmd.Start(); err != nil {
 		fmt.Println(err)
 		return
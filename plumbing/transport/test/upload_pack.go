@@ -8,11 +8,13 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
 
@@ -88,7 +90,9 @@ func (s *UploadPackSuite) TestAdvertisedReferencesFilterUnsupported(c *C) {
 
 	info, err := r.AdvertisedReferences()
 	c.Assert(err, IsNil)
-	c.Assert(info.Capabilities.Supports(capability.MultiACK), Equals, false)
+	for _, unsupported := range transport.UnsupportedCapabilities {
+		c.Assert(info.Capabilities.Supports(unsupported), Equals, false)
+	}
 }
 
 func (s *UploadPackSuite) TestCapabilities(c *C) {
@@ -101,6 +105,113 @@ func (s *UploadPackSuite) TestCapabilities(c *C) {
 	c.Assert(info.Capabilities.Get(capability.Agent), HasLen, 1)
 }
 
+// newUploadPackSessionV2 returns a session requesting the version 2 of the
+// protocol, skipping the test if the client does not allow it.
+func (s *UploadPackSuite) newUploadPackSessionV2(c *C) transport.UploadPackSession {
+	vc, ok := s.Client.(transport.ProtocolVersionTransport)
+	if !ok {
+		c.Skip("protocol versions are not supported")
+	}
+
+	r, err := vc.NewUploadPackSessionVersion(s.Endpoint, s.EmptyAuth, transport.ProtocolV2)
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *UploadPackSuite) TestAdvertisedReferencesWithPrefixes(c *C) {
+	r := s.newUploadPackSessionV2(c)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ps, ok := r.(transport.ReferencePrefixesSession)
+	if !ok {
+		c.Skip("reference prefixes are not supported")
+	}
+
+	info, err := ps.AdvertisedReferencesWithPrefixes([]string{"refs/heads/"})
+	c.Assert(err, IsNil)
+	c.Assert(info.Head, IsNil)
+	c.Assert(info.References["refs/heads/master"], Equals,
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	for name := range info.References {
+		c.Assert(strings.HasPrefix(name, "refs/heads/"), Equals, true)
+	}
+}
+
+func (s *UploadPackSuite) TestUploadPackProtocolV0(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	var info *packp.AdvRefs
+	if ps, ok := r.(transport.ReferencePrefixesSession); ok {
+		info, err = ps.AdvertisedReferencesWithPrefixes([]string{"refs/heads/"})
+	} else {
+		info, err = r.AdvertisedReferences()
+	}
+
+	c.Assert(err, IsNil)
+	c.Assert(info.Head, NotNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(info.Capabilities)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	var pack io.Reader = reader
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, reader)
+	}
+
+	s.checkObjectNumber(c, pack, 28)
+}
+
+func (s *UploadPackSuite) TestUploadPackProtocolV2(c *C) {
+	r := s.newUploadPackSessionV2(c)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(info.Head, NotNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(info.Capabilities)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	var pack io.Reader = reader
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, reader)
+	}
+
+	s.checkObjectNumber(c, pack, 28)
+}
+
+func (s *UploadPackSuite) TestUploadPackFromCapabilities(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(info.Capabilities)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Capabilities.Set(capability.NoProgress)
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	var pack io.Reader = reader
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, reader)
+	}
+
+	s.checkObjectNumber(c, pack, 28)
+}
+
 func (s *UploadPackSuite) TestUploadPack(c *C) {
 	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
 	c.Assert(err, IsNil)
@@ -239,6 +350,80 @@ func (s *UploadPackSuite) TestUploadPackPartial(c *C) {
 	s.checkObjectNumber(c, reader, 4)
 }
 
+func (s *UploadPackSuite) TestUploadPackWithNegotiator(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	s.testUploadPackWithNegotiator(c, r)
+}
+
+func (s *UploadPackSuite) TestUploadPackWithNegotiatorProtocolV0(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	s.testUploadPackWithNegotiator(c, r)
+}
+
+func (s *UploadPackSuite) TestUploadPackWithNegotiatorProtocolV2(c *C) {
+	s.testUploadPackWithNegotiator(c, s.newUploadPackSessionV2(c))
+}
+
+func (s *UploadPackSuite) testUploadPackWithNegotiator(c *C, r transport.UploadPackSession) {
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ns, ok := r.(transport.NegotiatorSession)
+	if !ok {
+		c.Skip("negotiation in multiple rounds not supported")
+	}
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	req := packp.NewUploadPackRequestFromCapabilities(info.Capabilities)
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	// the packfile is parsed without the common objects
+	req.Capabilities.Delete(capability.ThinPack)
+
+	// unknown haves, spanning several rounds, before the common one
+	common := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	n := &sliceNegotiator{}
+	for i := 0; i < 40; i++ {
+		n.haves = append(n.haves, plumbing.ComputeHash(plumbing.BlobObject, []byte{byte(i)}))
+	}
+
+	n.haves = append(n.haves, common)
+
+	reader, err := ns.UploadPackWithNegotiator(context.Background(), req, n)
+	c.Assert(err, IsNil)
+
+	var pack io.Reader = reader
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, reader)
+	}
+
+	s.checkObjectNumber(c, pack, 4)
+	c.Assert(n.acks, DeepEquals, []plumbing.Hash{common})
+}
+
+// sliceNegotiator is a transport.Negotiator sending the given haves.
+type sliceNegotiator struct {
+	haves []plumbing.Hash
+	acks  []plumbing.Hash
+}
+
+func (n *sliceNegotiator) Next() (plumbing.Hash, error) {
+	if len(n.haves) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+
+	h := n.haves[0]
+	n.haves = n.haves[1:]
+	return h, nil
+}
+
+func (n *sliceNegotiator) Ack(h plumbing.Hash) error {
+	n.acks = append(n.acks, h)
+	return nil
+}
+
 func (s *UploadPackSuite) TestFetchError(c *C) {
 	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
 	c.Assert(err, IsNil)
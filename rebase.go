@@ -0,0 +1,712 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrRebaseConflicts is returned when a commit can not be replayed
+	// cleanly during a rebase.
+	ErrRebaseConflicts = errors.New("rebase stopped on conflicts")
+	// ErrRebaseInProgress is returned by Rebase when another rebase is
+	// already in progress.
+	ErrRebaseInProgress = errors.New("a rebase is already in progress")
+	// ErrNoRebaseInProgress is returned when continuing, skipping or aborting
+	// a rebase and there is no rebase in progress.
+	ErrNoRebaseInProgress = errors.New("no rebase in progress")
+	// ErrRebaseNotSupported is returned when the storage can not keep the
+	// state of a rebase, see storer.RebaseStorer.
+	ErrRebaseNotSupported = errors.New("storage does not support rebase")
+)
+
+// RebaseAction is the action performed by a step of a rebase.
+type RebaseAction int8
+
+const (
+	// RebasePick replays the commit.
+	RebasePick RebaseAction = iota
+	// RebaseReword replays the commit using the message of the step.
+	RebaseReword
+	// RebaseSquash replays the commit melding it into the previous one, the
+	// messages of both commits are combined unless the step has a message.
+	RebaseSquash
+	// RebaseFixup is like RebaseSquash, but the message of the previous
+	// commit is kept.
+	RebaseFixup
+	// RebaseDrop removes the commit.
+	RebaseDrop
+)
+
+var rebaseActionNames = [...]string{"pick", "reword", "squash", "fixup", "drop"}
+
+func (a RebaseAction) String() string {
+	if a < RebasePick || a > RebaseDrop {
+		return fmt.Sprintf("RebaseAction(%d)", a)
+	}
+
+	return rebaseActionNames[a]
+}
+
+func parseRebaseAction(s string) (RebaseAction, error) {
+	for i, name := range rebaseActionNames {
+		if name == s || name[:1] == s {
+			return RebaseAction(i), nil
+		}
+	}
+
+	return 0, ErrInvalidRebaseTodo
+}
+
+// RebaseStep is a step of the todo list of a rebase.
+type RebaseStep struct {
+	// Action is the action to perform.
+	Action RebaseAction
+	// Commit is the commit to replay.
+	Commit plumbing.Hash
+	// Message replaces the message of the resulting commit when the action is
+	// RebaseReword or RebaseSquash.
+	Message string
+}
+
+// Rebase replays the commits reachable from HEAD and not from upstream on top
+// of onto, like `git rebase --onto <onto> <upstream>`. If onto is the zero
+// hash, upstream is used. The steps to perform can be given in the Todo of the
+// RebaseOptions, otherwise every commit is picked, merge commits are skipped.
+//
+// The state of the rebase is kept by the storage, see storer.RebaseStorer,
+// under .git/rebase-merge when the repository is stored in the filesystem.
+// HEAD is detached while the rebase is in progress. When a commit can not be
+// replayed cleanly the rebase stops, the conflicts are recorded in the index
+// and the worktree like in Worktree.Merge and ErrRebaseConflicts is returned.
+// The rebase can then be resumed with RebaseContinue once the conflicts are
+// resolved and added, or with RebaseSkip, or cancelled with RebaseAbort.
+//
+// Once all the steps are done the rebased branch is updated, HEAD is attached
+// to it again and the hash of the new HEAD is returned. NoErrAlreadyUpToDate
+// is returned if HEAD is already based on onto and no todo list was given.
+func (r *Repository) Rebase(upstream, onto plumbing.Hash, opts *RebaseOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	w, rs, err := r.rebaseWorktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := rs.RebaseFile(rebaseHeadNameFile); err == nil {
+		return plumbing.ZeroHash, ErrRebaseInProgress
+	} else if err != storer.ErrRebaseFileNotFound {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	st := &rebaseState{}
+	if head.Type() == plumbing.SymbolicReference {
+		st.headName = head.Target()
+		if head, err = r.Reference(plumbing.HEAD, true); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	upstreamCommit, err := r.CommitObject(upstream)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if onto.IsZero() {
+		onto = upstream
+	}
+
+	if _, err := r.CommitObject(onto); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	st.onto, st.origHead = onto, headCommit.Hash
+	if st.todo = opts.Todo; len(st.todo) == 0 {
+		commits, err := commitsToRebase(r.Storer, headCommit, upstreamCommit)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if onto == upstream {
+			isAncestor, err := upstreamCommit.IsAncestor(headCommit)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+
+			if isAncestor {
+				return headCommit.Hash, NoErrAlreadyUpToDate
+			}
+		}
+
+		for _, c := range commits {
+			st.todo = append(st.todo, RebaseStep{Action: RebasePick, Commit: c.Hash})
+		}
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	detached := plumbing.NewHashReference(plumbing.HEAD, onto)
+	if err := setReference(r.Storer, detached, nil, opts.Committer, "rebase (start): checkout "+onto.String()); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := st.save(rs); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.runRebase(rs, st, opts)
+}
+
+// RebaseContinue resumes a rebase stopped with conflicts. The changes in the
+// index are used to create the commit of the stopped step, so the conflicts
+// must be resolved and added before. Only the Committer and SignKey of the
+// options are used.
+func (r *Repository) RebaseContinue(opts *RebaseOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	w, rs, err := r.rebaseWorktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	st, err := loadRebaseState(rs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if !st.stopped.IsZero() {
+		idx, err := r.Storer.Index()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if hasUnmergedEntries(idx) {
+			return plumbing.ZeroHash, ErrUnmergedPaths
+		}
+
+		h := &buildTreeHelper{fs: w.Filesystem, s: r.Storer}
+		tree, err := h.BuildTree(idx)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		step := st.done[len(st.done)-1]
+		if err := w.commitRebaseStep(step, tree, opts); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		st.stopped = plumbing.ZeroHash
+		if err := st.save(rs); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return w.runRebase(rs, st, opts)
+}
+
+// RebaseSkip resumes a rebase stopped with conflicts, discarding the changes
+// of the stopped step. Only the Committer and SignKey of the options are used.
+func (r *Repository) RebaseSkip(opts *RebaseOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	w, rs, err := r.rebaseWorktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	st, err := loadRebaseState(rs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.reset(&ResetOptions{Mode: HardReset, Commit: head.Hash()}, ""); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	st.stopped = plumbing.ZeroHash
+	if err := st.save(rs); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.runRebase(rs, st, opts)
+}
+
+// RebaseAbort cancels the rebase in progress, HEAD, the index and the
+// worktree are restored to the state they had before the rebase started.
+func (r *Repository) RebaseAbort() error {
+	w, rs, err := r.rebaseWorktree()
+	if err != nil {
+		return err
+	}
+
+	st, err := loadRebaseState(rs)
+	if err != nil {
+		return err
+	}
+
+	head := plumbing.NewHashReference(plumbing.HEAD, st.origHead)
+	msg := "rebase (abort): returning to " + st.origHead.String()
+	if st.headName != "" {
+		head = plumbing.NewSymbolicReference(plumbing.HEAD, st.headName)
+		msg = "rebase (abort): returning to " + st.headName.String()
+	}
+
+	if err := setReference(r.Storer, head, nil, nil, msg); err != nil {
+		return err
+	}
+
+	if err := w.reset(&ResetOptions{Mode: HardReset, Commit: st.origHead}, ""); err != nil {
+		return err
+	}
+
+	return rs.RemoveRebase()
+}
+
+func (r *Repository) rebaseWorktree() (*Worktree, storer.RebaseStorer, error) {
+	rs, ok := r.Storer.(storer.RebaseStorer)
+	if !ok {
+		return nil, nil, ErrRebaseNotSupported
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return w, rs, nil
+}
+
+// runRebase performs the pending steps of the todo list, the state is saved
+// after every step. The commits are created on top of the detached HEAD, the
+// index and the worktree are only updated when the rebase stops or ends.
+func (w *Worktree) runRebase(rs storer.RebaseStorer, st *rebaseState, opts *RebaseOptions) (plumbing.Hash, error) {
+	for len(st.todo) != 0 {
+		step := st.todo[0]
+		st.todo = st.todo[1:]
+		st.done = append(st.done, step)
+
+		if step.Action != RebaseDrop {
+			tree, conflicts, err := w.applyRebaseStep(step)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+
+			if len(conflicts) != 0 {
+				if err := w.checkoutMergeResult(tree, conflicts); err != nil {
+					return plumbing.ZeroHash, err
+				}
+
+				st.stopped = step.Commit
+				if err := st.save(rs); err != nil {
+					return plumbing.ZeroHash, err
+				}
+
+				return plumbing.ZeroHash, ErrRebaseConflicts
+			}
+
+			if err := w.commitRebaseStep(step, tree.Hash, opts); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+
+		if err := st.save(rs); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return w.finishRebase(rs, st)
+}
+
+// applyRebaseStep merges the changes of the commit of the step into HEAD.
+func (w *Worktree) applyRebaseStep(step RebaseStep) (*object.Tree, []*mergeConflict, error) {
+	c, err := w.r.CommitObject(step.Commit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	onto, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return w.pickCommit(c, onto, "HEAD")
+}
+
+// commitRebaseStep creates the commit resulting of a step with the given tree
+// and moves HEAD to it. Picked commits whose changes are already in HEAD are
+// dropped, squashed commits replace HEAD.
+func (w *Worktree) commitRebaseStep(step RebaseStep, tree plumbing.Hash, opts *RebaseOptions) error {
+	c, err := w.r.CommitObject(step.Commit)
+	if err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	prev, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	author, msg, parents := c.Author, c.Message, []plumbing.Hash{prev.Hash}
+	switch step.Action {
+	case RebasePick, RebaseReword:
+		if tree == prev.TreeHash {
+			return nil
+		}
+
+		if step.Action == RebaseReword && step.Message != "" {
+			msg = step.Message
+		}
+	case RebaseSquash, RebaseFixup:
+		author, parents = prev.Author, prev.ParentHashes
+		switch {
+		case step.Action == RebaseFixup:
+			msg = prev.Message
+		case step.Message != "":
+			msg = step.Message
+		default:
+			msg = strings.TrimRight(prev.Message, "\n") + "\n\n" + c.Message
+		}
+	}
+
+	h, err := w.buildCommitObject(msg, &CommitOptions{
+		Author:    &author,
+		Committer: opts.Committer,
+		Parents:   parents,
+		SignKey:   opts.SignKey,
+	}, tree)
+	if err != nil {
+		return err
+	}
+
+	msg = fmt.Sprintf("rebase (%s): %s", step.Action, firstLine(msg))
+	return w.updateHEAD(h, opts.Committer, msg)
+}
+
+// finishRebase updates the rebased branch to HEAD, attaches HEAD to it and
+// removes the rebase state.
+func (w *Worktree) finishRebase(rs storer.RebaseStorer, st *rebaseState) (plumbing.Hash, error) {
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.reset(&ResetOptions{Mode: MergeReset, Commit: head.Hash()}, ""); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if st.headName != "" {
+		branch := plumbing.NewHashReference(st.headName, head.Hash())
+		msg := fmt.Sprintf("rebase (finish): %s onto %s", st.headName, st.onto)
+		if err := setReference(w.r.Storer, branch, nil, nil, msg); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		ref := plumbing.NewSymbolicReference(plumbing.HEAD, st.headName)
+		msg = "rebase (finish): returning to " + st.headName.String()
+		if err := setReference(w.r.Storer, ref, nil, nil, msg); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return head.Hash(), rs.RemoveRebase()
+}
+
+const (
+	rebaseHeadNameFile  = "head-name"
+	rebaseOntoFile      = "onto"
+	rebaseOrigHeadFile  = "orig-head"
+	rebaseTodoFile      = "git-rebase-todo"
+	rebaseDoneFile      = "done"
+	rebaseMsgNumFile    = "msgnum"
+	rebaseEndFile       = "end"
+	rebaseStoppedFile   = "stopped-sha"
+	rebaseMessagePrefix = "message-"
+
+	detachedHeadName = "detached HEAD"
+)
+
+// rebaseState is the state of a rebase in progress, it is stored using the
+// same files git uses in the rebase-merge directory. The messages given in the
+// steps are kept in additional message-<hash> files.
+type rebaseState struct {
+	headName       plumbing.ReferenceName
+	onto, origHead plumbing.Hash
+	todo, done     []RebaseStep
+	stopped        plumbing.Hash
+}
+
+func loadRebaseState(rs storer.RebaseStorer) (*rebaseState, error) {
+	headName, err := rs.RebaseFile(rebaseHeadNameFile)
+	if err == storer.ErrRebaseFileNotFound {
+		return nil, ErrNoRebaseInProgress
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	st := &rebaseState{}
+	if name := strings.TrimSpace(string(headName)); name != detachedHeadName {
+		st.headName = plumbing.ReferenceName(name)
+	}
+
+	for name, h := range map[string]*plumbing.Hash{
+		rebaseOntoFile:     &st.onto,
+		rebaseOrigHeadFile: &st.origHead,
+		rebaseStoppedFile:  &st.stopped,
+	} {
+		content, err := rs.RebaseFile(name)
+		if err == storer.ErrRebaseFileNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		*h = plumbing.NewHash(strings.TrimSpace(string(content)))
+	}
+
+	if st.todo, err = readRebaseSteps(rs, rebaseTodoFile); err != nil {
+		return nil, err
+	}
+
+	if st.done, err = readRebaseSteps(rs, rebaseDoneFile); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (st *rebaseState) save(rs storer.RebaseStorer) error {
+	headName := detachedHeadName
+	if st.headName != "" {
+		headName = st.headName.String()
+	}
+
+	var stopped string
+	if !st.stopped.IsZero() {
+		stopped = st.stopped.String() + "\n"
+	}
+
+	for _, f := range []struct{ name, content string }{
+		{rebaseHeadNameFile, headName + "\n"},
+		{rebaseOntoFile, st.onto.String() + "\n"},
+		{rebaseOrigHeadFile, st.origHead.String() + "\n"},
+		{rebaseMsgNumFile, fmt.Sprintf("%d\n", len(st.done))},
+		{rebaseEndFile, fmt.Sprintf("%d\n", len(st.done)+len(st.todo))},
+		{rebaseStoppedFile, stopped},
+	} {
+		if err := rs.SetRebaseFile(f.name, []byte(f.content)); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRebaseSteps(rs, rebaseTodoFile, st.todo); err != nil {
+		return err
+	}
+
+	return writeRebaseSteps(rs, rebaseDoneFile, st.done)
+}
+
+// writeRebaseSteps writes the steps using the format of the git todo list,
+// `<action> <hash>`, the messages of the steps are written in their own files.
+func writeRebaseSteps(rs storer.RebaseStorer, name string, steps []RebaseStep) error {
+	var buf bytes.Buffer
+	for _, step := range steps {
+		fmt.Fprintf(&buf, "%s %s\n", step.Action, step.Commit)
+		if step.Message == "" {
+			continue
+		}
+
+		content := []byte(step.Message)
+		if err := rs.SetRebaseFile(rebaseMessagePrefix+step.Commit.String(), content); err != nil {
+			return err
+		}
+	}
+
+	return rs.SetRebaseFile(name, buf.Bytes())
+}
+
+func readRebaseSteps(rs storer.RebaseStorer, name string) ([]RebaseStep, error) {
+	content, err := rs.RebaseFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []RebaseStep
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, ErrInvalidRebaseTodo
+		}
+
+		action, err := parseRebaseAction(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		step := RebaseStep{Action: action, Commit: plumbing.NewHash(fields[1])}
+		msg, err := rs.RebaseFile(rebaseMessagePrefix + step.Commit.String())
+		switch err {
+		case nil:
+			step.Message = string(msg)
+		case storer.ErrRebaseFileNotFound:
+		default:
+			return nil, err
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, s.Err()
+}
+
+// commitsToRebase returns the commits reachable from head but not from
+// upstream, in topological order, the oldest first. Merge commits are skipped,
+// since a rebase flattens the history.
+func commitsToRebase(s storer.EncodedObjectStorer, head, upstream *object.Commit) ([]*object.Commit, error) {
+	bases, err := head.MergeBase(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	// the commits reachable from both are the ones reachable from the merge
+	// bases, the walk stops once only those are left
+	tips := []revlist.Tip{{Hash: head.Hash}}
+	for _, b := range bases {
+		tips = append(tips, revlist.Tip{Hash: b.Hash, Exclude: true})
+	}
+
+	iter, err := revlist.Commits(s, tips, &revlist.CommitsOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[plumbing.Hash]*object.Commit)
+	var order []*object.Commit
+	err = iter.ForEach(func(c *revlist.Commit) error {
+		pending[c.Hash] = c.Commit
+		order = append(order, c.Commit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sorted []*object.Commit
+	var visit func(c *object.Commit)
+	visit = func(c *object.Commit) {
+		delete(pending, c.Hash)
+		for _, h := range c.ParentHashes {
+			if p, ok := pending[h]; ok {
+				visit(p)
+			}
+		}
+
+		if c.NumParents() <= 1 {
+			sorted = append(sorted, c)
+		}
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		if _, ok := pending[order[i].Hash]; ok {
+			visit(order[i])
+		}
+	}
+
+	return sorted, nil
+}
+
+// pickCommit applies the changes introduced by c, compared with its first
+// parent, to the tree of onto using a three-way merge.
+func (w *Worktree) pickCommit(c, onto *object.Commit, ontoLabel string) (*object.Tree, []*mergeConflict, error) {
+	var parent *object.Commit
+	if c.NumParents() != 0 {
+		var err error
+		if parent, err = c.Parent(0); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	base, err := commitTree(parent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ours, err := onto.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirs, err := c.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := newTreeMerger(w.r.Storer, ontoLabel, commitLabel(c))
+	tree, err := m.Merge(base, ours, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tree, m.conflicts, nil
+}
+
+// commitLabel returns the label used in the conflict markers for the given
+// commit, the abbreviated hash and the subject.
+func commitLabel(c *object.Commit) string {
+	return fmt.Sprintf("%s (%s)", c.Hash.String()[:7], firstLine(c.Message))
+}
+
+// firstLine returns the first line of the given message, its subject.
+func firstLine(msg string) string {
+	return strings.SplitN(strings.TrimSpace(msg), "\n", 2)[0]
+}
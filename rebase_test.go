@@ -0,0 +1,324 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type RebaseSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&RebaseSuite{})
+
+func (s *RebaseSuite) assertHead(c *C, r *Repository, branch string) *object.Commit {
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.SymbolicReference)
+	c.Assert(head.Target(), Equals, plumbing.NewBranchReferenceName(branch))
+
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(ref.Hash())
+	c.Assert(err, IsNil)
+	return commit
+}
+
+func (s *RebaseSuite) TestRebase(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+	commitFiles(c, w, map[string]string{"baz": "baz\n"}, "baz\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"qux": "qux\n"}, "qux\n")
+	checkoutBranch(c, w, "feature")
+
+	hash, err := r.Rebase(master, plumbing.ZeroHash, &RebaseOptions{
+		Committer: defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+
+	head := s.assertHead(c, r, "feature")
+	c.Assert(head.Hash, Equals, hash)
+	c.Assert(head.Message, Equals, "baz\n")
+
+	parent, err := head.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(parent.Message, Equals, "bar\n")
+	c.Assert(parent.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	for _, name := range []string{"foo", "bar", "baz", "qux"} {
+		_, err := fs.Stat(name)
+		c.Assert(err, IsNil)
+	}
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = r.Rebase(master, plumbing.ZeroHash, &RebaseOptions{
+		Committer: defaultSignature(),
+	})
+	c.Assert(err, Equals, NoErrAlreadyUpToDate)
+}
+
+func (s *RebaseSuite) TestRebaseOnto(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	upstream := commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+	commitFiles(c, w, map[string]string{"baz": "baz\n"}, "baz\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"qux": "qux\n"}, "qux\n")
+	checkoutBranch(c, w, "feature")
+
+	_, err := r.Rebase(upstream, master, &RebaseOptions{
+		Committer: defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+
+	head := s.assertHead(c, r, "feature")
+	c.Assert(head.Message, Equals, "baz\n")
+	c.Assert(head.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *RebaseSuite) TestRebaseConflictsContinue(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"foo": "feature\n"}, "foo\n")
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "master\n"}, "master\n")
+	checkoutBranch(c, w, "feature")
+
+	opts := &RebaseOptions{Committer: defaultSignature()}
+	_, err := r.Rebase(master, plumbing.ZeroHash, opts)
+	c.Assert(err, Equals, ErrRebaseConflicts)
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Type(), Equals, plumbing.HashReference)
+	c.Assert(head.Hash(), Equals, master)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, UpdatedButUnmerged)
+
+	_, err = r.Rebase(master, plumbing.ZeroHash, opts)
+	c.Assert(err, Equals, ErrRebaseInProgress)
+
+	_, err = r.RebaseContinue(opts)
+	c.Assert(err, Equals, ErrUnmergedPaths)
+
+	err = util.WriteFile(fs, "foo", []byte("resolved\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	_, err = r.RebaseContinue(opts)
+	c.Assert(err, IsNil)
+
+	commit := s.assertHead(c, r, "feature")
+	c.Assert(commit.Message, Equals, "bar\n")
+
+	parent, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(parent.Message, Equals, "foo\n")
+	c.Assert(parent.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "resolved\n")
+
+	_, err = r.RebaseContinue(opts)
+	c.Assert(err, Equals, ErrNoRebaseInProgress)
+}
+
+func (s *RebaseSuite) TestRebaseSkip(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	commitFiles(c, w, map[string]string{"foo": "feature\n"}, "foo\n")
+	commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "master\n"}, "master\n")
+	checkoutBranch(c, w, "feature")
+
+	opts := &RebaseOptions{Committer: defaultSignature()}
+	_, err := r.Rebase(master, plumbing.ZeroHash, opts)
+	c.Assert(err, Equals, ErrRebaseConflicts)
+
+	_, err = r.RebaseSkip(opts)
+	c.Assert(err, IsNil)
+
+	commit := s.assertHead(c, r, "feature")
+	c.Assert(commit.Message, Equals, "bar\n")
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	content, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "master\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *RebaseSuite) TestRebaseAbort(c *C) {
+	r, err := PlainInit(c.MkDir(), false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]string{"foo": "foo\n"}, "base\n")
+	err = w.Checkout(&CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName("feature"),
+		Create: true,
+	})
+	c.Assert(err, IsNil)
+
+	feature := commitFiles(c, w, map[string]string{"foo": "feature\n"}, "feature\n")
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"foo": "master\n"}, "master\n")
+	checkoutBranch(c, w, "feature")
+
+	_, err = r.Rebase(master, plumbing.ZeroHash, &RebaseOptions{
+		Committer: defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrRebaseConflicts)
+
+	dotgit := r.Storer.(interface{ Filesystem() billy.Filesystem }).Filesystem()
+	content, err := readWorktreeFile(dotgit, "rebase-merge/git-rebase-todo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "")
+
+	content, err = readWorktreeFile(dotgit, "rebase-merge/done")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "pick "+feature.String()+"\n")
+
+	content, err = readWorktreeFile(dotgit, "rebase-merge/head-name")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "refs/heads/feature\n")
+
+	err = r.RebaseAbort()
+	c.Assert(err, IsNil)
+
+	commit := s.assertHead(c, r, "feature")
+	c.Assert(commit.Hash, Equals, feature)
+
+	_, err = dotgit.Stat("rebase-merge")
+	c.Assert(err, NotNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	err = r.RebaseAbort()
+	c.Assert(err, Equals, ErrNoRebaseInProgress)
+}
+
+func (s *RebaseSuite) TestRebaseTodo(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	bar := commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+	baz := commitFiles(c, w, map[string]string{"baz": "baz\n"}, "baz\n")
+	fixup := commitFiles(c, w, map[string]string{"bar": "fixed\n"}, "fixup! bar\n")
+	qux := commitFiles(c, w, map[string]string{"qux": "qux\n"}, "qux\n")
+	quux := commitFiles(c, w, map[string]string{"quux": "quux\n"}, "quux\n")
+
+	checkoutBranch(c, w, "master")
+	master := commitFiles(c, w, map[string]string{"master": "master\n"}, "master\n")
+	checkoutBranch(c, w, "feature")
+
+	_, err := r.Rebase(master, plumbing.ZeroHash, &RebaseOptions{
+		Committer: defaultSignature(),
+		Todo: []RebaseStep{
+			{Action: RebaseReword, Commit: bar, Message: "reworded bar\n"},
+			{Action: RebaseFixup, Commit: fixup},
+			{Action: RebaseDrop, Commit: baz},
+			{Action: RebasePick, Commit: qux},
+			{Action: RebaseSquash, Commit: quux},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	head := s.assertHead(c, r, "feature")
+	c.Assert(head.Message, Equals, "qux\n\nquux\n")
+
+	parent, err := head.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(parent.Message, Equals, "reworded bar\n")
+	c.Assert(parent.ParentHashes, DeepEquals, []plumbing.Hash{master})
+
+	content, err := readWorktreeFile(fs, "bar")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "fixed\n")
+
+	_, err = fs.Stat("baz")
+	c.Assert(err, NotNil)
+
+	_, err = fs.Stat("quux")
+	c.Assert(err, IsNil)
+}
+
+func (s *RebaseSuite) TestRebaseInvalidOptions(c *C) {
+	r, _, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	_, err := r.Rebase(plumbing.ZeroHash, plumbing.ZeroHash, &RebaseOptions{})
+	c.Assert(err, Equals, ErrMissingCommitter)
+
+	h := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	_, err = r.Rebase(plumbing.ZeroHash, plumbing.ZeroHash, &RebaseOptions{
+		Committer: defaultSignature(),
+		Todo: []RebaseStep{
+			{Action: RebaseDrop, Commit: h},
+			{Action: RebaseSquash, Commit: h},
+		},
+	})
+	c.Assert(err, Equals, ErrInvalidRebaseTodo)
+}
+
+func (s *RebaseSuite) TestCommitsToRebaseMergedUpstream(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	x := commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+
+	checkoutBranch(c, w, "master")
+	merged := commitFiles(c, w, map[string]string{"baz": "baz\n"}, "baz\n")
+	checkoutBranch(c, w, "feature")
+
+	_, err := w.Merge(&MergeOptions{CommitHash: merged, Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	checkoutBranch(c, w, "master")
+	upstream := commitFiles(c, w, map[string]string{"qux": "qux\n"}, "qux\n")
+
+	ref, err := r.Reference(plumbing.NewBranchReferenceName("feature"), true)
+	c.Assert(err, IsNil)
+
+	head, err := r.CommitObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(head.NumParents(), Equals, 2)
+
+	upstreamCommit, err := r.CommitObject(upstream)
+	c.Assert(err, IsNil)
+
+	// the merge base is the merged commit, but the first commit of the
+	// repository is reachable from upstream too
+	commits, err := commitsToRebase(r.Storer, head, upstreamCommit)
+	c.Assert(err, IsNil)
+	c.Assert(commits, HasLen, 1)
+	c.Assert(commits[0].Hash, Equals, x)
+}
@@ -0,0 +1,249 @@
+package git
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrReflogNotSupported is returned when the storage does not keep the
+	// reflogs, see storer.ReflogStorer.
+	ErrReflogNotSupported = errors.New("storage does not support reflogs")
+	// ErrReflogEntryNotFound is returned when resolving a revision like
+	// <ref>@{n} or @{-n} and the reflog does not have enough entries.
+	ErrReflogEntryNotFound = errors.New("reflog entry not found")
+)
+
+const reflogCheckoutPrefix = "checkout: moving from "
+
+// Reflog returns the entries of the reflog of the given reference, the most
+// recent first, so the entry n is the one used to resolve <ref>@{n}.
+func (r *Repository) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil, ErrReflogNotSupported
+	}
+
+	entries, err := rs.Reflog(name)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*reflog.Entry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, entries[i])
+	}
+
+	return list, nil
+}
+
+// reflogEntry returns the hash of the reference at the n-th entry of its
+// reflog, as in <ref>@{n}. As git does, <ref>@{0} is the current value of a
+// reference without reflog.
+func (r *Repository) reflogEntry(name plumbing.ReferenceName, n int) (plumbing.Hash, error) {
+	entries, err := r.Reflog(name)
+	if err != nil && (n != 0 || err != ErrReflogNotSupported) {
+		return plumbing.ZeroHash, err
+	}
+
+	if n == 0 && len(entries) == 0 {
+		ref, err := storer.ResolveReference(r.Storer, name)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return ref.Hash(), nil
+	}
+
+	if n >= len(entries) {
+		return plumbing.ZeroHash, ErrReflogEntryNotFound
+	}
+
+	return entries[n].New, nil
+}
+
+// reflogEntryAt returns the hash of the reference at the given date, as in
+// <ref>@{date}, from the most recent entry of its reflog not after the date.
+// As git does, the oldest value is returned for a date before the reflog.
+func (r *Repository) reflogEntryAt(name plumbing.ReferenceName, date time.Time) (plumbing.Hash, error) {
+	entries, err := r.Reflog(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(entries) == 0 {
+		return plumbing.ZeroHash, ErrReflogEntryNotFound
+	}
+
+	for _, e := range entries {
+		if !e.Committer.When.After(date) {
+			return e.New, nil
+		}
+	}
+
+	oldest := entries[len(entries)-1]
+	if oldest.Old.IsZero() {
+		return oldest.New, nil
+	}
+
+	return oldest.Old, nil
+}
+
+// currentBranchOrHEAD returns the branch HEAD points to, or HEAD when it is
+// detached, the reference used to resolve @{n}.
+func (r *Repository) currentBranchOrHEAD() (plumbing.ReferenceName, error) {
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target(), nil
+	}
+
+	return plumbing.HEAD, nil
+}
+
+// previousCheckout returns the n-th branch, or commit, checked out before the
+// current one, as in @{-n}, looking for the checkout entries of the reflog of
+// HEAD.
+func (r *Repository) previousCheckout(n int) (string, error) {
+	entries, err := r.Reflog(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Message, reflogCheckoutPrefix) {
+			continue
+		}
+
+		n--
+		if n != 0 {
+			continue
+		}
+
+		from := strings.TrimPrefix(e.Message, reflogCheckoutPrefix)
+		if i := strings.LastIndex(from, " to "); i != -1 {
+			from = from[:i]
+		}
+
+		return from, nil
+	}
+
+	return "", ErrReflogEntryNotFound
+}
+
+// setReference sets the given reference, as CheckAndSetReference does, and
+// records the update in the reflog with the given signature and message, when
+// the storage supports it, see storer.LoggedReferenceStorer. If the signature
+// is nil, the storage uses the user of the configuration.
+func setReference(
+	s storer.ReferenceStorer, new, old *plumbing.Reference, sig *object.Signature, msg string,
+) error {
+	ls, ok := s.(storer.LoggedReferenceStorer)
+	if !ok {
+		return s.CheckAndSetReference(new, old)
+	}
+
+	var committer *reflog.Signature
+	if sig != nil {
+		committer = &reflog.Signature{Name: sig.Name, Email: sig.Email, When: sig.When}
+	}
+
+	return ls.SetLoggedReference(new, old, committer, msg)
+}
+
+// updateReferenceIfNeeded sets the given reference if it changed, as
+// checkAndUpdateReferenceStorerIfNeeded does, recording the update in the
+// reflog with the given message.
+func updateReferenceIfNeeded(
+	s storer.ReferenceStorer, new, old *plumbing.Reference, msg string,
+) (bool, error) {
+	p, err := s.Reference(new.Name())
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return false, err
+	}
+
+	// we use the string method to compare references, is the easiest way
+	if err == nil && new.String() == p.String() {
+		return false, nil
+	}
+
+	return true, setReference(s, new, old, nil, msg)
+}
+
+func newReflogEntry(old, new plumbing.Hash, sig *object.Signature, msg string) *reflog.Entry {
+	return &reflog.Entry{
+		Old: old,
+		New: new,
+		Committer: reflog.Signature{
+			Name:  sig.Name,
+			Email: sig.Email,
+			When:  sig.When,
+		},
+		Message: msg,
+	}
+}
+
+// rawConfigOption returns the value of the given option, without adding the
+// section to the configuration when it is missing.
+func rawConfigOption(cfg *config.Config, section, key string) string {
+	var value string
+	for _, s := range cfg.Raw.Sections {
+		if !s.IsName(section) {
+			continue
+		}
+
+		if v := s.Option(key); v != "" {
+			value = v
+		}
+	}
+
+	return value
+}
+
+// rawConfigSubsectionOption returns the value of the given option of a
+// subsection, as rawConfigOption does.
+func rawConfigSubsectionOption(cfg *config.Config, section, subsection, key string) string {
+	var value string
+	for _, s := range cfg.Raw.Sections {
+		if !s.IsName(section) {
+			continue
+		}
+
+		for _, ss := range s.Subsections {
+			if !ss.IsName(subsection) {
+				continue
+			}
+
+			if v := ss.Option(key); v != "" {
+				value = v
+			}
+		}
+	}
+
+	return value
+}
+
+// describeHEAD returns the name of the branch HEAD points to or, if detached,
+// the hash of its commit, as used by the checkout entries of the reflog.
+func describeHEAD(s storer.ReferenceStorer) (string, error) {
+	head, err := s.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), nil
+	}
+
+	return head.Hash().String(), nil
+}
@@ -0,0 +1,220 @@
+package git
+
+import (
+	"context"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type ReflogSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ReflogSuite{})
+
+func (s *ReflogSuite) reflogMessages(c *C, r *Repository, name plumbing.ReferenceName) []string {
+	entries, err := r.Reflog(name)
+	c.Assert(err, IsNil)
+
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+
+	return msgs
+}
+
+func (s *ReflogSuite) TestCommitCheckoutAndReset(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+
+	feature := commitFiles(c, w, map[string]string{"foo": "feature\n"}, "feature\n\nbody\n")
+	checkoutBranch(c, w, "master")
+
+	err = w.Reset(&ResetOptions{Mode: HardReset, Commit: feature})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.reflogMessages(c, r, plumbing.HEAD), DeepEquals, []string{
+		"reset: moving to " + feature.String(),
+		"checkout: moving from feature to master",
+		"commit: feature",
+		"checkout: moving from master to feature",
+		"commit (initial): base",
+	})
+
+	c.Assert(s.reflogMessages(c, r, "refs/heads/feature"), DeepEquals, []string{
+		"commit: feature",
+		"branch: Created from HEAD",
+	})
+
+	entries, err := r.Reflog(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(entries[0].Old, Equals, base)
+	c.Assert(entries[0].New, Equals, feature)
+	c.Assert(entries[4].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[4].Committer.Name, Equals, defaultSignature().Name)
+}
+
+func (s *ReflogSuite) TestUserIdentity(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	cfg, err := r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("user").SetOption("name", "bar").SetOption("email", "bar@bar.bar")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	checkoutBranch(c, w, "master")
+
+	entries, err := r.Reflog(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(entries[0].Committer.Name, Equals, "bar")
+	c.Assert(entries[0].Committer.Email, Equals, "bar@bar.bar")
+}
+
+func (s *ReflogSuite) TestStorerSetReference(c *C) {
+	st := memory.NewStorage()
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("user").SetOption("name", "bar").SetOption("email", "bar@bar.bar")
+
+	h := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	c.Assert(st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/foo")), IsNil)
+	c.Assert(st.SetReference(plumbing.NewHashReference("refs/heads/foo", h)), IsNil)
+	c.Assert(st.SetReference(plumbing.NewHashReference("refs/tags/foo", h)), IsNil)
+
+	for _, name := range []plumbing.ReferenceName{plumbing.HEAD, "refs/heads/foo"} {
+		entries, err := st.Reflog(name)
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 1)
+		c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+		c.Assert(entries[0].New, Equals, h)
+		c.Assert(entries[0].Message, Equals, "")
+		c.Assert(entries[0].Committer.Name, Equals, "bar")
+		c.Assert(entries[0].Committer.Email, Equals, "bar@bar.bar")
+	}
+
+	entries, err := st.Reflog("refs/tags/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ReflogSuite) TestLogAllRefUpdatesFalse(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	cfg, err := r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("core").SetOption("logallrefupdates", "false")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	commitFiles(c, w, map[string]string{"foo": "bar\n"}, "bar\n")
+
+	c.Assert(s.reflogMessages(c, r, "refs/heads/feature"), DeepEquals, []string{
+		"branch: Created from HEAD",
+	})
+}
+
+func (s *ReflogSuite) TestResolveRevision(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+
+	first := commitFiles(c, w, map[string]string{"foo": "first\n"}, "first\n")
+	second := commitFiles(c, w, map[string]string{"foo": "second\n"}, "second\n")
+	checkoutBranch(c, w, "master")
+
+	for rev, expected := range map[string]plumbing.Hash{
+		"@{0}":                   base,
+		"feature@{0}":            second,
+		"feature@{1}":            first,
+		"feature@{2}":            base,
+		"HEAD@{1}":               second,
+		"HEAD@{3}":               base,
+		"@{-1}":                  second,
+		"@{-1}~1":                first,
+		"@{-2}":                  base,
+		"refs/heads/feature@{1}": first,
+	} {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("revision %q", rev))
+		c.Assert(*h, Equals, expected, Commentf("revision %q", rev))
+	}
+
+	for _, rev := range []string{"feature@{3}", "@{-3}"} {
+		_, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, Equals, ErrReflogEntryNotFound, Commentf("revision %q", rev))
+	}
+}
+
+func (s *ReflogSuite) TestResolveRevisionWithoutReflog(c *C) {
+	r, _, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	h := head.Hash()
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/foo", h)), IsNil)
+
+	resolved, err := r.ResolveRevision("foo@{0}")
+	c.Assert(err, IsNil)
+	c.Assert(*resolved, Equals, h)
+
+	_, err = r.ResolveRevision("foo@{1}")
+	c.Assert(err, Equals, ErrReflogEntryNotFound)
+}
+
+func (s *ReflogSuite) TestDeleteReferenceRemovesReflog(c *C) {
+	st := memory.NewStorage()
+	ref := plumbing.NewHashReference("refs/heads/foo", plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"))
+	c.Assert(setReference(st, ref, nil, defaultSignature(), "foo"), IsNil)
+
+	entries, err := st.Reflog(ref.Name())
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	c.Assert(st.RemoveReference(ref.Name()), IsNil)
+
+	entries, err = st.Reflog(ref.Name())
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ReflogSuite) TestClone(c *C) {
+	url := s.GetBasicLocalRepositoryURL()
+	r, err := CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.reflogMessages(c, r, plumbing.HEAD), DeepEquals, []string{"clone: from " + url})
+	c.Assert(s.reflogMessages(c, r, "refs/heads/master"), DeepEquals, []string{"clone: from " + url})
+	c.Assert(s.reflogMessages(c, r, "refs/remotes/origin/master"), DeepEquals, []string{"fetch: storing head"})
+}
+
+func (s *ReflogSuite) TestFilesystem(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	h := commitFiles(c, w, map[string]string{"foo": "foo\n"}, "base\n")
+
+	r, err = PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	for _, name := range []plumbing.ReferenceName{plumbing.HEAD, plumbing.Master} {
+		entries, err := r.Reflog(name)
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 1)
+		c.Assert(entries[0].New, Equals, h)
+		c.Assert(entries[0].Message, Equals, "commit (initial): base")
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path"
+	"strings"
 
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/negotiator"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
@@ -26,9 +30,13 @@ import (
 )
 
 var (
-	NoErrAlreadyUpToDate     = errors.New("already up-to-date")
-	ErrDeleteRefNotSupported = errors.New("server does not support delete-refs")
-	ErrForceNeeded           = errors.New("some refs were not updated")
+	NoErrAlreadyUpToDate       = errors.New("already up-to-date")
+	ErrDeleteRefNotSupported   = errors.New("server does not support delete-refs")
+	ErrAtomicNotSupported      = errors.New("server does not support atomic push")
+	ErrPushOptionsNotSupported = errors.New("server does not support push options")
+	ErrForceNeeded             = errors.New("some refs were not updated")
+	ErrFilterNotSupported      = errors.New("server does not support filter")
+	ErrShallowNotSupported     = errors.New("server does not support the shallow request")
 )
 
 const (
@@ -171,7 +179,15 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		}
 	}
 
-	rs, err := pushHashes(ctx, s, r.s, req, hashesToPush, r.useRefDeltas(ar))
+	var bases []plumbing.Hash
+	if !ar.Capabilities.Supports(capability.NoThin) {
+		bases, err = thinPackBases(r.s, hashesToPush)
+		if err != nil {
+			return err
+		}
+	}
+
+	rs, err := pushHashes(ctx, s, r.s, req, hashesToPush, bases, r.useRefDeltas(ar))
 	if err != nil {
 		return err
 	}
@@ -204,6 +220,23 @@ func (r *Remote) newReferenceUpdateRequest(
 		}
 	}
 
+	if o.Atomic {
+		if !ar.Capabilities.Supports(capability.Atomic) {
+			return nil, ErrAtomicNotSupported
+		}
+
+		req.Capabilities.Set(capability.Atomic)
+	}
+
+	if len(o.Options) > 0 {
+		if !ar.Capabilities.Supports(capability.PushOptions) {
+			return nil, ErrPushOptionsNotSupported
+		}
+
+		req.Capabilities.Set(capability.PushOptions)
+		req.Options = o.Options
+	}
+
 	if err := r.addReferencesToUpdate(o.RefSpecs, localRefs, remoteRefs, req); err != nil {
 		return nil, err
 	}
@@ -226,7 +259,7 @@ func (r *Remote) updateRemoteReferenceStorage(
 			ref := plumbing.NewHashReference(local, c.New)
 			switch c.Action() {
 			case packp.Create, packp.Update:
-				if err := r.s.SetReference(ref); err != nil {
+				if err := setReference(r.s, ref, nil, nil, "update by push"); err != nil {
 					return err
 				}
 			case packp.Delete:
@@ -276,14 +309,20 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		o.RefSpecs = r.c.Fetch
 	}
 
-	s, err := newUploadPackSession(r.c.URLs[0], o.Auth)
+	s, err := newUploadPackSessionVersion(r.c.URLs[0], o.Auth, o.ProtocolVersion)
 	if err != nil {
 		return nil, err
 	}
 
 	defer ioutil.CheckClose(s, &err)
 
-	ar, err := s.AdvertisedReferences()
+	var ar *packp.AdvRefs
+	if ps, ok := s.(transport.ReferencePrefixesSession); ok {
+		ar, err = ps.AdvertisedReferencesWithPrefixes(referencePrefixes(o))
+	} else {
+		ar, err = s.AdvertisedReferences()
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -293,6 +332,12 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		return nil, err
 	}
 
+	if !req.Filter.IsZero() && !r.c.Promisor {
+		if err = r.registerPromisor(req.Filter); err != nil {
+			return nil, err
+		}
+	}
+
 	remoteRefs, err := ar.AllReferences()
 	if err != nil {
 		return nil, err
@@ -308,14 +353,22 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		return nil, err
 	}
 
-	req.Wants, err = getWants(r.s, refs)
+	// moving the shallow boundary requires the references already fetched
+	deepen := !req.Depth.IsZero() && o.Depth == 0
+	req.Wants, err = getWants(r.s, refs, deepen)
 	if len(req.Wants) > 0 {
-		req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+		var n transport.Negotiator
+		if _, ok := s.(transport.NegotiatorSession); ok && isMultiACK(req.Capabilities) {
+			n, err = newNegotiator(o.NegotiationAlgorithm, localRefs, remoteRefs, r.s)
+		} else {
+			req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+		}
+
 		if err != nil {
 			return nil, err
 		}
 
-		if err = r.fetchPack(ctx, o, s, req); err != nil {
+		if err = r.fetchPack(ctx, o, s, req, n); err != nil {
 			return nil, err
 		}
 	}
@@ -325,13 +378,38 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		return nil, err
 	}
 
-	if !updated {
+	if !updated && !deepen {
 		return remoteRefs, NoErrAlreadyUpToDate
 	}
 
 	return remoteRefs, nil
 }
 
+// referencePrefixes returns the prefixes of the references needed to fetch
+// with the given options, the servers speaking the version 2 of the protocol
+// advertise only the references starting with them.
+func referencePrefixes(o *FetchOptions) []string {
+	prefixes := []string{plumbing.HEAD.String()}
+	if o.Tags != NoTags {
+		prefixes = append(prefixes, "refs/tags/")
+	}
+
+	for _, rs := range o.RefSpecs {
+		if rs.IsDelete() {
+			continue
+		}
+
+		src := rs.Src()
+		if rs.IsWildcard() {
+			src = src[:strings.Index(src, "*")]
+		}
+
+		prefixes = append(prefixes, src)
+	}
+
+	return prefixes
+}
+
 func newUploadPackSession(url string, auth transport.AuthMethod) (transport.UploadPackSession, error) {
 	c, ep, err := newClient(url)
 	if err != nil {
@@ -341,6 +419,21 @@ func newUploadPackSession(url string, auth transport.AuthMethod) (transport.Uplo
 	return c.NewUploadPackSession(ep, auth)
 }
 
+// newUploadPackSessionVersion is like newUploadPackSession, requesting the
+// given version of the protocol if the transport allows it.
+func newUploadPackSessionVersion(url string, auth transport.AuthMethod, v transport.ProtocolVersion) (transport.UploadPackSession, error) {
+	c, ep, err := newClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if vc, ok := c.(transport.ProtocolVersionTransport); ok {
+		return vc.NewUploadPackSessionVersion(ep, auth, v)
+	}
+
+	return c.NewUploadPackSession(ep, auth)
+}
+
 func newSendPackSession(url string, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
 	c, ep, err := newClient(url)
 	if err != nil {
@@ -364,21 +457,34 @@ func newClient(url string) (transport.Transport, *transport.Endpoint, error) {
 	return c, ep, err
 }
 
+// fetchPack performs the upload-pack request, negotiating the haves with n in
+// multiple rounds if not nil.
 func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.UploadPackSession,
-	req *packp.UploadPackRequest) (err error) {
+	req *packp.UploadPackRequest, n transport.Negotiator) (err error) {
+
+	var reader *packp.UploadPackResponse
+	if n != nil {
+		reader, err = s.(transport.NegotiatorSession).UploadPackWithNegotiator(ctx, req, n)
+	} else {
+		reader, err = s.UploadPack(ctx, req)
+	}
 
-	reader, err := s.UploadPack(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	defer ioutil.CheckClose(reader, &err)
 
-	if err = r.updateShallow(o, reader); err != nil {
+	if err = r.updateShallow(reader); err != nil {
 		return err
 	}
 
-	if err = packfile.UpdateObjectStorage(r.s,
+	update := packfile.UpdateObjectStorage
+	if r.c.Promisor {
+		update = packfile.UpdatePromisorObjectStorage
+	}
+
+	if err = update(r.s,
 		buildSidebandIfSupported(req.Capabilities, reader, o.Progress),
 	); err != nil {
 		return err
@@ -387,6 +493,81 @@ func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.Upl
 	return err
 }
 
+// registerPromisor configures the remote as a promisor remote, using the
+// given filter by default on the next fetches. The configuration is saved if
+// the remote is one of the repository.
+func (r *Remote) registerPromisor(f packp.Filter) error {
+	r.c.Promisor = true
+	r.c.PartialCloneFilter = string(f)
+
+	cfg, err := r.s.Config()
+	if err != nil {
+		return err
+	}
+
+	c, ok := cfg.Remotes[r.c.Name]
+	if !ok {
+		return nil
+	}
+
+	c.Promisor = true
+	c.PartialCloneFilter = string(f)
+	return r.s.SetConfig(cfg)
+}
+
+// fetchObjects fetches the given objects, missing from a partial clone, from
+// the promisor remote. As the objects are not advertised by the server, it
+// has to allow requesting them, the version 2 of the protocol is requested
+// for that, whatever the ProtocolVersion of the fetch.
+func (r *Remote) fetchObjects(ctx context.Context, auth transport.AuthMethod, hashes []plumbing.Hash) (err error) {
+	s, err := newUploadPackSessionVersion(r.c.URLs[0], auth, transport.ProtocolV2)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	var ar *packp.AdvRefs
+	if ps, ok := s.(transport.ReferencePrefixesSession); ok {
+		ar, err = ps.AdvertisedReferencesWithPrefixes([]string{plumbing.HEAD.String()})
+	} else {
+		ar, err = s.AdvertisedReferences()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	req.Wants = hashes
+
+	if ar.Capabilities.Supports(capability.NoProgress) {
+		if err = req.Capabilities.Set(capability.NoProgress); err != nil {
+			return err
+		}
+	}
+
+	// the wanted objects are sent even if they don't match the filter, it
+	// avoids receiving the blobs of the wanted trees
+	if ar.Capabilities.Supports(capability.Filter) {
+		req.Filter = packp.FilterBlobNone()
+		if err = req.Capabilities.Set(capability.Filter); err != nil {
+			return err
+		}
+	}
+
+	reader, err := s.UploadPack(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(reader, &err)
+
+	return packfile.UpdatePromisorObjectStorage(r.s,
+		buildSidebandIfSupported(req.Capabilities, reader, nil),
+	)
+}
+
 func (r *Remote) addReferencesToUpdate(
 	refspecs []config.RefSpec,
 	localRefs []*plumbing.Reference,
@@ -583,9 +764,8 @@ func getHavesFromRef(
 		return nil
 	}
 
-	// Until go-git supports proper commit negotiation during an
-	// upload pack request, include up to `maxHavesToVisitPerRef`
-	// commits from the history of each ref.
+	// Without a negotiation in multiple rounds, include up to
+	// `maxHavesToVisitPerRef` commits from the history of each ref.
 	walker := object.NewCommitPreorderIter(commit, haves, nil)
 	toVisit := maxHavesToVisitPerRef
 	return walker.ForEach(func(c *object.Commit) error {
@@ -639,6 +819,47 @@ func getHaves(
 	return result, nil
 }
 
+func isMultiACK(l *capability.List) bool {
+	return l.Supports(capability.MultiACK) || l.Supports(capability.MultiACKDetailed)
+}
+
+// newNegotiator returns a negotiator walking the history of the local
+// references, stopping at the remote references present locally.
+func newNegotiator(
+	a negotiator.Algorithm,
+	localRefs []*plumbing.Reference,
+	remoteRefStorer storer.ReferenceStorer,
+	s storage.Storer,
+) (transport.Negotiator, error) {
+	n, err := negotiator.New(a, s)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteRefs, err := getRemoteRefsFromStorer(remoteRefStorer)
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range remoteRefs {
+		if err := n.KnownCommon(h); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range localRefs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		if err := n.AddTip(ref.Hash()); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
 const refspecAllTags = "+refs/tags/*:refs/tags/*"
 
 func calculateRefs(
@@ -708,7 +929,9 @@ func doCalculateRefs(
 	return err
 }
 
-func getWants(localStorer storage.Storer, refs memory.ReferenceStorage) ([]plumbing.Hash, error) {
+// getWants returns the hashes of the references missing in the storer, or of
+// every reference if all is true.
+func getWants(localStorer storage.Storer, refs memory.ReferenceStorage, all bool) ([]plumbing.Hash, error) {
 	wants := map[plumbing.Hash]bool{}
 	for _, ref := range refs {
 		hash := ref.Hash()
@@ -717,7 +940,7 @@ func getWants(localStorer storage.Storer, refs memory.ReferenceStorage) ([]plumb
 			return nil, err
 		}
 
-		if !exists {
+		if all || !exists {
 			wants[hash] = true
 		}
 	}
@@ -784,6 +1007,25 @@ func isFastForward(s storer.EncodedObjectStorer, old, new plumbing.Hash) (bool,
 	return found, err
 }
 
+// fetchReflogMessage returns the message recording in the reflog the update
+// of a reference by fetch, old is nil if the reference did not exist.
+func (r *Remote) fetchReflogMessage(old, new *plumbing.Reference) (string, error) {
+	if old == nil {
+		return "fetch: storing head", nil
+	}
+
+	ff, err := isFastForward(r.s, old.Hash(), new.Hash())
+	if err != nil && err != plumbing.ErrObjectNotFound {
+		return "", err
+	}
+
+	if !ff {
+		return "fetch: forced-update", nil
+	}
+
+	return "fetch: fast-forward", nil
+}
+
 func (r *Remote) newUploadPackRequest(o *FetchOptions,
 	ar *packp.AdvRefs) (*packp.UploadPackRequest, error) {
 
@@ -796,12 +1038,27 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 		}
 	}
 
+	if err := r.setShallowRequest(o, ar, req); err != nil {
+		return nil, err
+	}
+
 	if o.Progress == nil && ar.Capabilities.Supports(capability.NoProgress) {
 		if err := req.Capabilities.Set(capability.NoProgress); err != nil {
 			return nil, err
 		}
 	}
 
+	if f := r.filter(o); !f.IsZero() {
+		if !ar.Capabilities.Supports(capability.Filter) {
+			return nil, ErrFilterNotSupported
+		}
+
+		req.Filter = f
+		if err := req.Capabilities.Set(capability.Filter); err != nil {
+			return nil, err
+		}
+	}
+
 	isWildcard := true
 	for _, s := range o.RefSpecs {
 		if !s.IsWildcard() {
@@ -819,6 +1076,76 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 	return req, nil
 }
 
+// setShallowRequest sends the shallow commits of the repository, so the server
+// knows its shallow boundary, and requests to move it as set by the deepen,
+// shallow-since, shallow-exclude and unshallow options.
+func (r *Remote) setShallowRequest(o *FetchOptions, ar *packp.AdvRefs,
+	req *packp.UploadPackRequest) error {
+
+	shallows, err := r.s.Shallow()
+	if err != nil {
+		return err
+	}
+
+	if len(shallows) != 0 && ar.Capabilities.Supports(capability.Shallow) {
+		req.Shallows = shallows
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return err
+		}
+	}
+
+	var depths packp.Depths
+	var required []capability.Capability
+	switch {
+	case o.Deepen != 0:
+		depths = append(depths, packp.DepthCommits(o.Deepen))
+		required = append(required, capability.Shallow, capability.DeepenRelative)
+	case o.Unshallow && len(shallows) != 0:
+		depths = append(depths, packp.InfiniteDepth)
+		required = append(required, capability.Shallow)
+	}
+
+	if !o.ShallowSince.IsZero() {
+		depths = append(depths, packp.DepthSince(o.ShallowSince))
+		required = append(required, capability.DeepenSince)
+	}
+
+	for _, ref := range o.ShallowExclude {
+		depths = append(depths, packp.DepthReference(ref))
+		required = append(required, capability.DeepenNot)
+	}
+
+	for _, c := range required {
+		if !ar.Capabilities.Supports(c) {
+			return ErrShallowNotSupported
+		}
+
+		if err := req.Capabilities.Set(c); err != nil {
+			return err
+		}
+	}
+
+	switch len(depths) {
+	case 0:
+	case 1:
+		req.Depth = depths[0]
+	default:
+		req.Depth = depths
+	}
+
+	return nil
+}
+
+// filter returns the filter of the fetch, by default the partial clone filter
+// of a promisor remote.
+func (r *Remote) filter(o *FetchOptions) packp.Filter {
+	if o.Filter.IsZero() && r.c.Promisor {
+		return packp.Filter(r.c.PartialCloneFilter)
+	}
+
+	return o.Filter
+}
+
 func buildSidebandIfSupported(l *capability.List, reader io.Reader, p sideband.Progress) io.Reader {
 	var t sideband.Type
 
@@ -878,7 +1205,12 @@ func (r *Remote) updateLocalReferenceStorage(
 				}
 			}
 
-			refUpdated, err := checkAndUpdateReferenceStorerIfNeeded(r.s, new, old)
+			msg, err := r.fetchReflogMessage(old, new)
+			if err != nil {
+				return updated, err
+			}
+
+			refUpdated, err := updateReferenceIfNeeded(r.s, new, old, msg)
 			if err != nil {
 				return updated, err
 			}
@@ -1008,12 +1340,111 @@ func referencesToHashes(refs storer.ReferenceStorer) ([]plumbing.Hash, error) {
 	return hs, nil
 }
 
+// thinPackBases returns the objects known by the remote to be used as delta
+// bases of a thin pack of the objects hs: the trees and blobs changed by the
+// commits pushed, as found in their parents not pushed.
+func thinPackBases(s storer.EncodedObjectStorer, hs []plumbing.Hash) ([]plumbing.Hash, error) {
+	pushed := make(map[plumbing.Hash]bool, len(hs))
+	for _, h := range hs {
+		pushed[h] = true
+	}
+
+	var bases []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	add := func(h plumbing.Hash) {
+		if !pushed[h] && !seen[h] {
+			seen[h] = true
+			bases = append(bases, h)
+		}
+	}
+
+	for _, h := range hs {
+		c, err := object.GetCommit(s, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ph := range c.ParentHashes {
+			if pushed[ph] {
+				continue
+			}
+
+			if err := addChangedObjects(s, ph, c, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bases, nil
+}
+
+// addChangedObjects calls add with the trees and blobs of the parent commit
+// ph at the paths changed by c. The parent is ignored if it is not present,
+// e.g. beyond a shallow boundary.
+func addChangedObjects(
+	s storer.EncodedObjectStorer,
+	ph plumbing.Hash,
+	c *object.Commit,
+	add func(plumbing.Hash),
+) error {
+	parent, err := object.GetCommit(s, ph)
+	if err == plumbing.ErrObjectNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	from, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+
+	to, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return err
+	}
+
+	add(from.Hash)
+	for _, ch := range changes {
+		if ch.From.Name == "" || ch.From.TreeEntry.Mode == filemode.Submodule {
+			continue
+		}
+
+		add(ch.From.TreeEntry.Hash)
+
+		// the trees containing the changed entry changed too
+		dir := path.Dir(ch.From.Name)
+		for ; dir != "."; dir = path.Dir(dir) {
+			e, err := from.FindEntry(dir)
+			if err != nil {
+				return err
+			}
+
+			add(e.Hash)
+		}
+	}
+
+	return nil
+}
+
 func pushHashes(
 	ctx context.Context,
 	sess transport.ReceivePackSession,
 	s storage.Storer,
 	req *packp.ReferenceUpdateRequest,
 	hs []plumbing.Hash,
+	bases []plumbing.Hash,
 	useRefDeltas bool,
 ) (*packp.ReportStatus, error) {
 
@@ -1031,7 +1462,7 @@ func pushHashes(
 
 	go func() {
 		e := packfile.NewEncoder(wr, s, useRefDeltas)
-		if _, err := e.Encode(hs, config.Pack.Window); err != nil {
+		if _, err := e.EncodeThin(hs, bases, config.Pack.Window); err != nil {
 			done <- wr.CloseWithError(err)
 			return
 		}
@@ -1053,24 +1484,33 @@ func pushHashes(
 	return rs, nil
 }
 
-func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse) error {
-	if o.Depth == 0 || len(resp.Shallows) == 0 {
+// updateShallow moves the shallow boundary of the repository as sent by the
+// server: the unshallow commits are no longer shallow, since their parents
+// were fetched, and the shallow ones are added.
+func (r *Remote) updateShallow(resp *packp.UploadPackResponse) error {
+	if len(resp.Shallows) == 0 && len(resp.Unshallows) == 0 {
 		return nil
 	}
 
-	shallows, err := r.s.Shallow()
+	current, err := r.s.Shallow()
 	if err != nil {
 		return err
 	}
 
-outer:
-	for _, s := range resp.Shallows {
-		for _, oldS := range shallows {
-			if s == oldS {
-				continue outer
-			}
+	unshallows := make(map[plumbing.Hash]bool, len(resp.Unshallows))
+	for _, h := range resp.Unshallows {
+		unshallows[h] = true
+	}
+
+	var shallows []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	for _, h := range append(current, resp.Shallows...) {
+		if unshallows[h] || seen[h] {
+			continue
 		}
-		shallows = append(shallows, s)
+
+		seen[h] = true
+		shallows = append(shallows, h)
 	}
 
 	return r.s.SetShallow(shallows)
@@ -6,15 +6,20 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/negotiator"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
@@ -71,6 +76,136 @@ func (s *RemoteSuite) TestFetchWildcard(c *C) {
 	})
 }
 
+func (s *RemoteSuite) TestFetchReferencePrefixes(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	remoteRefs, err := r.fetch(context.Background(), &FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/master:refs/remotes/origin/master"),
+		},
+		Tags:            NoTags,
+		ProtocolVersion: transport.ProtocolV2,
+	})
+	c.Assert(err, IsNil)
+
+	iter, err := remoteRefs.IterReferences()
+	c.Assert(err, IsNil)
+
+	var names []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().String())
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	sort.Strings(names)
+	c.Assert(names, DeepEquals, []string{"HEAD", "refs/heads/master"})
+}
+
+func (s *RemoteSuite) TestFetchFilter(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	remote, err := r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{newPartialCloneSource(c, s.GetBasicLocalRepositoryURL())},
+	})
+	c.Assert(err, IsNil)
+
+	err = remote.Fetch(&FetchOptions{Filter: packp.FilterTreeDepth(0)})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes[DefaultRemoteName].Promisor, Equals, true)
+	c.Assert(cfg.Remotes[DefaultRemoteName].PartialCloneFilter, Equals, "tree:0")
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.HasEncodedObject(commit.TreeHash), Equals, plumbing.ErrObjectNotFound)
+
+	// the next fetches use the partial clone filter of the remote
+	o := &FetchOptions{RemoteName: DefaultRemoteName}
+	c.Assert(o.Validate(), IsNil)
+	ar := packp.NewAdvRefs()
+	ar.Capabilities.Add(capability.Filter)
+	req, err := remote.newUploadPackRequest(o, ar)
+	c.Assert(err, IsNil)
+	c.Assert(req.Filter, Equals, packp.FilterTreeDepth(0))
+}
+
+func (s *RemoteSuite) TestFetchFilterNotSupported(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	err := r.Fetch(&FetchOptions{Filter: packp.FilterBlobNone()})
+	c.Assert(err, Equals, ErrFilterNotSupported)
+	c.Assert(r.c.Promisor, Equals, false)
+}
+
+func (s *RemoteSuite) TestReferencePrefixes(c *C) {
+	prefixes := referencePrefixes(&FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/remotes/origin/*"),
+			config.RefSpec("refs/pull/*/head:refs/remotes/origin/pr/*"),
+			config.RefSpec("refs/notes/commits:refs/notes/commits"),
+			config.RefSpec(":refs/heads/foo"),
+		},
+		Tags: TagFollowing,
+	})
+
+	c.Assert(prefixes, DeepEquals, []string{
+		"HEAD", "refs/tags/", "refs/heads/", "refs/pull/", "refs/notes/commits",
+	})
+}
+
+func (s *RemoteSuite) TestFetchNegotiation(c *C) {
+	for _, a := range []negotiator.Algorithm{negotiator.Consecutive, negotiator.Skipping} {
+		for _, v := range []transport.ProtocolVersion{transport.ProtocolV0, transport.ProtocolV2} {
+			s.testFetchNegotiation(c, a, v)
+		}
+	}
+}
+
+func (s *RemoteSuite) testFetchNegotiation(c *C, a negotiator.Algorithm, v transport.ProtocolVersion) {
+	sto := memory.NewStorage()
+	r := NewRemote(sto, &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	err := r.Fetch(&FetchOptions{
+		RefSpecs:        []config.RefSpec{"refs/heads/branch:refs/remotes/origin/branch"},
+		ProtocolVersion: v,
+	})
+	c.Assert(err, IsNil)
+	fetched := len(sto.ObjectStorage.Objects)
+
+	err = r.Fetch(&FetchOptions{
+		RefSpecs:             []config.RefSpec{"refs/heads/master:refs/remotes/origin/master"},
+		NegotiationAlgorithm: a,
+		ProtocolVersion:      v,
+	})
+	c.Assert(err, IsNil)
+
+	// only the objects of the commit on top of the common history are sent
+	c.Assert(len(sto.ObjectStorage.Objects)-fetched, Equals, 4)
+	ref, err := sto.Reference("refs/remotes/origin/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+}
+
+func (s *RemoteSuite) TestFetchInvalidNegotiationAlgorithm(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	err := r.Fetch(&FetchOptions{NegotiationAlgorithm: negotiator.Algorithm(42)})
+	c.Assert(err, Equals, negotiator.ErrUnknownAlgorithm)
+}
+
 func (s *RemoteSuite) TestFetchWildcardTags(c *C) {
 	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		URLs: []string{s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())},
@@ -653,6 +788,117 @@ func (s *RemoteSuite) TestPushNewReferenceAndDeleteInBatch(c *C) {
 	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
 }
 
+func (s *RemoteSuite) TestPushAtomic(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: fs.Root(),
+	})
+	c.Assert(err, IsNil)
+
+	writeHook(c, url, "update", `[ "$1" != refs/heads/rejected ]`)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+
+	err = remote.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{
+			"refs/heads/master:refs/heads/accepted",
+			"refs/heads/master:refs/heads/rejected",
+		},
+		Atomic: true,
+	})
+	c.Assert(err, NotNil)
+
+	_, err = server.Storer.Reference(plumbing.ReferenceName("refs/heads/accepted"))
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	_, err = server.Storer.Reference(plumbing.ReferenceName("refs/heads/rejected"))
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RemoteSuite) TestPushAtomicNotSupported(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: fs.Root(),
+	})
+	c.Assert(err, IsNil)
+
+	setRawConfig(c, server, "receive", "advertiseAtomic", "false")
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+
+	err = remote.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/new"},
+		Atomic:   true,
+	})
+	c.Assert(err, Equals, ErrAtomicNotSupported)
+}
+
+func (s *RemoteSuite) TestPushOptions(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: fs.Root(),
+	})
+	c.Assert(err, IsNil)
+
+	setRawConfig(c, server, "receive", "advertisePushOptions", "true")
+	writeHook(c, url, "pre-receive",
+		`[ "$GIT_PUSH_OPTION_COUNT" = 2 ] && [ "$GIT_PUSH_OPTION_0" = ci.skip ] && [ "$GIT_PUSH_OPTION_1" = reviewer=foo ]`)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+
+	err = remote.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/new"},
+		Options:  []string{"ci.skip", "reviewer=foo"},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = server.Storer.Reference(plumbing.ReferenceName("refs/heads/new"))
+	c.Assert(err, IsNil)
+}
+
+func (s *RemoteSuite) TestPushOptionsNotSupported(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	url := c.MkDir()
+	_, err := PlainClone(url, true, &CloneOptions{
+		URL: fs.Root(),
+	})
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	remote, err := r.Remote(DefaultRemoteName)
+	c.Assert(err, IsNil)
+
+	err = remote.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/new"},
+		Options:  []string{"ci.skip"},
+	})
+	c.Assert(err, Equals, ErrPushOptionsNotSupported)
+}
+
 func (s *RemoteSuite) TestPushInvalidEndpoint(c *C) {
 	r := NewRemote(nil, &config.RemoteConfig{Name: "foo", URLs: []string{"http://\\"}})
 	err := r.Push(&PushOptions{RemoteName: "foo"})
@@ -703,6 +949,112 @@ func (s *RemoteSuite) TestPushWrongRemoteName(c *C) {
 	c.Assert(err, ErrorMatches, ".*remote names don't match.*")
 }
 
+// writeHook writes a git hook, running the given shell script, to the bare
+// repository at dir.
+func writeHook(c *C, dir, name, script string) {
+	hooks := filepath.Join(dir, "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+
+	err := ioutil.WriteFile(filepath.Join(hooks, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	c.Assert(err, IsNil)
+}
+
+// setRawConfig sets an option of the config of a repository.
+func setRawConfig(c *C, r *Repository, section, key, value string) {
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+
+	cfg.Raw.Section(section).SetOption(key, value)
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+}
+
+// commitLongJSON commits a change to the json/long.json file of a repository
+// cloned from the basic fixture.
+func commitLongJSON(c *C, r *Repository) plumbing.Hash {
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	f, err := w.Filesystem.OpenFile("json/long.json", os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte("\n"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	_, err = w.Add("json/long.json")
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("long.json\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *RemoteSuite) TestFetchThinPack(c *C) {
+	origin, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	dir, err := origin.Worktree()
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), true, &CloneOptions{
+		URL: dir.Filesystem.Root(),
+	})
+	c.Assert(err, IsNil)
+
+	h := commitLongJSON(c, origin)
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+
+	// the bases missing in the thin pack are read from the local storage
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	file, err := commit.File("json/long.json")
+	c.Assert(err, IsNil)
+	c.Assert(file.Size, Equals, int64(217849))
+}
+
+func (s *RemoteSuite) TestPushThinPack(c *C) {
+	url := c.MkDir()
+	server, err := PlainClone(url, true, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	h := commitLongJSON(c, r)
+	c.Assert(r.Push(&PushOptions{}), IsNil)
+
+	commit, err := server.CommitObject(h)
+	c.Assert(err, IsNil)
+	file, err := commit.File("json/long.json")
+	c.Assert(err, IsNil)
+	c.Assert(file.Size, Equals, int64(217849))
+}
+
+func (s *RemoteSuite) TestThinPackBases(c *C) {
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	h := commitLongJSON(c, r)
+	hs, err := revlist.Objects(r.Storer, []plumbing.Hash{h},
+		[]plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")})
+	c.Assert(err, IsNil)
+	c.Assert(hs, HasLen, 4)
+
+	bases, err := thinPackBases(r.Storer, hs)
+	c.Assert(err, IsNil)
+	c.Assert(bases, DeepEquals, []plumbing.Hash{
+		// the root tree, json/long.json and the json tree of the parent
+		plumbing.NewHash("a8d315b2b1c615d43042c3a62402b8a54288cf5c"),
+		plumbing.NewHash("49c6bb89b17060d7b4deacb7b338fcc6ea2352a9"),
+		plumbing.NewHash("5a877e6a906a2743ad6e45d99c1793642aaf8eda"),
+	})
+}
+
 func (s *RemoteSuite) TestGetHaves(c *C) {
 	f := fixtures.Basic().One()
 	sto := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
@@ -793,13 +1145,9 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	c.Assert(len(shallows), Equals, 0)
 
 	resp := new(packp.UploadPackResponse)
-	o := &FetchOptions{
-		Depth: 1,
-	}
-
 	for _, t := range tests {
 		resp.Shallows = t.hashes
-		err = remote.updateShallow(o, resp)
+		err = remote.updateShallow(resp)
 		c.Assert(err, IsNil)
 
 		shallow, err := remote.s.Shallow()
@@ -809,6 +1157,144 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	}
 }
 
+func (s *RemoteSuite) TestUpdateShallowsUnshallow(c *C) {
+	sto := filesystem.NewStorage(osfs.New(c.MkDir()), cache.NewObjectLRUDefault())
+	remote := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+	})
+
+	hashes := []plumbing.Hash{
+		plumbing.NewHash("0000000000000000000000000000000000000001"),
+		plumbing.NewHash("0000000000000000000000000000000000000002"),
+	}
+
+	c.Assert(sto.SetShallow(hashes), IsNil)
+
+	err := remote.updateShallow(&packp.UploadPackResponse{
+		ShallowUpdate: packp.ShallowUpdate{Unshallows: hashes[:1]},
+	})
+	c.Assert(err, IsNil)
+
+	shallows, err := sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallows, DeepEquals, hashes[1:])
+
+	err = remote.updateShallow(&packp.UploadPackResponse{
+		ShallowUpdate: packp.ShallowUpdate{Unshallows: hashes[1:]},
+	})
+	c.Assert(err, IsNil)
+
+	shallows, err = sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallows, HasLen, 0)
+
+	_, err = sto.Filesystem().Stat("shallow")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RemoteSuite) testFetchShallow(c *C, r *Remote, o *FetchOptions, shallows ...string) {
+	o.RefSpecs = []config.RefSpec{"+refs/heads/master:refs/remotes/origin/master"}
+	c.Assert(r.Fetch(o), IsNil)
+
+	var expected []plumbing.Hash
+	for _, h := range shallows {
+		expected = append(expected, plumbing.NewHash(h))
+	}
+
+	current, err := r.s.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(current, DeepEquals, expected)
+}
+
+func (s *RemoteSuite) TestFetchDeepen(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	s.testFetchShallow(c, r, &FetchOptions{Depth: 1},
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	)
+
+	s.testFetchShallow(c, r, &FetchOptions{Deepen: 2},
+		"af2d6a6954d532f8ffb47615169c8fdf9d383a1a",
+	)
+
+	_, err := r.s.EncodedObject(plumbing.CommitObject,
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	c.Assert(err, IsNil)
+}
+
+func (s *RemoteSuite) TestFetchUnshallow(c *C) {
+	sto := filesystem.NewStorage(osfs.New(c.MkDir()), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	s.testFetchShallow(c, r, &FetchOptions{Depth: 1},
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	)
+
+	s.testFetchShallow(c, r, &FetchOptions{Unshallow: true})
+
+	_, err := sto.Filesystem().Stat("shallow")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = r.s.EncodedObject(plumbing.CommitObject,
+		plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"))
+	c.Assert(err, IsNil)
+}
+
+func (s *RemoteSuite) TestFetchShallowSince(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	since := time.Date(2015, 3, 31, 11, 50, 0, 0, time.UTC)
+	s.testFetchShallow(c, r, &FetchOptions{ShallowSince: since},
+		"af2d6a6954d532f8ffb47615169c8fdf9d383a1a",
+	)
+
+	_, err := r.s.EncodedObject(plumbing.CommitObject,
+		plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *RemoteSuite) TestFetchShallowExclude(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	s.testFetchShallow(c, r, &FetchOptions{ShallowExclude: []string{"refs/heads/branch"}},
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+	)
+
+	_, err := r.s.EncodedObject(plumbing.CommitObject,
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *RemoteSuite) TestFetchShallowNotSupported(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: DefaultRemoteName,
+	})
+
+	ar := packp.NewAdvRefs()
+	ar.Capabilities.Add(capability.Shallow)
+
+	_, err := r.newUploadPackRequest(&FetchOptions{Deepen: 1}, ar)
+	c.Assert(err, Equals, ErrShallowNotSupported)
+
+	ar.Capabilities.Add(capability.DeepenRelative)
+	req, err := r.newUploadPackRequest(&FetchOptions{Deepen: 1}, ar)
+	c.Assert(err, IsNil)
+	c.Assert(req.Depth, Equals, packp.DepthCommits(1))
+	c.Assert(req.Capabilities.Supports(capability.DeepenRelative), Equals, true)
+}
+
 func (s *RemoteSuite) TestUseRefDeltas(c *C) {
 	url := c.MkDir()
 	_, err := PlainInit(url, true)
@@ -10,19 +10,24 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4/config"
-	"gopkg.in/src-d/go-git.v4/internal/revision"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	commitgraph_fmt "gopkg.in/src-d/go-git.v4/plumbing/format/commitgraph"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/object/commitgraph"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 
 	"gopkg.in/src-d/go-billy.v4"
@@ -62,6 +67,10 @@ type Repository struct {
 
 	r  map[string]*Remote
 	wt billy.Filesystem
+
+	// promisorAuth is the authentication used to fetch the objects missing
+	// from a partial clone, the one of its last clone or fetch.
+	promisorAuth transport.AuthMethod
 }
 
 // Init creates an empty git repository, based on the given Storer and worktree.
@@ -252,6 +261,11 @@ func PlainOpenWithOptions(path string, o *PlainOpenOptions) (*Repository, error)
 		return nil, err
 	}
 
+	dot, err = dotGitCommonDirectory(dot)
+	if err != nil {
+		return nil, err
+	}
+
 	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
 
 	return Open(s, wt)
@@ -327,6 +341,43 @@ func dotGitFileToOSFilesystem(path string, fs billy.Filesystem) (bfs billy.Files
 	return osfs.New(fs.Join(path, gitdir)), nil
 }
 
+// dotGitCommonDirectory returns the git directory joined with the common
+// directory of the repository when the git directory has a commondir file,
+// as the ones of the linked worktrees created by `git worktree add`.
+func dotGitCommonDirectory(fs billy.Filesystem) (bfs billy.Filesystem, err error) {
+	f, err := fs.Open(commonDirFile)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := strings.TrimSpace(string(b))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(fs.Root(), dir)
+	}
+
+	common := osfs.New(dir)
+	if _, err := common.Stat(""); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRepositoryNotExists
+		}
+
+		return nil, err
+	}
+
+	return dotgit.NewRepositoryFilesystem(fs, common), nil
+}
+
 // PlainClone a repository into the path with the given options, isBare defines
 // if the new repository will be bare or normal. If the path is not empty
 // ErrRepositoryAlreadyExists is returned.
@@ -732,9 +783,11 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	}
 
 	c := &config.RemoteConfig{
-		Name:  o.RemoteName,
-		URLs:  []string{o.URL},
-		Fetch: r.cloneRefSpec(o),
+		Name:               o.RemoteName,
+		URLs:               []string{o.URL},
+		Fetch:              r.cloneRefSpec(o),
+		Promisor:           !o.Filter.IsZero(),
+		PartialCloneFilter: string(o.Filter),
 	}
 
 	if _, err := r.CreateRemote(c); err != nil {
@@ -742,17 +795,23 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	}
 
 	ref, err := r.fetchAndUpdateReferences(ctx, &FetchOptions{
-		RefSpecs:   c.Fetch,
-		Depth:      o.Depth,
-		Auth:       o.Auth,
-		Progress:   o.Progress,
-		Tags:       o.Tags,
-		RemoteName: o.RemoteName,
+		RefSpecs:        c.Fetch,
+		Depth:           o.Depth,
+		Filter:          o.Filter,
+		Auth:            o.Auth,
+		Progress:        o.Progress,
+		Tags:            o.Tags,
+		RemoteName:      o.RemoteName,
+		ProtocolVersion: o.ProtocolVersion,
 	}, o.ReferenceName)
 	if err != nil {
 		return err
 	}
 
+	if c.Promisor {
+		r.promisorAuth = o.Auth
+	}
+
 	if r.wt != nil && !o.NoCheckout {
 		w, err := r.Worktree()
 		if err != nil {
@@ -764,10 +823,10 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 			return err
 		}
 
-		if err := w.Reset(&ResetOptions{
+		if err := w.resetContext(ctx, &ResetOptions{
 			Mode:   MergeReset,
 			Commit: head.Hash(),
-		}); err != nil {
+		}, ""); err != nil {
 			return err
 		}
 
@@ -888,7 +947,8 @@ func (r *Repository) fetchAndUpdateReferences(
 		return nil, err
 	}
 
-	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef)
+	msg := "clone: from " + remote.c.URLs[0]
+	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef, msg)
 	if err != nil {
 		return nil, err
 	}
@@ -901,7 +961,7 @@ func (r *Repository) fetchAndUpdateReferences(
 }
 
 func (r *Repository) updateReferences(spec []config.RefSpec,
-	resolvedRef *plumbing.Reference) (updated bool, err error) {
+	resolvedRef *plumbing.Reference, msg string) (updated bool, err error) {
 
 	if !resolvedRef.Name().IsBranch() {
 		// Detached HEAD mode
@@ -910,7 +970,7 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 			return false, err
 		}
 		head := plumbing.NewHashReference(plumbing.HEAD, h)
-		return updateReferenceStorerIfNeeded(r.Storer, head)
+		return r.updateReferenceAndLog(head, msg)
 	}
 
 	refs := []*plumbing.Reference{
@@ -923,7 +983,7 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 	refs = append(refs, r.calculateRemoteHeadReference(spec, resolvedRef)...)
 
 	for _, ref := range refs {
-		u, err := updateReferenceStorerIfNeeded(r.Storer, ref)
+		u, err := r.updateReferenceAndLog(ref, msg)
 		if err != nil {
 			return updated, err
 		}
@@ -959,6 +1019,12 @@ func (r *Repository) calculateRemoteHeadReference(spec []config.RefSpec,
 	return refs
 }
 
+// updateReferenceAndLog updates the given reference if needed, recording the
+// update in the reflog with the given message.
+func (r *Repository) updateReferenceAndLog(ref *plumbing.Reference, msg string) (bool, error) {
+	return updateReferenceIfNeeded(r.Storer, ref, nil, msg)
+}
+
 func checkAndUpdateReferenceStorerIfNeeded(
 	s storer.ReferenceStorer, r, old *plumbing.Reference) (
 	updated bool, err error) {
@@ -1012,7 +1078,18 @@ func (r *Repository) FetchContext(ctx context.Context, o *FetchOptions) error {
 		return err
 	}
 
-	return remote.FetchContext(ctx, o)
+	err = remote.FetchContext(ctx, o)
+	r.rememberPromisorAuth(remote, o.Auth)
+	return err
+}
+
+// rememberPromisorAuth keeps the given authentication, used with the remote,
+// to fetch the objects missing from a partial clone, if the remote is its
+// promisor remote.
+func (r *Repository) rememberPromisorAuth(remote *Remote, auth transport.AuthMethod) {
+	if auth != nil && remote.c.Promisor {
+		r.promisorAuth = auth
+	}
 }
 
 // Push performs a push to the remote. Returns NoErrAlreadyUpToDate if
@@ -1044,18 +1121,36 @@ func (r *Repository) PushContext(ctx context.Context, o *PushOptions) error {
 
 // Log returns the commit history from the given LogOptions.
 func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	topo := o.Order == LogOrderTopo || o.Order == LogOrderAuthorDate
 	fn := commitIterFunc(o.Order)
-	if fn == nil {
+	if fn == nil && !topo {
 		return nil, fmt.Errorf("invalid Order=%v", o.Order)
 	}
 
+	if o.FirstParent {
+		// the first parents are already in topological order
+		topo = false
+		fn = func(c *object.Commit) object.CommitIter {
+			return object.NewCommitFirstParentIter(c, nil)
+		}
+	}
+
 	var (
 		it  object.CommitIter
 		err error
 	)
-	if o.All {
+	switch {
+	case len(o.Revisions) != 0:
+		it, err = r.logRevisions(o)
+	case topo:
+		it, err = r.logTopoOrder(o.From, o.All, o.Order)
+	case o.All:
 		it, err = r.logAll(fn)
-	} else {
+	default:
 		it, err = r.log(o.From, fn)
 	}
 
@@ -1063,9 +1158,20 @@ func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
 		return nil, err
 	}
 
+	if len(o.Revisions) != 0 {
+		return it, nil
+	}
+
 	if o.FileName != nil {
 		// for `git log --all` also check parent (if the next commit comes from the real parent)
-		it = r.logWithFile(*o.FileName, it, o.All)
+		it = r.logWithFile(*o.FileName, it, o.All, o.Follow)
+	}
+
+	if f := newLogFilter(o); f != nil {
+		// walking a single history by committer time, the commits older
+		// than Since are the last ones
+		sorted := !o.All && (o.FirstParent || o.Order == LogOrderCommitterTime)
+		it = newFilterCommitIter(it, f, sorted)
 	}
 
 	return it, nil
@@ -1093,7 +1199,177 @@ func (r *Repository) logAll(commitIterFunc func(*object.Commit) object.CommitIte
 	return object.NewCommitAllIter(r.Storer, commitIterFunc)
 }
 
-func (*Repository) logWithFile(fileName string, commitIter object.CommitIter, checkParent bool) object.CommitIter {
+// logTopoOrder returns the commits reachable from the given one, or from HEAD
+// and all the references with all, in the topological order of order. With
+// all, the references are walked by committer time, the newest first.
+func (r *Repository) logTopoOrder(from plumbing.Hash, all bool, order LogOrder) (object.CommitIter, error) {
+	hashes, err := r.logTips(from, all)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := r.commitNodeIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []commitgraph.CommitNode
+	for _, h := range hashes {
+		node, err := index.Get(h)
+		if err != nil {
+			return nil, err
+		}
+
+		tips = append(tips, node)
+	}
+
+	if all {
+		// as git does, the tips are walked by committer time, the newest
+		// first, and not from HEAD
+		sort.SliceStable(tips, func(i, j int) bool {
+			return tips[i].CommitTime().After(tips[j].CommitTime())
+		})
+	}
+
+	var iter commitgraph.CommitNodeIter
+	if order == LogOrderAuthorDate {
+		iter, err = commitgraph.NewCommitNodeIterAuthorDateOrder(tips, nil, nil)
+	} else {
+		iter, err = commitgraph.NewCommitNodeIterTopoOrder(tips, nil, nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &commitNodeCommitIter{iter}, nil
+}
+
+// logTips returns the commit a log walk starts from, HEAD by default, or with
+// all, HEAD and the commits of all the references, as NewCommitAllIter does.
+func (r *Repository) logTips(from plumbing.Hash, all bool) ([]plumbing.Hash, error) {
+	if !all {
+		if from != plumbing.ZeroHash {
+			return []plumbing.Hash{from}, nil
+		}
+
+		head, err := r.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		return []plumbing.Hash{head.Hash()}, nil
+	}
+
+	var hashes []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	add := func(ref *plumbing.Reference) {
+		h := ref.Hash()
+		if seen[h] {
+			return
+		}
+
+		if _, err := object.GetCommit(r.Storer, h); err != nil {
+			// not a commit, skip it
+			return
+		}
+
+		seen[h] = true
+		hashes = append(hashes, h)
+	}
+
+	head, err := storer.ResolveReference(r.Storer, plumbing.HEAD)
+	if err == nil {
+		add(head)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			add(ref)
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+// commitNodeIndex returns the index of the commit nodes of the repository,
+// read from its commit-graph file if there is one, the generation numbers of
+// the nodes being available then, or else from its objects.
+func (r *Repository) commitNodeIndex() (idx commitgraph.CommitNodeIndex, err error) {
+	s, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return commitgraph.NewObjectCommitNodeIndex(r.Storer), nil
+	}
+
+	f, err := s.Filesystem().Open(path.Join("objects", "info", "commit-graph"))
+	if os.IsNotExist(err) {
+		return commitgraph.NewObjectCommitNodeIndex(r.Storer), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	// the file is read at once, to be closed before the nodes are walked
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := commitgraph_fmt.OpenFileIndex(bytes.NewReader(b))
+	if err != nil {
+		// as git does, an invalid commit-graph file is ignored
+		return commitgraph.NewObjectCommitNodeIndex(r.Storer), nil
+	}
+
+	return commitgraph.NewGraphCommitNodeIndex(index, r.Storer), nil
+}
+
+// commitNodeCommitIter returns the commits of the nodes of a CommitNodeIter.
+type commitNodeCommitIter struct {
+	iter commitgraph.CommitNodeIter
+}
+
+func (it *commitNodeCommitIter) Next() (*object.Commit, error) {
+	node, err := it.iter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return node.Commit()
+}
+
+func (it *commitNodeCommitIter) ForEach(cb func(*object.Commit) error) error {
+	return it.iter.ForEach(func(node commitgraph.CommitNode) error {
+		c, err := node.Commit()
+		if err != nil {
+			return err
+		}
+
+		return cb(c)
+	})
+}
+
+func (it *commitNodeCommitIter) Close() {
+	it.iter.Close()
+}
+
+func (*Repository) logWithFile(fileName string, commitIter object.CommitIter, checkParent, follow bool) object.CommitIter {
+	if follow {
+		return object.NewCommitFileFollowIterFromIter(fileName, commitIter, checkParent)
+	}
+
 	return object.NewCommitFileIterFromIter(fileName, commitIter, checkParent)
 }
 
@@ -1220,11 +1496,141 @@ func (r *Repository) CommitObjects() (object.CommitIter, error) {
 }
 
 // BlobObject returns a Blob with the given hash. If not found
-// plumbing.ErrObjectNotFound is returned.
+// plumbing.ErrObjectNotFound is returned. The blobs missing from a partial
+// clone are fetched from the promisor remote.
 func (r *Repository) BlobObject(h plumbing.Hash) (*object.Blob, error) {
+	b, err := object.GetBlob(r.Storer, h)
+	if err != plumbing.ErrObjectNotFound {
+		return b, err
+	}
+
+	if err := r.fetchPromisedObjects(context.Background(), []plumbing.Hash{h}); err != nil {
+		return nil, err
+	}
+
 	return object.GetBlob(r.Storer, h)
 }
 
+// FetchMissing fetches the given objects, missing from a partial clone, from
+// its promisor remote, nothing is fetched if the repository has no promisor
+// remote. The objects already present are not fetched again.
+//
+// The given authentication is kept to fetch the objects missing afterwards,
+// when they are read or checked out. If nil, the authentication used by the
+// last clone or fetch from the promisor remote is used, if any.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects to the
+// transport operations.
+func (r *Repository) FetchMissing(ctx context.Context, auth transport.AuthMethod, hashes []plumbing.Hash) error {
+	if auth != nil {
+		r.promisorAuth = auth
+	}
+
+	var missing []plumbing.Hash
+	for _, h := range hashes {
+		err := r.Storer.HasEncodedObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			missing = append(missing, h)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.fetchPromisedObjects(ctx, missing)
+}
+
+// fetchPromisedObjects fetches the given objects from the promisor remote of
+// a partial clone, nothing is fetched if the repository has no promisor
+// remote. The fetch uses the authentication kept by the repository, if any.
+func (r *Repository) fetchPromisedObjects(ctx context.Context, hashes []plumbing.Hash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	remote, err := r.promisorRemote()
+	if err != nil || remote == nil {
+		return err
+	}
+
+	return remote.fetchObjects(ctx, r.promisorAuth, hashes)
+}
+
+// fetchMissingTrees fetches the given tree and its subtrees, missing from a
+// partial clone with a tree:<depth> filter, from its promisor remote, a level
+// of the tree being fetched at once. Nothing is read if the repository has no
+// promisor remote.
+func (r *Repository) fetchMissingTrees(ctx context.Context, h plumbing.Hash) error {
+	remote, err := r.promisorRemote()
+	if err != nil || remote == nil {
+		return err
+	}
+
+	pending := []plumbing.Hash{h}
+	for len(pending) != 0 {
+		var missing []plumbing.Hash
+		for _, h := range pending {
+			err := r.Storer.HasEncodedObject(h)
+			if err == plumbing.ErrObjectNotFound {
+				missing = append(missing, h)
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := r.fetchPromisedObjects(ctx, missing); err != nil {
+			return err
+		}
+
+		var next []plumbing.Hash
+		for _, h := range pending {
+			t, err := object.GetTree(r.Storer, h)
+			if err != nil {
+				return err
+			}
+
+			for _, e := range t.Entries {
+				if e.Mode == filemode.Dir {
+					next = append(next, e.Hash)
+				}
+			}
+		}
+
+		pending = next
+	}
+
+	return nil
+}
+
+// promisorRemote returns the first promisor remote of the repository, by
+// name, or nil if there is none.
+func (r *Repository) promisorRemote() (*Remote, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name, c := range cfg.Remotes {
+		if c.Promisor {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(names)
+	return NewRemote(r.Storer, cfg.Remotes[names[0]]), nil
+}
+
 // BlobObjects returns an unsorted BlobIter with all the blobs in the repository.
 func (r *Repository) BlobObjects() (*object.BlobIter, error) {
 	iter, err := r.Storer.IterEncodedObjects(plumbing.BlobObject)
@@ -1307,148 +1713,24 @@ func (r *Repository) Worktree() (*Worktree, error) {
 }
 
 // ResolveRevision resolves revision to corresponding hash. It will always
-// resolve to a commit hash, not a tree or annotated tag.
+// resolve to a commit hash, not a tree or annotated tag, see
+// ResolveRevisionObject to resolve a revision to any object.
 //
 // Implemented resolvers : HEAD, branch, tag, heads/branch, refs/heads/branch,
-// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug})
+// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}),
+// and the ones of ResolveRevisionObject.
 func (r *Repository) ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, error) {
-	p := revision.NewParserFromString(string(rev))
-
-	items, err := p.Parse()
-
+	obj, err := r.ResolveRevisionObject(rev)
 	if err != nil {
 		return nil, err
 	}
 
-	var commit *object.Commit
-
-	for _, item := range items {
-		switch item.(type) {
-		case revision.Ref:
-			revisionRef := item.(revision.Ref)
-
-			var tryHashes []plumbing.Hash
-
-			maybeHash := plumbing.NewHash(string(revisionRef))
-
-			if !maybeHash.IsZero() {
-				tryHashes = append(tryHashes, maybeHash)
-			}
-
-			for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
-				ref, err := storer.ResolveReference(r.Storer, plumbing.ReferenceName(fmt.Sprintf(rule, revisionRef)))
-
-				if err == nil {
-					tryHashes = append(tryHashes, ref.Hash())
-					break
-				}
-			}
-
-			// in ambiguous cases, `git rev-parse` will emit a warning, but
-			// will always return the oid in preference to a ref; we don't have
-			// the ability to emit a warning here, so (for speed purposes)
-			// don't bother to detect the ambiguity either, just return in the
-			// priority that git would.
-			gotOne := false
-			for _, hash := range tryHashes {
-				commitObj, err := r.CommitObject(hash)
-				if err == nil {
-					commit = commitObj
-					gotOne = true
-					break
-				}
-
-				tagObj, err := r.TagObject(hash)
-				if err == nil {
-					// If the tag target lookup fails here, this most likely
-					// represents some sort of repo corruption, so let the
-					// error bubble up.
-					tagCommit, err := tagObj.Commit()
-					if err != nil {
-						return &plumbing.ZeroHash, err
-					}
-					commit = tagCommit
-					gotOne = true
-					break
-				}
-			}
-
-			if !gotOne {
-				return &plumbing.ZeroHash, plumbing.ErrReferenceNotFound
-			}
-
-		case revision.CaretPath:
-			depth := item.(revision.CaretPath).Depth
-
-			if depth == 0 {
-				break
-			}
-
-			iter := commit.Parents()
-
-			c, err := iter.Next()
-
-			if err != nil {
-				return &plumbing.ZeroHash, err
-			}
-
-			if depth == 1 {
-				commit = c
-
-				break
-			}
-
-			c, err = iter.Next()
-
-			if err != nil {
-				return &plumbing.ZeroHash, err
-			}
-
-			commit = c
-		case revision.TildePath:
-			for i := 0; i < item.(revision.TildePath).Depth; i++ {
-				c, err := commit.Parents().Next()
-
-				if err != nil {
-					return &plumbing.ZeroHash, err
-				}
-
-				commit = c
-			}
-		case revision.CaretReg:
-			history := object.NewCommitPreorderIter(commit, nil, nil)
-
-			re := item.(revision.CaretReg).Regexp
-			negate := item.(revision.CaretReg).Negate
-
-			var c *object.Commit
-
-			err := history.ForEach(func(hc *object.Commit) error {
-				if !negate && re.MatchString(hc.Message) {
-					c = hc
-					return storer.ErrStop
-				}
-
-				if negate && !re.MatchString(hc.Message) {
-					c = hc
-					return storer.ErrStop
-				}
-
-				return nil
-			})
-			if err != nil {
-				return &plumbing.ZeroHash, err
-			}
-
-			if c == nil {
-				return &plumbing.ZeroHash, fmt.Errorf(`No commit message match regexp : "%s"`, re.String())
-			}
-
-			commit = c
-		}
+	c, err := peelToCommit(obj)
+	if err != nil {
+		return nil, err
 	}
 
-	return &commit.Hash, nil
+	return &c.Hash, nil
 }
 
 type RepackConfig struct {
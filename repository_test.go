@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,9 +20,12 @@ import (
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
@@ -1010,6 +1014,227 @@ func (s *RepositorySuite) TestCloneSingleTag(c *C) {
 	c.Assert(originRemote.Fetch[0].String(), Equals, "+refs/tags/commit-tag:refs/tags/commit-tag")
 }
 
+// newPartialCloneSource returns the URL of a bare copy of the given
+// repository, allowing the filters of partial clones.
+func newPartialCloneSource(c *C, url string) string {
+	dir := c.MkDir()
+	r, err := PlainClone(dir, true, &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("uploadpack").SetOption("allowfilter", "true")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	return dir
+}
+
+func (s *RepositorySuite) TestClonePartial(c *C) {
+	url := newPartialCloneSource(c, s.GetBasicLocalRepositoryURL())
+
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{
+		URL:    url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes[DefaultRemoteName].Promisor, Equals, true)
+	c.Assert(cfg.Remotes[DefaultRemoteName].PartialCloneFilter, Equals, "blob:none")
+
+	// the blobs of HEAD are fetched by the checkout
+	content, err := ioutil.ReadFile(filepath.Join(dir, "LICENSE"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, "(?s)The MIT License.*")
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	// the packfiles of the clone and of the checkout are promisor packfiles
+	packs, err := r.Storer.(storer.PackedObjectStorer).ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 2)
+
+	dot := r.Storer.(*filesystem.Storage).Filesystem()
+	for _, h := range packs {
+		_, err := dot.Stat(fmt.Sprintf("objects/pack/pack-%s.promisor", h))
+		c.Assert(err, IsNil)
+	}
+
+}
+
+func (s *RepositorySuite) TestClonePartialTreeDepth(c *C) {
+	url := newPartialCloneSource(c, s.GetBasicLocalRepositoryURL())
+
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{
+		URL:    url,
+		Filter: packp.FilterTreeDepth(0),
+	})
+	c.Assert(err, IsNil)
+
+	// the trees of HEAD, and their blobs, are fetched by the checkout
+	content, err := ioutil.ReadFile(filepath.Join(dir, "go", "example.go"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, "(?s)package harvesterd.*")
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	err = w.Checkout(&CheckoutOptions{
+		Hash: plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(filepath.Join(dir, "go", "example.go"))
+	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(dir, "vendor"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RepositorySuite) TestClonePartialBlobObject(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL:        newPartialCloneSource(c, s.GetBasicLocalRepositoryURL()),
+		Filter:     packp.FilterBlobNone(),
+		NoCheckout: true,
+	})
+	c.Assert(err, IsNil)
+
+	blobs, err := r.BlobObjects()
+	c.Assert(err, IsNil)
+	c.Assert(blobs.ForEach(func(*object.Blob) error {
+		c.Fatal("unexpected blob")
+		return nil
+	}), IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	e, err := tree.FindEntry("vendor/foo.go")
+	c.Assert(err, IsNil)
+
+	blob, err := r.BlobObject(e.Hash)
+	c.Assert(err, IsNil)
+	c.Assert(blob.Hash, Equals, e.Hash)
+	c.Assert(r.Storer.HasEncodedObject(e.Hash), IsNil)
+}
+
+// authRecorder is a transport recording the authentication and the version of
+// the protocol of the upload-pack sessions, served by the file transport.
+type authRecorder struct {
+	transport.Transport
+	auths    []transport.AuthMethod
+	versions []transport.ProtocolVersion
+}
+
+func (t *authRecorder) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	t.auths = append(t.auths, auth)
+	t.versions = append(t.versions, transport.ProtocolV0)
+	return t.Transport.NewUploadPackSession(ep, nil)
+}
+
+func (t *authRecorder) NewUploadPackSessionVersion(ep *transport.Endpoint, auth transport.AuthMethod, v transport.ProtocolVersion) (transport.UploadPackSession, error) {
+	t.auths = append(t.auths, auth)
+	t.versions = append(t.versions, v)
+	return t.Transport.(transport.ProtocolVersionTransport).NewUploadPackSessionVersion(ep, nil, v)
+}
+
+type recordedAuth struct{}
+
+func (recordedAuth) Name() string   { return "recorded" }
+func (recordedAuth) String() string { return "recorded" }
+
+func (s *RepositorySuite) TestClonePartialFetchMissingAuth(c *C) {
+	rec := &authRecorder{Transport: client.Protocols["file"]}
+	client.InstallProtocol("authrec", rec)
+	defer client.InstallProtocol("authrec", nil)
+
+	url := newPartialCloneSource(c, s.GetBasicLocalRepositoryURL())
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL:        "authrec://" + url,
+		Auth:       recordedAuth{},
+		Filter:     packp.FilterBlobNone(),
+		NoCheckout: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(rec.auths, HasLen, 1)
+
+	// the blobs read are fetched with the authentication of the clone
+	blob := plumbing.NewHash("9a48f23120e880dfbe41f7c9b7b708e9ee62a492")
+	_, err = r.BlobObject(blob)
+	c.Assert(err, IsNil)
+	c.Assert(rec.auths, DeepEquals, []transport.AuthMethod{recordedAuth{}, recordedAuth{}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	license := plumbing.NewHash("c192bd6a24ea1ab01d78686e417c8bdc7c3d197f")
+	err = r.FetchMissing(ctx, nil, []plumbing.Hash{license})
+	c.Assert(err, NotNil)
+	c.Assert(r.Storer.HasEncodedObject(license), Equals, plumbing.ErrObjectNotFound)
+
+	// the present objects are not fetched again
+	err = r.FetchMissing(context.Background(), nil, []plumbing.Hash{blob, license})
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.HasEncodedObject(license), IsNil)
+	c.Assert(rec.auths, HasLen, 4)
+	c.Assert(rec.auths[3], Equals, recordedAuth{})
+}
+
+func (s *RepositorySuite) TestCloneProtocolVersion(c *C) {
+	rec := &authRecorder{Transport: client.Protocols["file"]}
+	client.InstallProtocol("authrec", rec)
+	defer client.InstallProtocol("authrec", nil)
+
+	url := "authrec://" + s.GetBasicLocalRepositoryURL()
+	for _, v := range []transport.ProtocolVersion{transport.ProtocolV0, transport.ProtocolV2} {
+		r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+			URL:             url,
+			ProtocolVersion: v,
+		})
+		c.Assert(err, IsNil)
+
+		ref, err := r.Reference("refs/remotes/origin/branch", false)
+		c.Assert(err, IsNil)
+		c.Assert(ref.Hash().String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	}
+
+	c.Assert(rec.versions, DeepEquals, []transport.ProtocolVersion{
+		transport.ProtocolV0, transport.ProtocolV2,
+	})
+}
+
+func (s *RepositorySuite) TestCloneFilterNotSupported(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL:    s.GetBasicLocalRepositoryURL(),
+		Filter: packp.FilterTreeDepth(0),
+	})
+	c.Assert(err, Equals, ErrFilterNotSupported)
+}
+
+func (s *RepositorySuite) TestCloneInvalidFilter(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+	err := r.clone(context.Background(), &CloneOptions{
+		URL:    s.GetBasicLocalRepositoryURL(),
+		Filter: packp.Filter("blob:limit=foo"),
+	})
+	c.Assert(err, NotNil)
+}
+
 func (s *RepositorySuite) TestCloneDetachedHEAD(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -1414,6 +1639,85 @@ func (s *RepositorySuite) TestLogAllOrderByTime(c *C) {
 	cIter.Close()
 }
 
+func (s *RepositorySuite) TestLogTopoOrder(c *C) {
+	r := openBasicRepository(c)
+
+	for _, t := range []struct {
+		o        *LogOptions
+		expected []string
+	}{
+		{&LogOptions{Order: LogOrderTopo}, []string{"6ecf0ef", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "b8e471f", "35e8510", "b029517"}},
+		{&LogOptions{Order: LogOrderTopo, All: true}, []string{"6ecf0ef", "e8d3ffa", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "b8e471f", "35e8510", "b029517"}},
+		{&LogOptions{Order: LogOrderAuthorDate, All: true}, []string{"6ecf0ef", "e8d3ffa", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "35e8510", "b8e471f", "b029517"}},
+		{&LogOptions{Order: LogOrderTopo, From: plumbing.NewHash("a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69")}, []string{"a5b8b09", "b8e471f", "b029517"}},
+		{&LogOptions{Order: LogOrderTopo, FirstParent: true}, []string{"6ecf0ef", "918c48b", "af2d6a6", "1669dce", "35e8510", "b029517"}},
+	} {
+		iter, err := r.Log(t.o)
+		c.Assert(err, IsNil)
+
+		var hashes []string
+		err = iter.ForEach(func(c *object.Commit) error {
+			hashes = append(hashes, c.Hash.String()[:7])
+			return nil
+		})
+		c.Assert(err, IsNil)
+		c.Assert(hashes, DeepEquals, t.expected)
+	}
+}
+
+func (s *RepositorySuite) TestLogTopoOrderAllNewestFirst(c *C) {
+	r := openBasicRepository(c)
+
+	// HEAD is older than master, which is walked first anyway
+	err := r.Storer.SetReference(plumbing.NewSymbolicReference(
+		plumbing.HEAD, plumbing.ReferenceName("refs/remotes/origin/branch"),
+	))
+	c.Assert(err, IsNil)
+
+	iter, err := r.Log(&LogOptions{Order: LogOrderTopo, All: true})
+	c.Assert(err, IsNil)
+
+	var hashes []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, DeepEquals, []string{
+		"6ecf0ef", "e8d3ffa", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "b8e471f", "35e8510", "b029517",
+	})
+}
+
+func (s *RepositorySuite) TestLogTopoOrderCommitGraph(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	st := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	p := f.Packfile()
+	defer p.Close()
+	c.Assert(packfile.UpdateObjectStorage(st, p), IsNil)
+
+	r, err := Open(st, nil)
+	c.Assert(err, IsNil)
+
+	index, err := r.commitNodeIndex()
+	c.Assert(err, IsNil)
+	node, err := index.Get(f.Head)
+	c.Assert(err, IsNil)
+	c.Assert(node.Generation(), Not(Equals), uint64(math.MaxUint64))
+
+	iter, err := r.Log(&LogOptions{From: f.Head, Order: LogOrderTopo})
+	c.Assert(err, IsNil)
+
+	var hashes []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, DeepEquals, []string{
+		"b9d6906", "6f6c5d2", "a45273f", "c0edf78", "bb13916", "03d2c02", "ce27506", "e713b52", "347c919",
+	})
+}
+
 func (s *RepositorySuite) TestLogHead(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -1646,6 +1950,55 @@ func (s *RepositorySuite) TestLogFileWithOtherParamsPass(c *C) {
 	c.Assert(iterErr, Equals, io.EOF)
 }
 
+func (s *RepositorySuite) TestLogFileFollow(c *C) {
+	content := strings.Repeat("line of the followed file\n", 20)
+	r, w, _ := newMergeRepository(c, map[string]string{
+		"foo": content,
+		"bar": "bar\n",
+	})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	base := head.Hash()
+	modified := commitFiles(c, w, map[string]string{"foo": content + "foo\n"}, "modify\n")
+	commitFiles(c, w, map[string]string{"bar": "baz\n"}, "unrelated\n")
+	renamed := commitFiles(c, w, map[string]string{
+		"foo":     "",
+		"dir/qux": content + "qux\n",
+	}, "rename\n")
+	last := commitFiles(c, w, map[string]string{"dir/qux": content + "quux\n"}, "last\n")
+
+	fileName := "dir/qux"
+	for follow, expected := range map[bool][]plumbing.Hash{
+		false: {last, renamed},
+		true:  {last, renamed, modified, base},
+	} {
+		cIter, err := r.Log(&LogOptions{FileName: &fileName, Follow: follow})
+		c.Assert(err, IsNil)
+
+		var hashes []plumbing.Hash
+		for {
+			commit, err := cIter.Next()
+			if err == io.EOF {
+				break
+			}
+
+			c.Assert(err, IsNil)
+			hashes = append(hashes, commit.Hash)
+		}
+
+		c.Assert(hashes, DeepEquals, expected, Commentf("follow %v", follow))
+	}
+}
+
+func (s *RepositorySuite) TestLogFollowRequiresFileName(c *C) {
+	r, _ := Init(memory.NewStorage(), nil)
+
+	_, err := r.Log(&LogOptions{Follow: true})
+	c.Assert(err, Equals, ErrFollowRequiresFileName)
+}
+
 func (s *RepositorySuite) TestCommit(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -2596,9 +2949,10 @@ func (s *RepositorySuite) TestBrokenMultipleShallowFetch(c *C) {
 		RefSpecs: []config.RefSpec{config.RefSpec("refs/heads/*:refs/heads/*")},
 	}), IsNil)
 
+	// the previous shallow commit is unshallowed, as git does
 	shallows, err = r.Storer.Shallow()
 	c.Assert(err, IsNil)
-	c.Assert(len(shallows), Equals, 3)
+	c.Assert(len(shallows), Equals, 2)
 
 	ref, err = r.Reference("refs/heads/master", true)
 	c.Assert(err, IsNil)
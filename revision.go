@@ -0,0 +1,481 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/internal/revision"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var (
+	// ErrNoUpstream is returned when resolving a revision like
+	// <branch>@{upstream} and the branch does not track a remote branch.
+	ErrNoUpstream = errors.New("no upstream configured for branch")
+	// ErrNoPushDestination is returned when resolving a revision like
+	// <branch>@{push} and the branch is not pushed to a remote branch.
+	ErrNoPushDestination = errors.New("no push destination configured for branch")
+	// ErrNotBranch is returned when resolving a revision like @{upstream} or
+	// @{push} on a reference that is not a branch, or with a detached HEAD.
+	ErrNotBranch = errors.New("reference is not a branch")
+)
+
+// ResolveRevisionObject resolves revision to the object it names, a commit,
+// a tree, a blob or an annotated tag, as git rev-parse does.
+//
+// Besides the revisions resolved by ResolveRevision, it resolves the
+// upstream and push branches (master@{upstream}, @{u}, @{push}), the reflog
+// entries by date (master@{2016-12-16T21:42:47Z}), the youngest commit with a
+// matching message (:/fix nasty bug), the paths of a tree (HEAD:README,
+// HEAD~1:docs), the paths of the index (:README, :2:README) and the peeling
+// of the objects (v1.0.0^{tree}, v1.0.0^{}, HEAD^{tag}).
+func (r *Repository) ResolveRevisionObject(rev plumbing.Revision) (object.Object, error) {
+	items, err := revision.NewParserFromString(string(rev)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var obj object.Object
+	var refName plumbing.ReferenceName
+
+	for _, item := range items {
+		switch item := item.(type) {
+		case revision.Ref:
+			obj, refName, err = r.resolveRevisionRef(string(item))
+		case revision.CaretPath:
+			obj, err = r.resolveCaretPath(obj, item.Depth)
+		case revision.TildePath:
+			obj, err = r.resolveTildePath(obj, item.Depth)
+		case revision.CaretReg:
+			obj, err = r.resolveCaretReg(obj, item.Regexp, item.Negate)
+		case revision.CaretType:
+			obj, err = peelObject(obj, item.ObjectType)
+		case revision.AtReflog:
+			if refName, err = r.revisionReflogName(obj, refName); err != nil {
+				break
+			}
+
+			var h plumbing.Hash
+			if h, err = r.reflogEntry(refName, item.Depth); err == nil {
+				obj, err = r.Object(plumbing.AnyObject, h)
+			}
+		case revision.AtDate:
+			if refName, err = r.revisionReflogName(obj, refName); err != nil {
+				break
+			}
+
+			var h plumbing.Hash
+			if h, err = r.reflogEntryAt(refName, item.Date); err == nil {
+				obj, err = r.Object(plumbing.AnyObject, h)
+			}
+		case revision.AtCheckout:
+			var name string
+			if name, err = r.previousCheckout(item.Depth); err == nil {
+				obj, err = r.ResolveRevisionObject(plumbing.Revision(name))
+			}
+		case revision.AtUpstream:
+			if refName, err = r.revisionBranchName(obj, refName); err != nil {
+				break
+			}
+
+			if refName, err = r.upstreamReference(refName); err == nil {
+				obj, refName, err = r.resolveRevisionRef(refName.String())
+			}
+		case revision.AtPush:
+			if refName, err = r.revisionBranchName(obj, refName); err != nil {
+				break
+			}
+
+			if refName, err = r.pushReference(refName); err == nil {
+				obj, refName, err = r.resolveRevisionRef(refName.String())
+			}
+		case revision.ColonReg:
+			obj, err = r.resolveColonReg(item.Regexp, item.Negate)
+		case revision.ColonPath:
+			if obj == nil {
+				obj, err = r.resolveIndexPath(item.Path, index.Merged)
+			} else {
+				obj, err = r.resolveTreePath(obj, item.Path)
+			}
+		case revision.ColonStagePath:
+			obj, err = r.resolveIndexPath(item.Path, index.Stage(item.Stage))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return obj, nil
+}
+
+// resolveRevisionRef resolves a hash or a reference name, as completed by
+// plumbing.RefRevParseRules, to its object and the name of the reference.
+func (r *Repository) resolveRevisionRef(rev string) (object.Object, plumbing.ReferenceName, error) {
+	var tryHashes []plumbing.Hash
+	var refName plumbing.ReferenceName
+
+	maybeHash := plumbing.NewHash(rev)
+	if !maybeHash.IsZero() {
+		tryHashes = append(tryHashes, maybeHash)
+	}
+
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		name := plumbing.ReferenceName(fmt.Sprintf(rule, rev))
+		ref, err := storer.ResolveReference(r.Storer, name)
+		if err == nil {
+			tryHashes = append(tryHashes, ref.Hash())
+			refName = name
+			break
+		}
+	}
+
+	// in ambiguous cases, `git rev-parse` will emit a warning, but will
+	// always return the oid in preference to a ref; we don't have the ability
+	// to emit a warning here, so (for speed purposes) don't bother to detect
+	// the ambiguity either, just return in the priority that git would.
+	for _, h := range tryHashes {
+		obj, err := r.Object(plumbing.AnyObject, h)
+		if err == nil {
+			return obj, refName, nil
+		}
+	}
+
+	return nil, "", plumbing.ErrReferenceNotFound
+}
+
+// resolveCaretPath resolves <rev>^<n>, the n-th parent of a commit, or the
+// commit itself if n is 0.
+func (r *Repository) resolveCaretPath(obj object.Object, n int) (object.Object, error) {
+	c, err := peelToCommit(obj)
+	if err != nil || n == 0 {
+		return c, err
+	}
+
+	if n > c.NumParents() {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	return r.CommitObject(c.ParentHashes[n-1])
+}
+
+// resolveTildePath resolves <rev>~<n>, the n-th generation ancestor of a
+// commit, following the first parents.
+func (r *Repository) resolveTildePath(obj object.Object, n int) (object.Object, error) {
+	c, err := peelToCommit(obj)
+	for i := 0; err == nil && i < n; i++ {
+		c, err = c.Parents().Next()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// resolveCaretReg resolves <rev>^{/<regexp>}, the first commit reachable from
+// a commit with a message matching the regular expression, or not matching it
+// if negate.
+func (r *Repository) resolveCaretReg(obj object.Object, re *regexp.Regexp, negate bool) (object.Object, error) {
+	from, err := peelToCommit(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *object.Commit
+	err = object.NewCommitPreorderIter(from, nil, nil).ForEach(func(hc *object.Commit) error {
+		if re.MatchString(hc.Message) != negate {
+			c = hc
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		return nil, fmt.Errorf(`No commit message match regexp : "%s"`, re.String())
+	}
+
+	return c, nil
+}
+
+// resolveColonReg resolves :/<regexp>, the youngest commit reachable from any
+// reference with a message matching the regular expression, or not matching
+// it if negate.
+func (r *Repository) resolveColonReg(re *regexp.Regexp, negate bool) (object.Object, error) {
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*object.Commit
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		obj, err := r.Object(plumbing.AnyObject, ref.Hash())
+		if err != nil {
+			return nil
+		}
+
+		if c, err := peelToCommit(obj); err == nil {
+			pending = append(pending, c)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *object.Commit
+	seen := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[c.Hash] {
+			continue
+		}
+
+		seen[c.Hash] = true
+		if re.MatchString(c.Message) != negate &&
+			(found == nil || c.Committer.When.After(found.Committer.When)) {
+			found = c
+		}
+
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			pending = append(pending, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf(`No commit message match regexp : "%s"`, re.String())
+	}
+
+	return found, nil
+}
+
+// resolveTreePath resolves <rev>:<path>, the blob or the tree at the given
+// path of the tree of a commit, relative to its root.
+func (r *Repository) resolveTreePath(obj object.Object, path string) (object.Object, error) {
+	tree, err := peelObject(obj, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	path = strings.Trim(strings.TrimPrefix(path, "./"), "/")
+	if path == "" {
+		return tree, nil
+	}
+
+	e, err := tree.(*object.Tree).FindEntry(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Object(plumbing.AnyObject, e.Hash)
+}
+
+// resolveIndexPath resolves :<n>:<path>, the blob at the given path and stage
+// of the index.
+func (r *Repository) resolveIndexPath(path string, stage index.Stage) (object.Object, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	path = strings.TrimPrefix(path, "./")
+	for _, e := range idx.Entries {
+		if e.Name == path && e.Stage == stage {
+			return r.BlobObject(e.Hash)
+		}
+	}
+
+	return nil, index.ErrEntryNotFound
+}
+
+// revisionReflogName returns the reference whose reflog is used to resolve
+// <ref>@{n} or <ref>@{date}: the reference resolved, if any, or the branch
+// HEAD points to.
+func (r *Repository) revisionReflogName(obj object.Object, refName plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	if refName != "" {
+		return refName, nil
+	}
+
+	if obj != nil {
+		return "", ErrReflogEntryNotFound
+	}
+
+	return r.currentBranchOrHEAD()
+}
+
+// revisionBranchName returns the branch used to resolve <branch>@{upstream}
+// or <branch>@{push}: the reference resolved, if any, or the branch HEAD
+// points to.
+func (r *Repository) revisionBranchName(obj object.Object, refName plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	if refName == "" && obj == nil {
+		var err error
+		if refName, err = r.currentBranchOrHEAD(); err != nil {
+			return "", err
+		}
+	}
+
+	if !refName.IsBranch() {
+		return "", ErrNotBranch
+	}
+
+	return refName, nil
+}
+
+// upstreamReference returns the reference tracking the upstream of a branch,
+// from its branch.<name>.remote and branch.<name>.merge configuration.
+func (r *Repository) upstreamReference(branch plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return "", err
+	}
+
+	b, ok := cfg.Branches[branch.Short()]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return "", ErrNoUpstream
+	}
+
+	if b.Remote == "." {
+		return b.Merge, nil
+	}
+
+	return trackingReference(cfg, b.Remote, b.Merge, ErrNoUpstream)
+}
+
+// pushReference returns the reference tracking the remote branch a branch is
+// pushed to, as git push would do it without arguments, following the
+// branch.<name>.pushRemote, remote.pushDefault and push.default
+// configuration.
+func (r *Repository) pushReference(branch plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return "", err
+	}
+
+	var upstreamRemote string
+	if b, ok := cfg.Branches[branch.Short()]; ok {
+		upstreamRemote = b.Remote
+	}
+
+	remote := rawConfigSubsectionOption(cfg, "branch", branch.Short(), "pushRemote")
+	if remote == "" {
+		remote = rawConfigOption(cfg, "remote", "pushDefault")
+	}
+
+	if remote == "" {
+		remote = upstreamRemote
+	}
+
+	if remote == "" {
+		remote = DefaultRemoteName
+	}
+
+	switch mode := rawConfigOption(cfg, "push", "default"); mode {
+	case "nothing":
+		return "", ErrNoPushDestination
+	case "upstream":
+		if remote != upstreamRemote {
+			return "", ErrNoPushDestination
+		}
+
+		return r.upstreamReference(branch)
+	case "", "simple":
+		if remote == upstreamRemote {
+			return r.upstreamReference(branch)
+		}
+	}
+
+	return trackingReference(cfg, remote, branch, ErrNoPushDestination)
+}
+
+// trackingReference returns the reference tracking the given reference of a
+// remote, from its fetch refspecs, or notTracked if none matches it.
+func trackingReference(
+	cfg *config.Config, remote string, name plumbing.ReferenceName, notTracked error,
+) (plumbing.ReferenceName, error) {
+	rc, ok := cfg.Remotes[remote]
+	if !ok {
+		return "", ErrRemoteNotFound
+	}
+
+	for _, rs := range rc.Fetch {
+		if rs.Match(name) {
+			return rs.Dst(name), nil
+		}
+	}
+
+	return "", notTracked
+}
+
+// peelToCommit returns the commit obj is, or points to through annotated
+// tags.
+func peelToCommit(obj object.Object) (*object.Commit, error) {
+	c, err := peelObject(obj, "commit")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.(*object.Commit), nil
+}
+
+// peelObject dereferences obj until an object of the given type, as
+// <rev>^{<type>} does: the annotated tags are peeled and a commit is
+// dereferenced to its tree. With the "tag" type obj must be an annotated tag,
+// with the "object" type it is returned as is and with the empty type the
+// annotated tags are peeled.
+func peelObject(obj object.Object, typ string) (object.Object, error) {
+	switch typ {
+	case "object":
+		return obj, nil
+	case "tag":
+		if obj.Type() != plumbing.TagObject {
+			return nil, fmt.Errorf("object %s is a %s, not a tag", obj.ID(), obj.Type())
+		}
+
+		return obj, nil
+	}
+
+	for {
+		if typ == "" && obj.Type() != plumbing.TagObject || obj.Type().String() == typ {
+			return obj, nil
+		}
+
+		var err error
+		switch o := obj.(type) {
+		case *object.Tag:
+			obj, err = o.Object()
+		case *object.Commit:
+			if typ != "tree" {
+				return nil, fmt.Errorf("object %s is a commit, not a %s", o.ID(), typ)
+			}
+
+			obj, err = o.Tree()
+		default:
+			return nil, fmt.Errorf("object %s is a %s, not a %s", obj.ID(), obj.Type(), typ)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package git
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type RevisionSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&RevisionSuite{})
+
+func (s *RevisionSuite) openFixture(c *C, url string) *Repository {
+	f := fixtures.ByURL(url).One()
+	sto := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	r, err := Open(sto, f.DotGit())
+	c.Assert(err, IsNil)
+
+	return r
+}
+
+func (s *RevisionSuite) assertObject(c *C, r *Repository, rev string, typ plumbing.ObjectType, hash string) {
+	obj, err := r.ResolveRevisionObject(plumbing.Revision(rev))
+	c.Assert(err, IsNil, Commentf("revision %q", rev))
+	c.Assert(obj.Type(), Equals, typ, Commentf("revision %q", rev))
+	c.Assert(obj.ID().String(), Equals, hash, Commentf("revision %q", rev))
+}
+
+func (s *RevisionSuite) TestTreePath(c *C) {
+	r := s.openFixture(c, "https://github.com/git-fixtures/basic.git")
+
+	for rev, hash := range map[string]string{
+		"HEAD:CHANGELOG":       "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		"master:./CHANGELOG":   "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		"HEAD~1:go/example.go": "880cd14280f4b9b6ed3986d6671f907d7cc2a198",
+	} {
+		s.assertObject(c, r, rev, plumbing.BlobObject, hash)
+	}
+
+	for rev, hash := range map[string]string{
+		"HEAD:":        "a8d315b2b1c615d43042c3a62402b8a54288cf5c",
+		"HEAD:go":      "a39771a7651f97faf5c72e08224d857fc35133db",
+		"v1.0.0:go/":   "a39771a7651f97faf5c72e08224d857fc35133db",
+		"HEAD^{tree}":  "a8d315b2b1c615d43042c3a62402b8a54288cf5c",
+		"master^{}:go": "a39771a7651f97faf5c72e08224d857fc35133db",
+	} {
+		s.assertObject(c, r, rev, plumbing.TreeObject, hash)
+	}
+
+	_, err := r.ResolveRevisionObject("HEAD:missing")
+	c.Assert(err, Equals, object.ErrEntryNotFound)
+}
+
+func (s *RevisionSuite) TestColonReg(c *C) {
+	r := s.openFixture(c, "https://github.com/git-fixtures/basic.git")
+
+	for rev, hash := range map[string]string{
+		":/binary file": "35e85108805c84807bc66a02d91535e1e24b38b9",
+		":/^Merge":      "1669dce138d9b841a518c64b10914d88f5e488ea",
+	} {
+		s.assertObject(c, r, rev, plumbing.CommitObject, hash)
+	}
+
+	_, err := r.ResolveRevisionObject(":/whatever")
+	c.Assert(err, ErrorMatches, `No commit message match regexp : "whatever"`)
+}
+
+func (s *RevisionSuite) TestCaretType(c *C) {
+	r := s.openFixture(c, "https://github.com/git-fixtures/tags.git")
+
+	for _, t := range []struct {
+		rev  string
+		typ  plumbing.ObjectType
+		hash string
+	}{
+		{"annotated-tag^{tag}", plumbing.TagObject, "b742a2a9fa0afcfa9a6fad080980fbc26b007c69"},
+		{"annotated-tag^{object}", plumbing.TagObject, "b742a2a9fa0afcfa9a6fad080980fbc26b007c69"},
+		{"annotated-tag^{}", plumbing.CommitObject, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f"},
+		{"annotated-tag^{commit}", plumbing.CommitObject, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f"},
+		{"annotated-tag^{tree}", plumbing.TreeObject, "70846e9a10ef7b41064b40f07713d5b8b9a8fc73"},
+		{"tree-tag^{}", plumbing.TreeObject, "70846e9a10ef7b41064b40f07713d5b8b9a8fc73"},
+		{"tree-tag^{tree}", plumbing.TreeObject, "70846e9a10ef7b41064b40f07713d5b8b9a8fc73"},
+		{"blob-tag^{}", plumbing.BlobObject, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+		{"annotated-tag", plumbing.TagObject, "b742a2a9fa0afcfa9a6fad080980fbc26b007c69"},
+	} {
+		s.assertObject(c, r, t.rev, t.typ, t.hash)
+	}
+
+	for rev, msg := range map[string]string{
+		"HEAD^{tag}":        "object f7b877701fbf855b44c0a9e86f3fdce2c298b07f is a commit, not a tag",
+		"blob-tag^{commit}": "object e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 is a blob, not a commit",
+		"tree-tag^{commit}": "object 70846e9a10ef7b41064b40f07713d5b8b9a8fc73 is a tree, not a commit",
+		"blob-tag~1":        "object e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 is a blob, not a commit",
+	} {
+		_, err := r.ResolveRevisionObject(plumbing.Revision(rev))
+		c.Assert(err, ErrorMatches, msg, Commentf("revision %q", rev))
+	}
+
+	h, err := r.ResolveRevision("annotated-tag")
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+
+	h, err = r.ResolveRevision("annotated-tag^{tag}")
+	c.Assert(err, IsNil)
+	c.Assert(h.String(), Equals, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+
+	_, err = r.ResolveRevision("tree-tag")
+	c.Assert(err, ErrorMatches, "object 70846e9a10ef7b41064b40f07713d5b8b9a8fc73 is a tree, not a commit")
+}
+
+func (s *RevisionSuite) TestIndexPath(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+	commitFiles(c, w, map[string]string{"foo": "first\n"}, "first\n")
+
+	foo := plumbing.ComputeHash(plumbing.BlobObject, []byte("foo\n"))
+	first := plumbing.ComputeHash(plumbing.BlobObject, []byte("first\n"))
+	for rev, hash := range map[string]plumbing.Hash{
+		":foo":       first,
+		":0:foo":     first,
+		":./foo":     first,
+		"HEAD:foo":   first,
+		"HEAD~1:foo": foo,
+		"master:foo": foo,
+	} {
+		s.assertObject(c, r, rev, plumbing.BlobObject, hash.String())
+	}
+
+	for _, rev := range []string{":bar", ":2:foo"} {
+		_, err := r.ResolveRevisionObject(plumbing.Revision(rev))
+		c.Assert(err, Equals, index.ErrEntryNotFound, Commentf("revision %q", rev))
+	}
+}
+
+func (s *RevisionSuite) TestAtDate(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+
+	first := commitFiles(c, w, map[string]string{"foo": "first\n"}, "first\n")
+	second := commitFiles(c, w, map[string]string{"foo": "second\n"}, "second\n")
+
+	at := func(date string) time.Time {
+		t, err := time.Parse(time.RFC3339, date)
+		c.Assert(err, IsNil)
+		return t
+	}
+
+	err = r.Storer.(storer.ReflogStorer).SetReflog("refs/heads/feature", []*reflog.Entry{
+		{New: base, Committer: reflog.Signature{When: at("2018-01-01T10:00:00Z")}},
+		{Old: base, New: first, Committer: reflog.Signature{When: at("2018-01-02T10:00:00Z")}},
+		{Old: first, New: second, Committer: reflog.Signature{When: at("2018-01-03T10:00:00Z")}},
+	})
+	c.Assert(err, IsNil)
+
+	for rev, expected := range map[string]plumbing.Hash{
+		"feature@{2017-12-31T10:00:00Z}":            base,
+		"feature@{2018-01-01T10:00:00Z}":            base,
+		"feature@{2018-01-02T09:00:00Z}":            base,
+		"feature@{2018-01-02T10:00:00Z}":            first,
+		"@{2018-01-02T11:00:00Z}":                   first,
+		"feature@{2019-01-01T00:00:00Z}":            second,
+		"feature@{2018-01-02T11:00:00Z}~1":          base,
+		"refs/heads/feature@{2018-01-03T10:00:00Z}": second,
+	} {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("revision %q", rev))
+		c.Assert(*h, Equals, expected, Commentf("revision %q", rev))
+	}
+
+	_, err = r.ResolveRevision("refs/tags/none@{2018-01-01T10:00:00Z}")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RevisionSuite) TestUpstreamAndPush(c *C) {
+	r, w, _ := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	base := head.Hash()
+	first := commitFiles(c, w, map[string]string{"foo": "first\n"}, "first\n")
+
+	for name, h := range map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/remotes/origin/master":  base,
+		"refs/remotes/origin/feature": first,
+		"refs/remotes/fork/feature":   base,
+	} {
+		err := r.Storer.SetReference(plumbing.NewHashReference(name, h))
+		c.Assert(err, IsNil)
+	}
+
+	_, err = r.ResolveRevision("@{upstream}")
+	c.Assert(err, Equals, ErrNoUpstream)
+
+	for _, name := range []string{"origin", "fork"} {
+		_, err = r.CreateRemote(&config.RemoteConfig{
+			Name: name,
+			URLs: []string{"https://example.com/" + name},
+		})
+		c.Assert(err, IsNil)
+	}
+
+	cfg, err := r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Branches["master"] = &config.Branch{
+		Name:   "master",
+		Remote: "origin",
+		Merge:  "refs/heads/master",
+	}
+	cfg.Branches["local"] = &config.Branch{
+		Name:   "local",
+		Remote: ".",
+		Merge:  "refs/heads/feature",
+	}
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/local", base)), IsNil)
+
+	for rev, expected := range map[string]plumbing.Hash{
+		"master@{upstream}": base,
+		"master@{u}":        base,
+		"local@{u}":         first,
+		"local@{u}~1":       base,
+		"master@{push}":     base,
+		"feature@{push}":    first,
+	} {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		c.Assert(err, IsNil, Commentf("revision %q", rev))
+		c.Assert(*h, Equals, expected, Commentf("revision %q", rev))
+	}
+
+	_, err = r.ResolveRevision("v1.0.0@{u}")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	_, err = r.CreateTag("v1", base, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.ResolveRevision("v1@{u}")
+	c.Assert(err, Equals, ErrNotBranch)
+
+	cfg, err = r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("remote").SetOption("pushDefault", "fork")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	h, err := r.ResolveRevision("@{push}")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, base)
+
+	cfg, err = r.Storer.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("push").SetOption("default", "nothing")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	_, err = r.ResolveRevision("@{push}")
+	c.Assert(err, Equals, ErrNoPushDestination)
+}
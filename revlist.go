@@ -0,0 +1,242 @@
+package git
+
+import (
+	"io"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// RevList returns an iterator of the commits reachable from the revisions of
+// o and not from the excluded ones, sorted by committer time, as git rev-list
+// does. The commits reachable from the left side of a symmetric difference
+// are marked as Left, as git rev-list --left-right does, and the boundary
+// commits, if requested, as Boundary.
+func (r *Repository) RevList(o *RevListOptions) (revlist.CommitIter, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	tips, err := r.revisionTips(o.Revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	return revlist.Commits(r.Storer, tips, &revlist.CommitsOptions{
+		Boundary:    o.Boundary,
+		FirstParent: o.FirstParent,
+	})
+}
+
+// logRevisions returns the commits of the revisions of o, as RevList does,
+// the ones not matching its filters, and its FileName, being skipped while
+// they are walked. The boundary commits are listed last, unfiltered.
+func (r *Repository) logRevisions(o *LogOptions) (object.CommitIter, error) {
+	iter, err := r.RevList(&RevListOptions{
+		Revisions:   o.Revisions,
+		Boundary:    o.Boundary,
+		FirstParent: o.FirstParent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var f *logFilter
+	if o.FileName != nil {
+		f = newLogFilter(o, *o.FileName)
+	} else {
+		f = newLogFilter(o)
+	}
+
+	walked := &revListCommitIter{iter: iter}
+	var it object.CommitIter = walked
+	if f != nil {
+		// the commits are walked by committer time, the ones older than
+		// Since are the last ones
+		it = newFilterCommitIter(it, f, true)
+	}
+
+	if !o.Boundary {
+		return it, nil
+	}
+
+	return &revListBoundaryIter{CommitIter: it, walked: walked}, nil
+}
+
+// revListCommitIter adapts a revlist.CommitIter to an object.CommitIter,
+// ending at the first boundary commit, which is kept.
+type revListCommitIter struct {
+	iter     revlist.CommitIter
+	boundary *revlist.Commit
+	done     bool
+}
+
+func (it *revListCommitIter) Next() (*object.Commit, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	c, err := it.iter.Next()
+	if err == io.EOF || err == nil && c.Boundary {
+		it.done, it.boundary = true, c
+		return nil, io.EOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Commit, nil
+}
+
+func (it *revListCommitIter) ForEach(cb func(*object.Commit) error) error {
+	return forEachCommit(it, cb)
+}
+
+func (it *revListCommitIter) Close() {
+	it.iter.Close()
+}
+
+// revListBoundaryIter lists the commits of an iterator, and then the boundary
+// commits of the walk of a revListCommitIter, once it is done.
+type revListBoundaryIter struct {
+	object.CommitIter
+	walked   *revListCommitIter
+	boundary bool
+}
+
+func (it *revListBoundaryIter) Next() (*object.Commit, error) {
+	if !it.boundary {
+		c, err := it.CommitIter.Next()
+		if err != io.EOF {
+			return c, err
+		}
+
+		it.boundary = true
+		// the commits may be filtered out before the end of the walk
+		for !it.walked.done {
+			if _, err := it.walked.Next(); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+
+		if b := it.walked.boundary; b != nil {
+			return b.Commit, nil
+		}
+
+		return nil, io.EOF
+	}
+
+	c, err := it.walked.iter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Commit, nil
+}
+
+func (it *revListBoundaryIter) ForEach(cb func(*object.Commit) error) error {
+	return forEachCommit(it, cb)
+}
+
+// forEachCommit calls cb for each commit of the iterator, until it returns
+// storer.ErrStop or an error.
+func forEachCommit(it object.CommitIter, cb func(*object.Commit) error) error {
+	for {
+		c, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := cb(c); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// revisionTips resolves the arguments of a rev-list to the tips of the walk:
+// a revision, ^<rev> excluding the commits reachable from it, <rev1>..<rev2>
+// as ^<rev1> <rev2>, <rev1>...<rev2> as <rev1> <rev2> excluding their merge
+// bases, and --not, reversing the exclusion of the following arguments. A
+// missing side of a range is HEAD.
+func (r *Repository) revisionTips(args []string) ([]revlist.Tip, error) {
+	var tips []revlist.Tip
+	var not bool
+
+	add := func(rev string, exclude, left bool) (*object.Commit, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := r.CommitObject(*h)
+		if err != nil {
+			return nil, err
+		}
+
+		tips = append(tips, revlist.Tip{Hash: c.Hash, Exclude: exclude != not, Left: left})
+		return c, nil
+	}
+
+	for _, arg := range args {
+		if arg == "--not" {
+			not = !not
+			continue
+		}
+
+		if i := strings.Index(arg, "..."); i != -1 {
+			left, err := add(arg[:i], false, true)
+			if err != nil {
+				return nil, err
+			}
+
+			right, err := add(arg[i+3:], false, false)
+			if err != nil {
+				return nil, err
+			}
+
+			bases, err := left.MergeBase(right)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, b := range bases {
+				tips = append(tips, revlist.Tip{Hash: b.Hash, Exclude: !not})
+			}
+
+			continue
+		}
+
+		var err error
+		if i := strings.Index(arg, ".."); i != -1 {
+			if _, err = add(arg[:i], true, false); err == nil {
+				_, err = add(arg[i+2:], false, false)
+			}
+		} else if strings.HasPrefix(arg, "^") {
+			_, err = add(arg[1:], true, false)
+		} else {
+			_, err = add(arg, false, false)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tips, nil
+}
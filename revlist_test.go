@@ -0,0 +1,186 @@
+package git
+
+import (
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/revlist"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git-fixtures.v3"
+)
+
+type RevListSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&RevListSuite{})
+
+// Basic fixture repository commits tree:
+//
+// * 6ecf0ef vendor stuff
+// | * e8d3ffa some code in a branch
+// |/
+// * 918c48b some code
+// * af2d6a6 some json
+// *   1669dce Merge branch 'master'
+// |\
+// | *   a5b8b09 Merge pull request #1
+// | |\
+// | | * b8e471f Creating changelog
+// | |/
+// * | 35e8510 binary file
+// |/
+// * b029517 Initial commit
+func openBasicRepository(c *C) *Repository {
+	f := fixtures.ByURL("https://github.com/git-fixtures/basic.git").One()
+	sto := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	r, err := Open(sto, f.DotGit())
+	c.Assert(err, IsNil)
+
+	return r
+}
+
+func revListHashes(c *C, iter revlist.CommitIter) []string {
+	var result []string
+	err := iter.ForEach(func(cm *revlist.Commit) error {
+		mark := ""
+		switch {
+		case cm.Boundary:
+			mark = "-"
+		case cm.Left:
+			mark = "<"
+		}
+
+		result = append(result, mark+cm.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	return result
+}
+
+func (s *RevListSuite) TestRevList(c *C) {
+	r := openBasicRepository(c)
+
+	for _, t := range []struct {
+		revisions []string
+		boundary  bool
+		expected  []string
+	}{
+		{nil, false, []string{"6ecf0ef", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "35e8510", "b8e471f", "b029517"}},
+		{[]string{"master..branch"}, false, []string{"e8d3ffa"}},
+		{[]string{"branch..master"}, false, []string{"6ecf0ef"}},
+		{[]string{"branch.."}, false, []string{"6ecf0ef"}},
+		{[]string{"..branch"}, false, []string{"e8d3ffa"}},
+		{[]string{"branch", "^master"}, false, []string{"e8d3ffa"}},
+		{[]string{"branch", "--not", "master"}, false, []string{"e8d3ffa"}},
+		{[]string{"--not", "^branch", "master"}, false, []string{"e8d3ffa"}},
+		{[]string{"master...branch"}, false, []string{"<6ecf0ef", "e8d3ffa"}},
+		{[]string{"master...branch"}, true, []string{"<6ecf0ef", "e8d3ffa", "-918c48b"}},
+		{[]string{"HEAD~4...HEAD~3^2^2"}, true, []string{"<35e8510", "b8e471f", "-b029517"}},
+		{[]string{"HEAD~3^2^2..HEAD~1"}, false, []string{"918c48b", "af2d6a6", "1669dce", "a5b8b09", "35e8510"}},
+		{[]string{"branch", "^HEAD~4"}, true, []string{"e8d3ffa", "918c48b", "af2d6a6", "1669dce", "a5b8b09", "b8e471f", "-35e8510", "-b029517"}},
+		{[]string{"^master"}, false, nil},
+	} {
+		iter, err := r.RevList(&RevListOptions{
+			Revisions: t.revisions,
+			Boundary:  t.boundary,
+		})
+		c.Assert(err, IsNil, Commentf("revisions %q", t.revisions))
+		c.Assert(revListHashes(c, iter), DeepEquals, t.expected, Commentf("revisions %q", t.revisions))
+	}
+}
+
+func (s *RevListSuite) TestRevListErrors(c *C) {
+	r := openBasicRepository(c)
+
+	for _, revisions := range [][]string{{"missing..master"}, {"master...missing"}, {"^missing"}} {
+		_, err := r.RevList(&RevListOptions{Revisions: revisions})
+		c.Assert(err, Equals, plumbing.ErrReferenceNotFound, Commentf("revisions %q", revisions))
+	}
+}
+
+// missingObjectStorer is a storage where an object is missing.
+type missingObjectStorer struct {
+	storage.Storer
+	missing plumbing.Hash
+}
+
+func (s *missingObjectStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if h == s.missing {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	return s.Storer.EncodedObject(t, h)
+}
+
+func (s *RevListSuite) TestLogRevisionsSince(c *C) {
+	r := openBasicRepository(c)
+	// the walk stops at the first commit older than Since
+	r.Storer = &missingObjectStorer{
+		Storer:  r.Storer,
+		missing: plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	}
+
+	since := time.Unix(1427802711, 0)
+	iter, err := r.Log(&LogOptions{Revisions: []string{"HEAD"}, Since: &since})
+	c.Assert(err, IsNil)
+
+	var hashes []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, DeepEquals, []string{"6ecf0ef", "918c48b", "af2d6a6"})
+}
+
+func (s *RevListSuite) TestLogRevisions(c *C) {
+	r := openBasicRepository(c)
+
+	iter, err := r.Log(&LogOptions{
+		Revisions: []string{"HEAD~4...HEAD~3^2^2"},
+		Boundary:  true,
+	})
+	c.Assert(err, IsNil)
+
+	var hashes []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, DeepEquals, []string{"35e8510", "b8e471f", "b029517"})
+
+	fileName := "CHANGELOG"
+	iter, err = r.Log(&LogOptions{
+		Revisions: []string{"HEAD", "^HEAD~4"},
+		FileName:  &fileName,
+	})
+	c.Assert(err, IsNil)
+
+	hashes = nil
+	iter.ForEach(func(c *object.Commit) error {
+		hashes = append(hashes, c.Hash.String()[:7])
+		return nil
+	})
+	c.Assert(hashes, DeepEquals, []string{"b8e471f"})
+
+	_, err = r.Log(&LogOptions{
+		Revisions: []string{"HEAD"},
+		Order:     LogOrderBSF,
+	})
+	c.Assert(err, Equals, ErrRevisionsOrder)
+
+	_, err = r.Log(&LogOptions{
+		Revisions: []string{"HEAD"},
+		FileName:  &fileName,
+		Follow:    true,
+	})
+	c.Assert(err, Equals, ErrRevisionsFollow)
+}
@@ -0,0 +1,498 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+var (
+	// ErrNoLocalChanges is returned by Stash when there are no changes to
+	// stash.
+	ErrNoLocalChanges = errors.New("no local changes to save")
+	// ErrStashNotFound is returned when the requested stash does not exist.
+	ErrStashNotFound = errors.New("stash not found")
+	// ErrStashNotSupported is returned when the storage can not keep the
+	// reflog of the stashes, see storer.ReflogStorer.
+	ErrStashNotSupported = errors.New("storage does not support stash")
+	// ErrStashIndexConflicts is returned by StashApply when the changes of the
+	// index can not be restored cleanly.
+	ErrStashIndexConflicts = errors.New("conflicts restoring the stashed index")
+	// ErrUntrackedFilesExist is returned by StashApply when a stashed
+	// untracked file already exists in the worktree.
+	ErrUntrackedFilesExist = errors.New("stashed untracked files already exist")
+)
+
+// refStash is the reference pointing to the last stash, the previous stashes
+// are kept in its reflog.
+const refStash plumbing.ReferenceName = "refs/stash"
+
+// StashEntry is a stash, the changes of the worktree and the index put aside
+// by Stash.
+type StashEntry struct {
+	// Hash is the hash of the stash commit, the commit with the state of the
+	// worktree.
+	Hash plumbing.Hash
+	// Message describes the stash.
+	Message string
+}
+
+// Stash saves the changes of the index and the worktree, and the untracked
+// files when requested, in a new stash and reverts them, like `git stash
+// push`. The stash is recorded the same way git does, as a commit with the
+// state of the worktree whose parents are HEAD, a commit with the state of
+// the index and, optionally, a commit with the untracked files. refs/stash
+// points to the last stash and its reflog keeps the previous ones.
+//
+// The hash of the stash commit is returned, ErrNoLocalChanges is returned if
+// there is nothing to stash.
+func (w *Worktree) Stash(opts *StashOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	rs, ok := w.r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return plumbing.ZeroHash, ErrStashNotSupported
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if hasUnmergedEntries(idx) {
+		return plumbing.ZeroHash, ErrUnmergedPaths
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := &buildTreeHelper{fs: w.Filesystem, s: w.r.Storer}
+	indexTree, err := h.BuildTree(idx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	worktreeIdx := copyIndex(idx)
+	untrackedIdx := &index.Index{Version: idx.Version}
+	changed := indexTree != commit.TreeHash
+
+	var untracked []string
+	keep := make(map[string]bool)
+	for path, fs := range status {
+		switch fs.Worktree {
+		case Unmodified:
+			continue
+		case Untracked:
+			if !opts.IncludeUntracked {
+				keep[path] = true
+				continue
+			}
+
+			untracked = append(untracked, path)
+		case Deleted:
+			if _, err := w.deleteFromIndex(worktreeIdx, path); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		default:
+			if err := w.addFileToStashIndex(worktreeIdx, path); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+
+		changed = true
+	}
+
+	if !changed {
+		return plumbing.ZeroHash, ErrNoLocalChanges
+	}
+
+	for _, path := range untracked {
+		if err := w.addFileToStashIndex(untrackedIdx, path); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	worktreeTree, err := h.BuildTree(worktreeIdx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	desc := stashDescription(head, commit)
+	commitOpts := &CommitOptions{
+		Author:    opts.Committer,
+		Committer: opts.Committer,
+		Parents:   []plumbing.Hash{commit.Hash},
+	}
+
+	indexCommit, err := w.buildCommitObject("index on "+desc+"\n", commitOpts, indexTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parents := []plumbing.Hash{commit.Hash, indexCommit}
+	if len(untracked) != 0 {
+		untrackedTree, err := h.BuildTree(untrackedIdx)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		untrackedCommit, err := w.buildCommitObject("untracked files on "+desc+"\n", &CommitOptions{
+			Author:    opts.Committer,
+			Committer: opts.Committer,
+		}, untrackedTree)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		parents = append(parents, untrackedCommit)
+	}
+
+	msg := "WIP on " + desc
+	if opts.Message != "" {
+		msg = fmt.Sprintf("On %s: %s", stashBranchName(head), opts.Message)
+	}
+
+	commitOpts.Parents = parents
+	stash, err := w.buildCommitObject(msg+"\n", commitOpts, worktreeTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.setStashReference(rs, stash, opts.Committer, firstLine(msg)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return stash, w.resetStashedChanges(commit, keep)
+}
+
+// StashList returns the stashes, the most recent first. The position of each
+// stash in the list is the number used to refer to it, as in stash@{n}.
+func (w *Worktree) StashList() ([]*StashEntry, error) {
+	rs, ok := w.r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil, ErrStashNotSupported
+	}
+
+	entries, err := rs.Reflog(refStash)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*StashEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, &StashEntry{
+			Hash:    entries[i].New,
+			Message: entries[i].Message,
+		})
+	}
+
+	return list, nil
+}
+
+// StashApply restores the changes saved in the n-th stash, the most recent
+// being zero, merging them with the current worktree. The index is only
+// restored when requested by the options, otherwise the files added in the
+// stash are the only ones added to the index.
+//
+// When the changes can not be restored cleanly, the conflicts are recorded in
+// the index and the worktree like in Merge and ErrMergeConflicts is returned.
+// The stash is kept.
+func (w *Worktree) StashApply(n int, opts *StashApplyOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	stash, err := w.stashCommit(n)
+	if err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	if err := w.checkCleanForMerge(); err != nil {
+		return err
+	}
+
+	ours, err := w.getTreeFromCommitHash(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	base, err := w.getTreeFromCommitHash(stash.ParentHashes[0])
+	if err != nil {
+		return err
+	}
+
+	theirs, err := stash.Tree()
+	if err != nil {
+		return err
+	}
+
+	var indexTree *object.Tree
+	if opts.Index && len(stash.ParentHashes) > 1 {
+		if indexTree, err = w.mergeStashIndex(base, ours, stash.ParentHashes[1]); err != nil {
+			return err
+		}
+	}
+
+	var untracked *object.Tree
+	if len(stash.ParentHashes) > 2 {
+		if untracked, err = w.getTreeFromCommitHash(stash.ParentHashes[2]); err != nil {
+			return err
+		}
+
+		if err := w.checkUntrackedFilesNotExist(untracked); err != nil {
+			return err
+		}
+	}
+
+	m := newTreeMerger(w.r.Storer, "Updated upstream", "Stashed changes")
+	tree, err := m.Merge(base, ours, theirs)
+	if err != nil {
+		return err
+	}
+
+	if err := w.checkoutMergeResult(tree, m.conflicts); err != nil {
+		return err
+	}
+
+	if untracked != nil {
+		if err := untracked.Files().ForEach(w.checkoutFile); err != nil {
+			return err
+		}
+	}
+
+	if len(m.conflicts) != 0 {
+		return ErrMergeConflicts
+	}
+
+	if indexTree != nil {
+		return w.resetIndex(indexTree)
+	}
+
+	return w.resetIndexKeepingAdded(ours, tree)
+}
+
+// StashPop applies the n-th stash, like StashApply, and drops it when it is
+// applied without conflicts.
+func (w *Worktree) StashPop(n int, opts *StashApplyOptions) error {
+	if err := w.StashApply(n, opts); err != nil {
+		return err
+	}
+
+	return w.StashDrop(n)
+}
+
+// StashDrop removes the n-th stash, the most recent being zero.
+func (w *Worktree) StashDrop(n int) error {
+	rs, ok := w.r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return ErrStashNotSupported
+	}
+
+	entries, err := rs.Reflog(refStash)
+	if err != nil {
+		return err
+	}
+
+	i := len(entries) - 1 - n
+	if n < 0 || i < 0 {
+		return ErrStashNotFound
+	}
+
+	entries = append(entries[:i:i], entries[i+1:]...)
+	if len(entries) == 0 {
+		if err := rs.RemoveReflog(refStash); err != nil {
+			return err
+		}
+
+		return w.r.Storer.RemoveReference(refStash)
+	}
+
+	// the reflog is written after the reference, replacing any entry the
+	// storage records for the update
+	ref := plumbing.NewHashReference(refStash, entries[len(entries)-1].New)
+	if err := w.r.Storer.SetReference(ref); err != nil {
+		return err
+	}
+
+	return rs.SetReflog(refStash, entries)
+}
+
+func (w *Worktree) stashCommit(n int) (*object.Commit, error) {
+	list, err := w.StashList()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n >= len(list) {
+		return nil, ErrStashNotFound
+	}
+
+	return w.r.CommitObject(list[n].Hash)
+}
+
+func (w *Worktree) setStashReference(
+	rs storer.ReflogStorer, h plumbing.Hash, committer *object.Signature, msg string,
+) error {
+	old, err := w.r.Storer.Reference(refStash)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	entries, err := rs.Reflog(refStash)
+	if err != nil {
+		return err
+	}
+
+	e := newReflogEntry(plumbing.ZeroHash, h, committer, msg)
+	if old != nil {
+		e.Old = old.Hash()
+	}
+
+	// refs/stash is always logged, whatever core.logAllRefUpdates is, so the
+	// reflog is written here, replacing any entry the storage records
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(refStash, h)); err != nil {
+		return err
+	}
+
+	return rs.SetReflog(refStash, append(entries, e))
+}
+
+// addFileToStashIndex stores the content of the given file and adds it to
+// the given index, that is not the index of the worktree.
+func (w *Worktree) addFileToStashIndex(idx *index.Index, path string) error {
+	h, err := w.copyFileToStorage(path)
+	if err != nil {
+		return err
+	}
+
+	return w.addOrUpdateFileToIndex(idx, path, h)
+}
+
+// mergeStashIndex merges the changes of the stashed index into the tree of
+// HEAD, the conflicts are not allowed.
+func (w *Worktree) mergeStashIndex(base, ours *object.Tree, h plumbing.Hash) (*object.Tree, error) {
+	theirs, err := w.getTreeFromCommitHash(h)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newTreeMerger(w.r.Storer, "Updated upstream", "Stashed changes")
+	t, err := m.Merge(base, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.conflicts) != 0 {
+		return nil, ErrStashIndexConflicts
+	}
+
+	return t, nil
+}
+
+func (w *Worktree) checkUntrackedFilesNotExist(t *object.Tree) error {
+	return t.Files().ForEach(func(f *object.File) error {
+		_, err := w.Filesystem.Lstat(f.Name)
+		if err == nil {
+			return ErrUntrackedFilesExist
+		}
+
+		return nil
+	})
+}
+
+// resetIndexKeepingAdded resets the index to the given tree of HEAD, keeping
+// the files that are new in the applied tree.
+func (w *Worktree) resetIndexKeepingAdded(head, applied *object.Tree) error {
+	if err := w.resetIndex(head); err != nil {
+		return err
+	}
+
+	changes, err := object.DiffTree(head, applied)
+	if err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range changes {
+		a, err := ch.Action()
+		if err != nil {
+			return err
+		}
+
+		if a != merkletrie.Insert {
+			continue
+		}
+
+		if err := w.addIndexFromFile(ch.To.Name, ch.To.TreeEntry.Hash, idx); err != nil {
+			return err
+		}
+	}
+
+	return w.r.Storer.SetIndex(idx)
+}
+
+// resetStashedChanges resets the index and the worktree to the given commit,
+// the untracked files in keep are not removed.
+func (w *Worktree) resetStashedChanges(c *object.Commit, keep map[string]bool) error {
+	t, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(t); err != nil {
+		return err
+	}
+
+	return w.resetWorktreeKeeping(context.Background(), t, keep)
+}
+
+func copyIndex(idx *index.Index) *index.Index {
+	cp := &index.Index{Version: idx.Version}
+	for _, e := range idx.Entries {
+		entry := *e
+		cp.Entries = append(cp.Entries, &entry)
+	}
+
+	return cp
+}
+
+func stashBranchName(head *plumbing.Reference) string {
+	if head.Name().IsBranch() {
+		return head.Name().Short()
+	}
+
+	return "(no branch)"
+}
+
+func stashDescription(head *plumbing.Reference, c *object.Commit) string {
+	return fmt.Sprintf("%s: %s %s", stashBranchName(head), c.Hash.String()[:7], firstLine(c.Message))
+}
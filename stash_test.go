@@ -0,0 +1,249 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type StashSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&StashSuite{})
+
+func (s *StashSuite) TestStash(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{
+		"foo": "foo\n",
+		"bar": "bar\n",
+	})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("staged\n"), 0644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "foo", []byte("modified\n"), 0644), IsNil)
+
+	c.Assert(util.WriteFile(fs, "new", []byte("new\n"), 0644), IsNil)
+	_, err = w.Add("new")
+	c.Assert(err, IsNil)
+
+	c.Assert(fs.Remove("bar"), IsNil)
+	c.Assert(util.WriteFile(fs, "untracked", []byte("untracked\n"), 0644), IsNil)
+
+	hash, err := w.Stash(&StashOptions{Committer: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	stash, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(stash.Message, Equals, "WIP on feature: "+head.Hash().String()[:7]+" base\n")
+	c.Assert(stash.NumParents(), Equals, 2)
+	c.Assert(stash.ParentHashes[0], Equals, head.Hash())
+
+	file, err := stash.File("foo")
+	c.Assert(err, IsNil)
+	content, err := file.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "modified\n")
+
+	_, err = stash.File("bar")
+	c.Assert(err, Equals, object.ErrFileNotFound)
+
+	index, err := stash.Parent(1)
+	c.Assert(err, IsNil)
+	file, err = index.File("foo")
+	c.Assert(err, IsNil)
+	content, err = file.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "staged\n")
+
+	ref, err := r.Reference(refStash, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, hash)
+
+	_, err = fs.Stat("new")
+	c.Assert(err, NotNil)
+
+	_, err = fs.Stat("untracked")
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("untracked").Worktree, Equals, Untracked)
+
+	err = w.StashPop(0, &StashApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+
+	data, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "modified\n")
+
+	_, err = fs.Stat("bar")
+	c.Assert(err, NotNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Modified)
+	c.Assert(status.File("foo").Worktree, Equals, Modified)
+	c.Assert(status.File("new").Staging, Equals, Added)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	_, err = r.Reference(refStash, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *StashSuite) TestStashNoLocalChanges(c *C) {
+	_, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	c.Assert(util.WriteFile(fs, "untracked", []byte("untracked\n"), 0644), IsNil)
+
+	_, err := w.Stash(&StashOptions{Committer: defaultSignature()})
+	c.Assert(err, Equals, ErrNoLocalChanges)
+
+	_, err = w.Stash(&StashOptions{})
+	c.Assert(err, Equals, ErrMissingCommitter)
+}
+
+func (s *StashSuite) TestStashIncludeUntracked(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	c.Assert(util.WriteFile(fs, "dir/untracked", []byte("untracked\n"), 0644), IsNil)
+
+	hash, err := w.Stash(&StashOptions{
+		Committer:        defaultSignature(),
+		Message:          "untracked",
+		IncludeUntracked: true,
+	})
+	c.Assert(err, IsNil)
+
+	stash, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(stash.Message, Equals, "On feature: untracked\n")
+	c.Assert(stash.NumParents(), Equals, 3)
+
+	_, err = fs.Stat("dir")
+	c.Assert(err, NotNil)
+
+	c.Assert(util.WriteFile(fs, "dir/untracked", []byte("other\n"), 0644), IsNil)
+	err = w.StashApply(0, &StashApplyOptions{})
+	c.Assert(err, Equals, ErrUntrackedFilesExist)
+	c.Assert(fs.Remove("dir/untracked"), IsNil)
+
+	err = w.StashApply(0, &StashApplyOptions{})
+	c.Assert(err, IsNil)
+
+	data, err := readWorktreeFile(fs, "dir/untracked")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "untracked\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("dir/untracked").Worktree, Equals, Untracked)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+}
+
+func (s *StashSuite) TestStashListAndDrop(c *C) {
+	r, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	var hashes []plumbing.Hash
+	for _, content := range []string{"first\n", "second\n", "third\n"} {
+		c.Assert(util.WriteFile(fs, "foo", []byte(content), 0644), IsNil)
+
+		h, err := w.Stash(&StashOptions{Committer: defaultSignature(), Message: content[:len(content)-1]})
+		c.Assert(err, IsNil)
+		hashes = append(hashes, h)
+	}
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 3)
+	c.Assert(list[0].Hash, Equals, hashes[2])
+	c.Assert(list[0].Message, Equals, "On feature: third")
+	c.Assert(list[2].Hash, Equals, hashes[0])
+
+	c.Assert(w.StashDrop(3), Equals, ErrStashNotFound)
+	c.Assert(w.StashDrop(1), IsNil)
+
+	list, err = w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Hash, Equals, hashes[2])
+	c.Assert(list[1].Hash, Equals, hashes[0])
+
+	c.Assert(w.StashDrop(0), IsNil)
+	ref, err := r.Reference(refStash, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, hashes[0])
+
+	err = w.StashApply(0, &StashApplyOptions{})
+	c.Assert(err, IsNil)
+
+	data, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "first\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("foo").Staging, Equals, Unmodified)
+	c.Assert(status.File("foo").Worktree, Equals, Modified)
+}
+
+func (s *StashSuite) TestStashApplyConflicts(c *C) {
+	_, w, fs := newMergeRepository(c, map[string]string{"foo": "foo\n"})
+
+	c.Assert(util.WriteFile(fs, "foo", []byte("stashed\n"), 0644), IsNil)
+	_, err := w.Stash(&StashOptions{Committer: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]string{"foo": "committed\n"}, "foo\n")
+
+	err = w.StashPop(0, &StashApplyOptions{})
+	c.Assert(err, Equals, ErrMergeConflicts)
+
+	data, err := readWorktreeFile(fs, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals,
+		"<<<<<<< Updated upstream\ncommitted\n=======\nstashed\n>>>>>>> Stashed changes\n",
+	)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+}
+
+func (s *StashSuite) TestStashFilesystem(c *C) {
+	r, err := PlainInit(c.MkDir(), false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]string{"foo": "foo\n"}, "base\n")
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("bar\n"), 0644), IsNil)
+
+	hash, err := w.Stash(&StashOptions{Committer: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	r, err = PlainOpen(w.Filesystem.Root())
+	c.Assert(err, IsNil)
+
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+
+	list, err := w.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0].Hash, Equals, hash)
+	c.Assert(list[0].Message, Matches, "WIP on master: .* base")
+}
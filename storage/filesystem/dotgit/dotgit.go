@@ -14,22 +14,26 @@ import (
 
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 
 	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
 )
 
 const (
-	suffix         = ".git"
-	packedRefsPath = "packed-refs"
-	configPath     = "config"
-	indexPath      = "index"
-	shallowPath    = "shallow"
-	modulePath     = "modules"
-	objectsPath    = "objects"
-	packPath       = "pack"
-	refsPath       = "refs"
+	suffix          = ".git"
+	packedRefsPath  = "packed-refs"
+	configPath      = "config"
+	indexPath       = "index"
+	shallowPath     = "shallow"
+	rebaseMergePath = "rebase-merge"
+	logsPath        = "logs"
+	modulePath      = "modules"
+	objectsPath     = "objects"
+	packPath        = "pack"
+	refsPath        = "refs"
 
 	tmpPackedRefsPrefix = "._packed-refs"
 
@@ -176,6 +180,16 @@ func (d *DotGit) ShallowWriter() (billy.File, error) {
 	return d.fs.Create(shallowPath)
 }
 
+// RemoveShallow removes the shallow file, making the repository complete.
+func (d *DotGit) RemoveShallow() error {
+	err := d.fs.Remove(shallowPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
 // Shallow returns a file pointer for read to the shallow file
 func (d *DotGit) Shallow() (billy.File, error) {
 	f, err := d.fs.Open(shallowPath)
@@ -190,11 +204,86 @@ func (d *DotGit) Shallow() (billy.File, error) {
 	return f, nil
 }
 
+// RebaseMergeWriter returns a file pointer for write to the given file of the
+// rebase-merge directory
+func (d *DotGit) RebaseMergeWriter(name string) (billy.File, error) {
+	return d.fs.Create(d.fs.Join(rebaseMergePath, name))
+}
+
+// RebaseMerge returns a file pointer for read to the given file of the
+// rebase-merge directory, nil is returned if the file does not exist.
+func (d *DotGit) RebaseMerge(name string) (billy.File, error) {
+	f, err := d.fs.Open(d.fs.Join(rebaseMergePath, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RemoveRebaseMerge removes the rebase-merge directory and its content.
+func (d *DotGit) RemoveRebaseMerge() error {
+	return util.RemoveAll(d.fs, rebaseMergePath)
+}
+
+// Reflog returns a file pointer for read to the reflog of the given reference,
+// nil is returned if the reflog does not exist.
+func (d *DotGit) Reflog(name plumbing.ReferenceName) (billy.File, error) {
+	f, err := d.fs.Open(d.reflogPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ReflogWriter returns a file pointer for write to the reflog of the given
+// reference, the existing content is truncated.
+func (d *DotGit) ReflogWriter(name plumbing.ReferenceName) (billy.File, error) {
+	return d.fs.Create(d.reflogPath(name))
+}
+
+// ReflogAppender returns a file pointer for append to the reflog of the given
+// reference, the reflog is created if it does not exist.
+func (d *DotGit) ReflogAppender(name plumbing.ReferenceName) (billy.File, error) {
+	return d.fs.OpenFile(d.reflogPath(name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+}
+
+// RemoveReflog removes the reflog of the given reference, if it exists.
+func (d *DotGit) RemoveReflog(name plumbing.ReferenceName) error {
+	err := d.fs.Remove(d.reflogPath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *DotGit) reflogPath(name plumbing.ReferenceName) string {
+	return d.fs.Join(logsPath, name.String())
+}
+
 // NewObjectPack return a writer for a new packfile, it saves the packfile to
 // disk and also generates and save the index for the given packfile.
 func (d *DotGit) NewObjectPack() (*PackWriter, error) {
 	d.cleanPackList()
-	return newPackWrite(d.fs)
+	return newPackWrite(d.fs, nil)
+}
+
+// NewThinObjectPack return a writer for a new packfile, as NewObjectPack does,
+// accepting thin packfiles: the bases of the deltas missing in the packfile are
+// read from bases and appended to it before saving it.
+func (d *DotGit) NewThinObjectPack(bases storer.EncodedObjectStorer) (*PackWriter, error) {
+	d.cleanPackList()
+	return newPackWrite(d.fs, bases)
 }
 
 // ObjectPacks returns the list of availables packfiles
@@ -316,9 +405,26 @@ func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) er
 	if err != nil {
 		return err
 	}
+
+	err = d.fs.Remove(d.objectPackPath(hash, `promisor`))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return d.fs.Remove(d.objectPackPath(hash, `idx`))
 }
 
+// ObjectPackIsPromisor returns true if the given packfile was received from a
+// promisor remote.
+func (d *DotGit) ObjectPackIsPromisor(hash plumbing.Hash) (bool, error) {
+	_, err := d.fs.Stat(d.objectPackPath(hash, `promisor`))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
 // NewObject return a writer for a new object file.
 func (d *DotGit) NewObject() (*ObjectWriter, error) {
 	d.cleanObjectList()
@@ -598,6 +704,16 @@ func (d *DotGit) checkReferenceAndTruncate(f billy.File, old *plumbing.Reference
 	if err != nil {
 		return err
 	}
+	// an empty file was just created, the reference may be packed
+	if ref.Type() == plumbing.HashReference && ref.Hash().IsZero() {
+		ref, err = d.packedRef(old.Name())
+		if err == plumbing.ErrReferenceNotFound {
+			return storage.ErrReferenceHasChanged
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if ref.Hash() != old.Hash() {
 		return storage.ErrReferenceHasChanged
 	}
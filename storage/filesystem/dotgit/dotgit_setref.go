@@ -25,11 +25,22 @@ func (d *DotGit) setRefRwfs(fileName, content string, old *plumbing.Reference) (
 		mode |= os.O_TRUNC
 	}
 
+	// a file created for a packed or missing reference is removed if the
+	// reference can't be set, not to hide the packed one
+	_, statErr := d.fs.Stat(fileName)
+	created := os.IsNotExist(statErr)
+
 	f, err := d.fs.OpenFile(fileName, mode, 0666)
 	if err != nil {
 		return err
 	}
 
+	defer func() {
+		if err != nil && created {
+			_ = d.fs.Remove(fileName)
+		}
+	}()
+
 	defer ioutil.CheckClose(f, &err)
 
 	// Lock is unlocked by the deferred Close above. This is because Unlock
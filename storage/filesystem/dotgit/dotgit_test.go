@@ -11,6 +11,7 @@ import (
 
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-billy.v4/osfs"
@@ -152,6 +153,29 @@ func (s *SuiteDotGit) TestRefsFromPackedRefs(c *C) {
 
 }
 
+func (s *SuiteDotGit) TestSetRefPacked(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	name := plumbing.ReferenceName("refs/remotes/origin/branch")
+	packed := plumbing.NewReferenceFromStrings(name.String(), "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	other := plumbing.NewReferenceFromStrings(name.String(), "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	err := dir.SetRef(packed, other)
+	c.Assert(err, Equals, storage.ErrReferenceHasChanged)
+
+	// the loose file is not left over the packed reference
+	_, err = fs.Stat(name.String())
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = dir.SetRef(other, packed)
+	c.Assert(err, IsNil)
+
+	ref, err := dir.Ref(name)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, other)
+}
+
 func (s *SuiteDotGit) TestRefsFromReferenceFile(c *C) {
 	fs := fixtures.Basic().ByTag(".git").One().DotGit()
 	dir := New(fs)
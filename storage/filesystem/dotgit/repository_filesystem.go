@@ -0,0 +1,167 @@
+package dotgit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// commonPaths are the paths of a git directory shared by all the worktrees of
+// a repository, with the exceptions of commonPathsExceptions, as described in
+// https://git-scm.com/docs/gitrepository-layout. The rest of the paths, like
+// HEAD or index, belong to each worktree.
+var commonPaths = []string{
+	"branches",
+	"common",
+	"config",
+	"gc.pid",
+	"hooks",
+	"info",
+	logsPath,
+	"lost-found",
+	objectsPath,
+	packedRefsPath,
+	refsPath,
+	"remotes",
+	"rr-cache",
+	shallowPath,
+	"svn",
+	"worktrees",
+}
+
+var commonPathsExceptions = []string{
+	"info/sparse-checkout",
+	"logs/HEAD",
+	"logs/refs/bisect",
+	"logs/refs/worktree",
+	"refs/bisect",
+	"refs/worktree",
+}
+
+// RepositoryFilesystem is a billy.Filesystem joining the git directory of a
+// linked worktree, created by `git worktree add`, with the common directory
+// its commondir file points to. The objects, the references and the
+// configuration are read and written in the common directory, while HEAD,
+// the index and the rest of the files of the worktree are kept in its own
+// git directory.
+type RepositoryFilesystem struct {
+	dotGitFs       billy.Filesystem
+	commonDotGitFs billy.Filesystem
+}
+
+// NewRepositoryFilesystem returns a RepositoryFilesystem for the given git
+// directory of a worktree and the common directory of the repository.
+func NewRepositoryFilesystem(dotGitFs, commonDotGitFs billy.Filesystem) *RepositoryFilesystem {
+	return &RepositoryFilesystem{
+		dotGitFs:       dotGitFs,
+		commonDotGitFs: commonDotGitFs,
+	}
+}
+
+// Worktree returns the git directory of the worktree.
+func (fs *RepositoryFilesystem) Worktree() billy.Filesystem {
+	return fs.dotGitFs
+}
+
+// Common returns the common directory of the repository.
+func (fs *RepositoryFilesystem) Common() billy.Filesystem {
+	return fs.commonDotGitFs
+}
+
+func (fs *RepositoryFilesystem) mapToRepositoryFsByPath(path string) billy.Filesystem {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || path == "/" {
+		return fs.dotGitFs
+	}
+
+	path = strings.TrimPrefix(path, "/")
+	// the packed-refs file is rewritten through a temporary file renamed to it
+	if strings.HasPrefix(path, tmpPackedRefsPrefix) {
+		return fs.commonDotGitFs
+	}
+
+	for _, p := range commonPathsExceptions {
+		if hasPathPrefix(path, p) {
+			return fs.dotGitFs
+		}
+	}
+
+	for _, p := range commonPaths {
+		if hasPathPrefix(path, p) {
+			return fs.commonDotGitFs
+		}
+	}
+
+	return fs.dotGitFs
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (fs *RepositoryFilesystem) Create(filename string) (billy.File, error) {
+	return fs.mapToRepositoryFsByPath(filename).Create(filename)
+}
+
+func (fs *RepositoryFilesystem) Open(filename string) (billy.File, error) {
+	return fs.mapToRepositoryFsByPath(filename).Open(filename)
+}
+
+func (fs *RepositoryFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return fs.mapToRepositoryFsByPath(filename).OpenFile(filename, flag, perm)
+}
+
+func (fs *RepositoryFilesystem) Stat(filename string) (os.FileInfo, error) {
+	return fs.mapToRepositoryFsByPath(filename).Stat(filename)
+}
+
+func (fs *RepositoryFilesystem) Rename(oldpath, newpath string) error {
+	return fs.mapToRepositoryFsByPath(newpath).Rename(oldpath, newpath)
+}
+
+func (fs *RepositoryFilesystem) Remove(filename string) error {
+	return fs.mapToRepositoryFsByPath(filename).Remove(filename)
+}
+
+func (fs *RepositoryFilesystem) Join(elem ...string) string {
+	return fs.dotGitFs.Join(elem...)
+}
+
+func (fs *RepositoryFilesystem) TempFile(dir, prefix string) (billy.File, error) {
+	return fs.mapToRepositoryFsByPath(filepath.Join(dir, prefix)).TempFile(dir, prefix)
+}
+
+func (fs *RepositoryFilesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return fs.mapToRepositoryFsByPath(path).ReadDir(path)
+}
+
+func (fs *RepositoryFilesystem) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.mapToRepositoryFsByPath(filename).MkdirAll(filename, perm)
+}
+
+func (fs *RepositoryFilesystem) Lstat(filename string) (os.FileInfo, error) {
+	return fs.mapToRepositoryFsByPath(filename).Lstat(filename)
+}
+
+func (fs *RepositoryFilesystem) Symlink(target, link string) error {
+	return fs.mapToRepositoryFsByPath(link).Symlink(target, link)
+}
+
+func (fs *RepositoryFilesystem) Readlink(link string) (string, error) {
+	return fs.mapToRepositoryFsByPath(link).Readlink(link)
+}
+
+func (fs *RepositoryFilesystem) Chroot(path string) (billy.Filesystem, error) {
+	return fs.mapToRepositoryFsByPath(path).Chroot(path)
+}
+
+func (fs *RepositoryFilesystem) Root() string {
+	return fs.dotGitFs.Root()
+}
+
+// Capabilities returns the capabilities supported by both directories.
+func (fs *RepositoryFilesystem) Capabilities() billy.Capability {
+	return billy.Capabilities(fs.dotGitFs) & billy.Capabilities(fs.commonDotGitFs)
+}
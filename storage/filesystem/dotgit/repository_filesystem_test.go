@@ -0,0 +1,82 @@
+package dotgit
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type RepositoryFilesystemSuite struct{}
+
+var _ = Suite(&RepositoryFilesystemSuite{})
+
+func (s *RepositoryFilesystemSuite) TestMapToRepositoryFsByPath(c *C) {
+	fs := memfs.New()
+	common, err := fs.Chroot("common")
+	c.Assert(err, IsNil)
+	worktree, err := fs.Chroot("common/worktrees/foo")
+	c.Assert(err, IsNil)
+
+	rfs := NewRepositoryFilesystem(worktree, common)
+	for path, isCommon := range map[string]bool{
+		"HEAD":                    false,
+		"index":                   false,
+		"ORIG_HEAD":               false,
+		"rebase-merge/onto":       false,
+		"logs/HEAD":               false,
+		"refs/bisect/bad":         false,
+		"info/sparse-checkout":    false,
+		"config":                  true,
+		"packed-refs":             true,
+		"objects/pack":            true,
+		"refs/heads/master":       true,
+		"logs/refs/heads/master":  true,
+		"info/exclude":            true,
+		tmpPackedRefsPrefix + "1": true,
+		"refsfoo":                 false,
+	} {
+		expected := worktree
+		if isCommon {
+			expected = common
+		}
+
+		c.Assert(rfs.mapToRepositoryFsByPath(path), Equals, expected, Commentf("path %q", path))
+	}
+}
+
+func (s *RepositoryFilesystemSuite) TestDotGit(c *C) {
+	fs := osfs.New(c.MkDir())
+	common, err := fs.Chroot("common")
+	c.Assert(err, IsNil)
+	worktree, err := fs.Chroot("common/worktrees/foo")
+	c.Assert(err, IsNil)
+
+	d := New(NewRepositoryFilesystem(worktree, common))
+	c.Assert(d.Initialize(), IsNil)
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/foo")
+	c.Assert(d.SetRef(head, nil), IsNil)
+	branch := plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(d.SetRef(branch, nil), IsNil)
+
+	_, err = worktree.Stat("HEAD")
+	c.Assert(err, IsNil)
+	_, err = common.Stat("HEAD")
+	c.Assert(err, NotNil)
+
+	_, err = common.Stat("refs/heads/foo")
+	c.Assert(err, IsNil)
+	_, err = common.Stat("objects/pack")
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(common, "packed-refs", []byte(
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/bar\n"), 0644), IsNil)
+	c.Assert(d.RemoveRef("refs/heads/bar"), IsNil)
+
+	refs, err := d.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+}
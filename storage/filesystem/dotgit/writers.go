@@ -9,6 +9,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/objfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 
 	"gopkg.in/src-d/go-billy.v4"
 )
@@ -21,8 +22,12 @@ import (
 // location, if the PackWriter is not used, nothing is written
 type PackWriter struct {
 	Notify func(plumbing.Hash, *idxfile.Writer)
+	// Promisor marks the packfile as received from a promisor remote, with
+	// an empty .promisor file next to it.
+	Promisor bool
 
 	fs       billy.Filesystem
+	bases    storer.EncodedObjectStorer
 	fr, fw   billy.File
 	synced   *syncedReader
 	checksum plumbing.Hash
@@ -31,7 +36,7 @@ type PackWriter struct {
 	result   chan error
 }
 
-func newPackWrite(fs billy.Filesystem) (*PackWriter, error) {
+func newPackWrite(fs billy.Filesystem, bases storer.EncodedObjectStorer) (*PackWriter, error) {
 	fw, err := fs.TempFile(fs.Join(objectsPath, packPath), "tmp_pack_")
 	if err != nil {
 		return nil, err
@@ -44,6 +49,7 @@ func newPackWrite(fs billy.Filesystem) (*PackWriter, error) {
 
 	writer := &PackWriter{
 		fs:     fs,
+		bases:  bases,
 		fw:     fw,
 		fr:     fr,
 		synced: newSyncedReader(fw, fr),
@@ -58,7 +64,7 @@ func (w *PackWriter) buildIndex() {
 	s := packfile.NewScanner(w.synced)
 	w.writer = new(idxfile.Writer)
 	var err error
-	w.parser, err = packfile.NewParser(s, w.writer)
+	w.parser, err = packfile.NewParserWithThinPackBases(s, w.bases, w.writer)
 	if err != nil {
 		w.result <- err
 		return
@@ -109,6 +115,10 @@ func (w *PackWriter) Close() error {
 		return err
 	}
 
+	if err := w.fixThinPack(); err != nil {
+		return err
+	}
+
 	if err := w.fr.Close(); err != nil {
 		return err
 	}
@@ -124,6 +134,22 @@ func (w *PackWriter) Close() error {
 	return w.save()
 }
 
+// fixThinPack appends to a thin packfile the bases missing in it, the checksum
+// of the packfile changes if any is added.
+func (w *PackWriter) fixThinPack() error {
+	if w.writer == nil || !w.writer.Finished() {
+		return nil
+	}
+
+	checksum, err := w.parser.FixThinPack(w.fw)
+	if err != nil {
+		return err
+	}
+
+	w.checksum = checksum
+	return nil
+}
+
 func (w *PackWriter) clean() error {
 	return w.fs.Remove(w.fw.Name())
 }
@@ -143,9 +169,24 @@ func (w *PackWriter) save() error {
 		return err
 	}
 
+	if w.Promisor {
+		if err := w.savePromisor(base); err != nil {
+			return err
+		}
+	}
+
 	return w.fs.Rename(w.fw.Name(), fmt.Sprintf("%s.pack", base))
 }
 
+func (w *PackWriter) savePromisor(base string) error {
+	f, err := w.fs.Create(fmt.Sprintf("%s.promisor", base))
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
 func (w *PackWriter) encodeIdx(writer io.Writer) error {
 	idx, err := w.writer.Index()
 	if err != nil {
@@ -7,10 +7,12 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-billy.v4/osfs"
@@ -64,6 +66,88 @@ func (s *SuiteDotGit) TestNewObjectPack(c *C) {
 	c.Assert(pfs.Close(), IsNil)
 }
 
+func (s *SuiteDotGit) TestNewObjectPackPromisor(c *C) {
+	f := fixtures.Basic().One()
+
+	dir, err := ioutil.TempDir("", "example")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fs := osfs.New(dir)
+	dot := New(fs)
+
+	w, err := dot.NewObjectPack()
+	c.Assert(err, IsNil)
+	w.Promisor = true
+
+	_, err = io.Copy(w, f.Packfile())
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	_, err = fs.Stat(fmt.Sprintf("objects/pack/pack-%s.promisor", f.PackfileHash))
+	c.Assert(err, IsNil)
+
+	h := f.PackfileHash
+	promisor, err := dot.ObjectPackIsPromisor(h)
+	c.Assert(err, IsNil)
+	c.Assert(promisor, Equals, true)
+
+	c.Assert(dot.DeleteOldObjectPackAndIndex(h, time.Time{}), IsNil)
+	promisor, err = dot.ObjectPackIsPromisor(h)
+	c.Assert(err, IsNil)
+	c.Assert(promisor, Equals, false)
+}
+
+func (s *SuiteDotGit) TestNewThinObjectPack(c *C) {
+	bases := memory.NewStorage()
+	f := fixtures.ByURL("https://github.com/spinnaker/spinnaker.git").One()
+	p, err := packfile.NewParserWithStorage(packfile.NewScanner(f.Packfile()), bases)
+	c.Assert(err, IsNil)
+	_, err = p.Parse()
+	c.Assert(err, IsNil)
+
+	fs := osfs.New(c.MkDir())
+	dot := New(fs)
+
+	thin := fixtures.ByTag("thinpack").One()
+	_, count, err := packfile.NewScanner(thin.Packfile()).Header()
+	c.Assert(err, IsNil)
+
+	w, err := dot.NewThinObjectPack(bases)
+	c.Assert(err, IsNil)
+
+	_, err = io.Copy(w, thin.Packfile())
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	hashes, err := dot.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, 1)
+	c.Assert(hashes[0], Not(Equals), thin.PackfileHash)
+
+	// the saved packfile is self-contained
+	pf, err := dot.ObjectPack(hashes[0])
+	c.Assert(err, IsNil)
+	defer pf.Close()
+
+	iw := new(idxfile.Writer)
+	p, err = packfile.NewParser(packfile.NewScanner(pf), iw)
+	c.Assert(err, IsNil)
+	checksum, err := p.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(checksum, Equals, hashes[0])
+
+	idx, err := iw.Index()
+	c.Assert(err, IsNil)
+	fixed, err := idx.Count()
+	c.Assert(err, IsNil)
+	c.Assert(fixed > int64(count), Equals, true)
+
+	ok, err := idx.Contains(thin.Head)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+}
+
 func (s *SuiteDotGit) TestNewObjectPackUnused(c *C) {
 	dir, err := ioutil.TempDir("", "example")
 	if err != nil {
@@ -146,7 +230,7 @@ func (s *SuiteDotGit) TestPackWriterUnusedNotify(c *C) {
 
 	fs := osfs.New(dir)
 
-	w, err := newPackWrite(fs)
+	w, err := newPackWrite(fs, nil)
 	c.Assert(err, IsNil)
 
 	w.Notify = func(h plumbing.Hash, idx *idxfile.Writer) {
@@ -94,15 +94,27 @@ func (s *ObjectStorage) NewEncodedObject() plumbing.EncodedObject {
 }
 
 func (s *ObjectStorage) PackfileWriter() (io.WriteCloser, error) {
+	return s.packfileWriter(false)
+}
+
+// PromisorPackfileWriter returns a writer for a packfile received from a
+// promisor remote, it is marked as such on disk.
+func (s *ObjectStorage) PromisorPackfileWriter() (io.WriteCloser, error) {
+	return s.packfileWriter(true)
+}
+
+func (s *ObjectStorage) packfileWriter(promisor bool) (io.WriteCloser, error) {
 	if err := s.requireIndex(); err != nil {
 		return nil, err
 	}
 
-	w, err := s.dir.NewObjectPack()
+	w, err := s.dir.NewThinObjectPack(s)
 	if err != nil {
 		return nil, err
 	}
 
+	w.Promisor = promisor
+
 	w.Notify = func(h plumbing.Hash, writer *idxfile.Writer) {
 		index, err := writer.Index()
 		if err == nil {
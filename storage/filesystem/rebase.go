@@ -0,0 +1,48 @@
+package filesystem
+
+import (
+	stdioutil "io/ioutil"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// RebaseStorage stores the state of a rebase in progress in the rebase-merge
+// directory of the .git folder.
+type RebaseStorage struct {
+	dir *dotgit.DotGit
+}
+
+// SetRebaseFile writes the given file in the rebase-merge directory.
+func (s *RebaseStorage) SetRebaseFile(name string, content []byte) (err error) {
+	f, err := s.dir.RebaseMergeWriter(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	_, err = f.Write(content)
+	return err
+}
+
+// RebaseFile returns the content of the given file in the rebase-merge
+// directory.
+func (s *RebaseStorage) RebaseFile(name string) (content []byte, err error) {
+	f, err := s.dir.RebaseMerge(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f == nil {
+		return nil, storer.ErrRebaseFileNotFound
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return stdioutil.ReadAll(f)
+}
+
+// RemoveRebase removes the rebase-merge directory.
+func (s *RebaseStorage) RemoveRebase() error {
+	return s.dir.RemoveRebaseMerge()
+}
@@ -0,0 +1,78 @@
+package filesystem
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+// ReflogStorage stores the reflogs of the references in the logs directory of
+// the .git folder.
+type ReflogStorage struct {
+	dir *dotgit.DotGit
+}
+
+// Reflog returns the entries of the reflog of the given reference.
+func (s *ReflogStorage) Reflog(name plumbing.ReferenceName) (entries []*reflog.Entry, err error) {
+	f, err := s.dir.Reflog(name)
+	if f == nil || err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewDecoder(f).Decode()
+}
+
+// AppendReflog appends an entry to the reflog of the given reference.
+func (s *ReflogStorage) AppendReflog(name plumbing.ReferenceName, e *reflog.Entry) (err error) {
+	f, err := s.dir.ReflogAppender(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewEncoder(f).Encode(e)
+}
+
+// SetReflog replaces the entries of the reflog of the given reference.
+func (s *ReflogStorage) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) (err error) {
+	f, err := s.dir.ReflogWriter(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewEncoder(f).Encode(entries...)
+}
+
+// RemoveReflog removes the reflog of the given reference.
+func (s *ReflogStorage) RemoveReflog(name plumbing.ReferenceName) error {
+	return s.dir.RemoveReflog(name)
+}
+
+// SetReference sets the given reference, recording the update in the reflog
+// with an empty message, see SetLoggedReference.
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	return s.SetLoggedReference(ref, nil, nil, "")
+}
+
+// CheckAndSetReference sets the given reference if old is its current value,
+// recording the update in the reflog with an empty message.
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	return s.SetLoggedReference(ref, old, nil, "")
+}
+
+// SetLoggedReference sets the given reference, as CheckAndSetReference does,
+// and records the update in the reflog, see storage.SetLoggedReference.
+func (s *Storage) SetLoggedReference(
+	ref, old *plumbing.Reference, committer *reflog.Signature, msg string,
+) error {
+	return storage.SetLoggedReference(s, &s.ReferenceStorage, ref, old, committer, msg)
+}
+
+// RemoveReference removes the given reference along with its reflog.
+func (s *Storage) RemoveReference(name plumbing.ReferenceName) error {
+	return storage.RemoveLoggedReference(s, &s.ReferenceStorage, name)
+}
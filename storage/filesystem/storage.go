@@ -19,6 +19,8 @@ type Storage struct {
 	ReferenceStorage
 	IndexStorage
 	ShallowStorage
+	RebaseStorage
+	ReflogStorage
 	ConfigStorage
 	ModuleStorage
 }
@@ -57,6 +59,8 @@ func NewStorageWithOptions(fs billy.Filesystem, cache cache.Object, ops Options)
 		ReferenceStorage: ReferenceStorage{dir: dir},
 		IndexStorage:     IndexStorage{dir: dir},
 		ShallowStorage:   ShallowStorage{dir: dir},
+		RebaseStorage:    RebaseStorage{dir: dir},
+		ReflogStorage:    ReflogStorage{dir: dir},
 		ConfigStorage:    ConfigStorage{dir: dir},
 		ModuleStorage:    ModuleStorage{dir: dir},
 	}
@@ -35,6 +35,9 @@ func setUpTest(s *StorageSuite, c *C, storage *Storage) {
 	var _ storer.IndexStorer = storage
 	var _ storer.ReferenceStorer = storage
 	var _ storer.ShallowStorer = storage
+	var _ storer.RebaseStorer = storage
+	var _ storer.ReflogStorer = storage
+	var _ storer.LoggedReferenceStorer = storage
 	var _ storer.DeltaObjectStorer = storage
 	var _ storer.PackfileWriter = storage
 
@@ -8,6 +8,7 @@ import (
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 )
@@ -22,6 +23,8 @@ type Storage struct {
 	ConfigStorage
 	ObjectStorage
 	ShallowStorage
+	RebaseStorage
+	ReflogStorage
 	IndexStorage
 	ReferenceStorage
 	ModuleStorage
@@ -33,6 +36,8 @@ func NewStorage() *Storage {
 		ReferenceStorage: make(ReferenceStorage),
 		ConfigStorage:    ConfigStorage{},
 		ShallowStorage:   ShallowStorage{},
+		RebaseStorage:    make(RebaseStorage),
+		ReflogStorage:    make(ReflogStorage),
 		ObjectStorage: ObjectStorage{
 			Objects: make(map[plumbing.Hash]plumbing.EncodedObject),
 			Commits: make(map[plumbing.Hash]plumbing.EncodedObject),
@@ -306,6 +311,76 @@ func (s ShallowStorage) Shallow() ([]plumbing.Hash, error) {
 	return s, nil
 }
 
+type RebaseStorage map[string][]byte
+
+func (s RebaseStorage) SetRebaseFile(name string, content []byte) error {
+	s[name] = content
+	return nil
+}
+
+func (s RebaseStorage) RebaseFile(name string) ([]byte, error) {
+	content, ok := s[name]
+	if !ok {
+		return nil, storer.ErrRebaseFileNotFound
+	}
+
+	return content, nil
+}
+
+func (s RebaseStorage) RemoveRebase() error {
+	for name := range s {
+		delete(s, name)
+	}
+
+	return nil
+}
+
+type ReflogStorage map[plumbing.ReferenceName][]*reflog.Entry
+
+func (s ReflogStorage) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	return s[name], nil
+}
+
+func (s ReflogStorage) AppendReflog(name plumbing.ReferenceName, e *reflog.Entry) error {
+	s[name] = append(s[name], e)
+	return nil
+}
+
+func (s ReflogStorage) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) error {
+	s[name] = entries
+	return nil
+}
+
+func (s ReflogStorage) RemoveReflog(name plumbing.ReferenceName) error {
+	delete(s, name)
+	return nil
+}
+
+// SetReference sets the given reference, recording the update in the reflog
+// with an empty message, see SetLoggedReference.
+func (s *Storage) SetReference(ref *plumbing.Reference) error {
+	return s.SetLoggedReference(ref, nil, nil, "")
+}
+
+// CheckAndSetReference sets the given reference if old is its current value,
+// recording the update in the reflog with an empty message.
+func (s *Storage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	return s.SetLoggedReference(ref, old, nil, "")
+}
+
+// SetLoggedReference sets the given reference, as CheckAndSetReference does,
+// and records the update in the reflog, see storage.SetLoggedReference.
+func (s *Storage) SetLoggedReference(
+	ref, old *plumbing.Reference, committer *reflog.Signature, msg string,
+) error {
+	return storage.SetLoggedReference(s, s.ReferenceStorage, ref, old, committer, msg)
+}
+
+// RemoveReference removes the given reference along with its reflog.
+func (s *Storage) RemoveReference(name plumbing.ReferenceName) error {
+	return storage.RemoveLoggedReference(s, s.ReferenceStorage, name)
+}
+
 type ModuleStorage map[string]*Storage
 
 func (s ModuleStorage) Module(name string) (storage.Storer, error) {
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// SetLoggedReference sets the reference in refs, as CheckAndSetReference
+// does, and records the update in the reflogs of s, as git does: in the reflog
+// of the reference and, when HEAD points to it, in the reflog of HEAD. It is
+// used by the storages to implement storer.LoggedReferenceStorer, refs being
+// the references of s not recording their updates.
+//
+// The entry is recorded with the given committer or, if nil, with the user of
+// the configuration. Nothing is recorded if s does not support reflogs, if the
+// reference is not logged, see core.logAllRefUpdates, or if it is set without
+// message to a symbolic reference or to the value it already has.
+func SetLoggedReference(
+	s Storer, refs storer.ReferenceStorer,
+	new, old *plumbing.Reference, committer *reflog.Signature, msg string,
+) error {
+	rs, ok := s.(storer.ReflogStorer)
+	if !ok || new == nil {
+		return refs.CheckAndSetReference(new, old)
+	}
+
+	name := new.Name()
+	oldHash, err := resolveReferenceHash(s, name)
+	if err != nil {
+		return err
+	}
+
+	if err := refs.CheckAndSetReference(new, old); err != nil {
+		return err
+	}
+
+	newHash, err := resolveReferenceHash(s, name)
+	if err != nil {
+		return err
+	}
+
+	if msg == "" && (new.Type() == plumbing.SymbolicReference || newHash == oldHash) {
+		return nil
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	if !isLoggedReference(cfg, name) {
+		return nil
+	}
+
+	e := &reflog.Entry{Old: oldHash, New: newHash, Message: msg}
+	if committer != nil {
+		e.Committer = *committer
+	} else {
+		e.Committer = reflog.Signature{
+			Name:  rawConfigOption(cfg, "user", "name"),
+			Email: rawConfigOption(cfg, "user", "email"),
+			When:  time.Now(),
+		}
+	}
+
+	if err := rs.AppendReflog(name, e); err != nil {
+		return err
+	}
+
+	if name == plumbing.HEAD {
+		return nil
+	}
+
+	head, err := s.Reference(plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if head.Type() != plumbing.SymbolicReference || head.Target() != name {
+		return nil
+	}
+
+	return rs.AppendReflog(plumbing.HEAD, e)
+}
+
+// RemoveLoggedReference removes the reference from refs, along with its reflog
+// in s, if s supports reflogs.
+func RemoveLoggedReference(s Storer, refs storer.ReferenceStorer, name plumbing.ReferenceName) error {
+	if err := refs.RemoveReference(name); err != nil {
+		return err
+	}
+
+	rs, ok := s.(storer.ReflogStorer)
+	if !ok {
+		return nil
+	}
+
+	return rs.RemoveReflog(name)
+}
+
+// isLoggedReference returns if the updates of the given reference are kept in
+// its reflog. By default, as in git, HEAD, the branches, the remote branches
+// and the notes are logged unless the repository is bare.
+func isLoggedReference(cfg *config.Config, name plumbing.ReferenceName) bool {
+	switch strings.ToLower(rawConfigOption(cfg, "core", "logallrefupdates")) {
+	case "always":
+		return true
+	case "false":
+		return false
+	case "":
+		if cfg.Core.IsBare {
+			return false
+		}
+	}
+
+	return name == plumbing.HEAD || name.IsBranch() || name.IsRemote() || name.IsNote()
+}
+
+// rawConfigOption returns the value of the given option, without adding the
+// section to the configuration when it is missing.
+func rawConfigOption(cfg *config.Config, section, key string) string {
+	var value string
+	for _, s := range cfg.Raw.Sections {
+		if !s.IsName(section) {
+			continue
+		}
+
+		if v := s.Option(key); v != "" {
+			value = v
+		}
+	}
+
+	return value
+}
+
+// resolveReferenceHash returns the hash the given reference points to, or the
+// zero hash if the reference, or its target, does not exist.
+func resolveReferenceHash(s storer.ReferenceStorer, name plumbing.ReferenceName) (plumbing.Hash, error) {
+	ref, err := storer.ResolveReference(s, name)
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, nil
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
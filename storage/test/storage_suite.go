@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/reflog"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage"
 
@@ -397,6 +399,111 @@ func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	c.Assert(result, DeepEquals, expected)
 }
 
+func (s *BaseStorageSuite) TestSetRebaseFileAndRebaseFile(c *C) {
+	rs, ok := s.Storer.(storer.RebaseStorer)
+	if !ok {
+		c.Skip("not a storer.RebaseStorer")
+	}
+
+	_, err := rs.RebaseFile("onto")
+	c.Assert(err, Equals, storer.ErrRebaseFileNotFound)
+
+	err = rs.SetRebaseFile("onto", []byte("foo\n"))
+	c.Assert(err, IsNil)
+
+	err = rs.SetRebaseFile("done", []byte("bar\n"))
+	c.Assert(err, IsNil)
+
+	content, err := rs.RebaseFile("onto")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo\n")
+
+	err = rs.RemoveRebase()
+	c.Assert(err, IsNil)
+
+	_, err = rs.RebaseFile("done")
+	c.Assert(err, Equals, storer.ErrRebaseFileNotFound)
+}
+
+func (s *BaseStorageSuite) TestAppendReflogAndReflog(c *C) {
+	rs, ok := s.Storer.(storer.ReflogStorer)
+	if !ok {
+		c.Skip("not a storer.ReflogStorer")
+	}
+
+	name := plumbing.ReferenceName("refs/heads/foo")
+	entries, err := rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	first := &reflog.Entry{
+		New:       plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		Committer: reflog.Signature{Name: "foo", Email: "foo@foo.com", When: time.Unix(1, 0)},
+		Message:   "branch: Created from HEAD",
+	}
+
+	second := &reflog.Entry{
+		Old:       first.New,
+		New:       plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+		Committer: first.Committer,
+		Message:   "commit: bar",
+	}
+
+	c.Assert(rs.AppendReflog(name, first), IsNil)
+	c.Assert(rs.AppendReflog(name, second), IsNil)
+
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].New, Equals, first.New)
+	c.Assert(entries[1].Message, Equals, "commit: bar")
+
+	c.Assert(rs.SetReflog(name, entries[1:]), IsNil)
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].New, Equals, second.New)
+
+	c.Assert(rs.RemoveReflog(name), IsNil)
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *BaseStorageSuite) TestSetLoggedReference(c *C) {
+	ls, ok := s.Storer.(storer.LoggedReferenceStorer)
+	if !ok {
+		c.Skip("not a storer.LoggedReferenceStorer")
+	}
+
+	rs := s.Storer.(storer.ReflogStorer)
+	name := plumbing.ReferenceName("refs/heads/foo")
+	ref := plumbing.NewHashReference(name, plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"))
+	committer := &reflog.Signature{Name: "foo", Email: "foo@foo.com", When: time.Unix(1, 0)}
+
+	c.Assert(ls.SetLoggedReference(ref, nil, committer, "branch: Created from HEAD"), IsNil)
+	c.Assert(s.Storer.SetReference(ref), IsNil)
+
+	next := plumbing.NewHashReference(name, plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"))
+	c.Assert(s.Storer.CheckAndSetReference(next, ref), IsNil)
+
+	entries, err := rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[0].New, Equals, ref.Hash())
+	c.Assert(entries[0].Committer.Name, Equals, "foo")
+	c.Assert(entries[0].Message, Equals, "branch: Created from HEAD")
+	c.Assert(entries[1].Old, Equals, ref.Hash())
+	c.Assert(entries[1].New, Equals, next.Hash())
+	c.Assert(entries[1].Message, Equals, "")
+
+	c.Assert(s.Storer.RemoveReference(name), IsNil)
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
 func (s *BaseStorageSuite) TestSetConfigAndConfig(c *C) {
 	expected := config.NewConfig()
 	expected.Core.IsBare = true
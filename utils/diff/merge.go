@@ -0,0 +1,202 @@
+package diff
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	conflictMarkerOurs   = "<<<<<<<"
+	conflictMarkerSep    = "======="
+	conflictMarkerTheirs = ">>>>>>>"
+)
+
+// Merge performs a line oriented three-way merge, similar to the one done by
+// `git merge-file`. The changes made from base to ours and from base to
+// theirs are combined into a single text. Changes touching the same or
+// adjacent lines of base are reported as conflicts, both versions are written
+// to the result between conflict markers labeled with ours and theirs.
+//
+// The merged text is returned along with a boolean that is true when the
+// merge is clean, without conflicts.
+func Merge(base, ours, theirs, oursLabel, theirsLabel string) (string, bool) {
+	switch {
+	case ours == theirs, base == theirs:
+		return ours, true
+	case base == ours:
+		return theirs, true
+	}
+
+	baseLines := splitLines(base)
+	lines := [2][]string{splitLines(ours), splitLines(theirs)}
+
+	hunks := append(lineHunks(base, ours, 0), lineHunks(base, theirs, 1)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].baseStart < hunks[j].baseStart
+	})
+
+	var buf bytes.Buffer
+	clean := true
+	pos := 0
+	for i := 0; i < len(hunks); {
+		lo, hi := hunks[i].baseStart, hunks[i].baseEnd
+		sides := 1 << uint(hunks[i].side)
+
+		j := i + 1
+		for ; j < len(hunks) && hunks[j].baseStart <= hi; j++ {
+			if hunks[j].baseEnd > hi {
+				hi = hunks[j].baseEnd
+			}
+
+			sides |= 1 << uint(hunks[j].side)
+		}
+
+		group := hunks[i:j]
+		writeLines(&buf, baseLines[pos:lo])
+
+		oursText := applyHunks(baseLines, lines[0], group, 0, lo, hi)
+		theirsText := applyHunks(baseLines, lines[1], group, 1, lo, hi)
+
+		switch {
+		case sides == 1:
+			buf.WriteString(oursText)
+		case sides == 2, oursText == theirsText:
+			buf.WriteString(theirsText)
+		default:
+			clean = false
+			writeConflict(&buf, oursText, theirsText, oursLabel, theirsLabel)
+		}
+
+		pos = hi
+		i = j
+	}
+
+	writeLines(&buf, baseLines[pos:])
+	return buf.String(), clean
+}
+
+// hunk is a range of lines of the base text replaced by a range of lines of
+// one of the sides being merged.
+type hunk struct {
+	baseStart, baseEnd   int
+	otherStart, otherEnd int
+	side                 int
+}
+
+func lineHunks(base, other string, side int) []hunk {
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = time.Hour
+	wBase, wOther, _ := dmp.DiffLinesToRunes(base, other)
+
+	var hunks []hunk
+	var current *hunk
+	var i, j int
+	for _, d := range dmp.DiffMainRunes(wBase, wOther, false) {
+		n := utf8.RuneCountInString(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+
+			i += n
+			j += n
+			continue
+		}
+
+		if current == nil {
+			current = &hunk{
+				baseStart: i, baseEnd: i,
+				otherStart: j, otherEnd: j,
+				side: side,
+			}
+		}
+
+		if d.Type == diffmatchpatch.DiffDelete {
+			i += n
+			current.baseEnd = i
+		} else {
+			j += n
+			current.otherEnd = j
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+
+	return hunks
+}
+
+// applyHunks returns the lines lo to hi of base after applying the hunks
+// of the given side.
+func applyHunks(base, other []string, hunks []hunk, side, lo, hi int) string {
+	var buf bytes.Buffer
+	pos := lo
+	for _, h := range hunks {
+		if h.side != side {
+			continue
+		}
+
+		writeLines(&buf, base[pos:h.baseStart])
+		writeLines(&buf, other[h.otherStart:h.otherEnd])
+		pos = h.baseEnd
+	}
+
+	writeLines(&buf, base[pos:hi])
+	return buf.String()
+}
+
+func writeConflict(buf *bytes.Buffer, ours, theirs, oursLabel, theirsLabel string) {
+	writeMarker(buf, conflictMarkerOurs, oursLabel)
+	writeWithNewline(buf, ours)
+	writeMarker(buf, conflictMarkerSep, "")
+	writeWithNewline(buf, theirs)
+	writeMarker(buf, conflictMarkerTheirs, theirsLabel)
+}
+
+func writeMarker(buf *bytes.Buffer, marker, label string) {
+	buf.WriteString(marker)
+	if label != "" {
+		buf.WriteString(" ")
+		buf.WriteString(label)
+	}
+
+	buf.WriteString("\n")
+}
+
+func writeWithNewline(buf *bytes.Buffer, text string) {
+	buf.WriteString(text)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		buf.WriteString("\n")
+	}
+}
+
+func writeLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+	}
+}
+
+// splitLines splits the text into lines, keeping the line endings, in the
+// same way the line diffs are computed.
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+
+	return lines
+}
@@ -0,0 +1,75 @@
+package diff_test
+
+import (
+	"gopkg.in/src-d/go-git.v4/utils/diff"
+
+	. "gopkg.in/check.v1"
+)
+
+var mergeTests = [...]struct {
+	base, ours, theirs string
+	exp                string
+	clean              bool
+}{
+	// trivial merges
+	{"a\n", "a\n", "a\n", "a\n", true},
+	{"a\n", "b\n", "a\n", "b\n", true},
+	{"a\n", "a\n", "b\n", "b\n", true},
+	{"a\n", "b\n", "b\n", "b\n", true},
+	// non overlapping changes
+	{
+		"a\nb\nc\nd\ne\n",
+		"A\nb\nc\nd\ne\n",
+		"a\nb\nc\nd\nE\n",
+		"A\nb\nc\nd\nE\n", true,
+	},
+	{
+		"a\nb\nc\n",
+		"0\na\nb\nc\n",
+		"a\nb\nc\nd\n",
+		"0\na\nb\nc\nd\n", true,
+	},
+	// same change on both sides
+	{
+		"a\nb\nc\n",
+		"a\nB\nc\n",
+		"a\nB\nc\n",
+		"a\nB\nc\n", true,
+	},
+	// conflicting changes
+	{
+		"a\nb\nc\n",
+		"a\nB\nc\n",
+		"a\nX\nc\n",
+		"a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\nc\n", false,
+	},
+	// adjacent changes conflict
+	{
+		"a\nb\nc\nd\n",
+		"a\nB\nc\nd\n",
+		"a\nb\nC\nd\n",
+		"a\n<<<<<<< ours\nB\nc\n=======\nb\nC\n>>>>>>> theirs\nd\n", false,
+	},
+	// missing newline at the end of file
+	{
+		"a",
+		"b",
+		"c",
+		"<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\n", false,
+	},
+	// add/add with empty base
+	{
+		"",
+		"a\n",
+		"b\n",
+		"<<<<<<< ours\na\n=======\nb\n>>>>>>> theirs\n", false,
+	},
+}
+
+func (s *suiteCommon) TestMerge(c *C) {
+	for i, t := range mergeTests {
+		merged, clean := diff.Merge(t.base, t.ours, t.theirs, "ours", "theirs")
+		c.Assert(merged, Equals, t.exp, Commentf("subtest %d", i))
+		c.Assert(clean, Equals, t.clean, Commentf("subtest %d", i))
+	}
+}
@@ -46,7 +46,8 @@ type Worktree struct {
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 // no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// How the changes are incorporated depends on PullOptions.Strategy, by default
+// only fast-forward updates are allowed.
 func (w *Worktree) Pull(o *PullOptions) error {
 	return w.PullContext(context.Background(), o)
 }
@@ -55,80 +56,242 @@ func (w *Worktree) Pull(o *PullOptions) error {
 // branch. Returns nil if the operation is successful, NoErrAlreadyUpToDate if
 // there are no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// How the changes are incorporated depends on PullOptions.Strategy, by default
+// only fast-forward updates are allowed.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects to the
 // transport operations.
 func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
+	_, err := w.PullWithResultContext(ctx, o)
+	return err
+}
+
+// PullResult describes how a pull updated the current branch.
+type PullResult struct {
+	// Action is what the pull did to the current branch.
+	Action PullAction
+	// Head is the commit pointed by HEAD after the pull.
+	Head plumbing.Hash
+	// Rebased is the number of commits replayed by a PullRebase strategy.
+	Rebased int
+	// Conflicts are the paths that could not be merged when Action is
+	// PullConflicted.
+	Conflicts []string
+}
+
+// PullAction is the action performed by a pull on the current branch.
+type PullAction int8
+
+const (
+	// PullUpToDate means the current branch already contains the changes.
+	PullUpToDate PullAction = iota
+	// PullFastForwarded means the current branch was fast-forwarded.
+	PullFastForwarded
+	// PullMerged means the changes were merged in a new merge commit.
+	PullMerged
+	// PullRebased means the local commits were replayed on top of the
+	// fetched ones.
+	PullRebased
+	// PullConflicted means the pull stopped because of conflicts.
+	PullConflicted
+)
+
+// PullWithResult is like Pull, but it also returns a PullResult describing
+// what happened to the current branch.
+func (w *Worktree) PullWithResult(o *PullOptions) (*PullResult, error) {
+	return w.PullWithResultContext(context.Background(), o)
+}
+
+// PullWithResultContext is like PullContext, but it also returns a PullResult
+// describing what happened to the current branch. When the pull stops on
+// conflicts, the result is returned along with ErrMergeConflicts or
+// ErrRebaseConflicts.
+//
+// The provided Context must be non-nil. If the context expires before the
+// operation is complete, an error is returned. The context only affects to the
+// transport operations.
+func (w *Worktree) PullWithResultContext(ctx context.Context, o *PullOptions) (*PullResult, error) {
 	if err := o.Validate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	remote, err := w.r.Remote(o.RemoteName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	fetchHead, err := remote.fetch(ctx, &FetchOptions{
-		RemoteName: o.RemoteName,
-		Depth:      o.Depth,
-		Auth:       o.Auth,
-		Progress:   o.Progress,
-		Force:      o.Force,
+		RemoteName:      o.RemoteName,
+		Depth:           o.Depth,
+		Auth:            o.Auth,
+		Progress:        o.Progress,
+		Force:           o.Force,
+		ProtocolVersion: o.ProtocolVersion,
 	})
 
+	w.r.rememberPromisorAuth(remote, o.Auth)
+
 	updated := true
 	if err == NoErrAlreadyUpToDate {
 		updated = false
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	ref, err := storer.ResolveReference(fetchHead, o.ReferenceName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	res := &PullResult{Action: PullFastForwarded, Head: ref.Hash()}
+
 	head, err := w.r.Head()
 	if err == nil {
 		if !updated && head.Hash() == ref.Hash() {
-			return NoErrAlreadyUpToDate
+			return &PullResult{Action: PullUpToDate, Head: head.Hash()}, NoErrAlreadyUpToDate
 		}
 
 		ff, err := isFastForward(w.r.Storer, head.Hash(), ref.Hash())
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if !ff {
-			return ErrNonFastForwardUpdate
+		if !ff || o.Strategy == PullNoFastForward {
+			res, err = w.pullDiverged(ref, remote, o)
+			if err != nil {
+				return res, err
+			}
+
+			return res, w.pullUpdateSubmodules(o)
 		}
 	}
 
 	if err != nil && err != plumbing.ErrReferenceNotFound {
-		return err
+		return nil, err
 	}
 
-	if err := w.updateHEAD(ref.Hash()); err != nil {
-		return err
+	if err := w.updateHEAD(ref.Hash(), o.Committer, "pull: Fast-forward"); err != nil {
+		return nil, err
 	}
 
-	if err := w.Reset(&ResetOptions{
+	if err := w.resetContext(ctx, &ResetOptions{
 		Mode:   MergeReset,
 		Commit: ref.Hash(),
-	}); err != nil {
-		return err
+	}, ""); err != nil {
+		return nil, err
 	}
 
-	if o.RecurseSubmodules != NoRecurseSubmodules {
-		return w.updateSubmodules(&SubmoduleUpdateOptions{
-			RecurseSubmodules: o.RecurseSubmodules,
-			Auth:              o.Auth,
-		})
+	return res, w.pullUpdateSubmodules(o)
+}
+
+// pullDiverged integrates the fetched reference into the current branch when
+// it can not be fast-forwarded, or a merge commit is required.
+func (w *Worktree) pullDiverged(ref *plumbing.Reference, remote *Remote, o *PullOptions) (*PullResult, error) {
+	switch o.Strategy {
+	case PullMerge, PullNoFastForward:
+		return w.pullMerge(ref, remote, o)
+	case PullRebase:
+		return w.pullRebase(ref, o)
 	}
 
-	return nil
+	return nil, ErrNonFastForwardUpdate
+}
+
+func (w *Worktree) pullMerge(ref *plumbing.Reference, remote *Remote, o *PullOptions) (*PullResult, error) {
+	mo := &MergeOptions{
+		CommitHash: ref.Hash(),
+		Message:    fmt.Sprintf("Merge branch '%s' of %s\n", ref.Name().Short(), remote.c.URLs[0]),
+		Author:     o.Author,
+		Committer:  o.Committer,
+	}
+
+	if o.Strategy == PullNoFastForward {
+		mo.FastForward = NoFastForward
+	}
+
+	h, err := w.Merge(mo)
+	switch err {
+	case nil:
+		return &PullResult{Action: PullMerged, Head: h}, nil
+	case NoErrAlreadyUpToDate:
+		return &PullResult{Action: PullUpToDate, Head: h}, err
+	case ErrMergeConflicts:
+		conflicts, cerr := w.unmergedPaths()
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		return &PullResult{Action: PullConflicted, Conflicts: conflicts}, err
+	}
+
+	return nil, err
+}
+
+// pullRebase rebases the current branch on the fetched reference with
+// Repository.Rebase. When it stops on conflicts, the rebase is left in
+// progress, to be resumed with RebaseContinue or cancelled with RebaseAbort.
+func (w *Worktree) pullRebase(ref *plumbing.Reference, o *PullOptions) (*PullResult, error) {
+	h, err := w.r.Rebase(ref.Hash(), plumbing.ZeroHash, &RebaseOptions{
+		Committer: o.Committer,
+	})
+	switch err {
+	case nil:
+		n, err := countFirstParents(w.r, h, ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		return &PullResult{Action: PullRebased, Head: h, Rebased: n}, nil
+	case NoErrAlreadyUpToDate:
+		return &PullResult{Action: PullUpToDate, Head: h}, err
+	case ErrRebaseConflicts:
+		head, herr := w.r.Head()
+		if herr != nil {
+			return nil, herr
+		}
+
+		conflicts, cerr := w.unmergedPaths()
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		return &PullResult{Action: PullConflicted, Head: head.Hash(), Conflicts: conflicts}, err
+	}
+
+	return nil, err
+}
+
+// countFirstParents returns the number of commits from h down to the given
+// ancestor, following the first parents.
+func countFirstParents(r *Repository, h, ancestor plumbing.Hash) (int, error) {
+	n := 0
+	for h != ancestor {
+		c, err := r.CommitObject(h)
+		if err != nil {
+			return 0, err
+		}
+
+		if c.NumParents() == 0 {
+			break
+		}
+
+		h = c.ParentHashes[0]
+		n++
+	}
+
+	return n, nil
+}
+
+func (w *Worktree) pullUpdateSubmodules(o *PullOptions) error {
+	if o.RecurseSubmodules == NoRecurseSubmodules {
+		return nil
+	}
+
+	return w.updateSubmodules(&SubmoduleUpdateOptions{
+		RecurseSubmodules: o.RecurseSubmodules,
+		Auth:              o.Auth,
+	})
 }
 
 func (w *Worktree) updateSubmodules(o *SubmoduleUpdateOptions) error {
@@ -164,17 +327,22 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		ro.Mode = SoftReset
 	}
 
+	from, err := describeHEAD(w.r.Storer)
+	if err != nil {
+		return err
+	}
+
 	if !opts.Hash.IsZero() && !opts.Create {
-		err = w.setHEADToCommit(opts.Hash)
+		err = w.setHEADToCommit(opts.Hash, reflogCheckoutPrefix+from+" to "+opts.Hash.String())
 	} else {
-		err = w.setHEADToBranch(opts.Branch, c)
+		err = w.setHEADToBranch(opts.Branch, c, reflogCheckoutPrefix+from+" to "+opts.Branch.Short())
 	}
 
 	if err != nil {
 		return err
 	}
 
-	return w.Reset(ro)
+	return w.reset(ro, "")
 }
 func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 	_, err := w.r.Storer.Reference(opts.Branch)
@@ -186,6 +354,7 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		return err
 	}
 
+	from := "HEAD"
 	if opts.Hash.IsZero() {
 		ref, err := w.r.Head()
 		if err != nil {
@@ -193,10 +362,12 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		}
 
 		opts.Hash = ref.Hash()
+	} else {
+		from = opts.Hash.String()
 	}
 
-	return w.r.Storer.SetReference(
-		plumbing.NewHashReference(opts.Branch, opts.Hash),
+	return setReference(w.r.Storer,
+		plumbing.NewHashReference(opts.Branch, opts.Hash), nil, nil, "branch: Created from "+from,
 	)
 }
 
@@ -233,12 +404,12 @@ func (w *Worktree) getCommitFromCheckoutOptions(opts *CheckoutOptions) (plumbing
 	return plumbing.ZeroHash, fmt.Errorf("unsupported tag target %q", o.Type())
 }
 
-func (w *Worktree) setHEADToCommit(commit plumbing.Hash) error {
+func (w *Worktree) setHEADToCommit(commit plumbing.Hash, msg string) error {
 	head := plumbing.NewHashReference(plumbing.HEAD, commit)
-	return w.r.Storer.SetReference(head)
+	return setReference(w.r.Storer, head, nil, nil, msg)
 }
 
-func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbing.Hash) error {
+func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbing.Hash, msg string) error {
 	target, err := w.r.Storer.Reference(branch)
 	if err != nil {
 		return err
@@ -251,7 +422,7 @@ func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbin
 		head = plumbing.NewHashReference(plumbing.HEAD, commit)
 	}
 
-	return w.r.Storer.SetReference(head)
+	return setReference(w.r.Storer, head, nil, nil, msg)
 }
 
 // Reset the worktree to a specified state.
@@ -260,6 +431,18 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 		return err
 	}
 
+	return w.reset(opts, "reset: moving to "+opts.Commit.String())
+}
+
+// reset is like Reset, but the options should be already validated. The
+// update of HEAD is recorded in the reflog with the given message, if any.
+func (w *Worktree) reset(opts *ResetOptions, msg string) error {
+	return w.resetContext(context.Background(), opts, msg)
+}
+
+// resetContext is like reset, the context being used to fetch the trees and
+// the blobs missing from a partial clone.
+func (w *Worktree) resetContext(ctx context.Context, opts *ResetOptions, msg string) error {
 	if opts.Mode == MergeReset {
 		unstaged, err := w.containsUnstagedChanges()
 		if err != nil {
@@ -271,7 +454,7 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 		}
 	}
 
-	if err := w.setHEADCommit(opts.Commit); err != nil {
+	if err := w.setHEADCommit(opts.Commit, msg); err != nil {
 		return err
 	}
 
@@ -279,7 +462,20 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 		return nil
 	}
 
-	t, err := w.getTreeFromCommitHash(opts.Commit)
+	if err := w.abortMerge(); err != nil {
+		return err
+	}
+
+	c, err := w.r.CommitObject(opts.Commit)
+	if err != nil {
+		return err
+	}
+
+	if err := w.r.fetchMissingTrees(ctx, c.TreeHash); err != nil {
+		return err
+	}
+
+	t, err := c.Tree()
 	if err != nil {
 		return err
 	}
@@ -291,7 +487,7 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 	}
 
 	if opts.Mode == MergeReset || opts.Mode == HardReset {
-		if err := w.resetWorktree(t); err != nil {
+		if err := w.resetWorktree(ctx, t); err != nil {
 			return err
 		}
 	}
@@ -299,6 +495,33 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 	return nil
 }
 
+// abortMerge removes the state of a merge, cherry-pick or revert stopped with
+// conflicts, the unmerged entries are dropped from the index.
+func (w *Worktree) abortMerge() error {
+	if err := w.removeMergeState(); err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	if !hasUnmergedEntries(idx) {
+		return nil
+	}
+
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Stage == index.Merged {
+			entries = append(entries, e)
+		}
+	}
+
+	idx.Entries = entries
+	return w.r.Storer.SetIndex(idx)
+}
+
 func (w *Worktree) resetIndex(t *object.Tree) error {
 	idx, err := w.r.Storer.Index()
 	if err != nil {
@@ -346,7 +569,13 @@ func (w *Worktree) resetIndex(t *object.Tree) error {
 	return w.r.Storer.SetIndex(idx)
 }
 
-func (w *Worktree) resetWorktree(t *object.Tree) error {
+func (w *Worktree) resetWorktree(ctx context.Context, t *object.Tree) error {
+	return w.resetWorktreeKeeping(ctx, t, nil)
+}
+
+// resetWorktreeKeeping is like resetWorktree, but the given files, that are
+// not present in the index, are not removed.
+func (w *Worktree) resetWorktreeKeeping(ctx context.Context, t *object.Tree, keep map[string]bool) error {
 	changes, err := w.diffStagingWithWorktree(true)
 	if err != nil {
 		return err
@@ -357,7 +586,15 @@ func (w *Worktree) resetWorktree(t *object.Tree) error {
 		return err
 	}
 
+	if err := w.fetchMissingBlobs(ctx, changes, t); err != nil {
+		return err
+	}
+
 	for _, ch := range changes {
+		if ch.To == nil && keep[ch.From.String()] {
+			continue
+		}
+
 		if err := w.checkoutChange(ch, t, idx); err != nil {
 			return err
 		}
@@ -366,6 +603,56 @@ func (w *Worktree) resetWorktree(t *object.Tree) error {
 	return w.r.Storer.SetIndex(idx)
 }
 
+// fetchMissingBlobs fetches at once the blobs to be checked out missing from
+// a partial clone, from its promisor remote.
+func (w *Worktree) fetchMissingBlobs(ctx context.Context, changes merkletrie.Changes, t *object.Tree) error {
+	var missing []plumbing.Hash
+	for _, ch := range changes {
+		if ch.To == nil {
+			continue
+		}
+
+		e, err := t.FindEntry(ch.To.String())
+		if err != nil {
+			return err
+		}
+
+		if e.Mode == filemode.Submodule {
+			continue
+		}
+
+		err = w.r.Storer.HasEncodedObject(e.Hash)
+		if err == plumbing.ErrObjectNotFound {
+			missing = append(missing, e.Hash)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.r.fetchPromisedObjects(ctx, missing)
+}
+
+// untrackedPaths returns the files of the worktree not present in the index,
+// the ignored files are not included.
+func (w *Worktree) untrackedPaths() (map[string]bool, error) {
+	changes, err := w.diffStagingWithWorktree(false)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, ch := range changes {
+		if ch.From == nil {
+			paths[ch.To.String()] = true
+		}
+	}
+
+	return paths, nil
+}
+
 func (w *Worktree) checkoutChange(ch merkletrie.Change, t *object.Tree, idx *index.Index) error {
 	a, err := ch.Action()
 	if err != nil {
@@ -418,7 +705,7 @@ func (w *Worktree) containsUnstagedChanges() (bool, error) {
 	return false, nil
 }
 
-func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
+func (w *Worktree) setHEADCommit(commit plumbing.Hash, msg string) error {
 	head, err := w.r.Reference(plumbing.HEAD, false)
 	if err != nil {
 		return err
@@ -426,7 +713,7 @@ func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
 
 	if head.Type() == plumbing.HashReference {
 		head = plumbing.NewHashReference(plumbing.HEAD, commit)
-		return w.r.Storer.SetReference(head)
+		return setReference(w.r.Storer, head, nil, nil, msg)
 	}
 
 	branch, err := w.r.Reference(head.Target(), false)
@@ -439,7 +726,7 @@ func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
 	}
 
 	branch = plumbing.NewHashReference(branch.Name(), commit)
-	return w.r.Storer.SetReference(branch)
+	return setReference(w.r.Storer, branch, nil, nil, msg)
 }
 
 func (w *Worktree) checkoutChangeSubmodule(name string,
@@ -34,6 +34,10 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
+	if hasUnmergedEntries(idx) {
+		return plumbing.ZeroHash, ErrUnmergedPaths
+	}
+
 	h := &buildTreeHelper{
 		fs: w.Filesystem,
 		s:  w.r.Storer,
@@ -49,7 +53,11 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
-	return commit, w.updateHEAD(commit)
+	if err := w.updateHEAD(commit, opts.Committer, commitReflogMessage(msg, opts.Parents)); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commit, w.removeMergeState()
 }
 
 func (w *Worktree) autoAddModifiedAndDeleted() error {
@@ -71,7 +79,9 @@ func (w *Worktree) autoAddModifiedAndDeleted() error {
 	return nil
 }
 
-func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
+// updateHEAD points HEAD, or the branch it points to, to the given commit and
+// records the update in the reflog with the given signature and message.
+func (w *Worktree) updateHEAD(commit plumbing.Hash, sig *object.Signature, msg string) error {
 	head, err := w.r.Storer.Reference(plumbing.HEAD)
 	if err != nil {
 		return err
@@ -83,7 +93,18 @@ func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
 	}
 
 	ref := plumbing.NewHashReference(name, commit)
-	return w.r.Storer.SetReference(ref)
+	return setReference(w.r.Storer, ref, nil, sig, msg)
+}
+
+func commitReflogMessage(msg string, parents []plumbing.Hash) string {
+	switch len(parents) {
+	case 0:
+		return "commit (initial): " + firstLine(msg)
+	case 1:
+		return "commit: " + firstLine(msg)
+	default:
+		return "commit (merge): " + firstLine(msg)
+	}
 }
 
 func (w *Worktree) buildCommitObject(msg string, opts *CommitOptions, tree plumbing.Hash) (plumbing.Hash, error) {
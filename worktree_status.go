@@ -100,6 +100,21 @@ func (w *Worktree) status(commit plumbing.Hash) (Status, error) {
 		}
 	}
 
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage == index.Merged {
+			continue
+		}
+
+		fs := s.File(e.Name)
+		fs.Staging = UpdatedButUnmerged
+		fs.Worktree = UpdatedButUnmerged
+	}
+
 	return s, nil
 }
 
@@ -470,6 +485,12 @@ func (w *Worktree) addOrUpdateFileToIndex(idx *index.Index, filename string, h p
 		return w.doAddFileToIndex(idx, filename, h)
 	}
 
+	// adding an unmerged path marks it as resolved
+	if e.Stage != index.Merged {
+		removeIndexEntries(idx, filename)
+		return w.doAddFileToIndex(idx, filename, h)
+	}
+
 	return w.doUpdateFileToIndex(e, filename, h)
 }
 
@@ -581,6 +602,8 @@ func (w *Worktree) deleteFromIndex(idx *index.Index, path string) (plumbing.Hash
 		return plumbing.ZeroHash, err
 	}
 
+	// any other stage of an unmerged path
+	removeIndexEntries(idx, path)
 	return e.Hash, nil
 }
 
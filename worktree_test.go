@@ -122,6 +122,230 @@ func (s *WorktreeSuite) TestPullNonFastForward(c *C) {
 	c.Assert(err, Equals, ErrNonFastForwardUpdate)
 }
 
+// setupDivergedPull returns a repository whose master branch has diverged from
+// the master branch of its origin, each one with a commit changing the given
+// files.
+func (s *WorktreeSuite) setupDivergedPull(c *C, remote, local map[string]string) (*Repository, *Worktree) {
+	url := c.MkDir()
+	path := fixtures.Basic().ByTag("worktree").One().Worktree().Root()
+
+	server, err := PlainClone(url, false, &CloneOptions{
+		URL: path,
+	})
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	w, err := server.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, remote, "remote\n")
+
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, local, "local\n")
+
+	return r, w
+}
+
+func (s *WorktreeSuite) TestPullMerge(c *C) {
+	r, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "foo\n"},
+		map[string]string{"bar": "bar\n"},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	res, err := w.PullWithResult(&PullOptions{
+		Strategy: PullMerge,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Action, Equals, PullMerged)
+
+	commit, err := r.CommitObject(res.Head)
+	c.Assert(err, IsNil)
+	c.Assert(commit.NumParents(), Equals, 2)
+	c.Assert(commit.ParentHashes[0], Equals, head.Hash())
+
+	_, err = commit.File("foo")
+	c.Assert(err, IsNil)
+	_, err = commit.File("bar")
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestPullMergeConflicts(c *C) {
+	_, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "remote\n"},
+		map[string]string{"foo": "local\n"},
+	)
+
+	res, err := w.PullWithResult(&PullOptions{
+		Strategy: PullMerge,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrMergeConflicts)
+	c.Assert(res.Action, Equals, PullConflicted)
+	c.Assert(res.Conflicts, DeepEquals, []string{"foo"})
+}
+
+func (s *WorktreeSuite) TestPullMergeMissingAuthor(c *C) {
+	_, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "foo\n"},
+		map[string]string{"bar": "bar\n"},
+	)
+
+	err := w.Pull(&PullOptions{Strategy: PullMerge})
+	c.Assert(err, Equals, ErrMissingAuthor)
+}
+
+func (s *WorktreeSuite) TestPullNoFastForwardStrategy(c *C) {
+	url := c.MkDir()
+	path := fixtures.Basic().ByTag("worktree").One().Worktree().Root()
+
+	server, err := PlainClone(url, false, &CloneOptions{
+		URL: path,
+	})
+	c.Assert(err, IsNil)
+
+	r, err := PlainClone(c.MkDir(), false, &CloneOptions{
+		URL: url,
+	})
+	c.Assert(err, IsNil)
+
+	w, err := server.Worktree()
+	c.Assert(err, IsNil)
+	remote := commitFiles(c, w, map[string]string{"foo": "foo\n"}, "remote\n")
+
+	w, err = r.Worktree()
+	c.Assert(err, IsNil)
+
+	res, err := w.PullWithResult(&PullOptions{
+		Strategy: PullNoFastForward,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Action, Equals, PullMerged)
+
+	commit, err := r.CommitObject(res.Head)
+	c.Assert(err, IsNil)
+	c.Assert(commit.NumParents(), Equals, 2)
+	c.Assert(commit.ParentHashes[1], Equals, remote)
+}
+
+func (s *WorktreeSuite) TestPullRebase(c *C) {
+	r, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "foo\n"},
+		map[string]string{"bar": "bar\n"},
+	)
+
+	res, err := w.PullWithResult(&PullOptions{
+		Strategy: PullRebase,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.Action, Equals, PullRebased)
+	c.Assert(res.Rebased, Equals, 1)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, res.Head)
+
+	commit, err := r.CommitObject(res.Head)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "local\n")
+	c.Assert(commit.NumParents(), Equals, 1)
+
+	parent, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(parent.Message, Equals, "remote\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestPullRebaseConflicts(c *C) {
+	r, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "remote\n"},
+		map[string]string{"foo": "local\n"},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	res, err := w.PullWithResult(&PullOptions{
+		Strategy: PullRebase,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrRebaseConflicts)
+	c.Assert(res.Action, Equals, PullConflicted)
+	c.Assert(res.Conflicts, DeepEquals, []string{"foo"})
+	c.Assert(res.Rebased, Equals, 0)
+
+	// the rebase is left in progress, on top of the fetched commit
+	detached, err := r.Storer.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(detached.Type(), Equals, plumbing.HashReference)
+	c.Assert(detached.Hash(), Equals, res.Head)
+
+	upstream, err := r.Reference(plumbing.NewRemoteReferenceName("origin", "master"), true)
+	c.Assert(err, IsNil)
+	c.Assert(res.Head, Equals, upstream.Hash())
+
+	data, err := readWorktreeFile(w.Filesystem, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, "(?s)<<<<<<< HEAD\nremote\n=======\nlocal\n>>>>>>> .*")
+
+	c.Assert(r.RebaseAbort(), IsNil)
+
+	aborted, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(aborted.Name(), Equals, plumbing.Master)
+	c.Assert(aborted.Hash(), Equals, head.Hash())
+}
+
+func (s *WorktreeSuite) TestPullRebaseConflictsContinue(c *C) {
+	r, w := s.setupDivergedPull(c,
+		map[string]string{"foo": "remote\n"},
+		map[string]string{"foo": "local\n"},
+	)
+
+	_, err := w.PullWithResult(&PullOptions{
+		Strategy: PullRebase,
+		Author:   defaultSignature(),
+	})
+	c.Assert(err, Equals, ErrRebaseConflicts)
+
+	c.Assert(util.WriteFile(w.Filesystem, "foo", []byte("resolved\n"), 0644), IsNil)
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	h, err := r.RebaseContinue(&RebaseOptions{Committer: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash(), Equals, h)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "local\n")
+
+	parent, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(parent.Message, Equals, "remote\n")
+}
+
 func (s *WorktreeSuite) TestPullUpdateReferencesIfNeeded(c *C) {
 	r, _ := Init(memory.NewStorage(), memfs.New())
 	r.CreateRemote(&config.RemoteConfig{
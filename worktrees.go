@@ -0,0 +1,551 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	stdioutil "io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+	"gopkg.in/src-d/go-git.v4/utils/ioutil"
+
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+const (
+	worktreesDir  = "worktrees"
+	commonDirFile = "commondir"
+	gitDirFile    = "gitdir"
+	lockedFile    = "locked"
+)
+
+var (
+	// ErrWorktreesNotSupported is returned when managing the linked worktrees
+	// of a repository not stored in a filesystem.
+	ErrWorktreesNotSupported = errors.New("linked worktrees require a filesystem storage")
+	// ErrWorktreeNotFound is returned when the linked worktree does not exist.
+	ErrWorktreeNotFound = errors.New("worktree not found")
+	// ErrWorktreeLocked is returned when moving or removing a locked worktree.
+	ErrWorktreeLocked = errors.New("worktree is locked")
+	// ErrWorktreePathExists is returned when adding or moving a worktree to a
+	// path already in use.
+	ErrWorktreePathExists = errors.New("worktree path already exists")
+	// ErrBranchCheckedOut is returned when adding a worktree with a branch
+	// already checked out by another worktree without forcing it.
+	ErrBranchCheckedOut = errors.New("branch is already checked out by another worktree")
+)
+
+// WorktreeInfo describes a worktree of a repository, see Repository.Worktrees.
+type WorktreeInfo struct {
+	// Name of the linked worktree in the worktrees directory of the
+	// repository, empty for the main worktree.
+	Name string
+	// Path of the worktree, or of the repository if it is bare.
+	Path string
+	// Head is the HEAD of the worktree, nil if it cannot be read.
+	Head *plumbing.Reference
+	// Locked is true if the worktree is locked, so it will not be moved,
+	// removed or pruned. LockReason is the reason given when it was locked.
+	Locked     bool
+	LockReason string
+	// Prunable is true if the path of the worktree does not exist anymore,
+	// so it will be removed by PruneWorktrees.
+	Prunable bool
+}
+
+// AddWorktree adds a linked worktree at the given path, as `git worktree add`
+// does, and returns the repository opened from it. The linked worktrees share
+// the objects, the references and the configuration of the repository, while
+// each one has its own HEAD and index.
+func (r *Repository) AddWorktree(path string, o *AddWorktreeOptions) (*Repository, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	common, err := r.commonDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+
+	if err := checkWorktreePath(path); err != nil {
+		return nil, err
+	}
+
+	co := &CheckoutOptions{Branch: o.Branch, Hash: o.Hash, Create: o.Create, Force: true}
+	if co.Branch == "" && co.Hash.IsZero() {
+		co.Branch = plumbing.NewBranchReferenceName(filepath.Base(path))
+		_, err := r.Storer.Reference(co.Branch)
+		co.Create = err == plumbing.ErrReferenceNotFound
+	}
+
+	start, err := r.worktreeStart(co)
+	if err != nil {
+		return nil, err
+	}
+
+	if co.Branch != "" && !co.Create && !o.Force {
+		if err := r.checkBranchNotCheckedOut(co.Branch); err != nil {
+			return nil, err
+		}
+	}
+
+	name := o.Name
+	if name == "" {
+		name = filepath.Base(path)
+	}
+
+	admin, err := createWorktreeAdminDir(common, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(path)
+	created := os.IsNotExist(err)
+
+	wr, err := initWorktree(admin, path, start, co, o)
+	if err != nil {
+		// nothing is left behind, so the worktree can be added again
+		removeFailedWorktree(common, admin, path, created)
+		return nil, err
+	}
+
+	return wr, nil
+}
+
+// initWorktree writes the files of a new linked worktree, in its admin
+// directory and at its path, and checks it out.
+func initWorktree(
+	admin billy.Filesystem, path string, start plumbing.Hash,
+	co *CheckoutOptions, o *AddWorktreeOptions,
+) (*Repository, error) {
+	files := map[string]string{
+		gitDirFile:    filepath.Join(path, GitDirName),
+		commonDirFile: filepath.Join("..", ".."),
+		"HEAD":        start.String(),
+	}
+
+	if o.Lock {
+		files[lockedFile] = o.LockReason
+	}
+
+	for name, content := range files {
+		if err := util.WriteFile(admin, name, []byte(content+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(path, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	gitdir := fmt.Sprintf("gitdir: %s\n", admin.Root())
+	if err := stdioutil.WriteFile(filepath.Join(path, GitDirName), []byte(gitdir), 0644); err != nil {
+		return nil, err
+	}
+
+	wr, err := PlainOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wr.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Checkout(co); err != nil {
+		return nil, err
+	}
+
+	return wr, nil
+}
+
+// removeFailedWorktree removes the admin directory of a worktree that could
+// not be added, and the files written at its path, the path itself if it was
+// created. The errors are ignored, the one adding the worktree being returned.
+func removeFailedWorktree(common, admin billy.Filesystem, path string, created bool) {
+	util.RemoveAll(common, common.Join(worktreesDir, filepath.Base(admin.Root())))
+	if created {
+		os.RemoveAll(path)
+		return
+	}
+
+	files, err := stdioutil.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		os.RemoveAll(filepath.Join(path, f.Name()))
+	}
+}
+
+// worktreeStart returns the commit a new worktree starts at, the given hash,
+// the branch to be checked out or HEAD.
+func (r *Repository) worktreeStart(o *CheckoutOptions) (plumbing.Hash, error) {
+	if !o.Hash.IsZero() {
+		return o.Hash, nil
+	}
+
+	name := plumbing.HEAD
+	if o.Branch != "" && !o.Create {
+		name = o.Branch
+	}
+
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
+
+func (r *Repository) checkBranchNotCheckedOut(branch plumbing.ReferenceName) error {
+	worktrees, err := r.Worktrees()
+	if err != nil {
+		return err
+	}
+
+	for _, wt := range worktrees {
+		if wt.Head != nil && wt.Head.Type() == plumbing.SymbolicReference &&
+			wt.Head.Target() == branch {
+			return ErrBranchCheckedOut
+		}
+	}
+
+	return nil
+}
+
+// checkWorktreePath checks the path of a new worktree does not exist or is an
+// empty directory.
+func checkWorktreePath(path string) error {
+	files, err := stdioutil.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil || len(files) != 0 {
+		return ErrWorktreePathExists
+	}
+
+	return nil
+}
+
+// createWorktreeAdminDir creates the directory of a new linked worktree in the
+// worktrees directory, adding a number to its name if it is already in use.
+func createWorktreeAdminDir(common billy.Filesystem, name string) (billy.Filesystem, error) {
+	dir := common.Join(worktreesDir, name)
+	for i := 1; ; i++ {
+		_, err := common.Stat(dir)
+		if os.IsNotExist(err) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		dir = common.Join(worktreesDir, fmt.Sprintf("%s%d", name, i))
+	}
+
+	if err := common.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return common.Chroot(dir)
+}
+
+// Worktrees returns the worktrees of the repository, as `git worktree list`
+// does, starting with the main worktree followed by the linked ones.
+func (r *Repository) Worktrees() ([]*WorktreeInfo, error) {
+	common, err := r.commonDir()
+	if err != nil {
+		return nil, err
+	}
+
+	main, err := r.mainWorktree(common)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := common.ReadDir(worktreesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	result := []*WorktreeInfo{main}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+
+		info, err := linkedWorktree(common, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+func (r *Repository) mainWorktree(common billy.Filesystem) (*WorktreeInfo, error) {
+	cfg, err := r.Storer.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	path := common.Root()
+	if !cfg.Core.IsBare {
+		path = filepath.Dir(path)
+	}
+
+	head, err := dotgit.New(common).Ref(plumbing.HEAD)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	return &WorktreeInfo{Path: path, Head: head}, nil
+}
+
+// linkedWorktree returns the information of the linked worktree with the
+// given name, read from its directory in the worktrees directory.
+func linkedWorktree(common billy.Filesystem, name string) (*WorktreeInfo, error) {
+	dir := common.Join(worktreesDir, name)
+	if _, err := common.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrWorktreeNotFound
+		}
+
+		return nil, err
+	}
+
+	admin, err := common.Chroot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &WorktreeInfo{Name: name}
+	gitdir, err := readWorktreeAdminFile(admin, gitDirFile)
+	switch {
+	case os.IsNotExist(err):
+		info.Prunable = true
+	case err != nil:
+		return nil, err
+	default:
+		info.Path = filepath.Dir(gitdir)
+		if _, err := os.Stat(gitdir); os.IsNotExist(err) {
+			info.Prunable = true
+		}
+	}
+
+	reason, err := readWorktreeAdminFile(admin, lockedFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	info.Locked = err == nil
+	info.LockReason = reason
+
+	info.Head, err = dotgit.New(admin).Ref(plumbing.HEAD)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func readWorktreeAdminFile(fs billy.Filesystem, name string) (content string, err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b, err := stdioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// LockWorktree locks the linked worktree with the given name, with an optional
+// reason, so it will not be moved, removed or pruned.
+func (r *Repository) LockWorktree(name, reason string) error {
+	common, info, err := r.linkedWorktree(name)
+	if err != nil {
+		return err
+	}
+
+	if info.Locked {
+		return ErrWorktreeLocked
+	}
+
+	path := common.Join(worktreesDir, name, lockedFile)
+	return util.WriteFile(common, path, []byte(reason+"\n"), 0644)
+}
+
+// UnlockWorktree unlocks the linked worktree with the given name.
+func (r *Repository) UnlockWorktree(name string) error {
+	common, _, err := r.linkedWorktree(name)
+	if err != nil {
+		return err
+	}
+
+	err = common.Remove(common.Join(worktreesDir, name, lockedFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// MoveWorktree moves the linked worktree with the given name to a new path,
+// which must not exist.
+func (r *Repository) MoveWorktree(name, path string) error {
+	common, info, err := r.linkedWorktree(name)
+	if err != nil {
+		return err
+	}
+
+	if info.Locked {
+		return ErrWorktreeLocked
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return ErrWorktreePathExists
+	}
+
+	if err := os.Rename(info.Path, path); err != nil {
+		return err
+	}
+
+	gitdir := filepath.Join(path, GitDirName) + "\n"
+	return util.WriteFile(common, common.Join(worktreesDir, name, gitDirFile), []byte(gitdir), 0644)
+}
+
+// RemoveWorktree removes the linked worktree with the given name, deleting its
+// path. Unless force is true, a worktree with modified or untracked files is
+// not removed and ErrWorktreeNotClean is returned.
+func (r *Repository) RemoveWorktree(name string, force bool) error {
+	common, info, err := r.linkedWorktree(name)
+	if err != nil {
+		return err
+	}
+
+	if info.Locked {
+		return ErrWorktreeLocked
+	}
+
+	if !force && !info.Prunable {
+		if err := checkWorktreeClean(info.Path); err != nil {
+			return err
+		}
+	}
+
+	if !info.Prunable {
+		if err := os.RemoveAll(info.Path); err != nil {
+			return err
+		}
+	}
+
+	return util.RemoveAll(common, common.Join(worktreesDir, name))
+}
+
+func checkWorktreeClean(path string) error {
+	r, err := PlainOpen(path)
+	if err != nil {
+		return err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+
+	if !status.IsClean() {
+		return ErrWorktreeNotClean
+	}
+
+	return nil
+}
+
+// PruneWorktrees removes the linked worktrees whose path does not exist
+// anymore, unless they are locked, and returns their names.
+func (r *Repository) PruneWorktrees() ([]string, error) {
+	worktrees, err := r.Worktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	common, err := r.commonDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, wt := range worktrees {
+		if wt.Name == "" || !wt.Prunable || wt.Locked {
+			continue
+		}
+
+		if err := util.RemoveAll(common, common.Join(worktreesDir, wt.Name)); err != nil {
+			return nil, err
+		}
+
+		pruned = append(pruned, wt.Name)
+	}
+
+	return pruned, nil
+}
+
+func (r *Repository) linkedWorktree(name string) (billy.Filesystem, *WorktreeInfo, error) {
+	common, err := r.commonDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := linkedWorktree(common, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return common, info, nil
+}
+
+// commonDir returns the git directory shared by all the worktrees of the
+// repository.
+func (r *Repository) commonDir() (billy.Filesystem, error) {
+	s, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, ErrWorktreesNotSupported
+	}
+
+	fs := s.Filesystem()
+	if rfs, ok := fs.(*dotgit.RepositoryFilesystem); ok {
+		return rfs.Common(), nil
+	}
+
+	return fs, nil
+}
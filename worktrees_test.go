@@ -0,0 +1,252 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+type WorktreesSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&WorktreesSuite{})
+
+func (s *WorktreesSuite) newRepository(c *C) (*Repository, string) {
+	dir := c.MkDir()
+	r, err := PlainInit(filepath.Join(dir, "main"), false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]string{"foo": "foo\n"}, "base\n")
+	return r, dir
+}
+
+func (s *WorktreesSuite) TestAddWorktree(c *C) {
+	r, dir := s.newRepository(c)
+	path := filepath.Join(dir, "feature")
+
+	wr, err := r.AddWorktree(path, &AddWorktreeOptions{})
+	c.Assert(err, IsNil)
+
+	head, err := wr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+
+	content, err := readWorktreeFile(wr.wt, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "foo\n")
+
+	w, err := wr.Worktree()
+	c.Assert(err, IsNil)
+	h := commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+
+	// the references and objects are shared, HEAD and the index are not
+	ref, err := r.Reference("refs/heads/feature", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+
+	_, err = r.CommitObject(h)
+	c.Assert(err, IsNil)
+
+	head, err = r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	mw, err := r.Worktree()
+	c.Assert(err, IsNil)
+	status, err := mw.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	worktrees, err := wr.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 2)
+	c.Assert(worktrees[0].Name, Equals, "")
+	c.Assert(worktrees[0].Path, Equals, filepath.Join(dir, "main"))
+	c.Assert(worktrees[0].Head.Target(), Equals, plumbing.Master)
+	c.Assert(worktrees[1].Name, Equals, "feature")
+	c.Assert(worktrees[1].Path, Equals, path)
+	c.Assert(worktrees[1].Head.Target(), Equals, plumbing.ReferenceName("refs/heads/feature"))
+	c.Assert(worktrees[1].Locked, Equals, false)
+	c.Assert(worktrees[1].Prunable, Equals, false)
+}
+
+func (s *WorktreesSuite) TestAddWorktreeBranch(c *C) {
+	r, dir := s.newRepository(c)
+
+	_, err := r.AddWorktree(filepath.Join(dir, "foo"), &AddWorktreeOptions{
+		Branch: plumbing.Master,
+	})
+	c.Assert(err, Equals, ErrBranchCheckedOut)
+
+	wr, err := r.AddWorktree(filepath.Join(dir, "foo"), &AddWorktreeOptions{
+		Branch: plumbing.Master,
+		Force:  true,
+	})
+	c.Assert(err, IsNil)
+
+	head, err := wr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+
+	master := head.Hash()
+	wr, err = r.AddWorktree(filepath.Join(dir, "bar", "foo"), &AddWorktreeOptions{
+		Hash: master,
+		Lock: true,
+	})
+	c.Assert(err, IsNil)
+
+	head, err = wr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.HEAD)
+	c.Assert(head.Hash(), Equals, master)
+
+	worktrees, err := r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 3)
+	c.Assert(worktrees[2].Name, Equals, "foo1")
+	c.Assert(worktrees[2].Locked, Equals, true)
+
+	_, err = r.AddWorktree(filepath.Join(dir, "main"), &AddWorktreeOptions{})
+	c.Assert(err, Equals, ErrWorktreePathExists)
+}
+
+func (s *WorktreesSuite) TestAddWorktreeFailed(c *C) {
+	r, dir := s.newRepository(c)
+	path := filepath.Join(dir, "foo")
+
+	_, err := r.AddWorktree(path, &AddWorktreeOptions{
+		Branch: plumbing.Master,
+		Create: true,
+	})
+	c.Assert(err, NotNil)
+
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	worktrees, err := r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 1)
+
+	_, err = r.AddWorktree(path, &AddWorktreeOptions{})
+	c.Assert(err, IsNil)
+
+	worktrees, err = r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 2)
+	c.Assert(worktrees[1].Name, Equals, "foo")
+}
+
+func (s *WorktreesSuite) TestLockMoveRemove(c *C) {
+	r, dir := s.newRepository(c)
+	path := filepath.Join(dir, "foo")
+
+	_, err := r.AddWorktree(path, &AddWorktreeOptions{})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.LockWorktree("foo", "reason"), IsNil)
+	c.Assert(r.LockWorktree("foo", ""), Equals, ErrWorktreeLocked)
+	c.Assert(r.LockWorktree("bar", ""), Equals, ErrWorktreeNotFound)
+
+	worktrees, err := r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees[1].Locked, Equals, true)
+	c.Assert(worktrees[1].LockReason, Equals, "reason")
+
+	moved := filepath.Join(dir, "moved")
+	c.Assert(r.MoveWorktree("foo", moved), Equals, ErrWorktreeLocked)
+	c.Assert(r.RemoveWorktree("foo", true), Equals, ErrWorktreeLocked)
+	c.Assert(r.UnlockWorktree("foo"), IsNil)
+
+	c.Assert(r.MoveWorktree("foo", moved), IsNil)
+
+	wr, err := PlainOpen(moved)
+	c.Assert(err, IsNil)
+	head, err := wr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/foo"))
+
+	c.Assert(util.WriteFile(wr.wt, "bar", []byte("bar\n"), 0644), IsNil)
+	c.Assert(r.RemoveWorktree("foo", false), Equals, ErrWorktreeNotClean)
+	c.Assert(r.RemoveWorktree("foo", true), IsNil)
+
+	_, err = os.Stat(moved)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	worktrees, err = r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 1)
+}
+
+func (s *WorktreesSuite) TestPruneWorktrees(c *C) {
+	r, dir := s.newRepository(c)
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		_, err := r.AddWorktree(filepath.Join(dir, name), &AddWorktreeOptions{})
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(r.LockWorktree("baz", ""), IsNil)
+	c.Assert(os.RemoveAll(filepath.Join(dir, "foo")), IsNil)
+	c.Assert(os.RemoveAll(filepath.Join(dir, "baz")), IsNil)
+
+	worktrees, err := r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 4)
+
+	pruned, err := r.PruneWorktrees()
+	c.Assert(err, IsNil)
+	c.Assert(pruned, DeepEquals, []string{"foo"})
+
+	worktrees, err = r.Worktrees()
+	c.Assert(err, IsNil)
+	c.Assert(worktrees, HasLen, 3)
+	c.Assert(worktrees[1].Name, Equals, "bar")
+	c.Assert(worktrees[2].Name, Equals, "baz")
+	c.Assert(worktrees[2].Prunable, Equals, true)
+}
+
+func (s *WorktreesSuite) TestPlainOpenGitWorktree(c *C) {
+	r, dir := s.newRepository(c)
+	path := filepath.Join(dir, "foo")
+
+	err := executeOnPath(filepath.Join(dir, "main"), "git worktree add -b foo "+path)
+	if err != nil {
+		c.Skip("git worktree add is not available")
+	}
+
+	wr, err := PlainOpen(path)
+	c.Assert(err, IsNil)
+
+	head, err := wr.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.ReferenceName("refs/heads/foo"))
+
+	w, err := wr.Worktree()
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	h := commitFiles(c, w, map[string]string{"bar": "bar\n"}, "bar\n")
+	ref, err := r.Reference("refs/heads/foo", false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+}
+
+func (s *WorktreesSuite) TestNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.Worktrees()
+	c.Assert(err, Equals, ErrWorktreesNotSupported)
+}